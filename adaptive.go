@@ -0,0 +1,508 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ============================================================================
+// ADAPTIVE QUIZZES - Questions that follow how the learner is doing
+// ============================================================================
+//
+// Instead of a fixed difficulty, an adaptive quiz asks one question at a time
+// and picks the next one's difficulty from the answers so far. Ability is
+// estimated with a simple one-parameter IRT (Rasch) model: a learner with
+// ability θ answers a question of difficulty b correctly with probability
+// 1 / (1 + e^-(θ-b)). Easy, Medium and Hard questions sit at fixed points on
+// that scale. After every answer we work out the posterior over θ on a grid
+// (standard normal prior), and ask next at whichever difficulty is closest to
+// the estimate, since that's where an answer tells us the most. The quiz
+// stops once the estimate's standard error is below adaptive.target_error
+// (after at least adaptive.min_questions) or at adaptive.max_questions, and
+// is then saved to the learner's history like any other quiz.
+//
+// Questions come from the learner's own question bank when it has unused
+// ones on the topic at the right difficulty, otherwise they're generated
+// (through the generation cache when it's on).
+
+// Hard upper limit for adaptive.max_questions
+const adaptiveMaxQuestions = 50
+
+// Where each difficulty sits on the ability scale
+var adaptiveLevels = []struct {
+	Name       string
+	Difficulty float64
+}{
+	{"Easy", -1.5},
+	{"Medium", 0},
+	{"Hard", 1.5},
+}
+
+// Chance that someone of this ability gets a question of this difficulty right
+func rasch(ability, difficulty float64) float64 {
+	return 1 / (1 + math.Exp(difficulty-ability))
+}
+
+// The ability scale's position for a difficulty name (Medium if unknown)
+func levelDifficulty(name string) float64 {
+	for _, l := range adaptiveLevels {
+		if l.Name == name {
+			return l.Difficulty
+		}
+	}
+	return 0
+}
+
+// The difficulty closest to an ability, where the next question tells us most
+func nearestLevel(ability float64) string {
+	best := adaptiveLevels[0]
+	for _, l := range adaptiveLevels[1:] {
+		if math.Abs(l.Difficulty-ability) < math.Abs(best.Difficulty-ability) {
+			best = l
+		}
+	}
+	return best.Name
+}
+
+// The learner's level in words: the hardest difficulty they're more likely
+// than not to get right (Easy at the very least)
+func abilityLevel(ability float64) string {
+	level := adaptiveLevels[0].Name
+	for _, l := range adaptiveLevels {
+		if rasch(ability, l.Difficulty) >= 0.5 {
+			level = l.Name
+		}
+	}
+	return level
+}
+
+// Estimate ability from the answered questions: the mean and standard
+// deviation of the posterior, worked out over a grid from -4 to 4
+func estimateAbility(items []AdaptiveItem) (ability, stdErr float64) {
+	const lo, hi, step = -4.0, 4.0, 0.05
+	var total, sum, sumSq float64
+	for theta := lo; theta <= hi+step/2; theta += step {
+		weight := math.Exp(-theta * theta / 2) // Standard normal prior
+		for _, item := range items {
+			if !item.Answered {
+				continue
+			}
+			p := rasch(theta, levelDifficulty(item.Level))
+			if !item.Correct {
+				p = 1 - p
+			}
+			weight *= p
+		}
+		total += weight
+		sum += weight * theta
+		sumSq += weight * theta * theta
+	}
+	ability = sum / total
+	return ability, math.Sqrt(math.Max(sumSq/total-ability*ability, 0))
+}
+
+// Have we asked enough?
+func (a *App) adaptiveDone(q *AdaptiveQuiz) bool {
+	n := q.answered()
+	cfg := a.config.Adaptive
+	return n >= cfg.MaxQuestions || (n >= cfg.MinQuestions && q.StdError <= cfg.TargetError)
+}
+
+// ----------- Picking questions -----------
+
+// Find the next question at the given difficulty: an unused one from the
+// learner's bank if there is one, otherwise a newly generated one
+func (a *App) nextAdaptiveQuestion(ctx context.Context, log *slog.Logger, q *AdaptiveQuiz, level string) (AdaptiveItem, error) {
+	asked := map[string]bool{}
+	var askedList []string
+	for _, item := range q.Items {
+		asked[normalizeQuestion(item.Question.Question)] = true
+		askedList = append(askedList, item.Question.Question)
+	}
+	isNew := func(c QuizQuestion) bool {
+		return validateQuestions([]QuizQuestion{c}) == nil && !asked[normalizeQuestion(c.Question)]
+	}
+	// The bank holds questions in whatever language they were made in
+	inLanguage := func(c QuizQuestion) bool {
+		return q.Language == "" || detectLanguage(c.Question+" "+c.Explanation+" "+strings.Join(c.Options, " ")) == q.Language
+	}
+
+	// The bank first - free, and questions the learner chose to keep
+	filter := BankFilter{Topic: q.Topic, Difficulty: level, Random: true, Limit: 20}
+	if q.QuizType != "Mixed" {
+		filter.Type = q.QuizType
+	}
+	if bank, err := a.store.SearchBank(ctx, q.UserID, filter); err != nil {
+		log.Warn("could not search the question bank", "error", err)
+	} else {
+		for _, b := range bank {
+			var c QuizQuestion
+			if json.Unmarshal(b.Question, &c) == nil && isNew(c) && inLanguage(c) {
+				a.metrics.adaptiveQuestions.Inc("bank")
+				return AdaptiveItem{Question: c, Level: level, Source: "bank"}, nil
+			}
+		}
+	}
+
+	// Then the AI. A cached pool may only hold questions we've used, in
+	// which case ask for a new one that avoids them.
+	req := QuizRequest{Topic: q.Topic, Difficulty: level, QuestionCount: 1, QuizType: q.QuizType, Language: q.Language}
+	quiz, _, err := a.generateCached(ctx, log, req)
+	if err != nil {
+		return AdaptiveItem{}, err
+	}
+	var generated []QuizQuestion
+	json.Unmarshal([]byte(quiz), &generated)
+	if len(generated) == 0 || !isNew(generated[0]) {
+		req.Avoid = askedList
+		if quiz, _, err = a.generateVerified(ctx, log, req); err != nil {
+			return AdaptiveItem{}, err
+		}
+		generated = nil
+		json.Unmarshal([]byte(quiz), &generated)
+	}
+	if len(generated) == 0 {
+		return AdaptiveItem{}, &LLMError{Kind: llmInvalidOutput, Err: errors.New("no question generated")}
+	}
+	a.metrics.adaptiveQuestions.Inc("generated")
+	return AdaptiveItem{Question: generated[0], Level: level, Source: "generated"}, nil
+}
+
+// Question text with case and spacing evened out, for spotting repeats
+func normalizeQuestion(text string) string {
+	return strings.Join(strings.Fields(strings.ToLower(text)), " ")
+}
+
+// Save a finished adaptive quiz to the learner's history, with their answers
+// as a completed attempt, and put the generated questions in their bank
+func (a *App) saveAdaptiveQuiz(ctx context.Context, log *slog.Logger, q *AdaptiveQuiz) (int, error) {
+	questions := make([]QuizQuestion, len(q.Items))
+	answers := make([]string, len(q.Items))
+	score := 0
+	for i, item := range q.Items {
+		questions[i], answers[i] = item.Question, item.Answer
+		if item.Correct {
+			score++
+		}
+	}
+	questionsJSON, _ := json.Marshal(questions)
+	answersJSON, _ := json.Marshal(answers)
+
+	// The model gets the credit (or the flags) if it wrote any of the questions
+	model := ""
+	for _, item := range q.Items {
+		if item.Source == "generated" {
+			model = a.llm.model
+		}
+	}
+	quizID, err := a.store.SaveQuiz(ctx, q.UserID, firstRunes("Adaptive: "+q.Topic, 200), string(questionsJSON), "", model)
+	if err != nil {
+		return 0, err
+	}
+	err = a.store.SaveAttempt(ctx, q.UserID, SaveQuizAttemptRequest{QuizID: quizID, Answers: string(answersJSON), Score: score, IsComplete: true})
+	if err != nil {
+		return 0, err
+	}
+
+	var entries []BankQuestion
+	for _, item := range q.Items {
+		if item.Source == "generated" {
+			one, _ := json.Marshal([]QuizQuestion{item.Question})
+			entries = append(entries, bankEntries(string(one), q.Topic, item.Level)...)
+		}
+	}
+	if err := a.store.AddToBank(ctx, q.UserID, quizID, entries); err != nil {
+		log.Warn("could not add questions to the bank", "quiz_id", quizID, "error", err)
+	}
+	return quizID, nil
+}
+
+// ----------- What the browser sees -----------
+
+// The question waiting for an answer, without the answer
+type adaptiveQuestion struct {
+	Index    int      `json:"index"`
+	Type     string   `json:"type,omitempty"`
+	Format   string   `json:"format,omitempty"`
+	Question string   `json:"question"`
+	Options  []string `json:"options"`
+	Left     []string `json:"left,omitempty"` // Matching: the items to match (the options are their matches)
+	Unit     string   `json:"unit,omitempty"`
+	ImageID  int      `json:"imageId,omitempty"`
+	Level    string   `json:"level"`
+}
+
+// Where an adaptive quiz has got to
+type adaptiveStatus struct {
+	ID       int               `json:"id"`
+	Topic    string            `json:"topic"`
+	Answered int               `json:"answered"`
+	Correct  int               `json:"correct"`
+	Ability  float64           `json:"ability"`
+	StdError float64           `json:"std_error"`
+	Level    string            `json:"level"` // The estimate in words: Easy, Medium or Hard
+	Finished bool              `json:"finished"`
+	Question *adaptiveQuestion `json:"question,omitempty"` // Waiting for an answer
+	// Once finished: chance of getting each difficulty right, and the quiz in their history
+	Chances map[string]float64 `json:"chances,omitempty"`
+	QuizID  int                `json:"quiz_id,omitempty"`
+}
+
+func newAdaptiveStatus(q *AdaptiveQuiz) adaptiveStatus {
+	st := adaptiveStatus{
+		ID:       q.ID,
+		Topic:    q.Topic,
+		Answered: q.answered(),
+		Ability:  math.Round(q.Ability*100) / 100,
+		StdError: math.Round(q.StdError*100) / 100,
+		Level:    abilityLevel(q.Ability),
+		Finished: q.Finished,
+		QuizID:   q.QuizID,
+	}
+	for _, item := range q.Items {
+		if item.Correct {
+			st.Correct++
+		}
+	}
+	if n := len(q.Items); n > 0 && !q.Items[n-1].Answered && !q.Finished {
+		item := q.Items[n-1]
+		qq := item.Question
+		st.Question = &adaptiveQuestion{Index: n - 1, Type: qq.Type, Format: qq.Format, Question: qq.Question, Options: qq.Options, Unit: qq.Unit, ImageID: qq.ImageID, Level: item.Level}
+		for _, p := range qq.Pairs {
+			st.Question.Left = append(st.Question.Left, p.Left)
+		}
+	}
+	if q.Finished {
+		st.Chances = map[string]float64{}
+		for _, l := range adaptiveLevels {
+			st.Chances[l.Name] = math.Round(rasch(q.Ability, l.Difficulty)*100) / 100
+		}
+	}
+	return st
+}
+
+// Turn a failure to get a question into a response
+func adaptiveQuestionError(w http.ResponseWriter, r *http.Request, err error) {
+	logFor(r.Context()).Warn("could not get an adaptive question", "error", err)
+	var llmErr *LLMError
+	if errors.As(err, &llmErr) {
+		httpError(w, r, llmErr.UserMessage(), llmErr.HTTPStatus())
+		return
+	}
+	httpError(w, r, msgNextQuestionFailed, http.StatusInternalServerError)
+}
+
+// ----------- Endpoints -----------
+
+// Start an adaptive quiz: POST /api/adaptive/start {"topic": "...", "quizType": "Multiple Choice"}
+// Answers with the first question.
+func (a *App) handleAdaptiveStart(w http.ResponseWriter, r *http.Request, user *User) {
+	if r.Method != http.MethodPost {
+		httpError(w, r, msgMethodNotAllowed, http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req QuizRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, r, msgInvalidRequest, http.StatusBadRequest)
+		return
+	}
+	req.Topic = firstRunes(strings.TrimSpace(req.Topic), 200)
+	if req.Topic == "" {
+		httpError(w, r, msgAdaptiveNeedsTopic, http.StatusBadRequest)
+		return
+	}
+	if req.Source != "" {
+		httpError(w, r, msgAdaptiveNoDocument, http.StatusBadRequest)
+		return
+	}
+	if req.QuizType == "" {
+		req.QuizType = "Multiple Choice"
+	}
+	language, ok := normalizeLanguage(req.Language)
+	if !ok {
+		httpError(w, r, msgUnsupportedLanguage, http.StatusBadRequest, req.Language)
+		return
+	}
+	req.Language = language
+	if !a.llm.Configured() {
+		httpError(w, r, msgNoAPIKey, http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now()
+	q := &AdaptiveQuiz{UserID: user.ID, Topic: req.Topic, QuizType: req.QuizType, Language: req.Language, StdError: 1, CreatedAt: now, UpdatedAt: now}
+	item, err := a.nextAdaptiveQuestion(r.Context(), logFor(r.Context()), q, nearestLevel(0))
+	if err != nil {
+		adaptiveQuestionError(w, r, err)
+		return
+	}
+	q.Items = append(q.Items, item)
+	if q.ID, err = a.store.CreateAdaptive(r.Context(), q); err != nil {
+		logFor(r.Context()).Error("could not save adaptive quiz", "error", err)
+		httpError(w, r, msgDatabaseError, http.StatusInternalServerError)
+		return
+	}
+	logFor(r.Context()).Info("adaptive quiz started", "adaptive_id", q.ID)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(newAdaptiveStatus(q))
+}
+
+// Where an adaptive quiz is: GET /api/adaptive?id=3
+func (a *App) handleAdaptiveStatus(w http.ResponseWriter, r *http.Request, user *User) {
+	if r.Method != http.MethodGet {
+		httpError(w, r, msgMethodNotAllowed, http.StatusMethodNotAllowed)
+		return
+	}
+	id, _ := strconv.Atoi(r.URL.Query().Get("id"))
+	q, err := a.store.GetAdaptive(r.Context(), user.ID, id)
+	if errors.Is(err, ErrNotFound) {
+		httpError(w, r, msgAdaptiveNotFound, http.StatusNotFound)
+		return
+	} else if err != nil {
+		httpError(w, r, msgDatabaseError, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newAdaptiveStatus(q))
+}
+
+// Answer the waiting question: POST /api/adaptive/answer {"id": 3, "answer": "Paris"}
+// Says whether it was right, and either asks the next question or reports
+// the learner's level. If the next question can't be had the answer still
+// counts, and POST /api/adaptive/next tries again.
+func (a *App) handleAdaptiveAnswer(w http.ResponseWriter, r *http.Request, user *User) {
+	if r.Method != http.MethodPost {
+		httpError(w, r, msgMethodNotAllowed, http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		ID     int        `json:"id"`
+		Answer AnswerText `json:"answer"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, r, msgInvalidRequest, http.StatusBadRequest)
+		return
+	}
+	log := logFor(r.Context()).With("adaptive_id", req.ID)
+
+	q, err := a.store.GetAdaptive(r.Context(), user.ID, req.ID)
+	if errors.Is(err, ErrNotFound) {
+		httpError(w, r, msgAdaptiveNotFound, http.StatusNotFound)
+		return
+	} else if err != nil {
+		httpError(w, r, msgDatabaseError, http.StatusInternalServerError)
+		return
+	}
+	n := len(q.Items)
+	if q.Finished || n == 0 || q.Items[n-1].Answered {
+		httpError(w, r, msgNoQuestionWaiting, http.StatusConflict)
+		return
+	}
+
+	// Mark it and update the estimate
+	revision := q.revision()
+	item := &q.Items[n-1]
+	item.Answered = true
+	item.Answer = firstRunes(string(req.Answer), 1000)
+	item.Correct = gradeAnswer(item.Question, string(req.Answer))
+	q.Ability, q.StdError = estimateAbility(q.Items)
+	q.Finished = a.adaptiveDone(q)
+	q.UpdatedAt = time.Now()
+	if err := a.store.UpdateAdaptive(r.Context(), q, revision); errors.Is(err, ErrAlreadyAnswered) {
+		httpError(w, r, msgAlreadyAnswered, http.StatusConflict)
+		return
+	} else if err != nil {
+		log.Error("could not save adaptive answer", "error", err)
+		httpError(w, r, msgDatabaseError, http.StatusInternalServerError)
+		return
+	}
+
+	result := map[string]interface{}{
+		"correct":       item.Correct,
+		"correctAnswer": item.Question.CorrectAnswer,
+		"explanation":   item.Question.Explanation,
+	}
+	if q.Finished {
+		if quizID, err := a.saveAdaptiveQuiz(r.Context(), log, q); err != nil {
+			log.Warn("could not save finished adaptive quiz to history", "error", err)
+		} else {
+			q.QuizID = quizID
+			if err := a.store.UpdateAdaptive(r.Context(), q, q.revision()); err != nil {
+				log.Warn("could not link adaptive quiz to history", "quiz_id", quizID, "error", err)
+			}
+		}
+		log.Info("adaptive quiz finished", "questions", q.answered(), "ability", q.Ability, "std_error", q.StdError)
+	} else if err := a.askNextAdaptive(r.Context(), log, q); err != nil {
+		log.Warn("could not get the next adaptive question", "error", err)
+		result["next_error"] = msgNextQuestionRetry.text(requestLanguage(r))
+	}
+
+	result["status"] = newAdaptiveStatus(q)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// Ask the next question if one is due: POST /api/adaptive/next {"id": 3}
+// Only needed when fetching it after an answer failed.
+func (a *App) handleAdaptiveNext(w http.ResponseWriter, r *http.Request, user *User) {
+	if r.Method != http.MethodPost {
+		httpError(w, r, msgMethodNotAllowed, http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		ID int `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, r, msgInvalidRequest, http.StatusBadRequest)
+		return
+	}
+
+	q, err := a.store.GetAdaptive(r.Context(), user.ID, req.ID)
+	if errors.Is(err, ErrNotFound) {
+		httpError(w, r, msgAdaptiveNotFound, http.StatusNotFound)
+		return
+	} else if err != nil {
+		httpError(w, r, msgDatabaseError, http.StatusInternalServerError)
+		return
+	}
+	if n := len(q.Items); !q.Finished && (n == 0 || q.Items[n-1].Answered) {
+		if err := a.askNextAdaptive(r.Context(), logFor(r.Context()).With("adaptive_id", q.ID), q); errors.Is(err, ErrAlreadyAnswered) {
+			q, err = a.store.GetAdaptive(r.Context(), user.ID, req.ID) // Someone else asked it first
+			if err != nil {
+				httpError(w, r, msgDatabaseError, http.StatusInternalServerError)
+				return
+			}
+		} else if err != nil {
+			adaptiveQuestionError(w, r, err)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newAdaptiveStatus(q))
+}
+
+// Pick the next question for the current estimate and save it as waiting
+func (a *App) askNextAdaptive(ctx context.Context, log *slog.Logger, q *AdaptiveQuiz) error {
+	item, err := a.nextAdaptiveQuestion(ctx, log, q, nearestLevel(q.Ability))
+	if err != nil {
+		return err
+	}
+	revision := q.revision()
+	q.Items = append(q.Items, item)
+	q.UpdatedAt = time.Now()
+	if err := a.store.UpdateAdaptive(ctx, q, revision); err != nil {
+		q.Items = q.Items[:len(q.Items)-1]
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ============================================================================
+// ADMIN ENDPOINTS - Only for the accounts listed in admin.emails
+// ============================================================================
+
+// Is this user one of the configured admins?
+func (a *App) isAdmin(user *User) bool {
+	for _, email := range a.config.Admin.Emails {
+		if strings.EqualFold(email, user.Email) {
+			return true
+		}
+	}
+	return false
+}
+
+// Wrap handlers that only admins may use
+func (a *App) requireAdmin(handler func(http.ResponseWriter, *http.Request, *User)) http.HandlerFunc {
+	return a.requireAuth(func(w http.ResponseWriter, r *http.Request, user *User) {
+		if !a.isAdmin(user) {
+			httpError(w, r, msgForbidden, http.StatusForbidden)
+			return
+		}
+		handler(w, r, user)
+	})
+}
+
+// ----------- Prompt templates -----------
+
+// GET lists every prompt template; POST saves one to the database
+func (a *App) handleAdminPrompts(w http.ResponseWriter, r *http.Request, user *User) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"templates": a.prompts.List(),
+			"pinned":    a.config.Prompts.Versions,
+		})
+	case http.MethodPost:
+		a.saveAdminPrompt(w, r, user)
+	default:
+		httpError(w, r, msgMethodNotAllowed, http.StatusMethodNotAllowed)
+	}
+}
+
+// Save a template (new version or replacement) and start using it straight away
+func (a *App) saveAdminPrompt(w http.ResponseWriter, r *http.Request, user *User) {
+	var p PromptTemplate
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		httpError(w, r, msgInvalidRequest, http.StatusBadRequest)
+		return
+	}
+	p.Source = promptSourceDatabase
+
+	// Check it parses and renders before it goes anywhere near the database
+	if err := checkPrompt(p); err != nil {
+		httpError(w, r, msgInvalidPrompt, http.StatusBadRequest, err)
+		return
+	}
+	// Every version starts with its default variant, which the others fall back to
+	if p.Variant != "default" && !a.prompts.hasVersion(p.Name, p.Version) {
+		httpError(w, r, msgSaveDefaultFirst, http.StatusBadRequest, p.Name, p.Version)
+		return
+	}
+	if err := a.store.SavePrompt(r.Context(), p); err != nil {
+		httpError(w, r, msgDatabaseError, http.StatusInternalServerError)
+		return
+	}
+	if err := a.prompts.Add(p); err != nil {
+		httpError(w, r, msgInvalidPrompt, http.StatusInternalServerError, err)
+		return
+	}
+	logFor(r.Context()).Info("prompt template saved", "prompt", p.Ref(), "admin", user.Email)
+
+	p.Body = ""
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(p)
+}
+
+// Show exactly what would be sent to the AI:
+// GET /api/admin/prompts/preview?name=quiz&version=1&quizType=True/False&language=es&topic=...&source=1
+func (a *App) handleAdminPromptPreview(w http.ResponseWriter, r *http.Request, user *User) {
+	if r.Method != http.MethodGet {
+		httpError(w, r, msgMethodNotAllowed, http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	valueOr := func(key, fallback string) string {
+		if v := q.Get(key); v != "" {
+			return v
+		}
+		return fallback
+	}
+	data := samplePromptData()
+	version, _ := strconv.Atoi(q.Get("version"))
+	count, err := strconv.Atoi(valueOr("questionCount", strconv.Itoa(data.QuestionCount)))
+	if err != nil {
+		httpError(w, r, msgQuestionCountNumber, http.StatusBadRequest)
+		return
+	}
+	data.Topic = valueOr("topic", data.Topic)
+	data.Difficulty = valueOr("difficulty", data.Difficulty)
+	data.QuestionCount = count
+	data.QuizType = valueOr("quizType", data.QuizType)
+	data.Language = q.Get("language")
+	data.LanguageName = languageName(data.Language)
+	data.HasSource = q.Get("source") != ""
+
+	prompt, err := a.prompts.RenderVersion(valueOr("name", "quiz"), version, data)
+	if err != nil {
+		httpError(w, r, msgPromptNotFound, http.StatusNotFound, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(prompt)
+}
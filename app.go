@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ============================================================================
+// APPLICATION STATE - Everything the handlers share
+// ============================================================================
+
+// The app itself: one storage backend, one session store, one AI client
+type App struct {
+	config   Config
+	store    Store
+	sessions *SessionStore
+	llm      *LLMClient
+	checker  *LLMClient // Answers questions blind to check the AI's answers (see verify.go)
+	embedder Embedder   // For spotting repeated questions
+	ocr      OCREngine  // Reads scanned pages and pictures; nil when there's no engine (see ocr.go)
+	metrics  *Metrics
+	prompts  *PromptLibrary
+	live     *liveHub      // Live multiplayer sessions being played right now
+	jobWake  chan struct{} // Wakes an idle worker when a job is queued
+
+	generations  sync.WaitGroup // Generation workers, waited on at shutdown
+	shuttingDown atomic.Bool    // Set once SIGTERM arrives so /readyz turns away new traffic
+}
+
+// Open the database and get everything ready to serve requests
+func NewApp(cfg Config) (*App, error) {
+	store, err := openStore(cfg.Database.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	prompts, err := LoadPromptLibrary(context.Background(), cfg.Prompts.Dir, cfg.Prompts.Versions, store)
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+
+	metrics := NewMetrics()
+	llm := NewLLMClient(cfg.LLM, metrics)
+	checker := llm
+	if cfg.Verify.Model != "" && cfg.Verify.Model != cfg.LLM.Model {
+		checkerCfg := cfg.LLM
+		checkerCfg.Model = cfg.Verify.Model
+		checker = NewLLMClient(checkerCfg, metrics)
+	}
+	return &App{
+		config:   cfg,
+		store:    store,
+		sessions: NewSessionStore(time.Duration(cfg.Session.Lifetime)),
+		llm:      llm,
+		checker:  checker,
+		embedder: newEmbedder(cfg, llm),
+		ocr:      newOCREngine(cfg.OCR),
+		metrics:  metrics,
+		prompts:  prompts,
+		live:     newLiveHub(),
+		jobWake:  make(chan struct{}, 1),
+	}, nil
+}
+
+// Release the database connections
+func (a *App) Close() error {
+	return a.store.Close()
+}
+
+// ============================================================================
+// SESSION STORE - Who is logged in
+// ============================================================================
+
+// Track logged-in users: session_id -> user_id (safe to use from many requests at once)
+type SessionStore struct {
+	mu       sync.RWMutex
+	lifetime time.Duration
+	sessions map[string]session
+}
+
+// One logged-in browser
+type session struct {
+	userID  int
+	expires time.Time
+}
+
+// Make an empty session store whose sessions last for the given time
+func NewSessionStore(lifetime time.Duration) *SessionStore {
+	return &SessionStore{lifetime: lifetime, sessions: map[string]session{}}
+}
+
+// Start a new session for a user and return its ID
+func (s *SessionStore) Create(userID int) string {
+	sessionID := uuid.New().String()
+	s.mu.Lock()
+	s.sessions[sessionID] = session{userID: userID, expires: time.Now().Add(s.lifetime)}
+	s.mu.Unlock()
+	return sessionID
+}
+
+// Find which user a session belongs to (expired sessions don't count)
+func (s *SessionStore) Get(sessionID string) (int, bool) {
+	s.mu.RLock()
+	sess, ok := s.sessions[sessionID]
+	s.mu.RUnlock()
+	if !ok {
+		return 0, false
+	}
+	if time.Now().After(sess.expires) {
+		s.Delete(sessionID)
+		return 0, false
+	}
+	return sess.userID, true
+}
+
+// End a session (logout)
+func (s *SessionStore) Delete(sessionID string) {
+	s.mu.Lock()
+	delete(s.sessions, sessionID)
+	s.mu.Unlock()
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ============================================================================
+// QUESTION BANK - Every saved question, searchable and reusable
+// ============================================================================
+//
+// When a quiz is saved its questions are also copied into the owner's
+// question bank, with the quiz's topic and difficulty and each question's
+// type. People can tag them, search them, and put together new quizzes from
+// them by hand or by random sampling.
+
+// Limits for bank searches and bank-built quizzes
+const (
+	bankDefaultLimit = 20
+	bankMaxLimit     = 100
+	bankMaxTags      = 20
+)
+
+// Markers for the owner to review in the quiz a question came from (see
+// dedupQuiz and verify.go). They don't follow the question into the bank.
+var reviewOnlyFields = []string{"duplicate", "lowConfidence"}
+
+// Split a quiz's questions into bank entries. Questions that aren't JSON
+// objects are skipped; everything else is kept as it was, less the
+// review-only markers.
+func bankEntries(questionsJSON, topic, difficulty string) []BankQuestion {
+	var raw []json.RawMessage
+	if err := json.Unmarshal([]byte(questionsJSON), &raw); err != nil {
+		return nil
+	}
+	var entries []BankQuestion
+	for _, r := range raw {
+		var q QuizQuestion
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(r, &q); err != nil || strings.TrimSpace(q.Question) == "" {
+			continue
+		}
+		if err := json.Unmarshal(r, &fields); err != nil {
+			continue
+		}
+		for _, f := range reviewOnlyFields {
+			delete(fields, f)
+		}
+		question, _ := json.Marshal(fields)
+		entries = append(entries, BankQuestion{
+			Topic:      firstRunes(strings.TrimSpace(topic), 200),
+			Difficulty: difficulty,
+			Type:       questionType(q),
+			Tags:       []string{},
+			Question:   question,
+		})
+	}
+	return entries
+}
+
+// A question's own type - a "Mixed" quiz has all three
+func questionType(q QuizQuestion) string {
+	return kindName(kindOf(q))
+}
+
+// Copy a newly saved quiz's questions into its owner's bank. The quiz is
+// already saved, so a failure here is logged rather than passed on.
+func (a *App) addQuizToBank(ctx context.Context, log *slog.Logger, userID, quizID int, questionsJSON, topic, difficulty string) {
+	if err := a.store.AddToBank(ctx, userID, quizID, bankEntries(questionsJSON, topic, difficulty)); err != nil {
+		log.Warn("could not add questions to the bank", "quiz_id", quizID, "error", err)
+	}
+}
+
+// Tags are lowercase words joined by dashes: "Cell Biology" -> "cell-biology"
+var nonTagChars = regexp.MustCompile(`[^\p{L}\p{N}]+`)
+
+func normalizeTags(tags []string) []string {
+	seen := map[string]bool{}
+	result := []string{}
+	for _, t := range tags {
+		t = strings.Trim(nonTagChars.ReplaceAllString(strings.ToLower(t), "-"), "-")
+		if t == "" || seen[t] || len(result) == bankMaxTags {
+			continue
+		}
+		seen[t] = true
+		result = append(result, firstRunes(t, 50))
+	}
+	return result
+}
+
+// ----------- Endpoints -----------
+
+// Search the bank:
+// GET /api/bank?q=photosynthesis&topic=biology&difficulty=Easy&type=True/False&tag=exam&random=1&limit=20
+func (a *App) handleBank(w http.ResponseWriter, r *http.Request, user *User) {
+	if r.Method != http.MethodGet {
+		httpError(w, r, msgMethodNotAllowed, http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	limit := bankDefaultLimit
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > bankMaxLimit {
+			httpError(w, r, msgBankLimit, http.StatusBadRequest, bankMaxLimit)
+			return
+		}
+		limit = n
+	}
+	var tag string
+	if tags := normalizeTags([]string{q.Get("tag")}); len(tags) > 0 {
+		tag = tags[0]
+	}
+
+	questions, err := a.store.SearchBank(r.Context(), user.ID, BankFilter{
+		Query:      q.Get("q"),
+		Topic:      q.Get("topic"),
+		Difficulty: q.Get("difficulty"),
+		Type:       q.Get("type"),
+		Tag:        tag,
+		Random:     q.Get("random") == "1" || q.Get("random") == "true",
+		Limit:      limit,
+	})
+	if err != nil {
+		logFor(r.Context()).Error("question bank search failed", "error", err)
+		httpError(w, r, msgDatabaseError, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(questions)
+}
+
+// Replace a question's tags: POST /api/bank/tags {"id": 12, "tags": ["exam", "chapter 3"]}
+func (a *App) handleBankTags(w http.ResponseWriter, r *http.Request, user *User) {
+	if r.Method != http.MethodPost {
+		httpError(w, r, msgMethodNotAllowed, http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		ID   int      `json:"id"`
+		Tags []string `json:"tags"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, r, msgInvalidRequest, http.StatusBadRequest)
+		return
+	}
+
+	tags := normalizeTags(req.Tags)
+	err := a.store.SetBankTags(r.Context(), user.ID, req.ID, tags)
+	if errors.Is(err, ErrNotFound) {
+		httpError(w, r, msgQuestionNotFound, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		httpError(w, r, msgDatabaseError, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"id": req.ID, "tags": tags})
+}
+
+// What to build a quiz from: hand-picked question IDs, or a random sample
+type bankQuizRequest struct {
+	Title       string `json:"title"`
+	QuestionIDs []int  `json:"question_ids"`
+	Sample      *struct {
+		Count      int    `json:"count"`
+		Query      string `json:"q"`
+		Topic      string `json:"topic"`
+		Difficulty string `json:"difficulty"`
+		Type       string `json:"type"`
+		Tag        string `json:"tag"`
+	} `json:"sample"`
+}
+
+// Build and save a new quiz from bank questions:
+// POST /api/bank/quiz {"title": "...", "question_ids": [3, 7, 9]}
+// POST /api/bank/quiz {"title": "...", "sample": {"count": 10, "tag": "exam"}}
+func (a *App) handleBankQuiz(w http.ResponseWriter, r *http.Request, user *User) {
+	if r.Method != http.MethodPost {
+		httpError(w, r, msgMethodNotAllowed, http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req bankQuizRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, r, msgInvalidRequest, http.StatusBadRequest)
+		return
+	}
+
+	var picked []BankQuestion
+	var err error
+	switch {
+	case len(req.QuestionIDs) > 0 && req.Sample != nil:
+		httpError(w, r, msgBankPickOne, http.StatusBadRequest)
+		return
+	case len(req.QuestionIDs) > bankMaxLimit:
+		httpError(w, r, msgTooManyQuestions, http.StatusBadRequest)
+		return
+	case len(req.QuestionIDs) > 0:
+		picked, err = a.store.BankQuestions(r.Context(), user.ID, req.QuestionIDs)
+	case req.Sample != nil:
+		if req.Sample.Count < 1 || req.Sample.Count > bankMaxLimit {
+			httpError(w, r, msgBankSample, http.StatusBadRequest, bankMaxLimit)
+			return
+		}
+		var tag string
+		if tags := normalizeTags([]string{req.Sample.Tag}); len(tags) > 0 {
+			tag = tags[0]
+		}
+		picked, err = a.store.SearchBank(r.Context(), user.ID, BankFilter{
+			Query:      req.Sample.Query,
+			Topic:      req.Sample.Topic,
+			Difficulty: req.Sample.Difficulty,
+			Type:       req.Sample.Type,
+			Tag:        tag,
+			Random:     true,
+			Limit:      req.Sample.Count,
+		})
+	default:
+		httpError(w, r, msgBankPickNone, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		logFor(r.Context()).Error("could not read question bank", "error", err)
+		httpError(w, r, msgDatabaseError, http.StatusInternalServerError)
+		return
+	}
+	if len(picked) == 0 {
+		httpError(w, r, msgBankNoMatches, http.StatusNotFound)
+		return
+	}
+
+	// Cleaned again on the way out: bank entries saved before questions were
+	// sanitized on every save still hold whatever markup they came with
+	questions := make([]QuizQuestion, 0, len(picked))
+	for _, entry := range picked {
+		var q QuizQuestion
+		if err := json.Unmarshal(entry.Question, &q); err != nil {
+			continue
+		}
+		q.Duplicate, q.LowConfidence = nil, nil // Banked before these were kept out
+		questions = append(questions, q)
+	}
+	prepareQuestions(questions, "")
+	questionsJSON, _ := json.Marshal(questions)
+
+	title := strings.TrimSpace(req.Title)
+	if title == "" {
+		title = "Question bank quiz"
+	}
+	// Saved straight to quizzes - the questions are in the bank already
+	quizID, err := a.store.SaveQuiz(r.Context(), user.ID, firstRunes(title, 200), string(questionsJSON), "", "")
+	if err != nil {
+		httpError(w, r, msgSaveQuizFailed, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{"quiz_id": quizID, "questions": questions})
+}
+
+// The first n characters of s
+func firstRunes(s string, n int) string {
+	if r := []rune(s); len(r) > n {
+		return string(r[:n])
+	}
+	return s
+}
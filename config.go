@@ -0,0 +1,687 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ============================================================================
+// CONFIGURATION - Defaults, then config file, then environment, then flags
+// ============================================================================
+
+// Every setting the app understands
+type Config struct {
+	Server   ServerConfig   `json:"server"`
+	Database DatabaseConfig `json:"database"`
+	LLM      LLMConfig      `json:"llm"`
+	Uploads  UploadConfig   `json:"uploads"`
+	OCR      OCRConfig      `json:"ocr"`
+	Jobs     JobConfig      `json:"jobs"`
+	Cache    CacheConfig    `json:"cache"`
+	Dedup    DedupConfig    `json:"dedup"`
+	Verify   VerifyConfig   `json:"verify"`
+	Exams    ExamConfig     `json:"exams"`
+	Live     LiveConfig     `json:"live"`
+	Adaptive AdaptiveConfig `json:"adaptive"`
+	Prompts  PromptConfig   `json:"prompts"`
+	Admin    AdminConfig    `json:"admin"`
+	Session  SessionConfig  `json:"session"`
+	Features FeatureConfig  `json:"features"`
+	Log      LogConfig      `json:"log"`
+}
+
+// Where and how the web server listens
+type ServerConfig struct {
+	Addr            string   `json:"addr"`             // Listen address, e.g. ":5000"
+	TLSCert         string   `json:"tls_cert"`         // Certificate file - set both this and tls_key to serve HTTPS
+	TLSKey          string   `json:"tls_key"`          // Private key file
+	ReadTimeout     Duration `json:"read_timeout"`     // Time allowed to read a whole request (uploads included)
+	WriteTimeout    Duration `json:"write_timeout"`    // Time allowed to produce a response - must outlast llm.timeout
+	IdleTimeout     Duration `json:"idle_timeout"`     // How long keep-alive connections may sit unused
+	ShutdownTimeout Duration `json:"shutdown_timeout"` // How long to let in-flight requests finish on SIGTERM
+}
+
+// Which database to use
+type DatabaseConfig struct {
+	URL string `json:"url"` // SQLite file path, or a postgres:// URL
+}
+
+// How we talk to the AI provider
+type LLMConfig struct {
+	Provider string   `json:"provider"` // Only "openai" for now (works with OpenAI-compatible servers via base_url)
+	Model    string   `json:"model"`    // Which AI to use: "gpt-4o"
+	APIKey   string   `json:"api_key"`  // Usually set with OPENAI_API_KEY rather than in a file
+	BaseURL  string   `json:"base_url"` // Chat completions URL
+	Timeout  Duration `json:"timeout"`  // Give up on a single AI call after this long
+
+	// How to ask for JSON: "json_schema" (structured outputs), "json_object"
+	// (JSON mode) or "none" (prompt only). We step down automatically if the
+	// provider rejects the one configured.
+	ResponseFormat string `json:"response_format"`
+
+	RetryBaseDelay  Duration `json:"retry_base_delay"` // First pause before retrying a failed call, doubled each time
+	RetryMaxDelay   Duration `json:"retry_max_delay"`  // Longest pause before a retry - a longer Retry-After means give up
+	BreakerFailures int      `json:"breaker_failures"` // Outages/timeouts in a row that make us stop calling the provider
+	BreakerCooldown Duration `json:"breaker_cooldown"` // How long to fail fast before trying the provider again
+}
+
+// Limits for uploaded documents
+type UploadConfig struct {
+	MaxBytes       int64  `json:"max_bytes"`        // Biggest upload we accept
+	Dir            string `json:"dir"`              // Where uploads are kept while we extract text
+	MaxSourceChars int    `json:"max_source_chars"` // How much of a document's text is sent to the AI
+	Suspicious     string `json:"suspicious"`       // What to do with documents that look like they hold instructions: warn or reject
+}
+
+// Reading text from scanned PDF pages and uploaded pictures
+type OCRConfig struct {
+	Engine    string   `json:"engine"`    // "tesseract" (runs the tesseract command; off if it isn't installed) or "off"
+	Command   string   `json:"command"`   // The tesseract program, looked up on the PATH if it's just a name
+	Languages string   `json:"languages"` // Tesseract languages to read, e.g. "eng" or "eng+deu"
+	Timeout   Duration `json:"timeout"`   // Time allowed to read one upload; pages not read by then are left out
+
+	MaxConcurrent int `json:"max_concurrent"` // How many pictures are read at once, across all uploads; the rest wait their turn
+}
+
+// Reusing generated questions for identical requests (switched on by features.cache)
+type CacheConfig struct {
+	TTL        Duration `json:"ttl"`         // How long a pool of questions is served before it's regenerated
+	PoolFactor int      `json:"pool_factor"` // Generate this many times the requested questions, and serve a different mix each time
+	MaxPool    int      `json:"max_pool"`    // Upper limit on a pool's size
+}
+
+// Catching generated questions that repeat each other or the user's earlier ones
+type DedupConfig struct {
+	Mode      string  `json:"mode"`      // "off", "flag" (mark duplicates) or "replace" (ask the AI for different questions)
+	Embedder  string  `json:"embedder"`  // "hashing" (local and free) or "openai" (embeddings API, falls back to hashing)
+	Model     string  `json:"model"`     // Embedding model for the openai embedder
+	URL       string  `json:"url"`       // Embeddings endpoint; empty means next to llm.base_url
+	Threshold float64 `json:"threshold"` // Cosine similarity from which two questions count as the same; 0 means the embedder's default
+	History   int     `json:"history"`   // How many of the user's latest bank questions to compare against
+}
+
+// Checking the AI's answers with a second call before a quiz is handed over
+type VerifyConfig struct {
+	Mode  string `json:"mode"`  // "off", "flag" (mark answers the checker disagrees with as low-confidence) or "regenerate" (replace those questions)
+	Model string `json:"model"` // Model that does the checking; empty means llm.model
+}
+
+// Timed quizzes, where the server keeps the clock
+type ExamConfig struct {
+	Grace         Duration `json:"grace"`          // Answers this late still count, to allow for slow networks
+	SweepInterval Duration `json:"sweep_interval"` // How often attempts that ran out of time are submitted
+	MaxTimeLimit  Duration `json:"max_time_limit"` // Longest time limit a quiz can have
+}
+
+// Live multiplayer sessions (switched on by features.live)
+type LiveConfig struct {
+	QuestionTime Duration `json:"question_time"` // Default time to answer each question; hosts can pick 5s to 2m
+	MaxPlayers   int      `json:"max_players"`   // Players allowed in one session
+	MaxSessions  int      `json:"max_sessions"`  // Sessions running at once on this server
+	LobbyTimeout Duration `json:"lobby_timeout"` // Sessions that haven't started by then are closed
+}
+
+// When adaptive quizzes stop asking questions
+type AdaptiveConfig struct {
+	MinQuestions int     `json:"min_questions"` // Always ask at least this many
+	MaxQuestions int     `json:"max_questions"` // Never ask more than this many
+	TargetError  float64 `json:"target_error"`  // Stop once the ability estimate's standard error is this small
+}
+
+// Background workers that generate quizzes
+type JobConfig struct {
+	Workers      int      `json:"workers"`       // How many generations run at once
+	Retries      int      `json:"retries"`       // Extra AI calls a job gets after a failed one
+	PollInterval Duration `json:"poll_interval"` // How often idle workers check the queue
+	Lease        Duration `json:"lease"`         // How long a running job stays claimed after its worker last checked in
+}
+
+// Where prompt templates come from beyond the built-in ones
+type PromptConfig struct {
+	Dir      string         `json:"dir"`      // Folder of <name>/v<version>/<variant>.tmpl files that override the built-in prompts
+	Versions map[string]int `json:"versions"` // Pin a prompt to a version, e.g. {"quiz": 1} - otherwise the newest is used
+}
+
+// Who can use the /api/admin endpoints
+type AdminConfig struct {
+	Emails []string `json:"emails"` // Accounts with admin rights
+}
+
+// How long people stay logged in and how their cookie behaves
+type SessionConfig struct {
+	Lifetime       Duration `json:"lifetime"`        // Session length, e.g. "720h" for 30 days
+	CookieSecure   bool     `json:"cookie_secure"`   // Only send cookies over HTTPS
+	CookieSameSite string   `json:"cookie_samesite"` // "lax", "strict" or "none"
+}
+
+// Parts of the app that can be switched off
+type FeatureConfig struct {
+	Signup    bool `json:"signup"`     // Let new people create accounts
+	Uploads   bool `json:"uploads"`    // Allow document uploads
+	APITokens bool `json:"api_tokens"` // Let users mint personal API tokens
+	Metrics   bool `json:"metrics"`    // Serve Prometheus metrics on /metrics
+	Cache     bool `json:"cache"`      // Reuse generated questions for identical quiz requests
+	Live      bool `json:"live"`       // Let users host live multiplayer sessions
+}
+
+// How much we log and in what shape
+type LogConfig struct {
+	Level  string `json:"level"`  // debug, info, warn or error
+	Format string `json:"format"` // "json" for log collectors, "text" for reading in a terminal
+}
+
+// A time.Duration that reads and writes as "30s", "5m", "720h" in JSON
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"30s\": %w", err)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// The settings we use when nothing else is said
+func defaultConfig() Config {
+	return Config{
+		Server: ServerConfig{
+			Addr:            ":5000",
+			ReadTimeout:     Duration(30 * time.Second),
+			WriteTimeout:    Duration(3 * time.Minute), // Quiz generation happens inside the request
+			IdleTimeout:     Duration(2 * time.Minute),
+			ShutdownTimeout: Duration(3 * time.Minute),
+		},
+		Database: DatabaseConfig{URL: "askify.db"},
+		LLM: LLMConfig{
+			Provider: "openai",
+			Model:    "gpt-4o",
+			BaseURL:  openAIChatURL,
+			Timeout:  Duration(2 * time.Minute), // A 20-question quiz can take a while
+
+			ResponseFormat: "json_schema",
+
+			RetryBaseDelay:  Duration(time.Second),
+			RetryMaxDelay:   Duration(time.Minute),
+			BreakerFailures: 5,
+			BreakerCooldown: Duration(30 * time.Second),
+		},
+		Uploads: UploadConfig{MaxBytes: 10 << 20, Dir: "uploads", MaxSourceChars: 20000, Suspicious: sourceWarn}, // 10MB
+		OCR:     OCRConfig{Engine: "tesseract", Command: "tesseract", Languages: "eng", Timeout: Duration(time.Minute), MaxConcurrent: 2},
+		Jobs:    JobConfig{Workers: 2, Retries: 2, PollInterval: Duration(time.Second), Lease: Duration(time.Minute)},
+		Cache:   CacheConfig{TTL: Duration(24 * time.Hour), PoolFactor: 2, MaxPool: 30},
+		Dedup: DedupConfig{
+			Mode:     dedupFlag,
+			Embedder: "hashing",
+			Model:    "text-embedding-3-small",
+			History:  200,
+		},
+		Verify: VerifyConfig{Mode: verifyOff},
+		Exams:  ExamConfig{Grace: Duration(2 * time.Second), SweepInterval: Duration(30 * time.Second), MaxTimeLimit: Duration(4 * time.Hour)},
+		Live: LiveConfig{
+			QuestionTime: Duration(20 * time.Second),
+			MaxPlayers:   50,
+			MaxSessions:  100,
+			LobbyTimeout: Duration(30 * time.Minute),
+		},
+		Adaptive: AdaptiveConfig{MinQuestions: 5, MaxQuestions: 20, TargetError: 0.5},
+		Session:  SessionConfig{Lifetime: Duration(30 * 24 * time.Hour), CookieSameSite: "lax"},
+		Features: FeatureConfig{
+			Signup:    true,
+			Uploads:   true,
+			APITokens: true,
+			Metrics:   true,
+			Live:      true,
+		},
+		Log: LogConfig{Level: "info", Format: "json"},
+	}
+}
+
+// Environment variables we read, and which setting each one fills in
+var configEnv = []struct {
+	name string
+	set  func(c *Config, v string) error
+}{
+	{"ASKIFY_ADDR", func(c *Config, v string) error { c.Server.Addr = v; return nil }},
+	{"PORT", func(c *Config, v string) error { c.Server.Addr = ":" + v; return nil }}, // Common on hosting platforms
+	{"ASKIFY_TLS_CERT", func(c *Config, v string) error { c.Server.TLSCert = v; return nil }},
+	{"ASKIFY_TLS_KEY", func(c *Config, v string) error { c.Server.TLSKey = v; return nil }},
+	{"ASKIFY_READ_TIMEOUT", func(c *Config, v string) error { return setDuration(&c.Server.ReadTimeout, v) }},
+	{"ASKIFY_WRITE_TIMEOUT", func(c *Config, v string) error { return setDuration(&c.Server.WriteTimeout, v) }},
+	{"ASKIFY_IDLE_TIMEOUT", func(c *Config, v string) error { return setDuration(&c.Server.IdleTimeout, v) }},
+	{"ASKIFY_SHUTDOWN_TIMEOUT", func(c *Config, v string) error { return setDuration(&c.Server.ShutdownTimeout, v) }},
+	{"DATABASE_URL", func(c *Config, v string) error { c.Database.URL = v; return nil }},
+	{"ASKIFY_LLM_PROVIDER", func(c *Config, v string) error { c.LLM.Provider = v; return nil }},
+	{"ASKIFY_LLM_MODEL", func(c *Config, v string) error { c.LLM.Model = v; return nil }},
+	{"OPENAI_API_KEY", func(c *Config, v string) error { c.LLM.APIKey = v; return nil }},
+	{"ASKIFY_LLM_BASE_URL", func(c *Config, v string) error { c.LLM.BaseURL = v; return nil }},
+	{"ASKIFY_LLM_TIMEOUT", func(c *Config, v string) error { return setDuration(&c.LLM.Timeout, v) }},
+	{"ASKIFY_LLM_RESPONSE_FORMAT", func(c *Config, v string) error { c.LLM.ResponseFormat = v; return nil }},
+	{"ASKIFY_LLM_RETRY_BASE_DELAY", func(c *Config, v string) error { return setDuration(&c.LLM.RetryBaseDelay, v) }},
+	{"ASKIFY_LLM_RETRY_MAX_DELAY", func(c *Config, v string) error { return setDuration(&c.LLM.RetryMaxDelay, v) }},
+	{"ASKIFY_LLM_BREAKER_FAILURES", func(c *Config, v string) error { return setInt(&c.LLM.BreakerFailures, v) }},
+	{"ASKIFY_LLM_BREAKER_COOLDOWN", func(c *Config, v string) error { return setDuration(&c.LLM.BreakerCooldown, v) }},
+	{"ASKIFY_UPLOAD_MAX_BYTES", func(c *Config, v string) error { return setInt64(&c.Uploads.MaxBytes, v) }},
+	{"ASKIFY_UPLOAD_DIR", func(c *Config, v string) error { c.Uploads.Dir = v; return nil }},
+	{"ASKIFY_UPLOAD_MAX_SOURCE_CHARS", func(c *Config, v string) error { return setInt(&c.Uploads.MaxSourceChars, v) }},
+	{"ASKIFY_UPLOAD_SUSPICIOUS", func(c *Config, v string) error { c.Uploads.Suspicious = v; return nil }},
+	{"ASKIFY_OCR_ENGINE", func(c *Config, v string) error { c.OCR.Engine = v; return nil }},
+	{"ASKIFY_OCR_COMMAND", func(c *Config, v string) error { c.OCR.Command = v; return nil }},
+	{"ASKIFY_OCR_LANGUAGES", func(c *Config, v string) error { c.OCR.Languages = v; return nil }},
+	{"ASKIFY_OCR_TIMEOUT", func(c *Config, v string) error { return setDuration(&c.OCR.Timeout, v) }},
+	{"ASKIFY_OCR_MAX_CONCURRENT", func(c *Config, v string) error { return setInt(&c.OCR.MaxConcurrent, v) }},
+	{"ASKIFY_JOB_WORKERS", func(c *Config, v string) error { return setInt(&c.Jobs.Workers, v) }},
+	{"ASKIFY_JOB_RETRIES", func(c *Config, v string) error { return setInt(&c.Jobs.Retries, v) }},
+	{"ASKIFY_JOB_POLL_INTERVAL", func(c *Config, v string) error { return setDuration(&c.Jobs.PollInterval, v) }},
+	{"ASKIFY_JOB_LEASE", func(c *Config, v string) error { return setDuration(&c.Jobs.Lease, v) }},
+	{"ASKIFY_CACHE_TTL", func(c *Config, v string) error { return setDuration(&c.Cache.TTL, v) }},
+	{"ASKIFY_CACHE_POOL_FACTOR", func(c *Config, v string) error { return setInt(&c.Cache.PoolFactor, v) }},
+	{"ASKIFY_CACHE_MAX_POOL", func(c *Config, v string) error { return setInt(&c.Cache.MaxPool, v) }},
+	{"ASKIFY_DEDUP_MODE", func(c *Config, v string) error { c.Dedup.Mode = v; return nil }},
+	{"ASKIFY_DEDUP_EMBEDDER", func(c *Config, v string) error { c.Dedup.Embedder = v; return nil }},
+	{"ASKIFY_DEDUP_MODEL", func(c *Config, v string) error { c.Dedup.Model = v; return nil }},
+	{"ASKIFY_DEDUP_URL", func(c *Config, v string) error { c.Dedup.URL = v; return nil }},
+	{"ASKIFY_DEDUP_THRESHOLD", func(c *Config, v string) error { return setFloat(&c.Dedup.Threshold, v) }},
+	{"ASKIFY_DEDUP_HISTORY", func(c *Config, v string) error { return setInt(&c.Dedup.History, v) }},
+	{"ASKIFY_VERIFY_MODE", func(c *Config, v string) error { c.Verify.Mode = v; return nil }},
+	{"ASKIFY_VERIFY_MODEL", func(c *Config, v string) error { c.Verify.Model = v; return nil }},
+	{"ASKIFY_EXAM_GRACE", func(c *Config, v string) error { return setDuration(&c.Exams.Grace, v) }},
+	{"ASKIFY_EXAM_SWEEP_INTERVAL", func(c *Config, v string) error { return setDuration(&c.Exams.SweepInterval, v) }},
+	{"ASKIFY_EXAM_MAX_TIME_LIMIT", func(c *Config, v string) error { return setDuration(&c.Exams.MaxTimeLimit, v) }},
+	{"ASKIFY_LIVE_QUESTION_TIME", func(c *Config, v string) error { return setDuration(&c.Live.QuestionTime, v) }},
+	{"ASKIFY_LIVE_MAX_PLAYERS", func(c *Config, v string) error { return setInt(&c.Live.MaxPlayers, v) }},
+	{"ASKIFY_LIVE_MAX_SESSIONS", func(c *Config, v string) error { return setInt(&c.Live.MaxSessions, v) }},
+	{"ASKIFY_LIVE_LOBBY_TIMEOUT", func(c *Config, v string) error { return setDuration(&c.Live.LobbyTimeout, v) }},
+	{"ASKIFY_ADAPTIVE_MIN_QUESTIONS", func(c *Config, v string) error { return setInt(&c.Adaptive.MinQuestions, v) }},
+	{"ASKIFY_ADAPTIVE_MAX_QUESTIONS", func(c *Config, v string) error { return setInt(&c.Adaptive.MaxQuestions, v) }},
+	{"ASKIFY_ADAPTIVE_TARGET_ERROR", func(c *Config, v string) error { return setFloat(&c.Adaptive.TargetError, v) }},
+	{"ASKIFY_PROMPTS_DIR", func(c *Config, v string) error { c.Prompts.Dir = v; return nil }},
+	{"ASKIFY_ADMIN_EMAILS", func(c *Config, v string) error { c.Admin.Emails = splitList(v); return nil }},
+	{"ASKIFY_SESSION_LIFETIME", func(c *Config, v string) error { return setDuration(&c.Session.Lifetime, v) }},
+	{"COOKIE_SECURE", func(c *Config, v string) error { return setBool(&c.Session.CookieSecure, v) }},
+	{"COOKIE_SAMESITE", func(c *Config, v string) error { c.Session.CookieSameSite = v; return nil }},
+	{"ASKIFY_FEATURE_SIGNUP", func(c *Config, v string) error { return setBool(&c.Features.Signup, v) }},
+	{"ASKIFY_FEATURE_UPLOADS", func(c *Config, v string) error { return setBool(&c.Features.Uploads, v) }},
+	{"ASKIFY_FEATURE_API_TOKENS", func(c *Config, v string) error { return setBool(&c.Features.APITokens, v) }},
+	{"ASKIFY_FEATURE_METRICS", func(c *Config, v string) error { return setBool(&c.Features.Metrics, v) }},
+	{"ASKIFY_FEATURE_CACHE", func(c *Config, v string) error { return setBool(&c.Features.Cache, v) }},
+	{"ASKIFY_FEATURE_LIVE", func(c *Config, v string) error { return setBool(&c.Features.Live, v) }},
+	{"ASKIFY_LOG_LEVEL", func(c *Config, v string) error { c.Log.Level = v; return nil }},
+	{"ASKIFY_LOG_FORMAT", func(c *Config, v string) error { c.Log.Format = v; return nil }},
+}
+
+func setDuration(d *Duration, v string) error {
+	parsed, err := time.ParseDuration(v)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+func setInt64(n *int64, v string) error {
+	parsed, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return err
+	}
+	*n = parsed
+	return nil
+}
+
+func setInt(n *int, v string) error {
+	parsed, err := strconv.Atoi(v)
+	if err != nil {
+		return err
+	}
+	*n = parsed
+	return nil
+}
+
+func setFloat(f *float64, v string) error {
+	parsed, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return err
+	}
+	*f = parsed
+	return nil
+}
+
+// Split a comma-separated list, dropping blanks
+func splitList(v string) []string {
+	var list []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+func setBool(b *bool, v string) error {
+	parsed, err := strconv.ParseBool(v)
+	if err != nil {
+		return err
+	}
+	*b = parsed
+	return nil
+}
+
+// Build the config: defaults, then the JSON file (-config or ASKIFY_CONFIG),
+// then environment variables (including .env), then command-line flags.
+func loadConfig(args []string) (Config, error) {
+	cfg := defaultConfig()
+
+	fs := flag.NewFlagSet("askify", flag.ContinueOnError)
+	configPath := fs.String("config", os.Getenv("ASKIFY_CONFIG"), "path to a JSON config file")
+	addr := fs.String("addr", "", "listen address, e.g. :5000")
+	dbURL := fs.String("db", "", "SQLite file path or postgres:// URL")
+	model := fs.String("model", "", "AI model to use")
+	tlsCert := fs.String("tls-cert", "", "TLS certificate file")
+	tlsKey := fs.String("tls-key", "", "TLS private key file")
+	if err := fs.Parse(args); err != nil {
+		return cfg, err
+	}
+
+	// Config file
+	if *configPath != "" {
+		f, err := os.Open(*configPath)
+		if err != nil {
+			return cfg, fmt.Errorf("config file: %w", err)
+		}
+		dec := json.NewDecoder(f)
+		dec.DisallowUnknownFields() // Catch typos instead of silently ignoring them
+		err = dec.Decode(&cfg)
+		f.Close()
+		if err != nil && !errors.Is(err, io.EOF) {
+			return cfg, fmt.Errorf("config file %s: %w", *configPath, err)
+		}
+	}
+
+	// Environment
+	for _, e := range configEnv {
+		if v, ok := os.LookupEnv(e.name); ok && v != "" {
+			if err := e.set(&cfg, v); err != nil {
+				return cfg, fmt.Errorf("%s: %w", e.name, err)
+			}
+		}
+	}
+
+	// Flags - only the ones actually given on the command line
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "addr":
+			cfg.Server.Addr = *addr
+		case "db":
+			cfg.Database.URL = *dbURL
+		case "model":
+			cfg.LLM.Model = *model
+		case "tls-cert":
+			cfg.Server.TLSCert = *tlsCert
+		case "tls-key":
+			cfg.Server.TLSKey = *tlsKey
+		}
+	})
+
+	return cfg, cfg.Validate()
+}
+
+// Check the settings make sense before we start serving
+func (c Config) Validate() error {
+	var problems []string
+	add := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if c.Server.Addr == "" {
+		add("server.addr must not be empty")
+	}
+	if (c.Server.TLSCert == "") != (c.Server.TLSKey == "") {
+		add("server.tls_cert and server.tls_key must be set together")
+	}
+	if c.Server.ReadTimeout <= 0 || c.Server.WriteTimeout <= 0 || c.Server.IdleTimeout <= 0 || c.Server.ShutdownTimeout <= 0 {
+		add("server timeouts must be positive")
+	}
+	if c.Server.WriteTimeout <= c.LLM.Timeout {
+		add("server.write_timeout (%s) must be longer than llm.timeout (%s) or generations get cut off",
+			time.Duration(c.Server.WriteTimeout), time.Duration(c.LLM.Timeout))
+	}
+	if c.Database.URL == "" {
+		add("database.url must not be empty")
+	}
+	if c.LLM.Provider != "openai" {
+		add("llm.provider %q is not supported (use \"openai\")", c.LLM.Provider)
+	}
+	if c.LLM.Model == "" {
+		add("llm.model must not be empty")
+	}
+	if !strings.HasPrefix(c.LLM.BaseURL, "http://") && !strings.HasPrefix(c.LLM.BaseURL, "https://") {
+		add("llm.base_url must be an http(s) URL")
+	}
+	if c.LLM.Timeout <= 0 {
+		add("llm.timeout must be positive")
+	}
+	if !slices.Contains(responseFormats, c.LLM.ResponseFormat) {
+		add("llm.response_format must be one of %s", strings.Join(responseFormats, ", "))
+	}
+	if c.LLM.RetryBaseDelay <= 0 || c.LLM.RetryMaxDelay < c.LLM.RetryBaseDelay {
+		add("llm.retry_base_delay must be positive and no longer than llm.retry_max_delay")
+	}
+	if c.LLM.BreakerFailures < 1 {
+		add("llm.breaker_failures must be at least 1")
+	}
+	if c.LLM.BreakerCooldown <= 0 {
+		add("llm.breaker_cooldown must be positive")
+	}
+	if c.Uploads.MaxBytes <= 0 {
+		add("uploads.max_bytes must be positive")
+	}
+	if c.Uploads.Dir == "" {
+		add("uploads.dir must not be empty")
+	}
+	if c.Uploads.MaxSourceChars < 1 {
+		add("uploads.max_source_chars must be at least 1")
+	}
+	if !slices.Contains(suspiciousSourcePolicies, c.Uploads.Suspicious) {
+		add("uploads.suspicious must be one of %s", strings.Join(suspiciousSourcePolicies, ", "))
+	}
+	if c.OCR.Engine != "tesseract" && c.OCR.Engine != "off" {
+		add("ocr.engine must be tesseract or off")
+	}
+	if c.OCR.Engine == "tesseract" && c.OCR.Command == "" {
+		add("ocr.command must not be empty")
+	}
+	if c.OCR.Timeout <= 0 {
+		add("ocr.timeout must be positive")
+	}
+	if c.OCR.MaxConcurrent < 1 {
+		add("ocr.max_concurrent must be at least 1")
+	}
+	if c.Jobs.Workers < 1 {
+		add("jobs.workers must be at least 1")
+	}
+	if c.Jobs.Retries < 0 {
+		add("jobs.retries must not be negative")
+	}
+	if c.Jobs.PollInterval <= 0 {
+		add("jobs.poll_interval must be positive")
+	}
+	if c.Jobs.Lease < Duration(3*time.Second) {
+		add("jobs.lease must be at least 3s")
+	}
+	if c.Cache.TTL <= 0 {
+		add("cache.ttl must be positive")
+	}
+	if c.Cache.PoolFactor < 1 {
+		add("cache.pool_factor must be at least 1")
+	}
+	if c.Cache.MaxPool < 1 {
+		add("cache.max_pool must be at least 1")
+	}
+	if !slices.Contains(dedupModes, c.Dedup.Mode) {
+		add("dedup.mode must be one of %s", strings.Join(dedupModes, ", "))
+	}
+	if c.Dedup.Embedder != "hashing" && c.Dedup.Embedder != "openai" {
+		add("dedup.embedder must be hashing or openai")
+	}
+	if c.Dedup.Threshold < 0 || c.Dedup.Threshold > 1 {
+		add("dedup.threshold must be between 0 and 1")
+	}
+	if c.Dedup.History < 0 {
+		add("dedup.history must not be negative")
+	}
+	if !slices.Contains(verifyModes, c.Verify.Mode) {
+		add("verify.mode must be one of %s", strings.Join(verifyModes, ", "))
+	}
+	if c.Exams.Grace < 0 {
+		add("exams.grace must not be negative")
+	}
+	if c.Exams.SweepInterval <= 0 {
+		add("exams.sweep_interval must be positive")
+	}
+	if c.Exams.MaxTimeLimit < Duration(time.Second) {
+		add("exams.max_time_limit must be at least 1s")
+	}
+	if c.Live.QuestionTime < Duration(liveMinQuestionTime) || c.Live.QuestionTime > Duration(liveMaxQuestionTime) {
+		add("live.question_time must be between %s and %s", liveMinQuestionTime, liveMaxQuestionTime)
+	}
+	if c.Live.MaxPlayers < 1 {
+		add("live.max_players must be at least 1")
+	}
+	if c.Live.MaxSessions < 1 {
+		add("live.max_sessions must be at least 1")
+	}
+	if c.Live.LobbyTimeout <= 0 {
+		add("live.lobby_timeout must be positive")
+	}
+	if c.Adaptive.MinQuestions < 1 || c.Adaptive.MaxQuestions < c.Adaptive.MinQuestions {
+		add("adaptive.min_questions must be at least 1 and no more than adaptive.max_questions")
+	}
+	if c.Adaptive.MaxQuestions > adaptiveMaxQuestions {
+		add("adaptive.max_questions must be at most %d", adaptiveMaxQuestions)
+	}
+	if c.Adaptive.TargetError <= 0 || c.Adaptive.TargetError >= 1 {
+		add("adaptive.target_error must be between 0 and 1")
+	}
+	for name, version := range c.Prompts.Versions {
+		if version < 1 {
+			add("prompts.versions.%s must be at least 1", name)
+		}
+	}
+	if c.Session.Lifetime <= 0 {
+		add("session.lifetime must be positive")
+	}
+	switch strings.ToLower(c.Session.CookieSameSite) {
+	case "lax", "strict":
+	case "none":
+		if !c.Session.CookieSecure {
+			add("session.cookie_samesite \"none\" requires session.cookie_secure")
+		}
+	default:
+		add("session.cookie_samesite must be lax, strict or none")
+	}
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.Log.Level)); err != nil {
+		add("log.level must be debug, info, warn or error")
+	}
+	if f := strings.ToLower(c.Log.Format); f != "json" && f != "text" {
+		add("log.format must be json or text")
+	}
+
+	if len(problems) > 0 {
+		return errors.New("invalid configuration:\n  " + strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// Cookie SameSite mode from the config string
+func (c Config) sameSite() http.SameSite {
+	switch strings.ToLower(c.Session.CookieSameSite) {
+	case "strict":
+		return http.SameSiteStrictMode
+	case "none":
+		return http.SameSiteNoneMode
+	default:
+		return http.SameSiteLaxMode
+	}
+}
+
+// Is the server serving HTTPS itself?
+func (c Config) tlsEnabled() bool {
+	return c.Server.TLSCert != "" && c.Server.TLSKey != ""
+}
+
+// Copy of the config that's safe to print (no secrets)
+func (c Config) redacted() Config {
+	if c.LLM.APIKey != "" {
+		c.LLM.APIKey = "***"
+	}
+	c.Database.URL = redactDSN(c.Database.URL)
+	return c
+}
+
+// A password given as a keyword (host=db password=secret, or 'quoted') or
+// as a URL parameter (?password=secret)
+var dsnPassword = regexp.MustCompile(`(?i)\bpassword=('(?:[^'\\]|\\.)*'|[^\s&]*)`)
+
+// Hide the passwords in a DSN before printing it: user:pass@ in a URL, and
+// password= in either form
+func redactDSN(dsn string) string {
+	dsn = dsnPassword.ReplaceAllStringFunc(dsn, func(m string) string {
+		key, _, _ := strings.Cut(m, "=")
+		return key + "=***"
+	})
+	scheme, rest, ok := strings.Cut(dsn, "://")
+	if !ok {
+		return dsn
+	}
+	authority, path := rest, ""
+	if i := strings.IndexAny(rest, "/?"); i >= 0 {
+		authority, path = rest[:i], rest[i:]
+	}
+	at := strings.LastIndex(authority, "@")
+	if at < 0 {
+		return dsn
+	}
+	user, _, hasPassword := strings.Cut(authority[:at], ":")
+	if !hasPassword {
+		return dsn
+	}
+	return scheme + "://" + user + ":***@" + authority[at+1:] + path
+}
+
+// Command-line entry point for "config print"
+func configCommand(args []string) int {
+	if len(args) == 0 || args[0] != "print" {
+		fmt.Fprintln(os.Stderr, "usage: askify config print [flags]")
+		return 2
+	}
+	cfg, err := loadConfig(args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(cfg.redacted())
+	return 0
+}
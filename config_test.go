@@ -0,0 +1,26 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// config print mustn't show a database password in any of the ways one can be given
+func TestRedactDSN(t *testing.T) {
+	for _, tc := range []struct{ dsn, want string }{
+		{"postgres://askify:s3cret@db:5432/askify?sslmode=disable", "postgres://askify:***@db:5432/askify?sslmode=disable"},
+		{"postgres://askify:p@ss@db/askify", "postgres://askify:***@db/askify"},
+		{"postgres://askify@db/askify?password=s3cret&sslmode=require", "postgres://askify@db/askify?password=***&sslmode=require"},
+		{"host=db user=askify password=s3cret dbname=askify", "host=db user=askify password=*** dbname=askify"},
+		{"host=db password='s3 cret' dbname=askify", "host=db password=*** dbname=askify"},
+		{"askify.db", "askify.db"},
+	} {
+		got := redactDSN(tc.dsn)
+		if got != tc.want {
+			t.Errorf("%s: got %s, want %s", tc.dsn, got, tc.want)
+		}
+		if strings.Contains(got, "s3") {
+			t.Errorf("%s: password left in %s", tc.dsn, got)
+		}
+	}
+}
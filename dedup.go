@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"math"
+)
+
+// ============================================================================
+// DUPLICATE QUESTIONS - Catching repeats within a quiz and across quizzes
+// ============================================================================
+//
+// Ask for several quizzes on one topic and the AI tends to come back with
+// the same handful of questions. Before a quiz is handed over, each question
+// is compared (by embedding similarity) with the others in the quiz and with
+// the user's most recent questions from their bank. In "flag" mode repeats
+// are marked so the page can say so; in "replace" mode the AI is asked for
+// different questions once, and anything still repeated is flagged.
+
+// Dedup modes
+const (
+	dedupOff     = "off"
+	dedupFlag    = "flag"
+	dedupReplace = "replace"
+)
+
+var dedupModes = []string{dedupOff, dedupFlag, dedupReplace}
+
+// Attached to a question that repeats an earlier one
+type DuplicateInfo struct {
+	Of         string  `json:"of"`         // The question it repeats
+	InQuiz     bool    `json:"inQuiz"`     // True if that's in the same quiz, false if from an earlier one
+	Similarity float64 `json:"similarity"` // Cosine similarity, 0 to 1
+}
+
+// Check a generated quiz for repeats and flag or replace them. Anything going
+// wrong here is logged and the quiz is passed through as it was - duplicates
+// are a nuisance, not a reason to fail the job.
+func (a *App) dedupQuiz(ctx context.Context, log *slog.Logger, userID int, req QuizRequest, quizJSON string) string {
+	if a.config.Dedup.Mode == dedupOff {
+		return quizJSON
+	}
+	var questions []QuizQuestion
+	if err := json.Unmarshal([]byte(quizJSON), &questions); err != nil {
+		return quizJSON
+	}
+
+	// The user's recent questions (anonymous users only have this quiz)
+	var earlier []QuizQuestion
+	if userID != 0 && a.config.Dedup.History > 0 {
+		bank, err := a.store.SearchBank(ctx, userID, BankFilter{Limit: a.config.Dedup.History})
+		if err != nil {
+			log.Warn("could not load earlier questions for duplicate check", "error", err)
+		}
+		for _, b := range bank {
+			var q QuizQuestion
+			if json.Unmarshal(b.Question, &q) == nil && q.Question != "" {
+				earlier = append(earlier, q)
+			}
+		}
+	}
+
+	d, err := a.newDeduper(ctx, earlier)
+	if err != nil {
+		log.Warn("duplicate check failed", "embedder", d.embedder.Name(), "error", err)
+		return quizJSON
+	}
+	repeats, err := d.check(ctx, questions)
+	if err != nil {
+		log.Warn("duplicate check failed", "embedder", d.embedder.Name(), "error", err)
+		return quizJSON
+	}
+
+	if len(repeats) > 0 && a.config.Dedup.Mode == dedupReplace {
+		repeats = a.replaceDuplicates(ctx, log, d, req, questions, repeats)
+	}
+	for i, dup := range repeats {
+		questions[i].Duplicate = dup
+		a.metrics.duplicateQuestions.Inc("flagged")
+	}
+	if len(repeats) > 0 {
+		log.Info("flagged duplicate questions", "count", len(repeats), "embedder", d.embedder.Name())
+	}
+
+	result, err := json.Marshal(questions)
+	if err != nil {
+		return quizJSON
+	}
+	return string(result)
+}
+
+// Ask the AI for new questions in place of the repeated ones. Returns the
+// repeats that are left (those with no usable replacement).
+func (a *App) replaceDuplicates(ctx context.Context, log *slog.Logger, d *deduper, req QuizRequest, questions []QuizQuestion, repeats map[int]*DuplicateInfo) map[int]*DuplicateInfo {
+	// Tell the AI what it's already used: this quiz, plus what got repeated
+	avoid := []string{}
+	for i, q := range questions {
+		if repeats[i] == nil {
+			avoid = append(avoid, q.Question)
+		} else if !repeats[i].InQuiz {
+			avoid = append(avoid, repeats[i].Of)
+		}
+	}
+
+	more := req
+	more.QuestionCount = len(repeats)
+	more.Avoid = avoid
+	moreJSON, _, err := a.generateVerified(ctx, log, more)
+	if err != nil {
+		log.Warn("could not generate replacement questions", "error", err)
+		return repeats
+	}
+	var replacements []QuizQuestion
+	if err := json.Unmarshal([]byte(moreJSON), &replacements); err != nil {
+		return repeats
+	}
+
+	// The deduper already holds the rest of the quiz, so replacements can't repeat it either
+	fresh, err := d.check(ctx, replacements)
+	if err != nil {
+		log.Warn("duplicate check of replacements failed", "error", err)
+		return repeats
+	}
+
+	next := 0
+	for i := range questions {
+		if repeats[i] == nil {
+			continue
+		}
+		for next < len(replacements) && fresh[next] != nil {
+			next++
+		}
+		if next == len(replacements) {
+			break
+		}
+		questions[i] = replacements[next]
+		delete(repeats, i)
+		a.metrics.duplicateQuestions.Inc("replaced")
+		next++
+	}
+	return repeats
+}
+
+// ----------- Similarity checks -----------
+
+// Compares questions against everything it has already seen
+type deduper struct {
+	embedder  Embedder
+	threshold float64  // From the config, or else the embedder's own
+	fixed     bool     // The threshold came from the config
+	history   int      // The first this many of seen are from earlier quizzes
+	seen      []string // What each question was compared by (see dedupText)
+	questions []string // And just its question text, which is what repeats point to
+	vectors   [][]float32
+}
+
+func (a *App) newDeduper(ctx context.Context, earlier []QuizQuestion) (*deduper, error) {
+	d := &deduper{embedder: a.embedder, threshold: a.config.Dedup.Threshold, fixed: a.config.Dedup.Threshold > 0}
+	if !d.fixed {
+		d.threshold = d.embedder.Threshold()
+	}
+	texts := make([]string, len(earlier))
+	questions := make([]string, len(earlier))
+	for i, q := range earlier {
+		texts[i], questions[i] = dedupText(q), q.Question
+	}
+	vectors, err := d.embed(ctx, texts)
+	if err != nil {
+		return d, err
+	}
+	d.seen, d.questions, d.vectors, d.history = texts, questions, vectors, len(earlier)
+	return d, nil
+}
+
+// Embed texts. If a remote embedder fails, this check carries on with the
+// local one - vectors from different embedders can't be compared, so
+// everything seen so far is embedded again.
+func (d *deduper) embed(ctx context.Context, texts []string) ([][]float32, error) {
+	vectors, err := d.embedder.Embed(ctx, texts)
+	if err == nil || d.embedder == localEmbedder {
+		return vectors, err
+	}
+	logFor(ctx).Warn("embeddings failed, using the local embedder", "embedder", d.embedder.Name(), "error", err)
+	d.embedder = localEmbedder
+	if !d.fixed {
+		d.threshold = d.embedder.Threshold()
+	}
+	if d.vectors, err = d.embedder.Embed(ctx, d.seen); err != nil {
+		return nil, err
+	}
+	return d.embedder.Embed(ctx, texts)
+}
+
+// What a question is compared by. Ordering and matching questions are
+// mostly told apart by their items ("Match each country with its capital"
+// can be asked many ways), so those count too.
+func dedupText(q QuizQuestion) string {
+	if kind := kindOf(q); kind == typeOrdering || kind == typeMatching {
+		return q.Question + " " + q.CorrectAnswer
+	}
+	return q.Question
+}
+
+// Find which questions repeat something already seen or an earlier question
+// in the same list (by index). Questions that aren't repeats are remembered.
+func (d *deduper) check(ctx context.Context, questions []QuizQuestion) (map[int]*DuplicateInfo, error) {
+	texts := make([]string, len(questions))
+	for i, q := range questions {
+		texts[i] = dedupText(q)
+	}
+	vectors, err := d.embed(ctx, texts)
+	if err != nil {
+		return nil, err
+	}
+
+	repeats := map[int]*DuplicateInfo{}
+	for i, v := range vectors {
+		best, bestAt := 0.0, -1
+		for j, seen := range d.vectors {
+			if sim := cosine(v, seen); sim > best {
+				best, bestAt = sim, j
+			}
+		}
+		if bestAt >= 0 && best >= d.threshold {
+			repeats[i] = &DuplicateInfo{
+				Of:         d.questions[bestAt],
+				InQuiz:     bestAt >= d.history,
+				Similarity: math.Round(best*100) / 100,
+			}
+			continue
+		}
+		d.seen = append(d.seen, texts[i])
+		d.questions = append(d.questions, questions[i].Question)
+		d.vectors = append(d.vectors, v)
+	}
+	return repeats, nil
+}
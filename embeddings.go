@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// ============================================================================
+// EMBEDDINGS - Turning questions into vectors we can compare
+// ============================================================================
+
+// Anything that can turn texts into vectors, where similar meaning gives a
+// high cosine similarity. Each returned vector is already normalized.
+type Embedder interface {
+	Embed(ctx context.Context, texts []string) ([][]float32, error)
+	Name() string
+	Threshold() float64 // Similarity from which two questions are usually the same one
+}
+
+// The embedder that always works: no network, no cost
+var localEmbedder Embedder = hashingEmbedder{dims: 512}
+
+// Pick the embedder from the dedup config. The OpenAI one needs an API key;
+// without it we quietly use the local one.
+func newEmbedder(cfg Config, llm *LLMClient) Embedder {
+	if cfg.Dedup.Embedder != "openai" || !llm.Configured() {
+		return localEmbedder
+	}
+	url := cfg.Dedup.URL
+	if url == "" {
+		url = strings.TrimSuffix(cfg.LLM.BaseURL, "/chat/completions") + "/embeddings"
+	}
+	return &openAIEmbedder{llm: llm, url: url, model: cfg.Dedup.Model}
+}
+
+// Cosine similarity of two normalized vectors
+func cosine(a, b []float32) float64 {
+	var dot float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+	}
+	return dot
+}
+
+func normalize(v []float32) []float32 {
+	var sum float64
+	for _, x := range v {
+		sum += float64(x) * float64(x)
+	}
+	if sum == 0 {
+		return v
+	}
+	n := float32(math.Sqrt(sum))
+	for i := range v {
+		v[i] /= n
+	}
+	return v
+}
+
+// ----------- Local hashing embedder -----------
+
+// Hashes words and word pairs into a fixed-size vector. It knows nothing
+// about meaning, so it only catches questions worded much the same way - but
+// that's what repeated generations mostly produce, and it's free and offline.
+type hashingEmbedder struct {
+	dims int
+}
+
+func (e hashingEmbedder) Name() string { return "hashing" }
+
+// Same question reworded ("What is..." / "Which city is...") scores about 0.8;
+// different questions on one topic ("capital of France" / "of Spain") under 0.5
+func (e hashingEmbedder) Threshold() float64 { return 0.75 }
+
+func (e hashingEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		v := make([]float32, e.dims)
+		words := embeddingWords(text)
+		for j, w := range words {
+			e.add(v, w, 1)
+			if j > 0 {
+				e.add(v, words[j-1]+" "+w, 0.5) // Pairs keep a little word order
+			}
+		}
+		vectors[i] = normalize(v)
+	}
+	return vectors, nil
+}
+
+// Add a feature, with the hash deciding both its slot and its sign
+func (e hashingEmbedder) add(v []float32, feature string, weight float32) {
+	h := fnv.New64a()
+	h.Write([]byte(feature))
+	sum := h.Sum64()
+	if sum>>63 == 1 {
+		weight = -weight
+	}
+	v[sum%uint64(e.dims)] += weight
+}
+
+// Lowercase words, leaving out the ones every question has
+func embeddingWords(text string) []string {
+	var words []string
+	for _, w := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	}) {
+		if !stopWords[w] && !shortStopWords[w] {
+			words = append(words, w)
+		}
+	}
+	return words
+}
+
+// Short words that say nothing about what a question asks (the longer ones
+// are shared with the document grounding check)
+var shortStopWords = map[string]bool{
+	"a": true, "an": true, "the": true, "of": true, "in": true, "on": true, "at": true,
+	"to": true, "is": true, "are": true, "was": true, "be": true, "by": true, "for": true,
+	"and": true, "or": true, "it": true, "its": true, "as": true, "do": true, "how": true,
+	"who": true, "why": true, "not": true, "can": true, "has": true, "had": true,
+}
+
+// ----------- OpenAI embeddings -----------
+
+// Calls the provider's embeddings endpoint
+type openAIEmbedder struct {
+	llm   *LLMClient // For the API key, HTTP client and metrics
+	url   string
+	model string
+}
+
+func (e *openAIEmbedder) Name() string { return "openai:" + e.model }
+
+func (e *openAIEmbedder) Threshold() float64 { return 0.88 }
+
+func (e *openAIEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	if len(texts) == 0 {
+		return nil, nil
+	}
+	start := time.Now()
+	body, _ := json.Marshal(map[string]interface{}{"model": e.model, "input": texts})
+	req, err := http.NewRequestWithContext(ctx, "POST", e.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+e.llm.apiKey)
+
+	vectors, err := func() ([][]float32, error) {
+		resp, err := e.llm.http.Do(req)
+		if err != nil {
+			return nil, classifyTransportError(ctx, err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+			return nil, classifyStatus(resp, msg)
+		}
+
+		var result struct {
+			Data []struct {
+				Index     int       `json:"index"`
+				Embedding []float32 `json:"embedding"`
+			} `json:"data"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			return nil, &LLMError{Kind: llmInvalidOutput, Err: fmt.Errorf("parse embeddings response: %w", err)}
+		}
+		vectors := make([][]float32, len(texts))
+		for _, d := range result.Data {
+			if d.Index >= 0 && d.Index < len(vectors) {
+				vectors[d.Index] = normalize(d.Embedding)
+			}
+		}
+		for _, v := range vectors {
+			if v == nil {
+				return nil, &LLMError{Kind: llmInvalidOutput, Err: errors.New("embeddings response is missing some inputs")}
+			}
+		}
+		return vectors, nil
+	}()
+
+	outcome := "ok"
+	var llmErr *LLMError
+	if errors.As(err, &llmErr) {
+		outcome = llmErr.Kind
+	} else if err != nil {
+		outcome = "error"
+	}
+	e.llm.metrics.llmRequests.Inc(e.model, outcome)
+	e.llm.metrics.llmDuration.Observe(time.Since(start).Seconds(), e.model)
+	return vectors, err
+}
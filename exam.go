@@ -0,0 +1,352 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ============================================================================
+// TIMED QUIZZES - Time limits the server enforces
+// ============================================================================
+//
+// A quiz can have a limit for the whole quiz, for each question, or both.
+// Timed quizzes are taken through /api/attempts/start and /api/attempts/answer:
+// the server starts the clock, marks each answer and keeps the score, and
+// turns away answers that arrive after time is up (allowing exams.grace for
+// the network). A question's clock starts when the previous answer arrives,
+// so questions can still be answered in any order. Attempts that run out of
+// time are submitted with whatever answers they have, either by the sweeper
+// or by the first late answer, and every answer's time is kept for analytics.
+// Until an attempt is over, quiz detail leaves out the answers to questions
+// it hasn't answered yet.
+
+// Check a pair of time limits (in seconds); returns what's wrong, or nil
+func (a *App) checkTimeLimits(timeLimit, questionTimeLimit int) error {
+	max := int(time.Duration(a.config.Exams.MaxTimeLimit).Seconds())
+	if timeLimit < 0 || timeLimit > max || questionTimeLimit < 0 || questionTimeLimit > max {
+		return msgTimeLimits.err(max)
+	}
+	return nil
+}
+
+// Give a newly saved quiz the time limits it was requested with
+func (a *App) applyTimeLimits(ctx context.Context, log *slog.Logger, userID, quizID, timeLimit, questionTimeLimit int) {
+	if timeLimit == 0 && questionTimeLimit == 0 {
+		return
+	}
+	if err := a.store.SetQuizTiming(ctx, userID, quizID, timeLimit, questionTimeLimit); err != nil {
+		log.Warn("could not set quiz time limits", "quiz_id", quizID, "error", err)
+	}
+}
+
+// When an attempt runs out if the next answer starts its clock at now: the
+// question's limit or the whole-quiz deadline, whichever comes first
+func attemptExpiry(deadline, now time.Time, questionTimeLimit int) time.Time {
+	expires := deadline
+	if questionTimeLimit > 0 {
+		questionEnds := now.Add(time.Duration(questionTimeLimit) * time.Second)
+		if expires.IsZero() || questionEnds.Before(expires) {
+			expires = questionEnds
+		}
+	}
+	return expires
+}
+
+// Where a timed attempt stands, as the browser sees it
+type attemptStatus struct {
+	QuizID            int           `json:"quiz_id"`
+	TimeLimit         int           `json:"time_limit"`           // Seconds for the whole quiz (0 = none)
+	QuestionTimeLimit int           `json:"question_time_limit"`  // Seconds for each question (0 = none)
+	Deadline          *time.Time    `json:"deadline,omitempty"`   // End of the whole-quiz limit
+	ExpiresAt         *time.Time    `json:"expires_at,omitempty"` // When the next answer has to be in by
+	ServerTime        time.Time     `json:"server_time"`          // So the browser can allow for its own clock being off
+	Answers           []interface{} `json:"answers"`              // Chosen answers so far, null where unanswered
+	Score             int           `json:"score"`
+	IsComplete        bool          `json:"is_complete"`
+	AutoSubmitted     bool          `json:"auto_submitted"`
+}
+
+func newAttemptStatus(quiz *Quiz, attempt *Attempt) attemptStatus {
+	status := attemptStatus{
+		QuizID:            quiz.ID,
+		TimeLimit:         quiz.TimeLimit,
+		QuestionTimeLimit: quiz.QuestionTimeLimit,
+		ServerTime:        time.Now().UTC(),
+		Answers:           []interface{}{},
+		Score:             attempt.Score,
+		IsComplete:        attempt.IsComplete,
+		AutoSubmitted:     attempt.AutoSubmitted,
+	}
+	json.Unmarshal([]byte(attempt.AnswersJSON), &status.Answers)
+	if !attempt.Deadline.IsZero() {
+		deadline := attempt.Deadline.UTC()
+		status.Deadline = &deadline
+	}
+	if !attempt.ExpiresAt.IsZero() && !attempt.IsComplete {
+		expires := attempt.ExpiresAt.UTC()
+		status.ExpiresAt = &expires
+	}
+	return status
+}
+
+// Has this attempt's time run out, grace included?
+func (a *App) timeIsUp(attempt *Attempt, now time.Time) bool {
+	return !attempt.ExpiresAt.IsZero() && now.After(attempt.ExpiresAt.Add(time.Duration(a.config.Exams.Grace)))
+}
+
+// Submit an unfinished attempt whose time ran out while nobody was looking,
+// rather than waiting for the sweeper. Returns the attempt as it now stands.
+func (a *App) catchUpAttempt(ctx context.Context, userID, quizID int, attempt *Attempt) *Attempt {
+	if attempt.IsComplete || !a.timeIsUp(attempt, time.Now()) {
+		return attempt
+	}
+	a.expireAttempts(ctx, logFor(ctx))
+	if updated, err := a.store.GetAttempt(ctx, userID, quizID); err == nil {
+		return updated
+	}
+	return attempt
+}
+
+// What gives a question's answer away
+var answerFields = []string{"correctAnswer", "correctAnswers", "pairs", "tolerance", "explanation"}
+
+// Take the answers out of a timed quiz's questions (as decoded from
+// questions_json) while its attempt is still going. Questions already
+// answered keep theirs, since /api/attempts/answer sent them back anyway.
+// Matching questions keep their left-hand sides as "left".
+func hideAnswers(questions, answers interface{}) {
+	list, _ := questions.([]interface{})
+	answered, _ := answers.([]interface{})
+	for i, item := range list {
+		q, ok := item.(map[string]interface{})
+		if !ok || (i < len(answered) && answered[i] != nil) {
+			continue
+		}
+		if pairs, ok := q["pairs"].([]interface{}); ok {
+			lefts := []interface{}{}
+			for _, p := range pairs {
+				if pair, ok := p.(map[string]interface{}); ok {
+					lefts = append(lefts, pair["left"])
+				}
+			}
+			q["left"] = lefts
+		}
+		for _, f := range answerFields {
+			delete(q, f)
+		}
+	}
+}
+
+// ----------- Endpoints -----------
+
+// Set a quiz's time limits: POST /api/quiz/timing {"quiz_id": 4, "time_limit": 600, "question_time_limit": 30}
+// Limits are in seconds, 0 for none. They can't change under an attempt that's
+// still going: its answers are timed (and kept hidden) by them.
+func (a *App) handleQuizTiming(w http.ResponseWriter, r *http.Request, user *User) {
+	if r.Method != http.MethodPost {
+		httpError(w, r, msgMethodNotAllowed, http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		QuizID            int `json:"quiz_id"`
+		TimeLimit         int `json:"time_limit"`
+		QuestionTimeLimit int `json:"question_time_limit"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, r, msgInvalidJSON, http.StatusBadRequest)
+		return
+	}
+	if err := a.checkTimeLimits(req.TimeLimit, req.QuestionTimeLimit); err != nil {
+		httpErrorFrom(w, r, err, http.StatusBadRequest)
+		return
+	}
+	attempt, err := a.store.GetAttempt(r.Context(), user.ID, req.QuizID)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		httpError(w, r, msgDatabaseError, http.StatusInternalServerError)
+		return
+	}
+	if err == nil && attempt.Tracked() && !a.catchUpAttempt(r.Context(), user.ID, req.QuizID, attempt).IsComplete {
+		httpError(w, r, msgAttemptRunning, http.StatusConflict)
+		return
+	}
+
+	err = a.store.SetQuizTiming(r.Context(), user.ID, req.QuizID, req.TimeLimit, req.QuestionTimeLimit)
+	if errors.Is(err, ErrNotFound) {
+		httpError(w, r, msgQuizNotFound, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		httpError(w, r, msgDatabaseError, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(req)
+}
+
+// Start (or pick up) an attempt and its clock: POST /api/attempts/start {"quiz_id": 4}
+// Works for untimed quizzes too - they get no deadline, but answer times are still recorded.
+func (a *App) handleStartAttempt(w http.ResponseWriter, r *http.Request, user *User) {
+	if r.Method != http.MethodPost {
+		httpError(w, r, msgMethodNotAllowed, http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		QuizID int `json:"quiz_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, r, msgInvalidJSON, http.StatusBadRequest)
+		return
+	}
+	quiz, err := a.store.GetQuiz(r.Context(), user.ID, req.QuizID)
+	if err != nil {
+		httpError(w, r, msgQuizNotFound, http.StatusNotFound)
+		return
+	}
+
+	now := time.Now()
+	var deadline time.Time
+	if quiz.TimeLimit > 0 {
+		deadline = now.Add(time.Duration(quiz.TimeLimit) * time.Second)
+	}
+	attempt, started, err := a.store.StartAttempt(r.Context(), user.ID, quiz.ID, now, deadline, attemptExpiry(deadline, now, quiz.QuestionTimeLimit))
+	if err != nil {
+		logFor(r.Context()).Error("could not start attempt", "quiz_id", quiz.ID, "error", err)
+		httpError(w, r, msgDatabaseError, http.StatusInternalServerError)
+		return
+	}
+	if started {
+		a.metrics.timedAttempts.Inc("started")
+	}
+
+	// Picking up an attempt whose time ran out while the page was closed
+	attempt = a.catchUpAttempt(r.Context(), user.ID, quiz.ID, attempt)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newAttemptStatus(quiz, attempt))
+}
+
+// Answer one question of a started attempt:
+// POST /api/attempts/answer {"quiz_id": 4, "question": 0, "answer": "Paris"}
+func (a *App) handleAnswer(w http.ResponseWriter, r *http.Request, user *User) {
+	if r.Method != http.MethodPost {
+		httpError(w, r, msgMethodNotAllowed, http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		QuizID   int        `json:"quiz_id"`
+		Question int        `json:"question"`
+		Answer   AnswerText `json:"answer"` // A string, or a list for list-answer questions
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, r, msgInvalidJSON, http.StatusBadRequest)
+		return
+	}
+	now := time.Now() // Timed from when the answer arrived, before any database work
+
+	quiz, err := a.store.GetQuiz(r.Context(), user.ID, req.QuizID)
+	if err != nil {
+		httpError(w, r, msgQuizNotFound, http.StatusNotFound)
+		return
+	}
+	var questions []QuizQuestion
+	json.Unmarshal([]byte(quiz.QuestionsJSON), &questions)
+	if req.Question < 0 || req.Question >= len(questions) {
+		httpError(w, r, msgNoSuchQuestion, http.StatusBadRequest)
+		return
+	}
+	if strings.TrimSpace(string(req.Answer)) == "" {
+		httpError(w, r, msgEmptyAnswer, http.StatusBadRequest)
+		return
+	}
+
+	attempt, err := a.store.GetAttempt(r.Context(), user.ID, quiz.ID)
+	if err != nil || !attempt.Tracked() {
+		httpError(w, r, msgStartFirst, http.StatusConflict)
+		return
+	}
+	if attempt.IsComplete {
+		httpError(w, r, msgAttemptFinished, http.StatusConflict)
+		return
+	}
+	if a.timeIsUp(attempt, now) {
+		a.metrics.timedAttempts.Inc("late_answer")
+		a.expireAttempts(r.Context(), logFor(r.Context()))
+		httpError(w, r, msgTimeUp, http.StatusConflict)
+		return
+	}
+
+	q := questions[req.Question]
+	answer := AttemptAnswer{
+		Question: req.Question,
+		Answer:   string(req.Answer),
+		Correct:  gradeAnswer(q, string(req.Answer)),
+		TimeMs:   now.Sub(attempt.QuestionStartedAt).Milliseconds(),
+	}
+	attempt, err = a.store.RecordAnswer(r.Context(), attempt.ID, len(questions), answer, now,
+		attemptExpiry(attempt.Deadline, now, quiz.QuestionTimeLimit))
+	switch {
+	case errors.Is(err, ErrAlreadyAnswered):
+		httpError(w, r, msgAlreadyAnswered, http.StatusConflict)
+		return
+	case errors.Is(err, ErrAttemptFinished):
+		httpError(w, r, msgAttemptFinished, http.StatusConflict)
+		return
+	case err != nil:
+		logFor(r.Context()).Error("could not record answer", "quiz_id", quiz.ID, "error", err)
+		httpError(w, r, msgDatabaseError, http.StatusInternalServerError)
+		return
+	}
+	if attempt.IsComplete {
+		a.metrics.timedAttempts.Inc("finished")
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Correct       bool   `json:"correct"`
+		CorrectAnswer string `json:"correctAnswer"`
+		Explanation   string `json:"explanation"`
+		TimeMs        int64  `json:"time_ms"`
+		attemptStatus
+	}{answer.Correct, q.CorrectAnswer, q.Explanation, answer.TimeMs, newAttemptStatus(quiz, attempt)})
+}
+
+// ----------- Sweeper -----------
+
+// Submit attempts that ran out of time every exams.sweep_interval until ctx is cancelled
+func (a *App) startAttemptSweeper(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(time.Duration(a.config.Exams.SweepInterval))
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				a.expireAttempts(context.Background(), slog.Default())
+			}
+		}
+	}()
+}
+
+func (a *App) expireAttempts(ctx context.Context, log *slog.Logger) {
+	n, err := a.store.ExpireAttempts(ctx, time.Now().Add(-time.Duration(a.config.Exams.Grace)))
+	if err != nil {
+		log.Error("could not submit expired attempts", "error", err)
+		return
+	}
+	for i := 0; i < n; i++ {
+		a.metrics.timedAttempts.Inc("auto_submitted")
+	}
+	if n > 0 {
+		log.Info("submitted attempts that ran out of time", "count", n)
+	}
+}
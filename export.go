@@ -0,0 +1,310 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ============================================================================
+// QUIZ EXPORT - Downloading a saved quiz as text, GIFT, JSON or a zip
+// ============================================================================
+//
+// GIFT is the plain-text format Moodle (and several other learning systems)
+// import questions from. It has no ordering questions and no blanks beyond
+// one per question, so those are written as comments - still in the file,
+// for someone to re-create by hand, but skipped by the importer.
+//
+// Images go along with the questions: inline in GIFT (as HTML) and JSON (as
+// data URLs), and as files next to the text in a zip. A plain text download
+// only names the image files.
+
+// Download a quiz: GET /api/quiz/export?id=12&format=txt|gift|json|zip
+func (a *App) handleQuizExport(w http.ResponseWriter, r *http.Request, user *User) {
+	if r.Method != http.MethodGet {
+		httpError(w, r, msgMethodNotAllowed, http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		httpError(w, r, msgInvalidQuizID, http.StatusBadRequest)
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "txt"
+	}
+	if format != "txt" && format != "gift" && format != "json" && format != "zip" {
+		httpError(w, r, msgExportFormat, http.StatusBadRequest)
+		return
+	}
+
+	quiz, err := a.store.GetQuiz(r.Context(), user.ID, id)
+	if err != nil {
+		httpError(w, r, msgQuizNotFound, http.StatusNotFound)
+		return
+	}
+	var questions []QuizQuestion
+	json.Unmarshal([]byte(quiz.QuestionsJSON), &questions)
+	images := a.quizImages(r.Context(), user.ID, questions)
+
+	var body []byte
+	contentType := "text/plain; charset=utf-8"
+	switch format {
+	case "txt":
+		body = []byte(quizText(quiz.Prompt, questions, images))
+	case "gift":
+		body = []byte(quizGIFT(quiz.Prompt, questions, images))
+	case "json":
+		contentType = "application/json"
+		export := map[string]interface{}{"title": quiz.Prompt, "questions": questions}
+		if len(images) > 0 {
+			inline := map[int]string{}
+			for id, m := range images {
+				inline[id] = dataURL(m)
+			}
+			export["images"] = inline
+		}
+		body, _ = json.MarshalIndent(export, "", "  ")
+	case "zip":
+		contentType = "application/zip"
+		body, err = quizZip(quiz.Prompt, questions, images)
+		if err != nil {
+			httpError(w, r, msgZipFailed, http.StatusInternalServerError)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+		"filename": fmt.Sprintf("quiz-%d.%s", quiz.ID, format),
+	}))
+	w.Write(body)
+}
+
+// The quiz's images by ID. One that's gone missing is left out, and its
+// question exported without it.
+func (a *App) quizImages(ctx context.Context, userID int, questions []QuizQuestion) map[int]*Media {
+	images := map[int]*Media{}
+	for _, q := range questions {
+		if q.ImageID == 0 || images[q.ImageID] != nil {
+			continue
+		}
+		m, err := a.store.GetMedia(ctx, userID, q.ImageID)
+		if err != nil {
+			logFor(ctx).Warn("image missing from export", "image_id", q.ImageID, "error", err)
+			continue
+		}
+		images[q.ImageID] = m
+	}
+	return images
+}
+
+// Where an image goes in a zip, and what the text calls it
+func imagePath(m *Media) string {
+	return fmt.Sprintf("images/%d.%s", m.ID, mediaTypes[m.MimeType])
+}
+
+// ----------- Plain text -----------
+
+// The quiz as someone would print it, answers at the end of each question
+func quizText(title string, questions []QuizQuestion, images map[int]*Media) string {
+	var b strings.Builder
+	b.WriteString(title + "\n")
+	for i, q := range questions {
+		fmt.Fprintf(&b, "\n%d. %s\n", i+1, q.Question)
+		if m := images[q.ImageID]; m != nil {
+			fmt.Fprintf(&b, "   [Image: %s]\n", imagePath(m))
+		}
+		switch kindOf(q) {
+		case typeMatching:
+			for j, p := range q.Pairs {
+				fmt.Fprintf(&b, "   %d) %s\n", j+1, p.Left)
+			}
+			b.WriteString("   Match with:\n")
+			fallthrough
+		case typeMultipleChoice, typeTrueFalse, typeMultiSelect, typeOrdering:
+			for j, option := range q.Options {
+				fmt.Fprintf(&b, "   %c) %s\n", 'A'+j, option)
+			}
+		}
+		answer := q.CorrectAnswer
+		if q.Unit != "" {
+			answer += " " + q.Unit
+		}
+		if q.Tolerance > 0 {
+			answer += fmt.Sprintf(" (± %g)", q.Tolerance)
+		}
+		fmt.Fprintf(&b, "Answer: %s\n", answer)
+		if q.Explanation != "" {
+			fmt.Fprintf(&b, "Explanation: %s\n", q.Explanation)
+		}
+	}
+	return b.String()
+}
+
+// The text version in a zip, with the images it names
+func quizZip(title string, questions []QuizQuestion, images map[int]*Media) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	add := func(name string, data []byte) error {
+		w, err := zw.Create(name)
+		if err == nil {
+			_, err = w.Write(data)
+		}
+		return err
+	}
+
+	if err := add("quiz.txt", []byte(quizText(title, questions, images))); err != nil {
+		return nil, err
+	}
+	written := map[int]bool{} // Once each, however many questions show it
+	for _, q := range questions {
+		if m := images[q.ImageID]; m != nil && !written[m.ID] {
+			if err := add(imagePath(m), m.Data); err != nil {
+				return nil, err
+			}
+			written[m.ID] = true
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// ----------- GIFT -----------
+
+// Credit for each right option of a multi-select question with n right
+// options. Moodle only takes percentages from its own list, which has 1/n
+// for n up to 10.
+var giftShares = []string{"100", "50", "33.33333", "25", "20", "16.66667", "14.28571", "12.5", "11.11111", "10"}
+
+// Characters GIFT gives a meaning to
+var giftEscaper = strings.NewReplacer(`\`, `\\`, `~`, `\~`, `=`, `\=`, `#`, `\#`, `{`, `\{`, `}`, `\}`, `:`, `\:`, "\n", `\n`)
+
+func giftEscape(s string) string {
+	return giftEscaper.Replace(strings.TrimSpace(s))
+}
+
+// The picture at the top of a question, in GIFT's HTML question text
+func giftImage(m *Media) string {
+	return giftEscaper.Replace(`<p><img src="` + dataURL(m) + `" alt=""></p>`)
+}
+
+// The quiz in Moodle's GIFT format
+func quizGIFT(title string, questions []QuizQuestion, images map[int]*Media) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "// %s\n", strings.ReplaceAll(title, "\n", " "))
+	fmt.Fprintf(&b, "$CATEGORY: %s\n", giftEscape(title))
+	for i, q := range questions {
+		b.WriteString("\n")
+		b.WriteString(giftQuestion(fmt.Sprintf("Q%d", i+1), q, images[q.ImageID]))
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// One question in GIFT, or a comment block for the ones GIFT can't hold.
+// A question with an image or Markdown is written as HTML, with the image
+// inline. Typed answers stay plain text, since that's what gets compared.
+func giftQuestion(name string, q QuizQuestion, img *Media) string {
+	asHTML := img != nil || q.Format == formatMarkdown
+	text, prefix := giftEscape, ""
+	if asHTML {
+		text = func(s string) string { return giftEscape(markupHTML(s, q.Format)) }
+		prefix = "[html]"
+		if img != nil {
+			prefix += giftImage(img)
+		}
+	}
+	head := "::" + name + "::" + prefix + text(q.Question)
+	if q.Unit != "" {
+		head += " (" + text(q.Unit) + ")"
+	}
+	feedback := ""
+	if q.Explanation != "" {
+		feedback = " ####" + text(q.Explanation)
+	}
+
+	var answers []string
+	switch kind := kindOf(q); kind {
+	case typeMultipleChoice:
+		for _, option := range q.Options {
+			mark := "~"
+			if option == q.CorrectAnswer {
+				mark = "="
+			}
+			answers = append(answers, mark+text(option))
+		}
+	case typeTrueFalse:
+		answers = []string{"FALSE"}
+		if strings.EqualFold(q.CorrectAnswer, "True") {
+			answers = []string{"TRUE"}
+		}
+	case typeShortAnswer:
+		answers = []string{"=" + giftEscape(q.CorrectAnswer)}
+	case typeMultiSelect:
+		share := giftShares[min(len(q.CorrectAnswers), len(giftShares))-1]
+		for _, option := range q.Options {
+			weight := "-100"
+			for _, right := range q.CorrectAnswers {
+				if option == right {
+					weight = share
+				}
+			}
+			answers = append(answers, "~%"+weight+"%"+text(option))
+		}
+	case typeMatching:
+		if len(q.Pairs) < 3 {
+			return giftComment(name, q, "Moodle needs at least three pairs to match")
+		}
+		for _, p := range q.Pairs {
+			answers = append(answers, "="+text(p.Left)+" -> "+giftEscape(p.Right))
+		}
+	case typeNumeric:
+		answer := strings.TrimSpace(q.CorrectAnswer)
+		if q.Tolerance > 0 {
+			answer += ":" + strconv.FormatFloat(q.Tolerance, 'g', -1, 64)
+		}
+		return head + " {#" + answer + feedback + "}"
+	case typeCloze:
+		if len(q.CorrectAnswers) != 1 {
+			return giftComment(name, q, "GIFT has one blank per question")
+		}
+		// A missing-word question: the answer goes where the blank is
+		parts := clozeBlank.Split(q.Question, 2)
+		if asHTML {
+			parts[0], parts[1] = markupHTML(parts[0], q.Format), markupHTML(parts[1], q.Format)
+		}
+		return "::" + name + "::" + prefix + giftEscaper.Replace(parts[0]) + "{=" + giftEscape(q.CorrectAnswers[0]) + feedback + "}" + giftEscaper.Replace(parts[1])
+	default:
+		return giftComment(name, q, "GIFT has no "+strings.ToLower(kindName(kind))+" questions")
+	}
+	return head + " {\n\t" + strings.Join(answers, "\n\t") + feedback + "\n}"
+}
+
+// A question GIFT can't hold, kept as a comment so it isn't lost
+func giftComment(name string, q QuizQuestion, why string) string {
+	lines := []string{fmt.Sprintf("// %s skipped: %s", name, why), "// " + q.Question}
+	if kindOf(q) == typeOrdering {
+		lines = append(lines, "// Correct order:")
+		for j, item := range q.CorrectAnswers {
+			lines = append(lines, fmt.Sprintf("//   %d. %s", j+1, item))
+		}
+	} else {
+		lines = append(lines, "// Answer: "+q.CorrectAnswer)
+	}
+	if q.Explanation != "" {
+		lines = append(lines, "// "+q.Explanation)
+	}
+	for i := range lines {
+		lines[i] = strings.ReplaceAll(lines[i], "\n", " ")
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ============================================================================
+// QUESTION FLAGS - Reporting bad questions and fixing them
+// ============================================================================
+//
+// The AI sometimes gets an answer wrong or words a question so it could go
+// either way. Anyone taking a quiz can flag a question with a reason; the
+// quiz's owner sees the open flags and either dismisses them or fixes the
+// question. A fix changes the quiz, its copy in the question bank, and the
+// score of every attempt whose answer to that question is now marked
+// differently. Admins get flag counts per prompt version and model, to see
+// which prompts and models need work.
+//
+// Attempts can only be re-scored if they recorded their answers. Timed and
+// adaptive attempts always do; the browser sends them for untimed quizzes
+// too. Live session results are left as they were played.
+
+// Why a question was flagged
+var flagReasons = []string{"wrong_answer", "ambiguous", "off_topic"}
+
+// Longest comment kept with a flag
+const flagCommentMax = 500
+
+// Flag a question: POST /api/flags {quiz_id, question, reason, comment}.
+// List flags on the user's quizzes: GET /api/flags?quiz_id=&status=open
+func (a *App) handleFlags(w http.ResponseWriter, r *http.Request, user *User) {
+	switch r.Method {
+	case http.MethodGet:
+		a.listFlags(w, r, user)
+	case http.MethodPost:
+		a.createFlag(w, r, user)
+	default:
+		httpError(w, r, msgMethodNotAllowed, http.StatusMethodNotAllowed)
+	}
+}
+
+func (a *App) createFlag(w http.ResponseWriter, r *http.Request, user *User) {
+	var req struct {
+		QuizID   int    `json:"quiz_id"`
+		Question int    `json:"question"` // Index into the quiz's questions
+		Reason   string `json:"reason"`
+		Comment  string `json:"comment"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, r, msgInvalidRequest, http.StatusBadRequest)
+		return
+	}
+	if !slices.Contains(flagReasons, req.Reason) {
+		httpError(w, r, msgFlagReason, http.StatusBadRequest, strings.Join(flagReasons, ", "))
+		return
+	}
+
+	quiz, err := a.store.GetQuiz(r.Context(), user.ID, req.QuizID)
+	if err != nil {
+		httpError(w, r, msgQuizNotFound, http.StatusNotFound)
+		return
+	}
+	var questions []json.RawMessage
+	json.Unmarshal([]byte(quiz.QuestionsJSON), &questions)
+	if req.Question < 0 || req.Question >= len(questions) {
+		httpError(w, r, msgQuestionNotFound, http.StatusNotFound)
+		return
+	}
+
+	flag := QuestionFlag{
+		QuizID:     quiz.ID,
+		QuizPrompt: quiz.Prompt,
+		Question:   req.Question,
+		UserID:     user.ID,
+		Reason:     req.Reason,
+		Comment:    firstRunes(strings.TrimSpace(req.Comment), flagCommentMax),
+		Status:     "open",
+		CreatedAt:  time.Now().UTC().Truncate(time.Millisecond),
+	}
+	flag.ID, err = a.store.FlagQuestion(r.Context(), flag)
+	if err != nil {
+		logFor(r.Context()).Error("could not save flag", "quiz_id", quiz.ID, "error", err)
+		httpError(w, r, msgDatabaseError, http.StatusInternalServerError)
+		return
+	}
+	a.metrics.questionFlags.Inc(flag.Reason)
+	logFor(r.Context()).Info("question flagged", "quiz_id", quiz.ID, "question", flag.Question, "reason", flag.Reason,
+		"prompt", quiz.PromptVersion, "model", quiz.Model)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(flag)
+}
+
+func (a *App) listFlags(w http.ResponseWriter, r *http.Request, user *User) {
+	q := r.URL.Query()
+	f := FlagFilter{Status: q.Get("status")}
+	if f.Status != "" && f.Status != "open" && f.Status != "fixed" && f.Status != "dismissed" {
+		httpError(w, r, msgFlagStatus, http.StatusBadRequest)
+		return
+	}
+	if id := q.Get("quiz_id"); id != "" {
+		var err error
+		if f.QuizID, err = strconv.Atoi(id); err != nil {
+			httpError(w, r, msgInvalidQuizID, http.StatusBadRequest)
+			return
+		}
+	}
+
+	flags, err := a.store.QuizFlags(r.Context(), user.ID, f)
+	if err != nil {
+		httpError(w, r, msgDatabaseError, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"flags": flags})
+}
+
+// Close a question's open flags, as the quiz's owner:
+// POST /api/flags/resolve {quiz_id, question, action: "dismiss"}, or
+// {quiz_id, question, action: "fix", fix: {correctAnswer, ...}} to change the question too.
+// Fields left out of fix keep their current values.
+func (a *App) handleFlagResolve(w http.ResponseWriter, r *http.Request, user *User) {
+	if r.Method != http.MethodPost {
+		httpError(w, r, msgMethodNotAllowed, http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		QuizID   int    `json:"quiz_id"`
+		Question int    `json:"question"`
+		Action   string `json:"action"`
+		Fix      struct {
+			Question      *string   `json:"question"`
+			Options       *[]string `json:"options"`
+			CorrectAnswer *string   `json:"correctAnswer"`
+			Explanation   *string   `json:"explanation"`
+
+			CorrectAnswers *[]string    `json:"correctAnswers"`
+			Pairs          *[]MatchPair `json:"pairs"`
+			Tolerance      *float64     `json:"tolerance"`
+			Unit           *string      `json:"unit"`
+			ImageID        *int         `json:"imageId"` // 0 takes the image away
+		} `json:"fix"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, r, msgInvalidRequest, http.StatusBadRequest)
+		return
+	}
+
+	switch req.Action {
+	case "dismiss":
+		n, err := a.store.ResolveFlags(r.Context(), user.ID, req.QuizID, req.Question, "dismissed", time.Now())
+		if err != nil {
+			httpError(w, r, msgDatabaseError, http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]int{"flags_resolved": n})
+		return
+	case "fix":
+	default:
+		httpError(w, r, msgFlagAction, http.StatusBadRequest)
+		return
+	}
+
+	quiz, err := a.store.GetQuiz(r.Context(), user.ID, req.QuizID)
+	if err != nil {
+		httpError(w, r, msgQuizNotFound, http.StatusNotFound)
+		return
+	}
+	var questions []QuizQuestion
+	if err := json.Unmarshal([]byte(quiz.QuestionsJSON), &questions); err != nil || req.Question < 0 || req.Question >= len(questions) {
+		httpError(w, r, msgQuestionNotFound, http.StatusNotFound)
+		return
+	}
+
+	old := questions[req.Question]
+	fixed := old
+	if req.Fix.Question != nil {
+		fixed.Question = strings.TrimSpace(*req.Fix.Question)
+	}
+	if req.Fix.Options != nil {
+		fixed.Options = *req.Fix.Options
+	}
+	if req.Fix.CorrectAnswer != nil {
+		fixed.CorrectAnswer = strings.TrimSpace(*req.Fix.CorrectAnswer)
+	}
+	if req.Fix.Explanation != nil {
+		fixed.Explanation = strings.TrimSpace(*req.Fix.Explanation)
+	}
+	if req.Fix.CorrectAnswers != nil {
+		fixed.CorrectAnswers = *req.Fix.CorrectAnswers
+	}
+	if req.Fix.Pairs != nil {
+		fixed.Pairs = *req.Fix.Pairs
+	}
+	if req.Fix.Tolerance != nil {
+		fixed.Tolerance = *req.Fix.Tolerance
+	}
+	if req.Fix.Unit != nil {
+		fixed.Unit = strings.TrimSpace(*req.Fix.Unit)
+	}
+	if req.Fix.ImageID != nil {
+		if *req.Fix.ImageID != 0 {
+			if _, err := a.store.GetMedia(r.Context(), user.ID, *req.Fix.ImageID); err != nil {
+				httpError(w, r, msgImageNotFound, http.StatusBadRequest)
+				return
+			}
+		}
+		fixed.ImageID = *req.Fix.ImageID
+	}
+	// New items to order or match get shuffled afresh
+	if kind := kindOf(fixed); (kind == typeOrdering && req.Fix.CorrectAnswers != nil) || (kind == typeMatching && req.Fix.Pairs != nil) {
+		fixed.Options = nil
+	}
+	prepared := []QuizQuestion{fixed}
+	prepareQuestions(prepared, "")
+	fixed = prepared[0]
+	fixed.LowConfidence = nil // The owner has had the final say on the answer
+	if err := validateQuestions([]QuizQuestion{fixed}); err != nil {
+		httpError(w, r, msgInvalidFix, http.StatusBadRequest, err)
+		return
+	}
+	questions[req.Question] = fixed
+	fixedJSON, _ := json.Marshal(fixed)
+	questionsJSON, _ := json.Marshal(questions)
+
+	// Mark every recorded answer to the question again
+	attempts, err := a.store.QuizAttempts(r.Context(), quiz.ID)
+	if err != nil {
+		httpError(w, r, msgDatabaseError, http.StatusInternalServerError)
+		return
+	}
+	var rescores []AttemptRescore
+	skipped := 0
+	for _, at := range attempts {
+		var answers []interface{}
+		if err := json.Unmarshal([]byte(at.AnswersJSON), &answers); err != nil {
+			skipped++ // Saved before the browser sent its answers
+			continue
+		}
+		if req.Question >= len(answers) {
+			continue
+		}
+		answer, ok := answers[req.Question].(string)
+		if !ok {
+			continue // Not answered
+		}
+		was, now := gradeAnswer(old, answer), gradeAnswer(fixed, answer)
+		switch {
+		case now && !was:
+			rescores = append(rescores, AttemptRescore{AttemptID: at.ID, Delta: 1, Correct: true})
+		case was && !now:
+			rescores = append(rescores, AttemptRescore{AttemptID: at.ID, Delta: -1, Correct: false})
+		}
+	}
+
+	closed, err := a.store.FixQuestion(r.Context(), QuestionFix{
+		UserID:   user.ID,
+		QuizID:   quiz.ID,
+		Question: req.Question,
+		OldJSON:  quiz.QuestionsJSON,
+		NewJSON:  string(questionsJSON),
+		OldText:  old.Question,
+		Fixed:    fixedJSON,
+		Rescores: rescores,
+		Now:      time.Now(),
+	})
+	if errors.Is(err, ErrQuizChanged) {
+		httpError(w, r, msgQuizChanged, http.StatusConflict)
+		return
+	}
+	if err != nil {
+		logFor(r.Context()).Error("could not fix question", "quiz_id", quiz.ID, "question", req.Question, "error", err)
+		httpError(w, r, msgDatabaseError, http.StatusInternalServerError)
+		return
+	}
+	logFor(r.Context()).Info("question fixed", "quiz_id", quiz.ID, "question", req.Question, "flags_resolved", closed,
+		"attempts_rescored", len(rescores), "attempts_skipped", skipped)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"question":          fixed,
+		"flags_resolved":    closed,
+		"attempts_rescored": len(rescores),
+		"attempts_skipped":  skipped, // Attempts that didn't record their answers
+	})
+}
+
+// Flag counts by prompt version and model: GET /api/admin/flags
+func (a *App) handleAdminFlagStats(w http.ResponseWriter, r *http.Request, user *User) {
+	if r.Method != http.MethodGet {
+		httpError(w, r, msgMethodNotAllowed, http.StatusMethodNotAllowed)
+		return
+	}
+	stats, err := a.store.FlagStats(r.Context())
+	if err != nil {
+		httpError(w, r, msgDatabaseError, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"stats": stats})
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"math/rand/v2"
+	"strings"
+	"time"
+)
+
+// ============================================================================
+// GENERATION CACHE - Reusing questions for requests we've already paid for
+// ============================================================================
+//
+// With features.cache on, requests that normalize to the same thing (topic,
+// difficulty, type, language, document and prompt version - but not the
+// number of questions) share a pool of generated questions. The pool is
+// bigger than what was asked for, so each hit serves a different mix. Pools
+// expire after cache.ttl, and "fresh": true in a request skips the lookup and
+// replaces the pool with new questions.
+
+// Generate a quiz, going through the cache when it's switched on
+func (a *App) generateCached(ctx context.Context, log *slog.Logger, req QuizRequest) (string, string, error) {
+	// Images are the user's own, so their questions aren't shared
+	if !a.config.Features.Cache || len(req.Images) > 0 {
+		return a.generateVerified(ctx, log, req)
+	}
+
+	// The prompt version is part of the key, so a new template means new questions
+	prompt, err := a.prompts.Render("quiz", quizPromptData(req))
+	if err != nil {
+		return "", "", err
+	}
+	key := cacheKey(req, prompt.Ref)
+
+	if req.Fresh {
+		a.metrics.cacheLookups.Inc("bypass")
+	} else {
+		pool, err := a.store.CachedPool(ctx, key, time.Now())
+		switch {
+		case err == nil:
+			if quiz, ok := pickQuestions(pool.QuestionsJSON, req.QuestionCount); ok {
+				a.metrics.cacheLookups.Inc("hit")
+				log.Info("served quiz from cache", "prompt", pool.PromptVersion)
+				return quiz, pool.PromptVersion, nil
+			}
+			// Pool is smaller than this request - make a bigger one below
+		case !errors.Is(err, ErrNotFound):
+			log.Warn("generation cache lookup failed", "error", err)
+		}
+		a.metrics.cacheLookups.Inc("miss")
+	}
+
+	poolReq := req
+	poolReq.QuestionCount = a.poolSize(req.QuestionCount)
+	pool, promptRef, err := a.generateVerified(ctx, log, poolReq)
+	if err != nil {
+		return "", "", err
+	}
+
+	err = a.store.SaveCachedPool(ctx, CachedPool{
+		Key:           key,
+		QuestionsJSON: pool,
+		PromptVersion: promptRef,
+		ExpiresAt:     time.Now().Add(time.Duration(a.config.Cache.TTL)),
+	})
+	if err != nil {
+		log.Warn("could not cache generated questions", "error", err)
+	}
+
+	// The AI may have come back with fewer than the pool size; serve what we can
+	quiz, ok := pickQuestions(pool, req.QuestionCount)
+	if !ok {
+		quiz, _ = pickQuestions(pool, 0)
+	}
+	return quiz, promptRef, nil
+}
+
+// How many questions to generate for a pool serving requests of count questions
+func (a *App) poolSize(count int) int {
+	size := count * a.config.Cache.PoolFactor
+	if size > a.config.Cache.MaxPool {
+		size = a.config.Cache.MaxPool
+	}
+	if size < count {
+		size = count // Never ask for fewer than the user wanted
+	}
+	return size
+}
+
+// Hash of everything that decides which questions a request should get.
+// Case and spacing don't matter; the question count doesn't either, since
+// requests of any size are served from the same pool.
+func cacheKey(req QuizRequest, promptRef string) string {
+	normalize := func(s string) string {
+		return strings.Join(strings.Fields(strings.ToLower(s)), " ")
+	}
+	h := sha256.New()
+	for _, part := range []string{promptRef, req.Topic, req.Difficulty, req.QuizType, req.Language, req.Source} {
+		h.Write([]byte(normalize(part)))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// A random count questions from a pool, as a JSON array. ok is false when the
+// pool doesn't have that many (count 0 means all of them, shuffled).
+func pickQuestions(poolJSON string, count int) (string, bool) {
+	var pool []json.RawMessage
+	if err := json.Unmarshal([]byte(poolJSON), &pool); err != nil || len(pool) < count {
+		return "", false
+	}
+	if count <= 0 {
+		count = len(pool)
+	}
+
+	picked := make([]json.RawMessage, 0, count)
+	for _, i := range rand.Perm(len(pool))[:count] {
+		picked = append(picked, pool[i])
+	}
+	quiz, err := json.Marshal(picked)
+	if err != nil {
+		return "", false
+	}
+	return string(quiz), true
+}
@@ -0,0 +1,394 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ============================================================================
+// GENERATION JOBS - Quizzes are made by background workers, not inside requests
+// ============================================================================
+//
+// POST /api/generate-quiz stores a job and returns straight away. A pool of
+// workers claims queued jobs from the database, calls the AI (retrying on
+// failure) and stores the result. Browsers poll /api/jobs?id=... or follow
+// /api/jobs/events?id=... until the job is done. Because the queue lives in
+// the database, a refresh or a server restart doesn't lose the quiz.
+//
+// Several servers can share one PostgreSQL queue. A claimed job carries a
+// lease that its worker keeps renewing while it runs; a job whose lease
+// runs out (its server crashed or was killed) goes back in the queue for
+// any server to pick up.
+
+// Job states
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+)
+
+// A job that keeps getting picked up without finishing (e.g. it crashes the
+// server every time) is given up on after this many tries
+const maxJobClaims = 3
+
+// A queued quiz generation
+type Job struct {
+	ID          string `json:"id"`
+	UserID      int    `json:"-"` // 0 for visitors who aren't logged in
+	Status      string `json:"status"`
+	RequestJSON string `json:"-"`                    // The QuizRequest as submitted
+	ResultJSON  string `json:"-"`                    // The generated quiz, once it succeeded
+	QuizID      int    `json:"quiz_id,omitempty"`    // Set when the quiz was saved to the user's history
+	Error       string `json:"error,omitempty"`      // Message to show the user
+	ErrorCode   string `json:"error_code,omitempty"` // What went wrong, e.g. "rate_limited" or "timeout"
+	Attempts    int    `json:"attempts"`             // How many times a worker has picked it up
+	CreatedAt   string `json:"created_at"`
+	UpdatedAt   string `json:"updated_at"`
+}
+
+// Put the job's error in lang, going by its error code. Jobs that failed
+// before every failure had one ("failed") get the generic message, not the
+// internal error they were stored with.
+func (j *Job) localizeError(lang string) {
+	switch {
+	case j.ErrorCode == "":
+	case j.ErrorCode == "failed":
+		j.Error = msgGenerationFailed.text(lang)
+	case messageCatalog[msgKey(j.ErrorCode)] != nil:
+		j.Error = msgKey(j.ErrorCode).text(lang)
+	default:
+		j.Error = (&LLMError{Kind: j.ErrorCode}).UserMessage().text(lang)
+	}
+}
+
+// Has the job stopped changing?
+func (j *Job) done() bool {
+	return j.Status == JobSucceeded || j.Status == JobFailed
+}
+
+// Clients get the generated quiz inline as JSON rather than as a string
+func (j *Job) MarshalJSON() ([]byte, error) {
+	type plain Job
+	var result json.RawMessage
+	if j.ResultJSON != "" {
+		result = json.RawMessage(j.ResultJSON)
+	}
+	return json.Marshal(struct {
+		*plain
+		Result json.RawMessage `json:"result,omitempty"`
+	}{(*plain)(j), result})
+}
+
+// Store a new job and nudge an idle worker to pick it up
+func (a *App) enqueueGeneration(ctx context.Context, userID int, req QuizRequest) (*Job, error) {
+	id, err := uuid.NewV7() // Time-ordered, so the queue can be read oldest first
+	if err != nil {
+		return nil, err
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	if err := a.store.CreateJob(ctx, id.String(), userID, string(body)); err != nil {
+		return nil, err
+	}
+
+	select {
+	case a.jobWake <- struct{}{}:
+	default: // A wake-up is already pending
+	}
+	return a.store.GetJob(ctx, id.String())
+}
+
+// ----------- Workers -----------
+
+// Start the worker pool. Workers stop picking up new jobs once ctx is
+// cancelled and finish the one they're on; a.generations tracks them.
+func (a *App) startWorkers(ctx context.Context) {
+	a.generations.Add(1)
+	go a.requeueExpiredJobs(ctx)
+
+	for i := 0; i < a.config.Jobs.Workers; i++ {
+		a.generations.Add(1)
+		go a.worker(ctx)
+	}
+}
+
+// Put back jobs whose worker has gone quiet, on start and then once a lease.
+// Only running jobs with an expired lease are touched, so jobs other servers
+// are still working on are left alone.
+func (a *App) requeueExpiredJobs(ctx context.Context) {
+	defer a.generations.Done()
+	ticker := time.NewTicker(time.Duration(a.config.Jobs.Lease))
+	defer ticker.Stop()
+	for {
+		if n, err := a.store.RequeueExpiredJobs(ctx, time.Now()); err != nil && ctx.Err() == nil {
+			slog.Error("could not requeue interrupted jobs", "error", err)
+		} else if n > 0 {
+			slog.Info("requeued interrupted jobs", "count", n)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Keep renewing a job's lease until stop is closed
+func (a *App) renewLease(log *slog.Logger, id string, stop <-chan struct{}) {
+	lease := time.Duration(a.config.Jobs.Lease)
+	ticker := time.NewTicker(lease / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		if err := a.store.RenewJobLease(context.Background(), id, time.Now().Add(lease)); err != nil {
+			log.Warn("could not renew job lease", "error", err)
+		}
+	}
+}
+
+// Claim and run jobs until ctx is cancelled
+func (a *App) worker(ctx context.Context) {
+	defer a.generations.Done()
+	for ctx.Err() == nil {
+		job, err := a.store.ClaimJob(context.Background(), time.Now().Add(time.Duration(a.config.Jobs.Lease)))
+		if err != nil {
+			if !errors.Is(err, ErrNotFound) {
+				slog.Error("could not claim job", "error", err)
+			}
+			// Nothing to do: wait for a new job, the next poll, or shutdown
+			select {
+			case <-ctx.Done():
+			case <-a.jobWake:
+			case <-time.After(time.Duration(a.config.Jobs.PollInterval)):
+			}
+			continue
+		}
+		a.runJob(job)
+	}
+}
+
+// Generate one quiz and record how it went. The job isn't tied to any
+// request, so it carries on if the browser that asked for it goes away.
+func (a *App) runJob(job *Job) {
+	ctx := context.Background()
+	log := slog.With("job_id", job.ID)
+	start := time.Now()
+	stop := make(chan struct{})
+	defer close(stop)
+	go a.renewLease(log, job.ID, stop)
+
+	if job.Attempts > maxJobClaims {
+		a.failJob(ctx, log, job, msgJobInterrupted.err())
+		return
+	}
+
+	var req QuizRequest
+	if err := json.Unmarshal([]byte(job.RequestJSON), &req); err != nil {
+		a.failJob(ctx, log, job, msgInvalidRequest.err())
+		return
+	}
+	if len(req.Images) > 0 {
+		images, err := a.loadImages(ctx, job.UserID, req.Images)
+		if err != nil {
+			log.Warn("could not load quiz images", "error", err)
+			a.failJob(ctx, log, job, msgQuizImageMissing.err())
+			return
+		}
+		for _, m := range images {
+			req.ImageData = append(req.ImageData, dataURL(m))
+		}
+	}
+
+	quiz, promptRef, err := a.generateCached(ctx, log, req)
+	if err != nil {
+		a.failJob(ctx, log, job, err)
+		return
+	}
+	quiz = a.dedupQuiz(ctx, log, job.UserID, req, quiz)
+
+	// Save it for logged-in users (same 200 character prompt the browser used to send)
+	var quizID int
+	if job.UserID != 0 {
+		prompt := []rune(req.Topic)
+		if len(prompt) > 200 {
+			prompt = prompt[:200]
+		}
+		quizID, err = a.store.SaveQuiz(ctx, job.UserID, string(prompt), quiz, promptRef, a.llm.model)
+		if err != nil {
+			log.Warn("could not save generated quiz", "error", err)
+			quizID = 0 // They still get the quiz, just not in their history
+		} else {
+			a.addQuizToBank(ctx, log, job.UserID, quizID, quiz, req.Topic, req.Difficulty)
+			a.applyTimeLimits(ctx, log, job.UserID, quizID, req.TimeLimit, req.QuestionTimeLimit)
+		}
+	}
+
+	if err := a.store.FinishJob(ctx, job.ID, quiz, quizID); err != nil {
+		log.Error("could not store job result", "error", err)
+		return
+	}
+	a.metrics.quizGenerations.Inc("success")
+	log.Info("job finished", "status", JobSucceeded, "prompt", promptRef, "duration_ms", time.Since(start).Milliseconds())
+}
+
+// Call the AI, retrying failures that might go away (rate limits, outages,
+// timeouts, unreadable answers) with exponential backoff
+func (a *App) generateWithRetries(ctx context.Context, log *slog.Logger, req QuizRequest) (string, string, error) {
+	for attempt := 0; ; attempt++ {
+		quiz, promptRef, err := a.generateQuiz(ctx, req)
+		if err == nil {
+			return quiz, promptRef, nil
+		}
+
+		var llmErr *LLMError
+		if !errors.As(err, &llmErr) || !llmErr.Retryable() || attempt >= a.config.Jobs.Retries {
+			return "", "", err
+		}
+		delay, ok := a.llm.backoff(attempt, llmErr.RetryAfter)
+		if !ok {
+			return "", "", err // Provider wants us to wait longer than we're willing to
+		}
+		log.Info("retrying generation", "attempt", attempt+2, "kind", llmErr.Kind, "delay_ms", delay.Milliseconds())
+		select {
+		case <-ctx.Done():
+			return "", "", ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+// Record a failed job with a message that's safe to show the user. The
+// error code says which message it was (the AI's kind of failure, or one of
+// our message keys), so it can be shown in the reader's language later.
+// Anything else (a template, database or decoding error) is only logged;
+// the user gets the generic message.
+func (a *App) failJob(ctx context.Context, log *slog.Logger, job *Job, cause error) {
+	message, code := msgGenerationFailed.text("en"), string(msgGenerationFailed)
+	var llmErr *LLMError
+	var msgErr *messageError
+	if errors.As(cause, &llmErr) {
+		message, code = llmErr.UserMessage().text("en"), llmErr.Kind
+	} else if errors.As(cause, &msgErr) {
+		message, code = msgErr.Error(), string(msgErr.key)
+	}
+
+	a.metrics.quizGenerations.Inc("failure")
+	log.Warn("job failed", "status", JobFailed, "error_code", code, "error", cause)
+	if err := a.store.FailJob(ctx, job.ID, code, message); err != nil {
+		log.Error("could not store job failure", "error", err)
+	}
+}
+
+// ----------- Status endpoints -----------
+
+// Look up the job in ?id=, hiding other users' jobs behind ErrNotFound.
+// Jobs from visitors who weren't logged in are only protected by their
+// unguessable ID.
+func (a *App) requestedJob(r *http.Request) (*Job, error) {
+	job, err := a.store.GetJob(r.Context(), r.URL.Query().Get("id"))
+	if err != nil {
+		return nil, err
+	}
+	if job.UserID != 0 {
+		user, ok := a.getRequestUser(r)
+		if !ok || user.ID != job.UserID {
+			return nil, ErrNotFound
+		}
+	}
+	return job, nil
+}
+
+// Poll a job: GET /api/jobs?id=...
+func (a *App) handleJobStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, r, msgMethodNotAllowed, http.StatusMethodNotAllowed)
+		return
+	}
+
+	job, err := a.requestedJob(r)
+	if errors.Is(err, ErrNotFound) {
+		httpError(w, r, msgJobNotFound, http.StatusNotFound)
+		return
+	} else if err != nil {
+		httpError(w, r, msgDatabaseError, http.StatusInternalServerError)
+		return
+	}
+
+	job.localizeError(requestLanguage(r))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
+
+// Follow a job as Server-Sent Events: GET /api/jobs/events?id=...
+// An event is sent whenever the job changes, and the stream ends once it's done.
+func (a *App) handleJobEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, r, msgMethodNotAllowed, http.StatusMethodNotAllowed)
+		return
+	}
+
+	job, err := a.requestedJob(r)
+	if errors.Is(err, ErrNotFound) {
+		httpError(w, r, msgJobNotFound, http.StatusNotFound)
+		return
+	} else if err != nil {
+		httpError(w, r, msgDatabaseError, http.StatusInternalServerError)
+		return
+	}
+
+	// The stream can outlast the server's write timeout
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	// Watch the database rather than in-process state, as another server may
+	// be running the job
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	lang := requestLanguage(r)
+	var lastSent string
+	for {
+		if state := job.Status + job.UpdatedAt + fmt.Sprint(job.Attempts); state != lastSent {
+			job.localizeError(lang)
+			data, _ := json.Marshal(job)
+			fmt.Fprintf(w, "data: %s\n\n", data)
+			if err := rc.Flush(); err != nil {
+				return
+			}
+			lastSent = state
+		}
+		if job.done() {
+			return
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+		}
+		// Let graceful shutdown finish - EventSource reconnects and the page falls back to polling
+		if a.shuttingDown.Load() {
+			return
+		}
+		if job, err = a.store.GetJob(r.Context(), job.ID); err != nil {
+			return
+		}
+	}
+}
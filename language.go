@@ -0,0 +1,445 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// ============================================================================
+// LANGUAGES - Quizzes in other languages, and errors in the user's own
+// ============================================================================
+//
+// A quiz request can name a language ("es", "Spanish" or "Español"); the
+// prompt templates then ask for questions, options and explanations in it.
+// When a document is uploaded without one, the document's language is
+// detected and used, so a French handout makes a French quiz. Saved quizzes
+// can be translated into another language as a new quiz.
+//
+// Errors and other messages for the user come from messages.go, in the
+// language the browser's Accept-Language prefers out of the ones we have
+// (acceptedLanguage below picks it), or English.
+
+// A language quizzes can be written in
+type Language struct {
+	Code   string `json:"code"`   // ISO 639-1, e.g. "es"
+	Name   string `json:"name"`   // English name, as the prompts use it
+	Native string `json:"native"` // What its speakers call it, for the language picker
+}
+
+var quizLanguages = []Language{
+	{"en", "English", "English"},
+	{"es", "Spanish", "Español"},
+	{"fr", "French", "Français"},
+	{"de", "German", "Deutsch"},
+	{"it", "Italian", "Italiano"},
+	{"pt", "Portuguese", "Português"},
+	{"nl", "Dutch", "Nederlands"},
+	{"pl", "Polish", "Polski"},
+	{"sv", "Swedish", "Svenska"},
+	{"tr", "Turkish", "Türkçe"},
+	{"ru", "Russian", "Русский"},
+	{"uk", "Ukrainian", "Українська"},
+	{"el", "Greek", "Ελληνικά"},
+	{"ar", "Arabic", "العربية"},
+	{"he", "Hebrew", "עברית"},
+	{"hi", "Hindi", "हिन्दी"},
+	{"ja", "Japanese", "日本語"},
+	{"ko", "Korean", "한국어"},
+	{"zh", "Chinese", "中文"},
+	{"th", "Thai", "ไทย"},
+}
+
+// Find a language by code ("pt", "pt-BR"), English name or native name.
+// An empty string is fine - it means no particular language.
+func normalizeLanguage(s string) (string, bool) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return "", true
+	}
+	code := strings.ToLower(s)
+	if i := strings.IndexAny(code, "-_"); i > 0 {
+		code = code[:i]
+	}
+	for _, l := range quizLanguages {
+		if l.Code == code || strings.EqualFold(l.Name, s) || strings.EqualFold(l.Native, s) {
+			return l.Code, true
+		}
+	}
+	return "", false
+}
+
+// The English name of a language code, or "" if it isn't one of ours
+func languageName(code string) string {
+	for _, l := range quizLanguages {
+		if l.Code == code {
+			return l.Name
+		}
+	}
+	return ""
+}
+
+// ----------- Detecting a document's language -----------
+
+// Common short words that give a Latin-script language away. Some are shared
+// ("de", "la"), which is fine - it's the total that counts.
+var languageStopWords = map[string][]string{
+	"en": {"the", "and", "of", "to", "is", "in", "that", "it", "for", "was", "with", "as", "are", "this", "be", "on", "by", "not", "or", "from", "have", "which", "an", "what"},
+	"es": {"el", "la", "los", "las", "de", "que", "y", "en", "es", "por", "con", "para", "una", "del", "se", "no", "al", "lo", "como", "más", "pero", "sus", "cuál", "qué"},
+	"fr": {"le", "la", "les", "des", "de", "et", "est", "un", "une", "du", "que", "dans", "pour", "pas", "qui", "sur", "au", "avec", "ce", "il", "sont", "quel", "quelle"},
+	"de": {"der", "die", "das", "und", "ist", "nicht", "ein", "eine", "zu", "den", "von", "mit", "sich", "des", "auf", "für", "im", "dem", "welche", "wie"},
+	"it": {"il", "di", "che", "la", "è", "e", "per", "un", "una", "non", "sono", "gli", "del", "della", "con", "le", "si", "nel", "anche", "quale"},
+	"pt": {"o", "os", "de", "que", "e", "do", "da", "em", "um", "uma", "para", "com", "não", "é", "por", "mais", "as", "dos", "das", "se", "qual"},
+	"nl": {"de", "het", "een", "en", "van", "is", "dat", "in", "op", "niet", "te", "zijn", "met", "voor", "er", "die", "aan", "ook", "welke", "wat"},
+	"pl": {"i", "w", "nie", "na", "się", "z", "jest", "że", "do", "to", "jak", "o", "są", "co", "ale", "od", "dla", "przez", "który", "jaki"},
+	"sv": {"och", "att", "det", "som", "en", "är", "på", "för", "av", "med", "inte", "den", "till", "har", "de", "om", "vilken", "vad"},
+	"tr": {"ve", "bir", "bu", "da", "de", "için", "ile", "olarak", "çok", "daha", "gibi", "olan", "ama", "değil", "ne", "hangi"},
+}
+
+// Words looked at when detecting a language - plenty to be sure, and a long
+// document doesn't cost more
+const detectWordLimit = 5000
+
+// Guess the language text is written in, or "" when it's too short or too
+// mixed to tell. Non-Latin scripts mostly give their language away; Latin
+// text is scored by how many of each language's common words it uses.
+func detectLanguage(text string) string {
+	scripts := map[string]int{}
+	letters := 0
+	for _, r := range text {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		letters++
+		switch {
+		case unicode.Is(unicode.Latin, r):
+			scripts["latin"]++
+		case unicode.Is(unicode.Cyrillic, r):
+			scripts["cyrillic"]++
+		case unicode.Is(unicode.Greek, r):
+			scripts["el"]++
+		case unicode.Is(unicode.Arabic, r):
+			scripts["ar"]++
+		case unicode.Is(unicode.Hebrew, r):
+			scripts["he"]++
+		case unicode.Is(unicode.Devanagari, r):
+			scripts["hi"]++
+		case unicode.Is(unicode.Thai, r):
+			scripts["th"]++
+		case unicode.Is(unicode.Hangul, r):
+			scripts["ko"]++
+		case unicode.Is(unicode.Hiragana, r), unicode.Is(unicode.Katakana, r):
+			scripts["kana"]++
+		case unicode.Is(unicode.Han, r):
+			scripts["han"]++
+		}
+	}
+	if letters == 0 {
+		return ""
+	}
+
+	script, most := "", 0
+	for s, n := range scripts {
+		if n > most || (n == most && s < script) {
+			script, most = s, n
+		}
+	}
+	// Chinese characters are shared with Japanese, which mixes in kana
+	if script == "han" || script == "kana" {
+		if scripts["kana"]*10 >= scripts["han"]+scripts["kana"] {
+			return "ja"
+		}
+		return "zh"
+	}
+	if most*2 < letters {
+		return "" // No script is in the majority
+	}
+	switch script {
+	case "latin":
+		return detectLatinLanguage(text)
+	case "cyrillic":
+		if strings.ContainsAny(strings.ToLower(text), "іїєґ") {
+			return "uk"
+		}
+		return "ru"
+	default:
+		return script
+	}
+}
+
+// Score Latin-script text by common words. The winner needs a few hits and a
+// clear lead, so a handful of borrowed words doesn't decide it.
+func detectLatinLanguage(text string) string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool { return !unicode.IsLetter(r) })
+	if len(words) > detectWordLimit {
+		words = words[:detectWordLimit]
+	}
+	scores := map[string]int{}
+	for _, w := range words {
+		for lang, common := range languageStopWords {
+			if slices.Contains(common, w) {
+				scores[lang]++
+			}
+		}
+	}
+
+	best, second, bestLang := 0, 0, ""
+	for lang, n := range scores {
+		switch {
+		case n > best || (n == best && lang < bestLang):
+			best, second, bestLang = n, max(best, second), lang
+		case n > second:
+			second = n
+		}
+	}
+	if best < 3 || best*4 <= second*5 {
+		return ""
+	}
+	return bestLang
+}
+
+// ----------- Translating saved quizzes -----------
+
+// Translate a saved quiz into another language, saving the result as a new
+// quiz: POST /api/quiz/translate {"quiz_id": 4, "language": "es"}
+func (a *App) handleTranslateQuiz(w http.ResponseWriter, r *http.Request, user *User) {
+	if r.Method != http.MethodPost {
+		httpError(w, r, msgMethodNotAllowed, http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		QuizID   int    `json:"quiz_id"`
+		Language string `json:"language"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, r, msgInvalidJSON, http.StatusBadRequest)
+		return
+	}
+	lang, ok := normalizeLanguage(req.Language)
+	if !ok || lang == "" {
+		httpError(w, r, msgUnsupportedLanguage, http.StatusBadRequest, req.Language)
+		return
+	}
+	quiz, err := a.store.GetQuiz(r.Context(), user.ID, req.QuizID)
+	if err != nil {
+		httpError(w, r, msgQuizNotFound, http.StatusNotFound)
+		return
+	}
+	var questions []QuizQuestion
+	if err := json.Unmarshal([]byte(quiz.QuestionsJSON), &questions); err != nil || len(questions) == 0 {
+		httpError(w, r, msgNoQuestions, http.StatusBadRequest)
+		return
+	}
+	// Translations are matched up against the original, so it has to hold together
+	if err := validateQuestions(questions); err != nil {
+		httpError(w, r, msgUntranslatable, http.StatusBadRequest, err)
+		return
+	}
+	if !a.llm.Configured() {
+		httpError(w, r, msgNoAPIKey, http.StatusInternalServerError)
+		return
+	}
+
+	log := logFor(r.Context())
+	translated, promptRef, err := a.translateQuestions(r.Context(), questions, lang)
+	if err != nil {
+		a.metrics.quizTranslations.Inc("failure")
+		log.Warn("could not translate quiz", "quiz_id", quiz.ID, "language", lang, "error", err)
+		var llmErr *LLMError
+		if errors.As(err, &llmErr) {
+			httpError(w, r, llmErr.UserMessage(), llmErr.HTTPStatus())
+			return
+		}
+		httpError(w, r, msgTranslateFailed, http.StatusInternalServerError)
+		return
+	}
+
+	// The copy is a quiz of its own: history, bank and the same time limits
+	translatedJSON, _ := json.Marshal(translated)
+	var native string
+	for _, l := range quizLanguages {
+		if l.Code == lang {
+			native = l.Native
+		}
+	}
+	title := firstRunes(quiz.Prompt+" ("+native+")", 200)
+	quizID, err := a.store.SaveQuiz(r.Context(), user.ID, title, string(translatedJSON), promptRef, a.llm.model)
+	if err != nil {
+		httpError(w, r, msgSaveQuizFailed, http.StatusInternalServerError)
+		return
+	}
+	a.addQuizToBank(r.Context(), log, user.ID, quizID, string(translatedJSON), quiz.Prompt, "")
+	a.applyTimeLimits(r.Context(), log, user.ID, quizID, quiz.TimeLimit, quiz.QuestionTimeLimit)
+	a.metrics.quizTranslations.Inc("success")
+	log.Info("quiz translated", "quiz_id", quiz.ID, "translation_id", quizID, "language", lang, "prompt", promptRef)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"quiz_id":   quizID,
+		"language":  lang,
+		"prompt":    title,
+		"questions": translated,
+	})
+}
+
+// Ask the AI to translate the questions, and check it kept their shape: the
+// same number of questions and options, and the right answer in the same
+// place. True/False options stay as they are, since that's how they're told
+// apart from other questions.
+func (a *App) translateQuestions(ctx context.Context, questions []QuizQuestion, lang string) ([]QuizQuestion, string, error) {
+	prompt, err := a.prompts.Render("translate", PromptData{
+		QuestionCount: len(questions),
+		Language:      lang,
+		LanguageName:  languageName(lang),
+		Questions:     questions,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	messages := []OpenAIMessage{
+		{Role: "system", Content: prompt.System},
+		{Role: "user", Content: prompt.User},
+	}
+	// Only the fields the quiz's question types use
+	var kinds []string
+	for _, q := range questions {
+		if kind := kindOf(q); !slices.Contains(kinds, kind) {
+			kinds = append(kinds, kind)
+		}
+	}
+	schema := quizSchema("Mixed")
+	if slices.ContainsFunc(kinds, func(k string) bool { return !legacyKind(k) }) {
+		schema = kindsSchema(kinds)
+	}
+	content, err := a.llm.Chat(ctx, messages, &OutputSchema{Name: "quiz", Schema: schema})
+	if err != nil {
+		return nil, "", err
+	}
+	translated, err := decodeQuizContent(content)
+	if err == nil {
+		err = matchTranslation(questions, translated)
+	}
+	if err == nil {
+		err = validateQuestions(translated)
+	}
+	if err != nil {
+		logFor(ctx).Warn("llm returned an unusable translation", "prompt", prompt.Ref,
+			"error", err, "content_preview", truncate(content, 500))
+		return nil, "", &LLMError{Kind: llmInvalidOutput, Err: err}
+	}
+	return translated, prompt.Ref, nil
+}
+
+// Check a translation against the original, question by question, and carry
+// over what isn't translated (the type, True/False options, numbers, the
+// answer check's doubts). Ordering and matching options are put back in the
+// original's shuffled order, and multi-select answers are taken from the
+// translated options in the original's places, so only the words change.
+func matchTranslation(original, translated []QuizQuestion) error {
+	if len(translated) != len(original) {
+		return fmt.Errorf("translation has %d questions, not %d", len(translated), len(original))
+	}
+	for i, o := range original {
+		t := &translated[i]
+		t.Type, t.ImageID, t.ImageRef = o.Type, o.ImageID, 0 // The picture stays the same
+		t.Format = o.Format
+		sanitizeQuestion(t)
+		switch kindOf(o) {
+		case typeTrueFalse:
+			if len(t.Options) != len(o.Options) {
+				return fmt.Errorf("question %d has a different number of options", i+1)
+			}
+			t.Options, t.CorrectAnswer = o.Options, o.CorrectAnswer
+		case typeMultipleChoice, typeShortAnswer:
+			if len(t.Options) != len(o.Options) {
+				return fmt.Errorf("question %d has a different number of options", i+1)
+			}
+			if len(o.Options) > 0 && slices.Index(t.Options, t.CorrectAnswer) != slices.Index(o.Options, o.CorrectAnswer) {
+				return fmt.Errorf("question %d has its answer in a different place", i+1)
+			}
+		case typeMultiSelect:
+			if len(t.Options) != len(o.Options) {
+				return fmt.Errorf("question %d has a different number of options", i+1)
+			}
+			t.CorrectAnswers = make([]string, len(o.CorrectAnswers))
+			for j, a := range o.CorrectAnswers {
+				k := slices.Index(o.Options, a)
+				if k < 0 {
+					return fmt.Errorf("question %d has an answer that isn't one of its options", i+1)
+				}
+				t.CorrectAnswers[j] = t.Options[k]
+			}
+		case typeOrdering:
+			if len(t.CorrectAnswers) != len(o.CorrectAnswers) {
+				return fmt.Errorf("question %d has a different number of items", i+1)
+			}
+			t.Options = reorder(o.Options, o.CorrectAnswers, t.CorrectAnswers)
+		case typeMatching:
+			if len(t.Pairs) != len(o.Pairs) {
+				return fmt.Errorf("question %d has a different number of pairs", i+1)
+			}
+			rights, translatedRights := make([]string, len(o.Pairs)), make([]string, len(t.Pairs))
+			for j := range o.Pairs {
+				rights[j], translatedRights[j] = o.Pairs[j].Right, t.Pairs[j].Right
+			}
+			t.Options = reorder(o.Options, rights, translatedRights)
+		case typeCloze:
+			if len(t.CorrectAnswers) != len(o.CorrectAnswers) {
+				return fmt.Errorf("question %d has a different number of blanks", i+1)
+			}
+			t.Options = []string{}
+		case typeNumeric:
+			t.Options, t.CorrectAnswer, t.Tolerance = []string{}, o.CorrectAnswer, o.Tolerance
+			if o.Unit == "" {
+				t.Unit = ""
+			}
+		}
+		prepareQuestions(translated[i:i+1], "")
+		t.Duplicate, t.LowConfidence = nil, o.LowConfidence
+	}
+	return nil
+}
+
+// Put translated items in the order the original shows them: shown[k] is
+// items[j], so the result's k-th is translated[j]
+func reorder(shown, items, translated []string) []string {
+	out := make([]string, len(shown))
+	for k, s := range shown {
+		if j := slices.Index(items, s); j >= 0 {
+			out[k] = translated[j]
+		}
+	}
+	return out
+}
+
+// ----------- Request language -----------
+
+// The language to answer in, from an Accept-Language header such as
+// "fr-CH, fr;q=0.9, en;q=0.8". The highest weighted one we have messages
+// for wins; "" if there's none.
+func acceptedLanguage(header string) string {
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			var err error
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		code, ok := normalizeLanguage(tag)
+		if ok && code != "" && q > bestQ && slices.Contains(messageLanguages, code) {
+			best, bestQ = code, q
+		}
+	}
+	return best
+}
@@ -0,0 +1,803 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"html/template"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// ============================================================================
+// LIVE SESSIONS - Kahoot-style games played together over WebSockets
+// ============================================================================
+//
+// A host turns one of their saved quizzes into a live session and gets a
+// six-digit PIN. Players join with the PIN and a nickname (no account
+// needed), and everyone stays connected to /api/live/ws. The server runs the
+// game in lockstep: the host starts it, every player gets the same question
+// at the same moment, answers score on correctness and speed, and the
+// leaderboard goes out when time is up or everyone has answered. The host
+// moves on to the next question, and when the game ends the results are
+// saved. Sessions are held in this process's memory, so everyone in a
+// session has to reach the same server.
+
+// Limits and scoring for live sessions
+const (
+	liveMinQuestionTime = 5 * time.Second
+	liveMaxQuestionTime = 2 * time.Minute
+	liveHostGrace       = time.Minute // How long the game waits for a host who dropped out (e.g. reloaded the page)
+	liveMaxNickname     = 20
+	liveBasePoints      = 500 // For any correct answer
+	liveSpeedPoints     = 500 // Extra for answering at once, shrinking to nothing as time runs out
+)
+
+// Where a session is in the game
+const (
+	liveLobby       = "lobby"       // Players joining, waiting for the host to start
+	liveQuestion    = "question"    // A question is open for answers
+	liveLeaderboard = "leaderboard" // Showing how the last question went
+	liveFinished    = "finished"
+)
+
+// Every live session running on this server, by PIN
+type liveHub struct {
+	mu        sync.Mutex
+	sessions  map[string]*liveSession
+	finishing sync.WaitGroup // Ended games still being saved and said goodbye to
+}
+
+func newLiveHub() *liveHub {
+	return &liveHub{sessions: map[string]*liveSession{}}
+}
+
+// One game in progress
+type liveSession struct {
+	app          *App
+	pin          string
+	hostID       int
+	quizID       int
+	title        string
+	questions    []QuizQuestion
+	questionTime time.Duration
+	done         chan struct{} // Closed when the game is over
+
+	mu            sync.Mutex
+	host          *wsConn // nil while the host is disconnected
+	players       []*livePlayer
+	state         string
+	current       int // The question being played, or last played
+	played        int // Questions whose answers have been revealed
+	questionStart time.Time
+	startedAt     time.Time
+	timer         *time.Timer // Ends the current question, or the lobby, or the wait for the host
+}
+
+// One player, kept after they disconnect so they can come back to their score
+type livePlayer struct {
+	nickname string
+	conn     *wsConn // nil while disconnected
+	score    int
+	correct  int
+	answers  []liveAnswer // One per question played
+	answered bool         // Has answered the current question
+	gained   int          // Points from the current question
+}
+
+// One line of the leaderboard
+type liveStanding struct {
+	player   *livePlayer
+	Nickname string `json:"nickname"`
+	Rank     int    `json:"rank"`
+	Score    int    `json:"score"`
+	Gained   int    `json:"gained"` // Points from the last question
+}
+
+// How a player answered one question
+type liveAnswer struct {
+	Answer  string `json:"answer,omitempty"` // Empty if they didn't answer
+	Correct bool   `json:"correct"`
+	Points  int    `json:"points"`
+	TimeMs  int64  `json:"time_ms,omitempty"`
+}
+
+// A message from a browser: host commands (start, next, skip, end) or a player's answer
+type liveCommand struct {
+	Type   string `json:"type"`
+	Index  int    `json:"index"`
+	Answer string `json:"answer"`
+}
+
+// Points for one answer: nothing if wrong, otherwise more the faster it came
+func livePoints(correct bool, elapsed, limit time.Duration) int {
+	if !correct {
+		return 0
+	}
+	left := 1 - float64(elapsed)/float64(limit)
+	if left < 0 {
+		left = 0
+	}
+	return liveBasePoints + int(float64(liveSpeedPoints)*left+0.5)
+}
+
+// Tidy up a nickname; ok is false if nothing usable is left
+func cleanNickname(name string) (string, bool) {
+	name = strings.Join(strings.FieldsFunc(name, func(r rune) bool {
+		return unicode.IsSpace(r) || unicode.IsControl(r) || unicode.Is(unicode.Cf, r)
+	}), " ")
+	name = firstRunes(name, liveMaxNickname)
+	return name, name != ""
+}
+
+// ----------- Hub -----------
+
+// Open a new session in the lobby, under a PIN nobody else is using
+func (h *liveHub) create(a *App, hostID int, quiz *Quiz, questions []QuizQuestion, questionTime time.Duration) (*liveSession, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.sessions) >= a.config.Live.MaxSessions {
+		return nil, msgTooManySessions.err()
+	}
+
+	var pin string
+	for pin == "" || h.sessions[pin] != nil {
+		n, err := rand.Int(rand.Reader, big.NewInt(900000))
+		if err != nil {
+			return nil, err
+		}
+		pin = strconv.Itoa(100000 + int(n.Int64()))
+	}
+
+	s := &liveSession{
+		app:          a,
+		pin:          pin,
+		hostID:       hostID,
+		quizID:       quiz.ID,
+		title:        quiz.Prompt,
+		questions:    questions,
+		questionTime: questionTime,
+		done:         make(chan struct{}),
+		state:        liveLobby,
+	}
+	h.sessions[pin] = s
+
+	// Nobody wants a lobby that never starts hanging around
+	s.timer = time.AfterFunc(time.Duration(a.config.Live.LobbyTimeout), func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.state == liveLobby {
+			s.finish(msgLiveNotStarted)
+		}
+	})
+	return s, nil
+}
+
+func (h *liveHub) get(pin string) *liveSession {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.sessions[pin]
+}
+
+func (h *liveHub) remove(pin string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.sessions, pin)
+}
+
+// End every session, saving what's been played (run when the server shuts down)
+func (h *liveHub) shutdown() {
+	h.mu.Lock()
+	sessions := make([]*liveSession, 0, len(h.sessions))
+	for _, s := range h.sessions {
+		sessions = append(sessions, s)
+	}
+	h.mu.Unlock()
+
+	for _, s := range sessions {
+		s.mu.Lock()
+		s.finish(msgServerRestarting)
+		s.mu.Unlock()
+	}
+	h.finishing.Wait()
+}
+
+// ----------- Game -----------
+// Everything below that changes the game expects s.mu to be held. Sending
+// only queues a message on the connection (see websocket.go), so a slow
+// client can't keep s.mu held.
+
+// Send a message to one connection (ignoring ones that have gone away)
+func (s *liveSession) send(conn *wsConn, msg map[string]interface{}) {
+	if conn == nil {
+		return
+	}
+	data, _ := json.Marshal(msg)
+	conn.WriteText(data)
+}
+
+// Tell one connection what went wrong, in its language
+func (s *liveSession) sendError(conn *wsConn, err error) {
+	if conn == nil {
+		return
+	}
+	s.send(conn, map[string]interface{}{"type": "error", "message": errorText(err, conn.lang)})
+}
+
+// Send a message to the host and every connected player
+func (s *liveSession) broadcast(msg map[string]interface{}) {
+	data, _ := json.Marshal(msg)
+	if s.host != nil {
+		s.host.WriteText(data)
+	}
+	for _, p := range s.players {
+		if p.conn != nil {
+			p.conn.WriteText(data)
+		}
+	}
+}
+
+// Tell everyone who's in the game
+func (s *liveSession) sendLobby() {
+	names := []string{}
+	for _, p := range s.players {
+		if p.conn != nil {
+			names = append(names, p.nickname)
+		}
+	}
+	s.broadcast(map[string]interface{}{"type": "lobby", "players": names})
+}
+
+// The message that puts question i on everyone's screen (no answer in it)
+func (s *liveSession) questionMessage() map[string]interface{} {
+	q := s.questions[s.current]
+	ends := s.questionStart.Add(s.questionTime)
+	return map[string]interface{}{
+		"type":        "question",
+		"index":       s.current,
+		"total":       len(s.questions),
+		"question":    q.Question,
+		"options":     q.Options,
+		"seconds":     int(s.questionTime.Seconds()),
+		"ends_at":     ends.UTC(),
+		"server_time": time.Now().UTC(),
+	}
+}
+
+// Open question i for answers until time runs out
+func (s *liveSession) ask(i int) {
+	s.state = liveQuestion
+	s.current = i
+	s.questionStart = time.Now()
+	for _, p := range s.players {
+		p.answered, p.gained = false, 0
+	}
+	s.broadcast(s.questionMessage())
+
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+	s.timer = time.AfterFunc(s.questionTime, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.state == liveQuestion && s.current == i {
+			s.reveal()
+		}
+	})
+}
+
+// Take a player's answer to the open question
+func (s *liveSession) answer(p *livePlayer, index int, answer string) {
+	if s.state != liveQuestion || index != s.current {
+		s.sendError(p.conn, msgQuestionNotOpen.err())
+		return
+	}
+	if p.answered {
+		return
+	}
+	elapsed := time.Since(s.questionStart)
+	correct := gradeAnswer(s.questions[s.current], answer)
+	p.answered = true
+	p.gained = livePoints(correct, elapsed, s.questionTime)
+	*p.answerFor(s.current) = liveAnswer{Answer: firstRunes(answer, 200), Correct: correct, Points: p.gained, TimeMs: elapsed.Milliseconds()}
+	s.send(p.conn, map[string]interface{}{"type": "answered", "index": index})
+
+	if s.allAnswered() {
+		s.reveal()
+	} else {
+		s.sendProgress()
+	}
+}
+
+// Let the host see answers coming in
+func (s *liveSession) sendProgress() {
+	answered, connected := 0, 0
+	for _, p := range s.players {
+		if p.conn != nil {
+			connected++
+			if p.answered {
+				answered++
+			}
+		}
+	}
+	s.send(s.host, map[string]interface{}{"type": "progress", "answered": answered, "players": connected})
+}
+
+// Has every connected player answered?
+func (s *liveSession) allAnswered() bool {
+	for _, p := range s.players {
+		if p.conn != nil && !p.answered {
+			return false
+		}
+	}
+	return true
+}
+
+// Close the question: add up the points and show the leaderboard
+func (s *liveSession) reveal() {
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+	s.state = liveLeaderboard
+	s.played = s.current + 1
+	for _, p := range s.players {
+		a := p.answerFor(s.current) // Players who didn't answer get an empty one
+		p.score += a.Points
+		if a.Correct {
+			p.correct++
+		}
+	}
+
+	q := s.questions[s.current]
+	standings := s.standings()
+	s.broadcast(map[string]interface{}{
+		"type":          "leaderboard",
+		"index":         s.current,
+		"total":         len(s.questions),
+		"last":          s.current == len(s.questions)-1,
+		"correctAnswer": q.CorrectAnswer,
+		"explanation":   q.Explanation,
+		"standings":     standings,
+	})
+	for _, st := range standings {
+		p := st.player
+		s.send(p.conn, map[string]interface{}{
+			"type":    "result",
+			"index":   s.current,
+			"correct": p.answers[s.current].Correct,
+			"points":  p.gained,
+			"score":   p.score,
+			"rank":    st.Rank,
+		})
+	}
+	if s.host == nil {
+		s.waitForHost() // They left during the question
+	}
+}
+
+// Everyone's position, best first. Equal scores share a rank.
+func (s *liveSession) standings() []liveStanding {
+	players := append([]*livePlayer(nil), s.players...)
+	sort.SliceStable(players, func(i, j int) bool {
+		if players[i].score != players[j].score {
+			return players[i].score > players[j].score
+		}
+		return strings.ToLower(players[i].nickname) < strings.ToLower(players[j].nickname)
+	})
+
+	result := make([]liveStanding, len(players))
+	rank := 0
+	for i, p := range players {
+		if i == 0 || p.score != players[i-1].score {
+			rank = i + 1
+		}
+		result[i] = liveStanding{player: p, Nickname: p.nickname, Rank: rank, Score: p.score, Gained: p.gained}
+	}
+	return result
+}
+
+// The answer slot for question i, adding empty ones for questions the player missed
+func (p *livePlayer) answerFor(i int) *liveAnswer {
+	for len(p.answers) <= i {
+		p.answers = append(p.answers, liveAnswer{})
+	}
+	return &p.answers[i]
+}
+
+// End the game: save the results, say goodbye and hang up
+func (s *liveSession) finish(reason msgKey) {
+	if s.state == liveFinished {
+		return
+	}
+	s.state = liveFinished
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+	close(s.done)
+	s.app.live.remove(s.pin)
+
+	standings := s.standings()
+	var result *LiveSessionResult
+	if s.played > 0 {
+		result = s.result(standings)
+		s.app.metrics.liveEvents.Inc("finished")
+	} else {
+		s.app.metrics.liveEvents.Inc("abandoned")
+	}
+	conns := []*wsConn{s.host}
+	for _, p := range s.players {
+		conns = append(conns, p.conn)
+	}
+	slog.Info("live session ended", "pin", s.pin, "quiz_id", s.quizID, "players", len(s.players), "played", s.played, "reason", string(reason))
+
+	// Saving and writing to every socket can be slow, so it's done from
+	// copies once s.mu is let go. Results are saved first so they're there
+	// for anyone who looks them up on hearing the game is over.
+	s.app.live.finishing.Add(1)
+	go func() {
+		defer s.app.live.finishing.Done()
+		if result != nil {
+			s.save(result)
+		}
+		for _, conn := range conns {
+			if conn != nil {
+				s.send(conn, map[string]interface{}{"type": "finished", "reason": reason.text(conn.lang), "standings": standings})
+				conn.Close(wsCloseNormal, "game over")
+			}
+		}
+		// Shutdown waits on this, so the goodbyes get out before the server stops
+		for _, conn := range conns {
+			if conn != nil {
+				<-conn.Written()
+			}
+		}
+	}()
+}
+
+// How everyone did in the questions that were played
+func (s *liveSession) result(standings []liveStanding) *LiveSessionResult {
+	result := &LiveSessionResult{
+		HostUserID:    s.hostID,
+		QuizID:        s.quizID,
+		Title:         s.title,
+		QuestionCount: s.played,
+		StartedAt:     s.startedAt,
+		FinishedAt:    time.Now(),
+	}
+	for _, st := range standings {
+		p := st.player
+		p.answerFor(s.played - 1)
+		answers, _ := json.Marshal(p.answers[:s.played])
+		result.Players = append(result.Players, LivePlayerResult{
+			Nickname: p.nickname,
+			Rank:     st.Rank,
+			Score:    p.score,
+			Correct:  p.correct,
+			Answers:  answers,
+		})
+	}
+	return result
+}
+
+// Store a game's results. Doesn't need s.mu.
+func (s *liveSession) save(result *LiveSessionResult) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if _, err := s.app.store.SaveLiveSession(ctx, *result); err != nil {
+		slog.Error("could not save live session results", "pin", s.pin, "error", err)
+	}
+}
+
+// Carry out a command from the host
+func (s *liveSession) hostCommand(cmd liveCommand) {
+	switch {
+	case cmd.Type == "start" && s.state == liveLobby:
+		if len(s.players) == 0 {
+			s.sendError(s.host, msgWaitForPlayers.err())
+			return
+		}
+		s.startedAt = time.Now()
+		s.app.metrics.liveEvents.Inc("started")
+		s.ask(0)
+	case cmd.Type == "skip" && s.state == liveQuestion:
+		s.reveal()
+	case cmd.Type == "next" && s.state == liveLeaderboard:
+		if s.current+1 < len(s.questions) {
+			s.ask(s.current + 1)
+		} else {
+			s.finish(msgLastQuestion)
+		}
+	case cmd.Type == "end":
+		s.finish(msgHostEnded)
+	default:
+		s.sendError(s.host, msgCantNow.err(cmd.Type))
+	}
+}
+
+// Bring someone who joined (or came back) up to date
+func (s *liveSession) catchUp(conn *wsConn) {
+	switch s.state {
+	case liveQuestion:
+		s.send(conn, s.questionMessage())
+	case liveLeaderboard:
+		s.send(conn, map[string]interface{}{"type": "leaderboard", "index": s.current, "total": len(s.questions),
+			"last": s.current == len(s.questions)-1, "correctAnswer": s.questions[s.current].CorrectAnswer,
+			"explanation": s.questions[s.current].Explanation, "standings": s.standings()})
+	}
+}
+
+// ----------- Connections -----------
+
+// Run the host's connection until it closes
+func (s *liveSession) serveHost(conn *wsConn) {
+	s.mu.Lock()
+	if s.state == liveFinished {
+		s.mu.Unlock()
+		conn.Close(wsCloseNormal, "game over")
+		return
+	}
+	if s.host != nil {
+		s.host.Close(wsClosePolicyError, "host connected elsewhere")
+	}
+	if s.state == liveLeaderboard && s.timer != nil {
+		s.timer.Stop() // The host is back in time
+	}
+	s.host = conn
+	s.send(conn, map[string]interface{}{
+		"type": "session", "pin": s.pin, "title": s.title, "total": len(s.questions),
+		"seconds": int(s.questionTime.Seconds()), "state": s.state,
+	})
+	s.sendLobby()
+	s.catchUp(conn)
+	s.mu.Unlock()
+
+	for {
+		data, err := conn.ReadMessage()
+		if err != nil {
+			break
+		}
+		var cmd liveCommand
+		if json.Unmarshal(data, &cmd) != nil {
+			continue
+		}
+		s.mu.Lock()
+		if s.host == conn {
+			s.hostCommand(cmd)
+		}
+		s.mu.Unlock()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.host != conn || s.state == liveFinished {
+		return
+	}
+	s.host = nil
+
+	// Give the host a minute to come back (a reload, a flaky connection).
+	// A question that's open carries on; its own timer still reveals it.
+	s.broadcast(map[string]interface{}{"type": "host_left"})
+	if s.state == liveLeaderboard {
+		s.waitForHost()
+	}
+}
+
+// End the game if the host doesn't come back soon. Only used between
+// questions: the lobby has its own timeout and an open question reveals itself.
+func (s *liveSession) waitForHost() {
+	s.timer = time.AfterFunc(liveHostGrace, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.host == nil {
+			s.finish(msgHostLeft)
+		}
+	})
+}
+
+// Run a player's connection until it closes
+func (s *liveSession) servePlayer(conn *wsConn, nickname string) {
+	s.mu.Lock()
+	p, err := s.join(conn, nickname)
+	if err != nil {
+		s.mu.Unlock()
+		s.sendError(conn, err)
+		conn.Close(wsClosePolicyError, "could not join")
+		return
+	}
+	s.send(conn, map[string]interface{}{"type": "joined", "nickname": p.nickname, "title": s.title, "total": len(s.questions), "score": p.score})
+	s.sendLobby()
+	s.catchUp(conn)
+	s.mu.Unlock()
+
+	for {
+		data, err := conn.ReadMessage()
+		if err != nil {
+			break
+		}
+		var cmd liveCommand
+		if json.Unmarshal(data, &cmd) != nil || cmd.Type != "answer" {
+			continue
+		}
+		s.mu.Lock()
+		if p.conn == conn {
+			s.answer(p, cmd.Index, cmd.Answer)
+		}
+		s.mu.Unlock()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if p.conn != conn || s.state == liveFinished {
+		return
+	}
+	p.conn = nil
+	if s.state == liveLobby {
+		// Nothing to come back to yet - free up the nickname
+		for i, other := range s.players {
+			if other == p {
+				s.players = append(s.players[:i], s.players[i+1:]...)
+				break
+			}
+		}
+	}
+	s.sendLobby()
+	if s.state == liveQuestion && s.allAnswered() {
+		s.reveal()
+	}
+}
+
+// Add a player, or reconnect one who dropped out under the same nickname
+func (s *liveSession) join(conn *wsConn, nickname string) (*livePlayer, error) {
+	if s.state == liveFinished {
+		return nil, msgGameFinished.err()
+	}
+	for _, p := range s.players {
+		if strings.EqualFold(p.nickname, nickname) {
+			if p.conn != nil {
+				return nil, msgNicknameTaken.err()
+			}
+			p.conn = conn
+			return p, nil
+		}
+	}
+	if len(s.players) >= s.app.config.Live.MaxPlayers {
+		return nil, msgGameFull.err()
+	}
+	p := &livePlayer{nickname: nickname, conn: conn}
+	s.players = append(s.players, p)
+	s.app.metrics.liveEvents.Inc("joined")
+	return p, nil
+}
+
+// ----------- Endpoints -----------
+
+// Host a saved quiz live: POST /api/live/sessions {"quiz_id": 4, "question_seconds": 20}
+func (a *App) handleLiveCreate(w http.ResponseWriter, r *http.Request, user *User) {
+	if r.Method != http.MethodPost {
+		httpError(w, r, msgMethodNotAllowed, http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		QuizID          int `json:"quiz_id"`
+		QuestionSeconds int `json:"question_seconds"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, r, msgInvalidJSON, http.StatusBadRequest)
+		return
+	}
+	questionTime := time.Duration(a.config.Live.QuestionTime)
+	if req.QuestionSeconds != 0 {
+		questionTime = time.Duration(req.QuestionSeconds) * time.Second
+		if questionTime < liveMinQuestionTime || questionTime > liveMaxQuestionTime {
+			httpError(w, r, msgQuestionSeconds, http.StatusBadRequest,
+				int(liveMinQuestionTime.Seconds()), int(liveMaxQuestionTime.Seconds()))
+			return
+		}
+	}
+
+	quiz, err := a.store.GetQuiz(r.Context(), user.ID, req.QuizID)
+	if err != nil {
+		httpError(w, r, msgQuizNotFound, http.StatusNotFound)
+		return
+	}
+	var questions []QuizQuestion
+	json.Unmarshal([]byte(quiz.QuestionsJSON), &questions)
+	if len(questions) == 0 {
+		httpError(w, r, msgNoQuestions, http.StatusBadRequest)
+		return
+	}
+	// Players answer with a tap, so live games keep to the original types.
+	// Images are only served to their owner, so players couldn't see them.
+	for _, q := range questions {
+		if kind := kindOf(q); !legacyKind(kind) {
+			httpError(w, r, msgNotPlayableLive, http.StatusBadRequest, kindName(kind))
+			return
+		}
+		if q.ImageID != 0 {
+			httpError(w, r, msgImagesNotLive, http.StatusBadRequest)
+			return
+		}
+	}
+
+	s, err := a.live.create(a, user.ID, quiz, questions, questionTime)
+	if err != nil {
+		httpErrorFrom(w, r, err, http.StatusServiceUnavailable)
+		return
+	}
+	a.metrics.liveEvents.Inc("created")
+	logFor(r.Context()).Info("live session created", "pin", s.pin, "quiz_id", quiz.ID)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"pin":              s.pin,
+		"title":            s.title,
+		"question_count":   len(questions),
+		"question_seconds": int(questionTime.Seconds()),
+	})
+}
+
+// Join a session: GET /api/live/ws?pin=123456&nickname=Sam, or ?pin=123456&role=host
+// for the host (who has to be logged in as the user who created it)
+func (a *App) handleLiveSocket(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	s := a.live.get(q.Get("pin"))
+	if s == nil {
+		httpError(w, r, msgNoLiveSession, http.StatusNotFound)
+		return
+	}
+
+	if q.Get("role") == "host" {
+		user, ok := a.getRequestUser(r)
+		if !ok || user.ID != s.hostID {
+			httpError(w, r, msgHostOnly, http.StatusForbidden)
+			return
+		}
+		conn, err := upgradeWebSocket(w, r)
+		if err != nil {
+			return
+		}
+		s.serveHost(conn)
+		return
+	}
+
+	nickname, ok := cleanNickname(q.Get("nickname"))
+	if !ok {
+		httpError(w, r, msgPickNickname, http.StatusBadRequest)
+		return
+	}
+	conn, err := upgradeWebSocket(w, r)
+	if err != nil {
+		return
+	}
+	s.servePlayer(conn, nickname)
+}
+
+// Results of the user's recent live sessions: GET /api/live/results
+func (a *App) handleLiveResults(w http.ResponseWriter, r *http.Request, user *User) {
+	sessions, err := a.store.LiveSessions(r.Context(), user.ID, 20)
+	if err != nil {
+		httpError(w, r, msgDatabaseError, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sessions)
+}
+
+// Serve the page hosts and players use
+func serveLivePage(w http.ResponseWriter, r *http.Request) {
+	tmpl, err := template.ParseFiles("templates/live.html")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	tmpl.Execute(w, nil)
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// ============================================================================
+// AI CLIENT - Talking to OpenAI
+// ============================================================================
+
+// Where chat completion requests go
+const openAIChatURL = "https://api.openai.com/v1/chat/completions"
+
+// Ways of asking for JSON, best first. Providers that reject one get the next.
+var responseFormats = []string{"json_schema", "json_object", "none"}
+
+// A JSON schema the AI's answer should follow
+type OutputSchema struct {
+	Name   string                 // Short identifier, e.g. "quiz"
+	Schema map[string]interface{} // The JSON schema itself
+}
+
+// Shared OpenAI client - one HTTP client (and connection pool) for the whole app
+type LLMClient struct {
+	apiKey   string       // Secret key from OPENAI_API_KEY
+	model    string       // Which AI to use: "gpt-4o"
+	endpoint string       // Chat completions URL
+	http     *http.Client // Reused so connections to OpenAI are kept alive
+	metrics  *Metrics     // Call counts, error rates and latency
+
+	retryBase time.Duration   // First pause before a retry, doubled each time
+	retryMax  time.Duration   // Longest pause we'll take before a retry
+	breaker   *circuitBreaker // Fails fast while the provider is down
+
+	format atomic.Int32 // Index into responseFormats - moves down when the provider turns one away
+}
+
+// Make a new OpenAI client from the llm section of the config
+func NewLLMClient(cfg LLMConfig, metrics *Metrics) *LLMClient {
+	c := &LLMClient{
+		apiKey:   cfg.APIKey,
+		model:    cfg.Model,
+		endpoint: cfg.BaseURL,
+		http:     &http.Client{Timeout: time.Duration(cfg.Timeout)},
+		metrics:  metrics,
+
+		retryBase: time.Duration(cfg.RetryBaseDelay),
+		retryMax:  time.Duration(cfg.RetryMaxDelay),
+		breaker:   newCircuitBreaker(cfg.BreakerFailures, time.Duration(cfg.BreakerCooldown)),
+	}
+	c.format.Store(int32(max(slices.Index(responseFormats, cfg.ResponseFormat), 0)))
+	return c
+}
+
+// Is there an API key to call the provider with?
+func (c *LLMClient) Configured() bool {
+	return c.apiKey != ""
+}
+
+// Send a conversation to OpenAI and return the AI's reply. With a schema the
+// reply is requested as JSON following it, using the best mode the provider
+// supports. The call is abandoned when ctx is cancelled. Failures come back
+// as *LLMError so callers can tell rate limits from outages from bad requests.
+func (c *LLMClient) Chat(ctx context.Context, messages []OpenAIMessage, schema *OutputSchema) (string, error) {
+	for {
+		level := c.format.Load()
+		format := responseFormat(responseFormats[level], schema)
+		content, err := c.call(ctx, OpenAIRequest{Model: c.model, Messages: messages, ResponseFormat: format})
+
+		// Provider doesn't do this kind of JSON mode - step down and ask again
+		if format != nil && formatUnsupported(err) && int(level) < len(responseFormats)-1 {
+			if c.format.CompareAndSwap(level, level+1) {
+				logFor(ctx).Warn("provider rejected response format, falling back",
+					"format", responseFormats[level], "next", responseFormats[level+1], "error", err)
+			}
+			continue
+		}
+		return content, err
+	}
+}
+
+// The response_format to send for a mode, or nil for plain text
+func responseFormat(mode string, schema *OutputSchema) *OpenAIResponseFormat {
+	if schema == nil {
+		return nil
+	}
+	switch mode {
+	case "json_schema":
+		return &OpenAIResponseFormat{
+			Type:       "json_schema",
+			JSONSchema: &OpenAIJSONSchema{Name: schema.Name, Strict: true, Schema: schema.Schema},
+		}
+	case "json_object":
+		return &OpenAIResponseFormat{Type: "json_object"}
+	default:
+		return nil
+	}
+}
+
+// Did the provider turn down the request because of response_format?
+func formatUnsupported(err error) bool {
+	var llmErr *LLMError
+	if !errors.As(err, &llmErr) || llmErr.Kind != llmRejected || llmErr.Status != http.StatusBadRequest {
+		return false
+	}
+	detail := strings.ToLower(llmErr.Err.Error())
+	return strings.Contains(detail, "response_format") || strings.Contains(detail, "json_schema")
+}
+
+// One call to the provider, guarded by the circuit breaker and counted in the metrics
+func (c *LLMClient) call(ctx context.Context, req OpenAIRequest) (string, error) {
+	// Don't pile onto a provider that's down
+	probe, ok, wait := c.breaker.allow()
+	if !ok {
+		c.metrics.llmRequests.Inc(c.model, llmCircuitOpen)
+		return "", &LLMError{Kind: llmCircuitOpen, RetryAfter: wait, Err: errors.New("circuit breaker open")}
+	}
+
+	start := time.Now()
+	content, err := c.chat(ctx, req)
+	elapsed := time.Since(start)
+	c.breaker.record(probe, err)
+
+	// Count every call so error rates and latency show up on /metrics
+	outcome := "ok"
+	if err != nil {
+		outcome = "error"
+		var llmErr *LLMError
+		if errors.As(err, &llmErr) {
+			outcome = llmErr.Kind
+		}
+		logFor(ctx).Warn("llm call failed", "model", c.model, "duration_ms", elapsed.Milliseconds(), "error", err)
+	} else {
+		logFor(ctx).Debug("llm call finished", "model", c.model, "duration_ms", elapsed.Milliseconds(), "content_bytes", len(content))
+	}
+	c.metrics.llmRequests.Inc(c.model, outcome)
+	c.metrics.llmDuration.Observe(elapsed.Seconds(), c.model)
+
+	return content, err
+}
+
+// One round trip to the chat completions endpoint
+func (c *LLMClient) chat(ctx context.Context, req OpenAIRequest) (string, error) {
+	// Prepare request to OpenAI
+	reqBody, _ := json.Marshal(req)
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", c.endpoint, bytes.NewBuffer(reqBody))
+	if err != nil {
+		return "", &LLMError{Kind: llmRejected, Err: err}
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Authorization", "Bearer "+c.apiKey)
+
+	// Send request to OpenAI
+	resp, err := c.http.Do(httpReq)
+	if err != nil {
+		return "", classifyTransportError(ctx, err)
+	}
+	defer resp.Body.Close()
+
+	// Check if OpenAI responded successfully
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return "", classifyStatus(resp, body)
+	}
+
+	// Parse OpenAI's response (the body can still time out while we read it)
+	var openAIResp OpenAIResponse
+	if err := json.NewDecoder(resp.Body).Decode(&openAIResp); err != nil {
+		var syntaxErr *json.SyntaxError
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &syntaxErr) || errors.As(err, &typeErr) {
+			return "", &LLMError{Kind: llmInvalidOutput, Err: fmt.Errorf("parse OpenAI response: %w", err)}
+		}
+		return "", classifyTransportError(ctx, err)
+	}
+
+	// Check for OpenAI errors
+	if openAIResp.Error.Message != "" {
+		return "", &LLMError{Kind: llmRejected, Err: errors.New(openAIResp.Error.Message)}
+	}
+
+	if len(openAIResp.Choices) == 0 {
+		return "", &LLMError{Kind: llmInvalidOutput, Err: errors.New("no choices in OpenAI response")}
+	}
+
+	// Get the generated content
+	choice := openAIResp.Choices[0]
+	if choice.Message.Refusal != "" {
+		return "", &LLMError{Kind: llmRejected, Err: fmt.Errorf("refused: %s", choice.Message.Refusal)}
+	}
+	if choice.FinishReason == "length" {
+		return "", &LLMError{Kind: llmInvalidOutput, Err: errors.New("answer was cut off at the token limit")}
+	}
+	return choice.Message.Content, nil
+}
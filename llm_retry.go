@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ============================================================================
+// AI CALL FAILURES - Classifying errors, backing off, and failing fast
+// ============================================================================
+
+// Kinds of AI provider failure - they decide whether we retry, whether the
+// circuit breaker counts it, and what the user is told
+const (
+	llmRateLimited   = "rate_limited"   // 429: slow down and try again
+	llmUnavailable   = "unavailable"    // 5xx or couldn't connect
+	llmTimeout       = "timeout"        // No answer in time
+	llmInvalidOutput = "invalid_output" // Answered, but not with a usable quiz
+	llmRejected      = "rejected"       // Other 4xx (bad key, bad request) - retrying won't help
+	llmCircuitOpen   = "circuit_open"   // We're not calling the provider right now
+)
+
+// A classified AI provider failure
+type LLMError struct {
+	Kind       string
+	Status     int           // HTTP status from the provider, 0 if we never got one
+	RetryAfter time.Duration // From the provider's Retry-After header, or how long the circuit stays open
+	Err        error         // The underlying detail, for logs only
+}
+
+func (e *LLMError) Error() string {
+	if e.Status != 0 {
+		return fmt.Sprintf("llm %s (status %d): %v", e.Kind, e.Status, e.Err)
+	}
+	return fmt.Sprintf("llm %s: %v", e.Kind, e.Err)
+}
+
+func (e *LLMError) Unwrap() error {
+	return e.Err
+}
+
+// Is it worth asking again?
+func (e *LLMError) Retryable() bool {
+	return e.Kind != llmRejected
+}
+
+// What to tell the person waiting for their quiz (upstream bodies stay in the logs)
+func (e *LLMError) UserMessage() msgKey {
+	switch e.Kind {
+	case llmRateLimited:
+		return msgAIBusy
+	case llmUnavailable, llmCircuitOpen:
+		return msgAIUnavailable
+	case llmTimeout:
+		return msgAITimeout
+	case llmInvalidOutput:
+		return msgAIBadOutput
+	default:
+		return msgAIRejected
+	}
+}
+
+// HTTP status to answer with when the failure reaches a client directly
+func (e *LLMError) HTTPStatus() int {
+	switch e.Kind {
+	case llmRateLimited:
+		return http.StatusTooManyRequests
+	case llmUnavailable, llmCircuitOpen:
+		return http.StatusServiceUnavailable
+	case llmTimeout:
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusBadGateway
+	}
+}
+
+// Turn a failed round trip into an LLMError. Cancellation by our own caller
+// is passed through untouched - that's not the provider's fault.
+func classifyTransportError(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return &LLMError{Kind: llmTimeout, Err: err}
+	}
+	return &LLMError{Kind: llmUnavailable, Err: err}
+}
+
+// Turn a non-200 answer into an LLMError
+func classifyStatus(resp *http.Response, body []byte) error {
+	err := &LLMError{Status: resp.StatusCode, Err: errors.New(string(body))}
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		err.Kind = llmRateLimited
+		err.RetryAfter = parseRetryAfter(resp.Header.Get("Retry-After"))
+	case resp.StatusCode == http.StatusRequestTimeout, resp.StatusCode == http.StatusGatewayTimeout:
+		err.Kind = llmTimeout
+	case resp.StatusCode >= 500:
+		err.Kind = llmUnavailable
+		err.RetryAfter = parseRetryAfter(resp.Header.Get("Retry-After"))
+	default:
+		err.Kind = llmRejected
+	}
+	return err
+}
+
+// Retry-After is either a number of seconds or an HTTP date
+func parseRetryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if when, err := http.ParseTime(v); err == nil {
+		if d := time.Until(when); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+// ----------- Backoff -----------
+
+// How long to wait before retry number attempt (0 = first retry): exponential
+// with jitter so a burst of failed jobs doesn't retry in lockstep, and never
+// sooner than the provider asked for. ok is false when the wait would be
+// longer than we're willing to sit on a job.
+func (c *LLMClient) backoff(attempt int, retryAfter time.Duration) (time.Duration, bool) {
+	delay := c.retryBase << attempt
+	if delay <= 0 || delay > c.retryMax {
+		delay = c.retryMax
+	}
+	delay = delay/2 + rand.N(delay/2+1) // Somewhere between half and all of it
+
+	if retryAfter > delay {
+		delay = retryAfter
+	}
+	return delay, delay <= c.retryMax
+}
+
+// ----------- Circuit breaker -----------
+
+// Stops calling the provider after a run of outages or timeouts. While open,
+// calls fail straight away; after the cooldown one trial call is let through,
+// and its result decides whether the circuit closes or stays open.
+type circuitBreaker struct {
+	threshold int           // Consecutive failures that open the circuit
+	cooldown  time.Duration // How long it stays open
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	probe     uint64 // The trial call in flight, 0 if there isn't one
+	probes    uint64 // Trial calls let through so far, to number them
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{threshold: threshold, cooldown: cooldown}
+}
+
+// May a call go out now? If not, also says how long until it's worth asking
+// again. A trial call gets a probe number, to be handed back to record.
+func (b *circuitBreaker) allow() (uint64, bool, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures < b.threshold {
+		return 0, true, 0
+	}
+	if wait := time.Until(b.openUntil); wait > 0 {
+		return 0, false, wait
+	}
+	if b.probe != 0 {
+		return 0, false, time.Second
+	}
+	b.probes++
+	b.probe = b.probes
+	return b.probe, true, 0
+}
+
+// Record how a call went. Only outages and timeouts count against the
+// provider - a rate limit or a bad answer means it's up. Calls that went out
+// before the circuit opened can finish while the trial call is still going;
+// only the trial call itself lets another one through.
+func (b *circuitBreaker) record(probe uint64, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if probe != 0 && probe == b.probe {
+		b.probe = 0
+	}
+
+	var llmErr *LLMError
+	switch {
+	case err == nil:
+		b.failures = 0
+	case errors.As(err, &llmErr) && (llmErr.Kind == llmUnavailable || llmErr.Kind == llmTimeout):
+		b.failures++
+		if b.failures >= b.threshold {
+			b.openUntil = time.Now().Add(b.cooldown)
+		}
+	case errors.As(err, &llmErr):
+		b.failures = 0
+	}
+	// Anything else (our caller gave up) says nothing about the provider
+}
+
+// Is the circuit open right now, and for how much longer?
+func (b *circuitBreaker) open() (bool, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures < b.threshold {
+		return false, 0
+	}
+	wait := time.Until(b.openUntil)
+	return wait > 0, wait
+}
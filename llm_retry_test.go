@@ -0,0 +1,34 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// A call that went out before the circuit opened mustn't let a second trial
+// call through while the first is still going
+func TestCircuitBreakerOneProbe(t *testing.T) {
+	b := newCircuitBreaker(1, time.Millisecond)
+	straggler, ok, _ := b.allow()
+	if !ok || straggler != 0 {
+		t.Fatalf("closed circuit: probe %d, ok %v", straggler, ok)
+	}
+	b.record(0, &LLMError{Kind: llmUnavailable, Err: errors.New("down")})
+	time.Sleep(2 * time.Millisecond)
+
+	probe, ok, _ := b.allow()
+	if !ok || probe == 0 {
+		t.Fatalf("after the cooldown: probe %d, ok %v", probe, ok)
+	}
+	b.record(straggler, &LLMError{Kind: llmTimeout, Err: errors.New("slow")})
+	time.Sleep(2 * time.Millisecond)
+	if _, ok, _ := b.allow(); ok {
+		t.Fatal("a second trial call went out while the first was in flight")
+	}
+
+	b.record(probe, nil)
+	if _, ok, _ := b.allow(); !ok {
+		t.Fatal("circuit stayed open after the trial call succeeded")
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+// ============================================================================
+// LOGGING AND REQUEST TRACKING - Structured logs tied together by request ID
+// ============================================================================
+
+// Header used to pass request IDs in from proxies and back out to clients
+const requestIDHeader = "X-Request-ID"
+
+// Incoming request IDs are only trusted if they look harmless
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// Key for storing the request ID in a request's context
+type requestIDKey struct{}
+
+// Set up the process-wide structured logger ("json" or "text" output)
+func setupLogger(cfg LogConfig) {
+	var level slog.Level
+	level.UnmarshalText([]byte(cfg.Level)) // Validated at startup, defaults to info
+
+	opts := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler = slog.NewJSONHandler(os.Stderr, opts)
+	if strings.EqualFold(cfg.Format, "text") {
+		handler = slog.NewTextHandler(os.Stderr, opts)
+	}
+	slog.SetDefault(slog.New(handler))
+}
+
+// Get the request ID stored by the requestID middleware (empty outside a request)
+func requestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// Logger that stamps every line with the current request's ID
+func logFor(ctx context.Context) *slog.Logger {
+	if id := requestIDFrom(ctx); id != "" {
+		return slog.Default().With("request_id", id)
+	}
+	return slog.Default()
+}
+
+// Give every request an ID (reusing a sane one from a proxy) and echo it back
+func requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id = uuid.New().String()
+		}
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+// Remembers the status code a handler wrote so we can log and count it
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	if s.status == 0 {
+		s.status = code
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	return s.ResponseWriter.Write(b)
+}
+
+// Let http.ResponseController reach the real writer (flushing, deadlines)
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// HTTP methods that get their own metrics label; anything else a client
+// makes up is counted as "other"
+var metricMethods = map[string]bool{
+	http.MethodGet: true, http.MethodHead: true, http.MethodPost: true, http.MethodPut: true,
+	http.MethodPatch: true, http.MethodDelete: true, http.MethodOptions: true,
+}
+
+// Log every request and record its latency and status in the metrics.
+// The route label is the mux pattern (e.g. "/api/quiz-detail"), not the raw
+// path, and the method label is one of metricMethods, so IDs, typos and
+// made-up methods can't blow up the number of series.
+func (a *App) observeRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		route := r.Pattern
+		if route == "" {
+			route = "unmatched"
+		}
+		method := r.Method
+		if !metricMethods[method] {
+			method = "other"
+		}
+		elapsed := time.Since(start)
+
+		a.metrics.httpRequests.Inc(route, method, strconv.Itoa(rec.status))
+		a.metrics.httpDuration.Observe(elapsed.Seconds(), route, method)
+
+		logFor(r.Context()).Info("request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"route", route,
+			"status", rec.status,
+			"duration_ms", elapsed.Milliseconds(),
+			"remote_addr", r.RemoteAddr,
+		)
+	})
+}
+
+// Shorten long text for log lines (max is in bytes)
+func truncate(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	// Back up to the start of a character so none is cut in half
+	for max > 0 && !utf8.RuneStart(s[max]) {
+		max--
+	}
+	return s[:max] + "..."
+}
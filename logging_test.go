@@ -0,0 +1,25 @@
+package main
+
+import (
+	"testing"
+	"unicode/utf8"
+)
+
+func TestTruncateKeepsCharactersWhole(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		max  int
+		want string
+	}{
+		{"short", 10, "short"},
+		{"hello world", 5, "hello..."},
+		{"Grüße", 3, "Gr..."}, // ü is two bytes; cutting at 3 would split it
+		{"日本語", 4, "日..."},
+		{"日本語", 2, "..."},
+	} {
+		got := truncate(tc.in, tc.max)
+		if got != tc.want || !utf8.ValidString(got) {
+			t.Errorf("truncate(%q, %d) = %q, want %q", tc.in, tc.max, got, tc.want)
+		}
+	}
+}
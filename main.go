@@ -1,24 +1,22 @@
 package main
 
 import (
-	"bytes"
-	"database/sql"
+	"context"
 	"encoding/json"
 	"fmt"
 	"html/template"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"encoding/gob"
 
-	"github.com/google/uuid"
 	"github.com/joho/godotenv"
 	"github.com/ledongthuc/pdf"
-	_ "modernc.org/sqlite" // Pure-Go SQLite driver (no CGO required)
 	"github.com/nguyenthenguyen/docx"
 	"golang.org/x/crypto/bcrypt"
 )
@@ -33,24 +31,65 @@ type QuizRequest struct {
 	Difficulty    string `json:"difficulty"`     // How hard: Easy, Medium, or Hard
 	QuestionCount int    `json:"questionCount"`  // How many questions: 5, 10, 15, etc.
 	QuizType      string `json:"quizType"`       // What kind: Multiple Choice, True/False, etc.
+	Language      string `json:"language,omitempty"` // Language code for the quiz, e.g. "es" (optional)
+	Source        string `json:"source,omitempty"`   // Text of an uploaded document to quiz on - untrusted (optional)
+	Fresh         bool   `json:"fresh,omitempty"`    // Skip the generation cache and ask the AI for new questions
+
+	TimeLimit         int `json:"time_limit,omitempty"`          // Seconds for the whole quiz once saved (0 = no limit)
+	QuestionTimeLimit int `json:"question_time_limit,omitempty"` // Seconds for each question once saved (0 = no limit)
+
+	Images []int `json:"images,omitempty"` // IDs of the user's images to ask questions about (optional, see media.go)
+
+	Avoid     []string `json:"-"` // Questions the AI shouldn't repeat (set when replacing duplicates)
+	ImageData []string `json:"-"` // The images as data URLs, loaded when the job runs
 }
 
 // Single message in conversation with OpenAI
 type OpenAIMessage struct {
-	Role    string `json:"role"`    // Who's speaking: "system" (instructions), "user" (our request)
-	Content string `json:"content"` // What they're saying
+	Role    string   `json:"role"`              // Who's speaking: "system" (instructions), "user" (our request)
+	Content string   `json:"content"`           // What they're saying
+	Refusal string   `json:"refusal,omitempty"` // Set instead of content when the AI declines to answer
+	Images  []string `json:"-"`                 // Data URLs of images to show the AI along with the content
+}
+
+// A message with images is sent as a list of parts: the text, then each image
+func (m OpenAIMessage) MarshalJSON() ([]byte, error) {
+	type plain OpenAIMessage // Without this method, so it doesn't call itself
+	if len(m.Images) == 0 {
+		return json.Marshal(plain(m))
+	}
+	parts := []map[string]interface{}{{"type": "text", "text": m.Content}}
+	for _, url := range m.Images {
+		parts = append(parts, map[string]interface{}{"type": "image_url", "image_url": map[string]string{"url": url}})
+	}
+	return json.Marshal(map[string]interface{}{"role": m.Role, "content": parts})
 }
 
 // Full request we send to OpenAI
 type OpenAIRequest struct {
-	Model    string          `json:"model"`    // Which AI to use: "gpt-4o"
-	Messages []OpenAIMessage `json:"messages"` // Conversation history
+	Model          string                `json:"model"`                     // Which AI to use: "gpt-4o"
+	Messages       []OpenAIMessage       `json:"messages"`                  // Conversation history
+	ResponseFormat *OpenAIResponseFormat `json:"response_format,omitempty"` // Ask for JSON (optionally matching a schema)
+}
+
+// How we want the answer shaped: "json_schema" or "json_object"
+type OpenAIResponseFormat struct {
+	Type       string            `json:"type"`
+	JSONSchema *OpenAIJSONSchema `json:"json_schema,omitempty"`
+}
+
+// A named JSON schema the answer must follow
+type OpenAIJSONSchema struct {
+	Name   string                 `json:"name"`
+	Strict bool                   `json:"strict"`
+	Schema map[string]interface{} `json:"schema"`
 }
 
 // Response we get back from OpenAI
 type OpenAIResponse struct {
 	Choices []struct {
-		Message OpenAIMessage `json:"message"` // AI's generated response
+		Message      OpenAIMessage `json:"message"`       // AI's generated response
+		FinishReason string        `json:"finish_reason"` // "length" means the answer was cut off
 	} `json:"choices"`
 	Error struct {
 		Message string `json:"message"` // If something went wrong
@@ -105,23 +144,28 @@ type QuizDetail struct {
 	Score      int         `json:"score"`       // Their score
 	IsComplete bool        `json:"is_complete"` // Completion status
 	Date       string      `json:"date"`        // When created
+	PromptVersion string   `json:"prompt_version,omitempty"` // Prompt template that generated it
+
+	TimeLimit         int             `json:"time_limit"`              // Seconds for the whole quiz (0 = no limit)
+	QuestionTimeLimit int             `json:"question_time_limit"`     // Seconds for each question (0 = no limit)
+	AutoSubmitted     bool            `json:"auto_submitted"`          // Time ran out before every question was answered
+	AnswerTimes       []AttemptAnswer `json:"answer_times,omitempty"`  // Each answer and how long it took (timed attempts only)
 }
 
 // When saving a newly generated quiz
 type SaveQuizRequest struct {
-	Prompt    string      `json:"prompt"`    // What the quiz is about
-	Questions interface{} `json:"questions"` // The actual quiz content
+	Prompt    string         `json:"prompt"`    // What the quiz is about
+	Questions []QuizQuestion `json:"questions"` // The actual quiz content
+
+	TimeLimit         int `json:"time_limit"`          // Seconds for the whole quiz (0 = no limit)
+	QuestionTimeLimit int `json:"question_time_limit"` // Seconds for each question (0 = no limit)
 }
 
 // ============================================================================
 // APPLICATION SETUP AND CONFIGURATION
 // ============================================================================
 
-// Where we store our data
-const dbPath = "askify.db"
-
-// Track logged-in users: session_id -> user_id
-var sessions = map[string]int{}
+// Name of the cookie that remembers who is logged in
 const sessionCookieName = "askify_session"
 
 // Tell Go how to store complex data in sessions
@@ -149,12 +193,12 @@ func checkPasswordHash(password, hash string) bool {
 // AUTHENTICATION MIDDLEWARE - Protecting routes
 // ============================================================================
 
-// Wrap handlers that require login - checks if user is logged in first
-func requireAuth(handler func(http.ResponseWriter, *http.Request, *User)) http.HandlerFunc {
+// Wrap handlers that require login - checks for a session cookie or API token first
+func (a *App) requireAuth(handler func(http.ResponseWriter, *http.Request, *User)) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		user, ok := getSessionUser(r)
+		user, ok := a.getRequestUser(r)
 		if !ok {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			httpError(w, r, msgUnauthorized, http.StatusUnauthorized)
 			return
 		}
 		handler(w, r, user)
@@ -162,7 +206,7 @@ func requireAuth(handler func(http.ResponseWriter, *http.Request, *User)) http.H
 }
 
 // Get user info from session cookie
-func getSessionUser(r *http.Request) (*User, bool) {
+func (a *App) getSessionUser(r *http.Request) (*User, bool) {
 	// Look for our session cookie
 	c, err := r.Cookie(sessionCookieName)
 	if err != nil {
@@ -171,25 +215,18 @@ func getSessionUser(r *http.Request) (*User, bool) {
 	
 	// Find user ID for this session
 	sessionID := c.Value
-	userID, ok := sessions[sessionID]
+	userID, ok := a.sessions.Get(sessionID)
 	if !ok {
 		return nil, false // Invalid session
 	}
 	
-	// Get user details from database
-	db, err := sql.Open("sqlite", dbPath)
+	// Get user details from the database
+	user, err := a.store.UserByID(r.Context(), userID)
 	if err != nil {
-		return nil, false
-	}
-	defer db.Close()
-	
-	var user User
-	row := db.QueryRow("SELECT id, email, name FROM users WHERE id=?", userID)
-	if err := row.Scan(&user.ID, &user.Email, &user.Name); err != nil {
 		return nil, false // User not found in database
 	}
 	
-	return &user, true
+	return user, true
 }
 
 // ============================================================================
@@ -197,157 +234,135 @@ func getSessionUser(r *http.Request) (*User, bool) {
 // ============================================================================
 
 // Create new user account
-func handleSignup(db *sql.DB) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodPost {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-			return
-		}
-		
-		// Read signup data from request
-		var req SignupRequest
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			http.Error(w, "Invalid JSON", http.StatusBadRequest)
-			return
-		}
-		
-		// Check required fields
-		if req.Email == "" || req.Password == "" {
-			http.Error(w, "Email and password are required", http.StatusBadRequest)
-			return
-		}
-		
-		// Secure the password
-		hash, err := hashPassword(req.Password)
-		if err != nil {
-			http.Error(w, "Error hashing password", http.StatusInternalServerError)
-			return
-		}
-		
-		// Use email username as default name if none provided
-		if req.Name == "" {
-			req.Name = strings.Split(req.Email, "@")[0]
-		}
-		
-		// Save user to database
-		res, err := db.Exec("INSERT INTO users(email, password_hash, name) VALUES(?, ?, ?)", req.Email, hash, req.Name)
-		if err != nil {
-			log.Printf("Error creating user: %v", err)
-			http.Error(w, "User already exists or error saving user", http.StatusBadRequest)
-			return
-		}
-		
-		// Get the new user's ID
-		userID, _ := res.LastInsertId()
-		
-		// Create session so they stay logged in
-		sessionID := uuid.New().String()
-		sessions[sessionID] = int(userID)
-		
-		// Set session cookie in browser
-		http.SetCookie(w, &http.Cookie{
-			Name:     sessionCookieName,
-			Value:    sessionID,
-			HttpOnly: true, // Prevent JavaScript access (security)
-			Path:     "/",
-			MaxAge:   86400 * 30, // Expires in 30 days
-		})
-		
-		// Send success response with user info
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"status": "ok",
-			"user": User{
-				ID:    int(userID),
-				Email: req.Email,
-				Name:  req.Name,
-			},
-		})
+func (a *App) handleSignup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, r, msgMethodNotAllowed, http.StatusMethodNotAllowed)
+		return
+	}
+	
+	// New accounts can be switched off in the config
+	if !a.config.Features.Signup {
+		httpError(w, r, msgSignupsDisabled, http.StatusForbidden)
+		return
+	}
+	
+	// Read signup data from request
+	var req SignupRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, r, msgInvalidJSON, http.StatusBadRequest)
+		return
 	}
+	
+	// Check required fields
+	if req.Email == "" || req.Password == "" {
+		httpError(w, r, msgCredentialsMissing, http.StatusBadRequest)
+		return
+	}
+	
+	// Secure the password
+	hash, err := hashPassword(req.Password)
+	if err != nil {
+		httpError(w, r, msgPasswordFailed, http.StatusInternalServerError)
+		return
+	}
+	
+	// Use email username as default name if none provided
+	if req.Name == "" {
+		req.Name = strings.Split(req.Email, "@")[0]
+	}
+	
+	// Save user to database and get the new user's ID
+	userID, err := a.store.CreateUser(r.Context(), req.Email, hash, req.Name)
+	if err != nil {
+		logFor(r.Context()).Warn("error creating user", "error", err)
+		httpError(w, r, msgUserExists, http.StatusBadRequest)
+		return
+	}
+	
+	// Create session so they stay logged in
+	sessionID := a.sessions.Create(userID)
+	
+	// Set session cookie in browser (expires when the session does)
+	http.SetCookie(w, a.newSessionCookie(sessionID))
+	
+	// Send success response with user info
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": "ok",
+		"user": User{
+			ID:    userID,
+			Email: req.Email,
+			Name:  req.Name,
+		},
+	})
 }
 
 // Log user in
-func handleLogin(db *sql.DB) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodPost {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-			return
-		}
-		
-		// Read login data
-		var req LoginRequest
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			http.Error(w, "Invalid JSON", http.StatusBadRequest)
-			return
-		}
-		
-		// Look up user in database
-		var id int
-		var hash, name string
-		row := db.QueryRow("SELECT id, password_hash, name FROM users WHERE email=?", req.Email)
-		if err := row.Scan(&id, &hash, &name); err != nil {
-			http.Error(w, "Invalid credentials", http.StatusUnauthorized)
-			return
-		}
-		
-		// Check if password is correct
-		if !checkPasswordHash(req.Password, hash) {
-			http.Error(w, "Invalid credentials", http.StatusUnauthorized)
-			return
-		}
-		
-		// Create session
-		sessionID := uuid.New().String()
-		sessions[sessionID] = id
-		
-		// Set session cookie
-		http.SetCookie(w, &http.Cookie{
-			Name:     sessionCookieName,
-			Value:    sessionID,
-			HttpOnly: true,
-			Path:     "/",
-			MaxAge:   86400 * 30,
-		})
-		
-		// Send success response
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"status": "ok",
-			"user": User{
-				ID:    id,
-				Email: req.Email,
-				Name:  name,
-			},
-		})
+func (a *App) handleLogin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, r, msgMethodNotAllowed, http.StatusMethodNotAllowed)
+		return
+	}
+	
+	// Read login data
+	var req LoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, r, msgInvalidJSON, http.StatusBadRequest)
+		return
+	}
+	
+	// Look up user in database
+	user, hash, err := a.store.UserByEmail(r.Context(), req.Email)
+	if err != nil {
+		httpError(w, r, msgInvalidCredentials, http.StatusUnauthorized)
+		return
 	}
+	
+	// Check if password is correct
+	if !checkPasswordHash(req.Password, hash) {
+		httpError(w, r, msgInvalidCredentials, http.StatusUnauthorized)
+		return
+	}
+	
+	// Create session
+	sessionID := a.sessions.Create(user.ID)
+	
+	// Set session cookie
+	http.SetCookie(w, a.newSessionCookie(sessionID))
+	
+	// Send success response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": "ok",
+		"user": user,
+	})
 }
 
 // Log user out
-func handleLogout(w http.ResponseWriter, r *http.Request) {
+func (a *App) handleLogout(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		httpError(w, r, msgMethodNotAllowed, http.StatusMethodNotAllowed)
 		return
 	}
 	
 	// Remove session from memory
 	c, err := r.Cookie(sessionCookieName)
 	if err == nil {
-		delete(sessions, c.Value)
+		a.sessions.Delete(c.Value)
 	}
 	
 	// Expire the cookie in browser
-	http.SetCookie(w, &http.Cookie{
-		Name: sessionCookieName, Value: "", Path: "/", MaxAge: -1})
+	http.SetCookie(w, a.expiredSessionCookie())
 	
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
 }
 
 // Get current user's profile
-func handleUserProfile(w http.ResponseWriter, r *http.Request) {
-	user, ok := getSessionUser(r)
+func (a *App) handleUserProfile(w http.ResponseWriter, r *http.Request) {
+	user, ok := a.getRequestUser(r)
 	if !ok {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		httpError(w, r, msgUnauthorized, http.StatusUnauthorized)
 		return
 	}
 	
@@ -356,234 +371,163 @@ func handleUserProfile(w http.ResponseWriter, r *http.Request) {
 }
 
 // ============================================================================
-// DATABASE SETUP - Creating tables and structure
+// QUIZ MANAGEMENT HANDLERS - Saving, history, details
 // ============================================================================
 
-// Initialize database and create tables if they don't exist
-func mustInitDB() *sql.DB {
-	// Connect to SQLite database
-	db, err := sql.Open("sqlite", dbPath)
-	if err != nil {
-		log.Fatalf("Failed to open DB: %v", err)
+// Save or update quiz attempt results
+func (a *App) handleSaveQuizAttempt(w http.ResponseWriter, r *http.Request, user *User) {
+	if r.Method != http.MethodPost {
+		httpError(w, r, msgMethodNotAllowed, http.StatusMethodNotAllowed)
+		return
 	}
 	
-	// Test connection works
-	if err := db.Ping(); err != nil {
-		log.Fatalf("Failed to ping DB: %v", err)
+	var req SaveQuizAttemptRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, r, msgInvalidJSON, http.StatusBadRequest)
+		return
 	}
 	
-	// Create users table
-	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS users (
-                id INTEGER PRIMARY KEY AUTOINCREMENT,
-                email TEXT UNIQUE NOT NULL,
-                password_hash TEXT NOT NULL,
-                name TEXT NOT NULL DEFAULT '',
-                created_at DATETIME NOT NULL DEFAULT (datetime('now'))
-        )`)
-	if err != nil {
-		log.Fatalf("Failed to create users table: %v", err)
+	// The server keeps the score for timed quizzes, so the browser can't overwrite it
+	if quiz, err := a.store.GetQuiz(r.Context(), user.ID, req.QuizID); err == nil && quiz.Timed() {
+		httpError(w, r, msgTimedAttempt, http.StatusConflict)
+		return
 	}
-	
-	// If we added the name column later, check if it exists and add it
-	var nameColumnExists bool
-	err = db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('users') WHERE name='name'`).Scan(&nameColumnExists)
-	if err == nil && !nameColumnExists {
-		log.Println("Adding name column to users table...")
-		_, err = db.Exec(`ALTER TABLE users ADD COLUMN name TEXT NOT NULL DEFAULT ''`)
-		if err != nil {
-			log.Printf("Warning: Could not add name column: %v", err)
-		}
+	if attempt, err := a.store.GetAttempt(r.Context(), user.ID, req.QuizID); err == nil && attempt.Tracked() {
+		httpError(w, r, msgServerScored, http.StatusConflict)
+		return
 	}
 	
-	// Create table for storing generated quizzes
-	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS quizzes (
-                id INTEGER PRIMARY KEY AUTOINCREMENT,
-                user_id INTEGER NOT NULL,
-                prompt TEXT NOT NULL,
-                questions_json TEXT NOT NULL,
-                created_at DATETIME NOT NULL DEFAULT (datetime('now')),
-                FOREIGN KEY(user_id) REFERENCES users(id)
-        )`)
-	if err != nil {
-		log.Fatalf("Failed to create quizzes table: %v", err)
-	}
-	
-	// Create table for tracking quiz attempts and scores
-	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS quiz_attempts (
-                id INTEGER PRIMARY KEY AUTOINCREMENT,
-                user_id INTEGER NOT NULL,
-                quiz_id INTEGER NOT NULL,
-                answers_json TEXT,
-                score INTEGER,
-                is_complete BOOLEAN NOT NULL DEFAULT 0,
-                started_at DATETIME NOT NULL DEFAULT (datetime('now')),
-                completed_at DATETIME,
-                FOREIGN KEY(user_id) REFERENCES users(id),
-                FOREIGN KEY(quiz_id) REFERENCES quizzes(id)
-        )`)
-	if err != nil {
-		log.Fatalf("Failed to create quiz_attempts table: %v", err)
+	// Create the attempt, or update it if the user already attempted this quiz
+	if err := a.store.SaveAttempt(r.Context(), user.ID, req); err != nil {
+		httpError(w, r, msgSaveAttemptFailed, http.StatusInternalServerError)
+		return
 	}
 	
-	log.Println("Database initialized successfully")
-	return db
-}
-
-// ============================================================================
-// QUIZ MANAGEMENT HANDLERS - Saving, history, details
-// ============================================================================
-
-// Save or update quiz attempt results
-func handleSaveQuizAttempt(db *sql.DB) http.HandlerFunc {
-	return requireAuth(func(w http.ResponseWriter, r *http.Request, user *User) {
-		if r.Method != http.MethodPost {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-			return
-		}
-		
-		var req SaveQuizAttemptRequest
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			http.Error(w, "Invalid JSON", http.StatusBadRequest)
-			return
-		}
-		
-		// Check if user already attempted this quiz
-		var attemptID int
-		row := db.QueryRow("SELECT id FROM quiz_attempts WHERE quiz_id=? AND user_id=?", req.QuizID, user.ID)
-		if err := row.Scan(&attemptID); err == nil {
-			// Update existing attempt
-			_, err := db.Exec("UPDATE quiz_attempts SET answers_json=?, score=?, is_complete=?, completed_at=CASE WHEN ? THEN datetime('now') ELSE completed_at END WHERE id=?", 
-				req.Answers, req.Score, req.IsComplete, req.IsComplete, attemptID)
-			if err != nil {
-				http.Error(w, "Failed to update attempt", http.StatusInternalServerError)
-				return
-			}
-		} else {
-			// Create new attempt record
-			_, err := db.Exec("INSERT INTO quiz_attempts (user_id,quiz_id,answers_json,score,is_complete) VALUES (?,?,?,?,?)", 
-				user.ID, req.QuizID, req.Answers, req.Score, req.IsComplete)
-			if err != nil {
-				http.Error(w, "Failed to save attempt", http.StatusInternalServerError)
-				return
-			}
-		}
-		
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
-	})
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
 }
 
 // Get user's quiz history
-func handleQuizHistory(db *sql.DB) http.HandlerFunc {
-	return requireAuth(func(w http.ResponseWriter, r *http.Request, user *User) {
-		// Get all quizzes for this user with their attempt data and questions_json
-		rows, err := db.Query(`SELECT q.id, q.prompt, IFNULL(a.score,0), q.created_at, IFNULL(a.is_complete,0), q.questions_json
-            FROM quizzes q
-            LEFT JOIN quiz_attempts a ON q.id=a.quiz_id AND a.user_id=?
-            WHERE q.user_id=? ORDER BY q.created_at DESC`, user.ID, user.ID)
-		if err != nil {
-			http.Error(w, "Failed to query history", http.StatusInternalServerError)
-			return
-		}
-		defer rows.Close()
-		
-		var result []QuizHistoryItem
-		for rows.Next() {
-			var it QuizHistoryItem
-			var questionsJSON string
-			if err := rows.Scan(&it.QuizID, &it.Prompt, &it.Score, &it.Date, &it.IsComplete, &questionsJSON); err == nil {
-				// Calculate total questions by parsing the questions_json
-				var questions []interface{}
-				if err := json.Unmarshal([]byte(questionsJSON), &questions); err == nil {
-					it.TotalQuestions = len(questions)
-				} else {
-					// If parsing fails, set to 0
-					it.TotalQuestions = 0
-				}
-				it.QuestionsJSON = questionsJSON
-				result = append(result, it)
-			}
+func (a *App) handleQuizHistory(w http.ResponseWriter, r *http.Request, user *User) {
+	// Get all quizzes for this user with their attempt data and questions_json
+	result, err := a.store.QuizHistory(r.Context(), user.ID)
+	if err != nil {
+		httpError(w, r, msgHistoryFailed, http.StatusInternalServerError)
+		return
+	}
+	
+	for i := range result {
+		// Calculate total questions by parsing the questions_json
+		var questions []interface{}
+		if err := json.Unmarshal([]byte(result[i].QuestionsJSON), &questions); err == nil {
+			result[i].TotalQuestions = len(questions)
+		} else {
+			// If parsing fails, set to 0
+			result[i].TotalQuestions = 0
 		}
-		
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(result)
-	})
+	}
+	
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
 }
 
 // Get detailed information about a specific quiz
-func handleQuizDetail(db *sql.DB) http.HandlerFunc {
-	return requireAuth(func(w http.ResponseWriter, r *http.Request, user *User) {
-		quizIDStr := r.URL.Query().Get("id")
-		if quizIDStr == "" {
-			http.Error(w, "Missing id", http.StatusBadRequest)
-			return
-		}
-		
-		var (
-			quizID                int
-			prompt, questionsJSON string
-			created               string
-		)
-		
-		// Get basic quiz info
-		row := db.QueryRow("SELECT id, prompt, questions_json, created_at FROM quizzes WHERE id=? AND user_id=?", quizIDStr, user.ID)
-		if err := row.Scan(&quizID, &prompt, &questionsJSON, &created); err != nil {
-			http.Error(w, "Quiz not found", http.StatusNotFound)
-			return
+func (a *App) handleQuizDetail(w http.ResponseWriter, r *http.Request, user *User) {
+	quizIDStr := r.URL.Query().Get("id")
+	if quizIDStr == "" {
+		httpError(w, r, msgMissingID, http.StatusBadRequest)
+		return
+	}
+	
+	// Get basic quiz info (a non-numeric id can't match any quiz)
+	quizID, err := strconv.Atoi(quizIDStr)
+	if err != nil {
+		httpError(w, r, msgQuizNotFound, http.StatusNotFound)
+		return
+	}
+	quiz, err := a.store.GetQuiz(r.Context(), user.ID, quizID)
+	if err != nil {
+		httpError(w, r, msgQuizNotFound, http.StatusNotFound)
+		return
+	}
+	
+	// Parse questions from JSON
+	var questions interface{}
+	json.Unmarshal([]byte(quiz.QuestionsJSON), &questions)
+	
+	// Get attempt data if exists
+	attempt, err := a.store.GetAttempt(r.Context(), user.ID, quizID)
+	if err != nil {
+		attempt = &Attempt{}
+	} else {
+		attempt = a.catchUpAttempt(r.Context(), user.ID, quizID, attempt)
+	}
+	
+	var answers interface{}
+	json.Unmarshal([]byte(attempt.AnswersJSON), &answers)
+	
+	// A timed quiz keeps its answers to itself until the attempt is over
+	if quiz.Timed() && !attempt.IsComplete {
+		hideAnswers(questions, answers)
+	}
+	
+	// How long each answer took, for attempts the server timed
+	var answerTimes []AttemptAnswer
+	if attempt.Tracked() {
+		if answerTimes, err = a.store.AttemptAnswers(r.Context(), attempt.ID); err != nil {
+			logFor(r.Context()).Warn("could not load answer times", "quiz_id", quizID, "error", err)
 		}
-		
-		// Parse questions from JSON
-		var questions interface{}
-		json.Unmarshal([]byte(questionsJSON), &questions)
-		
-		// Get attempt data if exists
-		var answersJSON string
-		var score int
-		var isComplete bool
-		var completedAt string
-		row = db.QueryRow("SELECT answers_json, score, is_complete, completed_at FROM quiz_attempts WHERE quiz_id=? AND user_id=?", quizID, user.ID)
-		_ = row.Scan(&answersJSON, &score, &isComplete, &completedAt)
-		
-		var answers interface{}
-		json.Unmarshal([]byte(answersJSON), &answers)
-		
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(QuizDetail{
-			QuizID: quizID, Prompt: prompt, Questions: questions, Answers: answers, 
-			Score: score, IsComplete: isComplete, Date: created,
-		})
+	}
+	
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(QuizDetail{
+		QuizID: quiz.ID, Prompt: quiz.Prompt, Questions: questions, Answers: answers, 
+		Score: attempt.Score, IsComplete: attempt.IsComplete, Date: quiz.CreatedAt,
+		PromptVersion: quiz.PromptVersion,
+		TimeLimit: quiz.TimeLimit, QuestionTimeLimit: quiz.QuestionTimeLimit,
+		AutoSubmitted: attempt.AutoSubmitted, AnswerTimes: answerTimes,
 	})
 }
 
 // Save a newly generated quiz to database
-func handleSaveQuiz(db *sql.DB) http.HandlerFunc {
-	return requireAuth(func(w http.ResponseWriter, r *http.Request, user *User) {
-		if r.Method != http.MethodPost {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-			return
-		}
-		
-		var req SaveQuizRequest
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			http.Error(w, "Invalid JSON", http.StatusBadRequest)
-			return
-		}
-		
-		// Convert questions to JSON for storage
-		questionsJSON, _ := json.Marshal(req.Questions)
-		
-		// Save to database
-		res, err := db.Exec("INSERT INTO quizzes (user_id, prompt, questions_json) VALUES (?, ?, ?)", 
-			user.ID, req.Prompt, string(questionsJSON))
-		if err != nil {
-			http.Error(w, "Failed to save quiz", http.StatusInternalServerError)
-			return
-		}
-		
-		quizID, _ := res.LastInsertId()
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]int64{"quiz_id": quizID})
-	})
+func (a *App) handleSaveQuiz(w http.ResponseWriter, r *http.Request, user *User) {
+	if r.Method != http.MethodPost {
+		httpError(w, r, msgMethodNotAllowed, http.StatusMethodNotAllowed)
+		return
+	}
+	
+	var req SaveQuizRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, r, msgInvalidJSON, http.StatusBadRequest)
+		return
+	}
+	if err := a.checkTimeLimits(req.TimeLimit, req.QuestionTimeLimit); err != nil {
+		httpErrorFrom(w, r, err, http.StatusBadRequest)
+		return
+	}
+	
+	// Questions from the client are cleaned and checked just like generated
+	// ones - they'll be shown to whoever takes the quiz
+	prepareQuestions(req.Questions, "")
+	if err := validateQuestions(req.Questions); err != nil {
+		httpError(w, r, msgInvalidQuiz, http.StatusBadRequest, err)
+		return
+	}
+	
+	// Convert questions to JSON for storage
+	questionsJSON, _ := json.Marshal(req.Questions)
+	
+	// Save to database
+	quizID, err := a.store.SaveQuiz(r.Context(), user.ID, req.Prompt, string(questionsJSON), "", "")
+	if err != nil {
+		httpError(w, r, msgSaveQuizFailed, http.StatusInternalServerError)
+		return
+	}
+	a.addQuizToBank(r.Context(), logFor(r.Context()), user.ID, quizID, string(questionsJSON), req.Prompt, "")
+	a.applyTimeLimits(r.Context(), logFor(r.Context()), user.ID, quizID, req.TimeLimit, req.QuestionTimeLimit)
+	
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"quiz_id": quizID})
 }
 
 // ============================================================================
@@ -593,33 +537,99 @@ func handleSaveQuiz(db *sql.DB) http.HandlerFunc {
 // Application entry point - this runs when we start the server
 func main() {
 	godotenv.Load() // Load environment variables from .env file
-	db := mustInitDB()
-	defer db.Close()
+
+	// Maintenance commands: "askify config print [flags]"
+	if len(os.Args) > 1 && os.Args[1] == "config" {
+		os.Exit(configCommand(os.Args[2:]))
+	}
+
+	// Settings from the config file, environment and flags
+	cfg, err := loadConfig(os.Args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	setupLogger(cfg.Log)
+
+	app, err := NewApp(cfg)
+	if err != nil {
+		slog.Error("failed to start", "error", err)
+		os.Exit(1)
+	}
+	defer app.Close()
+	slog.Info("database initialized", "backend", app.store.Backend())
 
 	// Create directories we need
-	os.MkdirAll("uploads", 0755)    // For uploaded files
-	os.MkdirAll("templates", 0755)  // For HTML pages
-	os.MkdirAll("static/js", 0755)  // For JavaScript
-	os.MkdirAll("static/img", 0755) // For images
-
-	// Set up all the URL routes and what functions handle them
-	http.HandleFunc("/", serveHome) // Main page
-	http.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("static")))) // CSS, JS, images
-	http.HandleFunc("/api/upload", handleUpload) // File uploads
-	http.HandleFunc("/api/generate-quiz", handleGenerateQuiz) // Create new quizzes
-	http.HandleFunc("/api/save-quiz", handleSaveQuiz(db)) // Save quizzes
-	http.HandleFunc("/api/signup", handleSignup(db)) // Create account
-	http.HandleFunc("/api/login", handleLogin(db)) // Log in
-	http.HandleFunc("/api/logout", handleLogout) // Log out
-	http.HandleFunc("/api/user-profile", handleUserProfile) // Get user info
-	http.HandleFunc("/api/save-quiz-attempt", handleSaveQuizAttempt(db)) // Save quiz results
-	http.HandleFunc("/api/quiz-history", handleQuizHistory(db)) // Get quiz history
-	http.HandleFunc("/api/quiz-detail", handleQuizDetail(db)) // Get quiz details
-
-	// Start the web server
-	port := "5000"
-	log.Printf("Server starting on port %s...", port)
-	log.Fatal(http.ListenAndServe(":"+port, nil))
+	os.MkdirAll(cfg.Uploads.Dir, 0755) // For uploaded files
+	os.MkdirAll("templates", 0755)     // For HTML pages
+	os.MkdirAll("static/js", 0755)     // For JavaScript
+	os.MkdirAll("static/img", 0755)    // For images
+
+	// Start the web server and run until SIGINT/SIGTERM
+	if err := app.Serve(); err != nil {
+		slog.Error("server error", "error", err)
+		os.Exit(1)
+	}
+}
+
+// Set up all the URL routes and what functions handle them
+func (a *App) routes() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", serveHome) // Main page
+	mux.HandleFunc("/healthz", a.handleHealthz) // Is the process alive?
+	mux.HandleFunc("/readyz", a.handleReadyz) // Can it serve traffic?
+	if a.config.Features.Metrics {
+		mux.HandleFunc("/metrics", a.handleMetrics) // Prometheus metrics
+	}
+	mux.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("static")))) // CSS, JS, images
+	if a.config.Features.Uploads {
+		mux.HandleFunc("/api/upload", a.handleUpload) // File uploads
+	}
+	mux.HandleFunc("/api/generate-quiz", a.handleGenerateQuiz) // Queue a new quiz
+	mux.HandleFunc("/api/jobs", a.handleJobStatus) // Poll a queued quiz
+	mux.HandleFunc("/api/jobs/events", a.handleJobEvents) // Follow a queued quiz as it runs
+	mux.HandleFunc("/api/save-quiz", a.requireAuth(a.handleSaveQuiz)) // Save quizzes
+	mux.HandleFunc("/api/signup", a.handleSignup) // Create account
+	mux.HandleFunc("/api/login", a.handleLogin) // Log in
+	mux.HandleFunc("/api/logout", a.handleLogout) // Log out
+	mux.HandleFunc("/api/user-profile", a.handleUserProfile) // Get user info
+	mux.HandleFunc("/api/save-quiz-attempt", a.requireAuth(a.handleSaveQuizAttempt)) // Save quiz results
+	mux.HandleFunc("/api/quiz-history", a.requireAuth(a.handleQuizHistory)) // Get quiz history
+	mux.HandleFunc("/api/quiz-detail", a.requireAuth(a.handleQuizDetail)) // Get quiz details
+	mux.HandleFunc("/api/quiz/timing", a.requireAuth(a.handleQuizTiming)) // Set a quiz's time limits
+	mux.HandleFunc("/api/quiz/translate", a.requireAuth(a.handleTranslateQuiz)) // Copy a quiz into another language
+	mux.HandleFunc("/api/quiz/export", a.requireAuth(a.handleQuizExport)) // Download a quiz as text, GIFT or JSON
+	mux.HandleFunc("/api/attempts/start", a.requireAuth(a.handleStartAttempt)) // Start the clock on a quiz
+	mux.HandleFunc("/api/attempts/answer", a.requireAuth(a.handleAnswer)) // Answer one question against the clock
+	mux.HandleFunc("/api/adaptive", a.requireAuth(a.handleAdaptiveStatus)) // Where an adaptive quiz has got to
+	mux.HandleFunc("/api/adaptive/start", a.requireAuth(a.handleAdaptiveStart)) // Start an adaptive quiz
+	mux.HandleFunc("/api/adaptive/answer", a.requireAuth(a.handleAdaptiveAnswer)) // Answer and get the next question
+	mux.HandleFunc("/api/adaptive/next", a.requireAuth(a.handleAdaptiveNext)) // Retry getting the next question
+	mux.HandleFunc("/api/bank", a.requireAuth(a.handleBank)) // Search the question bank
+	mux.HandleFunc("/api/bank/tags", a.requireAuth(a.handleBankTags)) // Tag a bank question
+	mux.HandleFunc("/api/bank/quiz", a.requireAuth(a.handleBankQuiz)) // Build a quiz from bank questions
+	mux.HandleFunc("/api/flags", a.requireAuth(a.handleFlags)) // Flag a question, or list flags on your quizzes
+	mux.HandleFunc("/api/flags/resolve", a.requireAuth(a.handleFlagResolve)) // Dismiss flags or fix the question
+	mux.HandleFunc("/api/media", a.requireAuth(a.handleMedia)) // Upload an image, or fetch one for a question
+	mux.HandleFunc("/api/admin/prompts", a.requireAdmin(a.handleAdminPrompts)) // List or save prompt templates
+	mux.HandleFunc("/api/admin/prompts/preview", a.requireAdmin(a.handleAdminPromptPreview)) // See a rendered prompt
+	mux.HandleFunc("/api/admin/flags", a.requireAdmin(a.handleAdminFlagStats)) // Flag counts by prompt version and model
+	if a.config.Features.APITokens {
+		mux.HandleFunc("/api/tokens", a.requireSession(a.handleAPITokens)) // List or create API tokens
+		mux.HandleFunc("/api/tokens/revoke", a.requireSession(a.handleRevokeAPIToken)) // Revoke an API token
+	}
+	if a.config.Features.Live {
+		mux.HandleFunc("/live", serveLivePage) // Host or join a live game
+		mux.HandleFunc("/api/live/sessions", a.requireAuth(a.handleLiveCreate)) // Host a saved quiz live
+		mux.HandleFunc("/api/live/ws", a.handleLiveSocket) // Play a live game
+		mux.HandleFunc("/api/live/results", a.requireAuth(a.handleLiveResults)) // Results of past live games
+	}
+	return mux
+}
+
+// Full handler stack: request IDs, logging and metrics, security headers, error translation, then CSRF checks, then the routes
+func (a *App) Handler() http.Handler {
+	return requestID(a.observeRequests(a.securityHeaders(a.csrfProtect(a.routes()))))
 }
 
 // Serve the main HTML page
@@ -643,23 +653,24 @@ func serveHome(w http.ResponseWriter, r *http.Request) {
 // ============================================================================
 
 // Handle file uploads and extract text from documents
-func handleUpload(w http.ResponseWriter, r *http.Request) {
+func (a *App) handleUpload(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		httpError(w, r, msgMethodNotAllowed, http.StatusMethodNotAllowed)
 		return
 	}
 
-	// Parse the uploaded file (max 10MB)
-	err := r.ParseMultipartForm(10 << 20)
+	// Parse the uploaded file (max 10MB by default) - bigger bodies are cut off
+	r.Body = http.MaxBytesReader(w, r.Body, a.config.Uploads.MaxBytes)
+	err := r.ParseMultipartForm(a.config.Uploads.MaxBytes)
 	if err != nil {
-		http.Error(w, "Error parsing form", http.StatusBadRequest)
+		httpError(w, r, msgFormError, http.StatusBadRequest)
 		return
 	}
 
 	// Get the uploaded file
 	file, header, err := r.FormFile("file")
 	if err != nil {
-		http.Error(w, "Error retrieving file", http.StatusBadRequest)
+		httpError(w, r, msgFileMissing, http.StatusBadRequest)
 		return
 	}
 	defer file.Close()
@@ -675,10 +686,10 @@ func handleUpload(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Save file temporarily
-	tempPath := filepath.Join("uploads", safeName)
+	tempPath := filepath.Join(a.config.Uploads.Dir, safeName)
 	out, err := os.Create(tempPath)
 	if err != nil {
-		http.Error(w, "Error saving file", http.StatusInternalServerError)
+		httpError(w, r, msgFileSaveFailed, http.StatusInternalServerError)
 		return
 	}
 	defer out.Close()
@@ -686,10 +697,46 @@ func handleUpload(w http.ResponseWriter, r *http.Request) {
 
 	io.Copy(out, file)
 
+	// Images are kept for logged-in users: a picture on its own, or the ones in a PDF
+	user, loggedIn := a.getRequestUser(r)
+	images := []uploadedImage{}
+	ocr := false // Whether any of the text was read with OCR (see ocr.go)
+
 	// Extract text based on file type
 	switch ext {
 	case ".pdf":
-		text, err = extractPDFText(tempPath)
+		var scanned []int
+		text, scanned, err = a.pdfText(r.Context(), tempPath)
+		ocr = len(scanned) > 0
+		if err == nil && loggedIn {
+			images = a.savePDFImages(r.Context(), logFor(r.Context()), user.ID, safeName, tempPath, scanned)
+		}
+	case ".png", ".jpg", ".jpeg", ".gif":
+		// Without an account the picture isn't kept, but its text can still be read
+		if !loggedIn && a.ocr == nil {
+			httpError(w, r, msgImagesNeedLogin, http.StatusUnauthorized)
+			return
+		}
+		data, _ := os.ReadFile(tempPath)
+		_, size, err := checkImage(data)
+		if err != nil {
+			httpErrorFrom(w, r, err, http.StatusBadRequest)
+			return
+		}
+		if loggedIn {
+			saved, err := a.saveImage(r.Context(), user.ID, safeName, data, "upload")
+			if err != nil {
+				httpErrorFrom(w, r, err, http.StatusBadRequest)
+				return
+			}
+			images = append(images, *saved)
+		}
+		text = a.imageText(r.Context(), data, size.Width, size.Height)
+		ocr = text != ""
+		if !loggedIn && !ocr {
+			httpError(w, r, msgNoImageText, http.StatusUnprocessableEntity)
+			return
+		}
 	case ".docx":
 		text, err = extractDocxText(tempPath)
 	case ".txt":
@@ -700,40 +747,41 @@ func handleUpload(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err != nil {
-		http.Error(w, "Error extracting text: "+err.Error(), http.StatusInternalServerError)
+		httpError(w, r, msgExtractFailed, http.StatusInternalServerError, err)
 		return
 	}
 
-	// Send extracted text back to frontend
+	// Send extracted text back to frontend, with its language if we can tell
+	// and any images we kept
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"text": text})
+	json.NewEncoder(w).Encode(map[string]interface{}{"text": text, "language": detectLanguage(text), "images": images, "ocr": ocr})
 }
 
-// Extract text from PDF files
-func extractPDFText(path string) (string, error) {
+// Extract text from PDF files, page by page. Pages we can't read, and
+// scans, come back empty.
+func extractPDFText(path string) ([]string, error) {
 	f, r, err := pdf.Open(path)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	defer f.Close()
 
-	var text strings.Builder
+	var pages []string
 	totalPages := r.NumPage()
 
 	// Read text from each page (limit to 10 pages for performance)
 	for pageIndex := 1; pageIndex <= totalPages && pageIndex <= 10; pageIndex++ {
 		p := r.Page(pageIndex)
-		if p.V.IsNull() {
-			continue
-		}
-		pageText, err := p.GetPlainText(nil)
-		if err == nil {
-			text.WriteString(pageText)
-			text.WriteString("\n")
+		pageText := ""
+		if !p.V.IsNull() {
+			if text, err := p.GetPlainText(nil); err == nil {
+				pageText = text
+			}
 		}
+		pages = append(pages, pageText)
 	}
 
-	return text.String(), nil
+	return pages, nil
 }
 
 // Extract text from Word documents
@@ -752,10 +800,10 @@ func extractDocxText(path string) (string, error) {
 // AI QUIZ GENERATION - The magic happens here!
 // ============================================================================
 
-// Generate quizzes using OpenAI's AI
-func handleGenerateQuiz(w http.ResponseWriter, r *http.Request) {
+// Queue a quiz generation and hand back the job to poll or follow
+func (a *App) handleGenerateQuiz(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		httpError(w, r, msgMethodNotAllowed, http.StatusMethodNotAllowed)
 		return
 	}
 
@@ -763,105 +811,168 @@ func handleGenerateQuiz(w http.ResponseWriter, r *http.Request) {
 	var req QuizRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		http.Error(w, "Invalid request", http.StatusBadRequest)
+		httpError(w, r, msgInvalidRequest, http.StatusBadRequest)
 		return
 	}
-
-	// Get OpenAI API key from environment
-	apiKey := os.Getenv("OPENAI_API_KEY")
-	if apiKey == "" {
-		http.Error(w, "OpenAI API key not configured", http.StatusInternalServerError)
+	if err := a.checkTimeLimits(req.TimeLimit, req.QuestionTimeLimit); err != nil {
+		httpErrorFrom(w, r, err, http.StatusBadRequest)
 		return
 	}
+	language, ok := normalizeLanguage(req.Language)
+	if !ok {
+		httpError(w, r, msgUnsupportedLanguage, http.StatusBadRequest, req.Language)
+		return
+	}
+	req.Language = language
 
-	// Create the prompt that tells AI what kind of quiz to make
-	prompt := fmt.Sprintf(`Create a %d-question %s quiz on the following topic with %s difficulty level.
-
-Topic: %s
+	// Uploaded document text is untrusted - tidy it up and see if it's trying to give orders
+	if req.Source != "" {
+		req.Source = cleanSource(req.Source, a.config.Uploads.MaxSourceChars)
+		if found := scanSource(req.Source); len(found) > 0 {
+			if a.config.Uploads.Suspicious == sourceReject {
+				a.metrics.suspiciousSources.Inc("rejected")
+				logFor(r.Context()).Warn("rejected document with instruction-like text", "patterns", found)
+				httpError(w, r, msgInjection, http.StatusUnprocessableEntity)
+				return
+			}
+			a.metrics.suspiciousSources.Inc("warned")
+			logFor(r.Context()).Warn("document contains instruction-like text", "patterns", found)
+		}
+		if strings.TrimSpace(req.Topic) == "" {
+			req.Topic = "Uploaded document"
+		}
+		// Quiz in the document's language unless asked for another
+		if req.Language == "" {
+			req.Language = detectLanguage(req.Source)
+		}
+	}
 
-Please format the response as a JSON array with the following structure:
-[
-  {
-    "question": "Question text here?",
-    "options": ["Option A", "Option B", "Option C", "Option D"],
-    "correctAnswer": "Option A",
-    "explanation": "Brief explanation why this is correct"
-  }
-]
+	// Images have to be the user's own, and there's only so many the AI can look at
+	if len(req.Images) > 0 {
+		user, ok := a.getRequestUser(r)
+		if !ok {
+			httpError(w, r, msgImagesNeedLogin, http.StatusUnauthorized)
+			return
+		}
+		if len(req.Images) > quizMaxImages {
+			httpError(w, r, msgTooManyImages, http.StatusBadRequest, quizMaxImages)
+			return
+		}
+		if _, err := a.loadImages(r.Context(), user.ID, req.Images); err != nil {
+			httpError(w, r, msgImageNotFound, http.StatusBadRequest)
+			return
+		}
+		if strings.TrimSpace(req.Topic) == "" {
+			req.Topic = "Uploaded images"
+		}
+	}
 
-IMPORTANT: Return ONLY the JSON array, no additional text, no code blocks, no explanations.`,
-		req.QuestionCount, req.QuizType, req.Difficulty, req.Topic)
+	// Make sure we have an OpenAI API key
+	if !a.llm.Configured() {
+		httpError(w, r, msgNoAPIKey, http.StatusInternalServerError)
+		return
+	}
 
-	// Prepare request to OpenAI
-	openAIReq := OpenAIRequest{
-		Model: "gpt-4o",
-		Messages: []OpenAIMessage{
-			{Role: "system", Content: "You are an expert educational quiz creator. Always respond with valid JSON only, no additional text."},
-			{Role: "user", Content: prompt},
-		},
+	// Don't queue work we already know will fail
+	if open, wait := a.llm.breaker.open(); open {
+		llmErr := &LLMError{Kind: llmCircuitOpen, RetryAfter: wait}
+		w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		httpError(w, r, llmErr.UserMessage(), llmErr.HTTPStatus())
+		return
 	}
 
-	// Send request to OpenAI
-	reqBody, _ := json.Marshal(openAIReq)
-	httpReq, _ := http.NewRequest("POST", "https://api.openai.com/v1/chat/completions", bytes.NewBuffer(reqBody))
-	httpReq.Header.Set("Content-Type", "application/json")
-	httpReq.Header.Set("Authorization", "Bearer "+apiKey)
+	// Logged-in users get the finished quiz saved to their history
+	var userID int
+	if user, ok := a.getRequestUser(r); ok {
+		userID = user.ID
+	}
 
-	client := &http.Client{}
-	resp, err := client.Do(httpReq)
+	job, err := a.enqueueGeneration(r.Context(), userID, req)
 	if err != nil {
-		http.Error(w, "Error calling OpenAI API: "+err.Error(), http.StatusInternalServerError)
+		logFor(r.Context()).Error("could not queue generation", "error", err)
+		httpError(w, r, msgQueueFailed, http.StatusInternalServerError)
 		return
 	}
-	defer resp.Body.Close()
 
-	// Check if OpenAI responded successfully
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		http.Error(w, fmt.Sprintf("OpenAI API error (status %d): %s", resp.StatusCode, string(body)), http.StatusInternalServerError)
-		return
-	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/api/jobs?id="+job.ID)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job)
+}
 
-	// Parse OpenAI's response
-	var openAIResp OpenAIResponse
-	if err := json.NewDecoder(resp.Body).Decode(&openAIResp); err != nil {
-		http.Error(w, "Failed to parse OpenAI response: "+err.Error(), http.StatusInternalServerError)
-		return
+// What the quiz prompt template gets to work with
+func quizPromptData(req QuizRequest) PromptData {
+	return PromptData{
+		Topic:         req.Topic,
+		Difficulty:    req.Difficulty,
+		QuestionCount: req.QuestionCount,
+		QuizType:      req.QuizType,
+		Language:      req.Language,
+		LanguageName:  languageName(req.Language),
+		HasSource:     req.Source != "",
 	}
+}
 
-	// Check for OpenAI errors
-	if openAIResp.Error.Message != "" {
-			http.Error(w, "OpenAI Error: "+openAIResp.Error.Message, http.StatusInternalServerError)
-			return
+// Ask the AI for a quiz and return it as a validated JSON array, along with
+// the prompt template it was made from
+func (a *App) generateQuiz(ctx context.Context, req QuizRequest) (string, string, error) {
+	// Fill in the prompt template for this kind of quiz. The schema does the
+	// real work when the provider supports it; the template's example keeps
+	// providers without JSON schema mode on track.
+	prompt, err := a.prompts.Render("quiz", quizPromptData(req))
+	if err != nil {
+		return "", "", err
 	}
 
-	if len(openAIResp.Choices) == 0 {
-		http.Error(w, "No response from OpenAI", http.StatusInternalServerError)
-		return
+	messages := []OpenAIMessage{
+		{Role: "system", Content: prompt.System},
+		{Role: "user", Content: prompt.User},
+	}
+	// Asking again for questions that don't repeat ones we already have
+	if len(req.Avoid) > 0 {
+		messages[1].Content += "\n\nDo not repeat or reword any of these questions:\n- " + strings.Join(req.Avoid, "\n- ")
+	}
+	// An uploaded document goes in its own message, fenced off, never into the template
+	if req.Source != "" {
+		boundary := newSourceBoundary()
+		messages[0].Content += "\n\n" + sourceRules(boundary, len(scanSource(req.Source)) > 0)
+		messages = append(messages, OpenAIMessage{Role: "user", Content: wrapSource(req.Source, boundary)})
+	}
+	// Images go in a message of their own too, and each question says which one it's about
+	schema := quizSchema(req.QuizType)
+	if len(req.ImageData) > 0 {
+		messages[1].Content += "\n\n" + imageRules(len(req.ImageData))
+		messages = append(messages, OpenAIMessage{Role: "user", Content: "The images, in order:", Images: req.ImageData})
+		schema = withImageField(schema)
 	}
 
-	// Get the generated quiz content
-	quizContent := openAIResp.Choices[0].Message.Content
-	
-	// Clean up the response
-	quizContent = strings.TrimSpace(quizContent)
-	
-	// Remove markdown code blocks if AI added them
-	quizContent = strings.TrimPrefix(quizContent, "```json")
-	quizContent = strings.TrimPrefix(quizContent, "```")
-	quizContent = strings.TrimSuffix(quizContent, "```")
-	quizContent = strings.TrimSpace(quizContent)
+	quizContent, err := a.llm.Chat(ctx, messages, &OutputSchema{Name: "quiz", Schema: schema})
+	if err != nil {
+		return "", "", err
+	}
 
-	// Validate that we got proper JSON
-	var quizData interface{}
-	if err := json.Unmarshal([]byte(quizContent), &quizData); err != nil {
+	// Pull the questions out of the answer and check they're usable
+	questions, err := parseQuizContent(quizContent, req.QuizType, prompt.Format)
+	if err != nil {
 		// Log the problematic response for debugging
-		log.Printf("Raw OpenAI response: %s", quizContent)
-		http.Error(w, "OpenAI returned invalid JSON. Please try again. Error: "+err.Error(), http.StatusInternalServerError)
-		return
+		logFor(ctx).Warn("llm returned an unusable quiz", "prompt", prompt.Ref,
+			"error", err, "content_bytes", len(quizContent), "content_preview", truncate(quizContent, 500))
+		return "", "", &LLMError{Kind: llmInvalidOutput, Err: err}
 	}
+	attachImages(questions, req.Images)
 
-	// Send the generated quiz back to frontend
-	w.Header().Set("Content-Type", "application/json")
-	w.Write([]byte(quizContent))
-}
\ No newline at end of file
+	// Questions that don't come from the document mean the model was led astray
+	if req.Source != "" {
+		if err := checkGrounding(questions, req.Source, req.Language); err != nil {
+			logFor(ctx).Warn("quiz doesn't match the uploaded document", "prompt", prompt.Ref, "error", err)
+			return "", "", &LLMError{Kind: llmInvalidOutput, Err: err}
+		}
+	}
+
+	// The frontend wants a plain array of questions
+	quizJSON, err := json.Marshal(questions)
+	if err != nil {
+		return "", "", err
+	}
+	return string(quizJSON), prompt.Ref, nil
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"html"
+	"regexp"
+	"strings"
+)
+
+// ============================================================================
+// MARKUP - Markdown, math and code in question text
+// ============================================================================
+//
+// Questions made by a prompt that asks for it are written in a small piece
+// of Markdown: **bold**, *italics*, `code`, fenced code blocks, and LaTeX
+// math between $...$ (inline) or $$...$$ (on its own). Those questions have
+// "format": "markdown"; everything else is plain text.
+//
+// The markup is cleaned on the way in (see sanitizeMarkup), the browser
+// renders it with the same rules as markupHTML below, and exports keep it:
+// as it was in text and JSON, and as HTML in GIFT.
+
+const formatMarkdown = "markdown"
+
+// One piece of marked-up text
+type markupSegment struct {
+	kind string // "text", "code", "codeblock", "math" or "displaymath"
+	text string
+	lang string // Code blocks: the language after the opening fence
+}
+
+// Split text into prose, code and math. The browser splits it the same way
+// (renderMarkup in script.js), so the two have to change together.
+//
+//   - A fence (```) opens a code block only at the start of a line, and an
+//     unclosed one runs to the end of the text.
+//   - $...$ is only math when the $ opening it is followed by something
+//     other than a space, and the $ closing it comes after something other
+//     than a space and isn't followed by a digit - so "$5 and $10" stays text.
+//   - \$ is a dollar sign, never math.
+func markupSegments(s string) []markupSegment {
+	var segs []markupSegment
+	var prose strings.Builder
+	flush := func() {
+		if prose.Len() > 0 {
+			segs = append(segs, markupSegment{kind: "text", text: prose.String()})
+			prose.Reset()
+		}
+	}
+	add := func(seg markupSegment) {
+		flush()
+		segs = append(segs, seg)
+	}
+
+	for i := 0; i < len(s); {
+		switch {
+		case (i == 0 || s[i-1] == '\n') && strings.HasPrefix(s[i:], "```"):
+			seg := markupSegment{kind: "codeblock"}
+			nl := strings.IndexByte(s[i+3:], '\n')
+			if nl < 0 {
+				seg.lang, i = s[i+3:], len(s) // Just the opening fence
+			} else {
+				seg.lang = s[i+3 : i+3+nl]
+				start := i + 3 + nl + 1
+				switch end := closingFence(s[start:]); {
+				case end < 0:
+					seg.text, i = s[start:], len(s)
+				case end == 0:
+					i = start + 3
+				default:
+					seg.text, i = s[start:start+end], start+end+4
+				}
+			}
+			seg.lang = strings.TrimSpace(seg.lang)
+			add(seg)
+		case strings.HasPrefix(s[i:], "$$"):
+			if end := strings.Index(s[i+2:], "$$"); end >= 0 {
+				add(markupSegment{kind: "displaymath", text: s[i+2 : i+2+end]})
+				i += end + 4
+				continue
+			}
+			prose.WriteString("$$")
+			i += 2
+		case s[i] == '\\' && i+1 < len(s) && s[i+1] == '$':
+			prose.WriteString(`\$`)
+			i += 2
+		case s[i] == '`':
+			if end := strings.IndexByte(s[i+1:], '`'); end > 0 {
+				add(markupSegment{kind: "code", text: s[i+1 : i+1+end]})
+				i += end + 2
+				continue
+			}
+			prose.WriteByte(s[i])
+			i++
+		case s[i] == '$':
+			if end := closingDollar(s, i); end > 0 {
+				add(markupSegment{kind: "math", text: s[i+1 : end]})
+				i = end + 1
+				continue
+			}
+			prose.WriteByte(s[i])
+			i++
+		default:
+			prose.WriteByte(s[i])
+			i++
+		}
+	}
+	flush()
+	return segs
+}
+
+// Where the fence closing a code block starts in its body (at a newline,
+// or right at the start for an empty block), or -1 if it isn't closed
+func closingFence(body string) int {
+	if strings.HasPrefix(body, "```") {
+		return 0
+	}
+	return strings.Index(body, "\n```")
+}
+
+// The $ closing inline math opened at s[open], or -1
+func closingDollar(s string, open int) int {
+	if open+1 >= len(s) || s[open+1] == ' ' || s[open+1] == '\n' || s[open+1] == '$' {
+		return -1
+	}
+	for j := open + 2; j < len(s); j++ {
+		switch {
+		case s[j] == '\n' && j+1 < len(s) && s[j+1] == '\n':
+			return -1 // Math doesn't run across paragraphs
+		case s[j] != '$' || s[j-1] == '\\':
+			continue
+		case s[j-1] == ' ' || (j+1 < len(s) && s[j+1] >= '0' && s[j+1] <= '9'):
+			continue
+		}
+		return j
+	}
+	return -1
+}
+
+// ----------- Cleaning -----------
+
+var (
+	// HTML tags and comments have no place in the Markdown we ask for - a
+	// comparison belongs in math or code, where it's left alone
+	markupTags = regexp.MustCompile(`<!--[\s\S]*?-->|</?[A-Za-z][A-Za-z0-9]*(?:\s[^<>]*)?/?>`)
+	// Links and images to places other programs might run or load
+	markupUnsafeLinks = regexp.MustCompile(`!?\[([^\]]*)\]\(\s*(?i:javascript|vbscript|data|file):(?:[^()]|\([^()]*\))*\)`)
+	// TeX commands that link out or pull in HTML, with their options and
+	// first argument (the address or file) - \href{url}{text} leaves {text}
+	markupUnsafeTeX = regexp.MustCompile(`\\(?:href|url|includegraphics|html[A-Za-z]*)\b\s*(?:\[[^\]]*\])?\s*(?:\{[^{}]*\})?`)
+)
+
+// Clean Markdown so it's safe wherever it ends up - here, or in whatever
+// an export is imported into. Tags are taken out of the prose, risky links
+// and TeX commands dropped, and an unclosed code block closed. Cleaning
+// clean text changes nothing, so it's safe to do more than once.
+func sanitizeMarkup(s string) string {
+	var b strings.Builder
+	for _, seg := range markupSegments(s) {
+		switch seg.kind {
+		case "text":
+			b.WriteString(markupUnsafeLinks.ReplaceAllString(markupTags.ReplaceAllString(seg.text, ""), "$1"))
+		case "code":
+			b.WriteString("`" + seg.text + "`")
+		case "codeblock":
+			b.WriteString("```" + seg.lang + "\n" + seg.text)
+			if seg.text != "" {
+				b.WriteString("\n")
+			}
+			b.WriteString("```")
+		case "math", "displaymath":
+			tex := strings.TrimSpace(markupUnsafeTeX.ReplaceAllString(seg.text, ""))
+			if tex == "" {
+				continue
+			}
+			fence := map[string]string{"math": "$", "displaymath": "$$"}[seg.kind]
+			b.WriteString(fence + tex + fence)
+		}
+	}
+	return b.String()
+}
+
+// Clean every piece of text a Markdown question shows
+func sanitizeQuestion(q *QuizQuestion) {
+	if q.Format != formatMarkdown {
+		return
+	}
+	clean := func(list []string) {
+		for i := range list {
+			list[i] = sanitizeMarkup(list[i])
+		}
+	}
+	q.Question = sanitizeMarkup(q.Question)
+	q.Explanation = sanitizeMarkup(q.Explanation)
+	q.CorrectAnswer = sanitizeMarkup(q.CorrectAnswer)
+	clean(q.Options)
+	clean(q.CorrectAnswers)
+	for i := range q.Pairs {
+		q.Pairs[i].Left, q.Pairs[i].Right = sanitizeMarkup(q.Pairs[i].Left), sanitizeMarkup(q.Pairs[i].Right)
+	}
+}
+
+// ----------- HTML -----------
+
+var (
+	markupBold    = regexp.MustCompile(`\*\*([^*\n]+)\*\*`)
+	markupItalics = regexp.MustCompile(`\*([^*\s][^*\n]*)\*`)
+)
+
+// Text as HTML for a question in the given format. Plain text is escaped;
+// Markdown gets code blocks, code, bold and italics, with math left as TeX
+// between \( \) or \[ \] for MathJax (which is what Moodle renders math with).
+func markupHTML(s, format string) string {
+	if format != formatMarkdown {
+		return html.EscapeString(s)
+	}
+	var b strings.Builder
+	for _, seg := range markupSegments(s) {
+		text := html.EscapeString(seg.text)
+		switch seg.kind {
+		case "text":
+			text = strings.ReplaceAll(text, `\$`, "$")
+			text = markupItalics.ReplaceAllString(markupBold.ReplaceAllString(text, "<strong>$1</strong>"), "<em>$1</em>")
+			b.WriteString(strings.ReplaceAll(text, "\n", "<br>"))
+		case "code":
+			b.WriteString("<code>" + text + "</code>")
+		case "codeblock":
+			b.WriteString("<pre><code>" + text + "</code></pre>")
+		case "math":
+			b.WriteString(`\(` + text + `\)`)
+		case "displaymath":
+			b.WriteString(`\[` + text + `\]`)
+		}
+	}
+	return b.String()
+}
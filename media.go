@@ -0,0 +1,426 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif" // Registered for image.DecodeConfig
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/ledongthuc/pdf"
+)
+
+// ============================================================================
+// MEDIA - Images that questions can show
+// ============================================================================
+//
+// Images come from two places: uploaded on their own, or taken out of the
+// pages of an uploaded PDF. Either way they're saved to the media table
+// against the user who sent them and only ever served back to that user.
+// A quiz request can list image IDs; the AI gets to see them and says which
+// question is about which image, and the question keeps the image's ID.
+
+// Limits for images
+const (
+	mediaMaxBytes = 5 << 20 // 5MB per image
+	mediaMinSide  = 64      // Smaller images in PDFs are icons and bullets, not diagrams
+	mediaMaxSide  = 4000    // Bigger images in PDFs would take too much memory to convert
+	pdfMaxImages  = 8       // Images taken from one PDF
+	quizMaxImages = 4       // Images one quiz can be generated from
+)
+
+// Image types we keep, and the file extension each is exported with
+var mediaTypes = map[string]string{
+	"image/png":  "png",
+	"image/jpeg": "jpg",
+	"image/gif":  "gif",
+}
+
+// An image as the frontend sees it after an upload
+type uploadedImage struct {
+	ID     int    `json:"id"`
+	URL    string `json:"url"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	Page   int    `json:"page,omitempty"` // Which page of a PDF it came from
+}
+
+// Where the browser fetches an image from
+func mediaURL(id int) string {
+	return "/api/media?id=" + strconv.Itoa(id)
+}
+
+// The image inline, for exports and for the AI
+func dataURL(m *Media) string {
+	return "data:" + m.MimeType + ";base64," + base64.StdEncoding.EncodeToString(m.Data)
+}
+
+// Check an image is one we keep, and get its type and size. The type comes
+// from the bytes, never from the file name.
+func checkImage(data []byte) (string, image.Config, error) {
+	if len(data) > mediaMaxBytes {
+		return "", image.Config{}, msgImageTooBig.err(mediaMaxBytes >> 20)
+	}
+	mimeType := http.DetectContentType(data)
+	if mediaTypes[mimeType] == "" {
+		return "", image.Config{}, msgImageType.err()
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return "", image.Config{}, msgImageUnreadable.err()
+	}
+	return mimeType, config, nil
+}
+
+// Check an image and save it for the user
+func (a *App) saveImage(ctx context.Context, userID int, name string, data []byte, source string) (*uploadedImage, error) {
+	mimeType, config, err := checkImage(data)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := a.store.SaveMedia(ctx, &Media{
+		UserID:    userID,
+		Name:      firstRunes(name, 200),
+		MimeType:  mimeType,
+		Data:      data,
+		Width:     config.Width,
+		Height:    config.Height,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	a.metrics.mediaSaved.Inc(source)
+	return &uploadedImage{ID: id, URL: mediaURL(id), Width: config.Width, Height: config.Height}, nil
+}
+
+// The user's images with these IDs, in order. Any that are missing (or not
+// theirs) is an error.
+func (a *App) loadImages(ctx context.Context, userID int, ids []int) ([]*Media, error) {
+	images := make([]*Media, 0, len(ids))
+	for _, id := range ids {
+		m, err := a.store.GetMedia(ctx, userID, id)
+		if err != nil {
+			return nil, fmt.Errorf("image %d: %w", id, err)
+		}
+		images = append(images, m)
+	}
+	return images, nil
+}
+
+// ----------- Generating from images -----------
+
+// What the AI is told about the images it's shown
+func imageRules(count int) string {
+	return fmt.Sprintf(`You are also shown %d image(s), numbered from 1 in the order they come. `+
+		`Ask about what they show (diagrams, charts, labels, processes) as well as any text. `+
+		`Set "image" on each question to the number of the image it is about, or 0 if it isn't about one. `+
+		`A question about an image must make sense to someone looking at that image.`, count)
+}
+
+// Add the "image" number to a quiz schema's questions
+func withImageField(schema map[string]interface{}) map[string]interface{} {
+	question := schema["properties"].(map[string]interface{})["questions"].(map[string]interface{})["items"].(map[string]interface{})
+	question["properties"].(map[string]interface{})["image"] = map[string]interface{}{
+		"type":        "integer",
+		"description": "Number of the image the question is about, from 1; 0 for none",
+	}
+	question["required"] = append(question["required"].([]string), "image")
+	return schema
+}
+
+// Swap the image numbers the AI gave for the images' IDs. A number that
+// isn't one of the images leaves the question without one.
+func attachImages(questions []QuizQuestion, ids []int) {
+	for i := range questions {
+		if ref := questions[i].ImageRef; ref >= 1 && ref <= len(ids) {
+			questions[i].ImageID = ids[ref-1]
+		}
+		questions[i].ImageRef = 0
+	}
+}
+
+// ----------- Endpoint -----------
+
+// Fetch an image:  GET /api/media?id=12
+// Upload an image: POST /api/media (multipart, field "file")
+func (a *App) handleMedia(w http.ResponseWriter, r *http.Request, user *User) {
+	switch r.Method {
+	case http.MethodGet:
+		id, err := strconv.Atoi(r.URL.Query().Get("id"))
+		if err != nil {
+			httpError(w, r, msgInvalidImageID, http.StatusBadRequest)
+			return
+		}
+		m, err := a.store.GetMedia(r.Context(), user.ID, id)
+		if errors.Is(err, ErrNotFound) {
+			httpError(w, r, msgImageNotFound, http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			httpError(w, r, msgDatabaseError, http.StatusInternalServerError)
+			return
+		}
+		// Images never change once saved, but they're only this user's
+		w.Header().Set("Content-Type", m.MimeType)
+		w.Header().Set("Cache-Control", "private, max-age=86400")
+		w.Header().Set("Content-Length", strconv.Itoa(len(m.Data)))
+		w.Write(m.Data)
+
+	case http.MethodPost:
+		if !a.config.Features.Uploads {
+			httpError(w, r, msgUploadsOff, http.StatusForbidden)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, mediaMaxBytes+1<<20) // Room for the multipart wrapping
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			httpError(w, r, msgFileMissing, http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+		data, err := io.ReadAll(io.LimitReader(file, mediaMaxBytes+1))
+		if err != nil {
+			httpError(w, r, msgFileReadFailed, http.StatusBadRequest)
+			return
+		}
+
+		saved, err := a.saveImage(r.Context(), user.ID, header.Filename, data, "upload")
+		if err != nil {
+			logFor(r.Context()).Info("image upload turned away", "error", err)
+			httpErrorFrom(w, r, err, http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(saved)
+
+	default:
+		httpError(w, r, msgMethodNotAllowed, http.StatusMethodNotAllowed)
+	}
+}
+
+// ----------- Images in PDFs -----------
+
+// An image found on a page of a PDF, ready to save
+type pdfImage struct {
+	Page int
+	Data []byte // PNG or JPEG
+}
+
+// Save the images in an uploaded PDF for the user. A PDF we can't take
+// images from still gives its text, so problems are only logged. The
+// pictures on scanned pages are the pages themselves, not diagrams on them,
+// so those pages are skipped.
+func (a *App) savePDFImages(ctx context.Context, log *slog.Logger, userID int, name, path string, scanned []int) []uploadedImage {
+	found, err := extractPDFImages(path, scanned)
+	if err != nil {
+		log.Warn("could not read images from PDF", "error", err)
+	}
+	saved := []uploadedImage{}
+	for _, img := range found {
+		stored, err := a.saveImage(ctx, userID, fmt.Sprintf("%s page %d", name, img.Page), img.Data, "pdf")
+		if err != nil {
+			log.Info("skipped image in PDF", "page", img.Page, "error", err)
+			continue
+		}
+		stored.Page = img.Page
+		saved = append(saved, *stored)
+	}
+	return saved
+}
+
+// Pull the pictures out of the first pages of a PDF, leaving out the pages
+// given. Each picture is only taken once - the same logo on every page is
+// one image.
+func extractPDFImages(path string, skipPages []int) ([]pdfImage, error) {
+	var images []pdfImage
+	seen := map[[32]byte]bool{}
+	err := eachPDFImage(path, func(page, width, height int) bool {
+		return !slices.Contains(skipPages, page) &&
+			width >= mediaMinSide && height >= mediaMinSide && width <= mediaMaxSide && height <= mediaMaxSide
+	}, func(page int, data []byte) bool {
+		sum := sha256.Sum256(data)
+		if !seen[sum] {
+			seen[sum] = true
+			images = append(images, pdfImage{Page: page, Data: data})
+		}
+		return len(images) < pdfMaxImages
+	})
+	return images, err
+}
+
+// Go through the pictures on the first pages of a PDF (the same 10 pages we
+// take text from), in page order. wanted picks the ones worth decoding by
+// page and size; found gets each of those as a PNG or JPEG, and returns
+// false to stop. The PDF library only decodes compressed pixel data, so
+// photos stored as JPEG are found by looking for them in the file itself;
+// pixel data is turned into a PNG.
+func eachPDFImage(path string, wanted func(page, width, height int) bool, found func(page int, data []byte) bool) error {
+	f, r, err := pdf.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var jpegs []rawJPEG // Looked for the first time a page has one
+	lookedForJPEGs := false
+
+	for pageIndex := 1; pageIndex <= r.NumPage() && pageIndex <= 10; pageIndex++ {
+		p := r.Page(pageIndex)
+		if p.V.IsNull() {
+			continue
+		}
+		xobjects := p.Resources().Key("XObject")
+		for _, name := range xobjects.Keys() {
+			x := xobjects.Key(name)
+			if x.Key("Subtype").Name() != "Image" {
+				continue
+			}
+			width, height := int(x.Key("Width").Int64()), int(x.Key("Height").Int64())
+			if !wanted(pageIndex, width, height) {
+				continue
+			}
+
+			var data []byte
+			var err error
+			if filter := x.Key("Filter"); filter.Name() == "DCTDecode" || (filter.Len() == 1 && filter.Index(0).Name() == "DCTDecode") {
+				if !lookedForJPEGs {
+					jpegs, lookedForJPEGs = findJPEGs(raw), true
+				}
+				data = matchJPEG(jpegs, width, height, int(x.Key("Length").Int64()))
+			} else {
+				data, err = pdfPixels(x, width, height)
+			}
+			if data == nil || err != nil {
+				continue // Masks, odd colour spaces, filters the library doesn't have...
+			}
+			if !found(pageIndex, data) {
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+// Decode an image's pixel data and encode it as a PNG. Only 8-bit grey and
+// RGB images are handled, which covers most charts and diagrams. The PDF
+// library panics on data it can't decode, so that's caught here.
+func pdfPixels(x pdf.Value, width, height int) (data []byte, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			data, err = nil, fmt.Errorf("unreadable image: %v", p)
+		}
+	}()
+	if x.Key("ImageMask").Bool() || x.Key("BitsPerComponent").Int64() != 8 {
+		return nil, errors.New("not an 8-bit image")
+	}
+
+	space := x.Key("ColorSpace")
+	components := 0
+	switch space.Kind() {
+	case pdf.Name:
+		components = map[string]int{"DeviceGray": 1, "DeviceRGB": 3}[space.Name()]
+	case pdf.Array:
+		if space.Index(0).Name() == "ICCBased" {
+			components = int(space.Index(1).Key("N").Int64())
+		}
+	}
+	if components != 1 && components != 3 {
+		return nil, errors.New("unsupported colour space")
+	}
+
+	pixels := make([]byte, width*height*components)
+	if _, err := io.ReadFull(x.Reader(), pixels); err != nil {
+		return nil, err
+	}
+	var img image.Image
+	if components == 1 {
+		img = &image.Gray{Pix: pixels, Stride: width, Rect: image.Rect(0, 0, width, height)}
+	} else {
+		rgba := image.NewRGBA(image.Rect(0, 0, width, height))
+		for i := 0; i < width*height; i++ {
+			copy(rgba.Pix[i*4:], pixels[i*3:i*3+3])
+			rgba.Pix[i*4+3] = 255
+		}
+		img = rgba
+	}
+
+	var b bytes.Buffer
+	if err := png.Encode(&b, img); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// A JPEG stored in a PDF's bytes
+type rawJPEG struct {
+	data          []byte
+	width, height int
+}
+
+// Every stream in the file that holds a JPEG. Encrypted PDFs have none we
+// can read, which is fine - their images are skipped.
+func findJPEGs(raw []byte) []rawJPEG {
+	var found []rawJPEG
+	for i := 0; ; {
+		n := bytes.Index(raw[i:], []byte("stream"))
+		if n < 0 {
+			return found
+		}
+		start := i + n + len("stream")
+		i = start
+		if bytes.HasPrefix(raw[start:], []byte("\r\n")) {
+			start += 2
+		} else if bytes.HasPrefix(raw[start:], []byte("\n")) {
+			start++
+		}
+		if !bytes.HasPrefix(raw[start:], []byte{0xff, 0xd8}) {
+			continue
+		}
+		end := bytes.Index(raw[start:], []byte("endstream"))
+		if end < 0 {
+			return found
+		}
+		data := bytes.TrimRight(raw[start:start+end], "\r\n")
+		if config, err := jpeg.DecodeConfig(bytes.NewReader(data)); err == nil {
+			found = append(found, rawJPEG{data: data, width: config.Width, height: config.Height})
+		}
+		i = start + end
+	}
+}
+
+// The JPEG the image object refers to: one the same size, and the same
+// length if more than one is that size
+func matchJPEG(jpegs []rawJPEG, width, height, length int) []byte {
+	var match []byte
+	for _, j := range jpegs {
+		if j.width != width || j.height != height {
+			continue
+		}
+		if len(j.data) == length {
+			return j.data
+		}
+		if match == nil {
+			match = j.data
+		}
+	}
+	return match
+}
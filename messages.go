@@ -0,0 +1,403 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// ============================================================================
+// MESSAGES - What the server tells people, in each language it speaks
+// ============================================================================
+//
+// Handlers never write user-facing text themselves. Every message has a key
+// (the msg... constants) and an entry in messageCatalog with its English
+// text and translations, and httpError answers in the language the request
+// prefers (see acceptedLanguage). Text with %s or %d in it takes arguments
+// the way fmt.Sprintf does; the arguments (names, numbers, a library's
+// error) go in as they are.
+
+// The key of a user-facing message. Keys are also stored as the error_code
+// of jobs that fail for a reason of our own, so don't rename them.
+type msgKey string
+
+// General
+const (
+	msgMethodNotAllowed msgKey = "method_not_allowed"
+	msgDatabaseError    msgKey = "database_error"
+	msgInvalidJSON      msgKey = "invalid_json"
+	msgInvalidRequest   msgKey = "invalid_request"
+	msgMissingID        msgKey = "missing_id"
+	msgUnauthorized     msgKey = "unauthorized"
+	msgForbidden        msgKey = "forbidden"
+	msgMissingCSRFToken msgKey = "missing_csrf_token"
+	msgInvalidCSRFToken msgKey = "invalid_csrf_token"
+	msgCSRFTokenFailed  msgKey = "csrf_token_failed"
+)
+
+// Accounts and API tokens
+const (
+	msgInvalidCredentials msgKey = "invalid_credentials"
+	msgCredentialsMissing msgKey = "credentials_missing"
+	msgUserExists         msgKey = "user_exists"
+	msgSignupsDisabled    msgKey = "signups_disabled"
+	msgPasswordFailed     msgKey = "password_failed"
+	msgTokenNameRequired  msgKey = "token_name_required"
+	msgUnknownScope       msgKey = "unknown_scope"
+	msgTokenFailed        msgKey = "token_failed"
+	msgTokenSaveFailed    msgKey = "token_save_failed"
+	msgTokenRevokeFailed  msgKey = "token_revoke_failed"
+	msgTokensFailed       msgKey = "tokens_failed"
+	msgTokenNotFound      msgKey = "token_not_found"
+)
+
+// Quizzes, history and generation
+const (
+	msgQuizNotFound        msgKey = "quiz_not_found"
+	msgQuestionNotFound    msgKey = "question_not_found"
+	msgNoSuchQuestion      msgKey = "no_such_question"
+	msgInvalidQuizID       msgKey = "invalid_quiz_id"
+	msgInvalidQuiz         msgKey = "invalid_quiz"
+	msgNoQuestions         msgKey = "no_questions"
+	msgTooManyQuestions    msgKey = "too_many_questions"
+	msgSaveQuizFailed      msgKey = "save_quiz_failed"
+	msgSaveAttemptFailed   msgKey = "save_attempt_failed"
+	msgHistoryFailed       msgKey = "history_failed"
+	msgTimedAttempt        msgKey = "timed_attempt"
+	msgServerScored        msgKey = "server_scored"
+	msgNoAPIKey            msgKey = "no_api_key"
+	msgQueueFailed         msgKey = "queue_failed"
+	msgGenerationFailed    msgKey = "generation_failed"
+	msgJobNotFound         msgKey = "job_not_found"
+	msgJobInterrupted      msgKey = "job_interrupted"
+	msgQuizImageMissing    msgKey = "quiz_image_missing"
+	msgUnsupportedLanguage msgKey = "unsupported_language"
+	msgUntranslatable      msgKey = "untranslatable"
+	msgTranslateFailed     msgKey = "translate_failed"
+	msgExportFormat        msgKey = "export_format"
+	msgZipFailed           msgKey = "zip_failed"
+)
+
+// Uploads and images
+const (
+	msgFormError       msgKey = "form_error"
+	msgFileMissing     msgKey = "file_missing"
+	msgFileReadFailed  msgKey = "file_read_failed"
+	msgFileSaveFailed  msgKey = "file_save_failed"
+	msgExtractFailed   msgKey = "extract_failed"
+	msgInjection       msgKey = "injection"
+	msgUploadsOff      msgKey = "uploads_off"
+	msgImagesNeedLogin msgKey = "images_need_login"
+	msgNoImageText     msgKey = "no_image_text"
+	msgTooManyImages   msgKey = "too_many_images"
+	msgImageNotFound   msgKey = "image_not_found"
+	msgInvalidImageID  msgKey = "invalid_image_id"
+	msgImageTooBig     msgKey = "image_too_big"
+	msgImageType       msgKey = "image_type"
+	msgImageUnreadable msgKey = "image_unreadable"
+)
+
+// Timed and adaptive quizzes
+const (
+	msgTimeLimits         msgKey = "time_limits"
+	msgAttemptRunning     msgKey = "attempt_running"
+	msgStartFirst         msgKey = "start_first"
+	msgAttemptFinished    msgKey = "attempt_finished"
+	msgAlreadyAnswered    msgKey = "already_answered"
+	msgTimeUp             msgKey = "time_up"
+	msgEmptyAnswer        msgKey = "empty_answer"
+	msgAdaptiveNotFound   msgKey = "adaptive_not_found"
+	msgAdaptiveNeedsTopic msgKey = "adaptive_needs_topic"
+	msgAdaptiveNoDocument msgKey = "adaptive_no_document"
+	msgNextQuestionFailed msgKey = "next_question_failed"
+	msgNextQuestionRetry  msgKey = "next_question_retry"
+	msgNoQuestionWaiting  msgKey = "no_question_waiting"
+)
+
+// Question bank and flags
+const (
+	msgBankLimit     msgKey = "bank_limit"
+	msgBankSample    msgKey = "bank_sample"
+	msgBankPickOne   msgKey = "bank_pick_one"
+	msgBankPickNone  msgKey = "bank_pick_none"
+	msgBankNoMatches msgKey = "bank_no_matches"
+	msgFlagReason    msgKey = "flag_reason"
+	msgFlagStatus    msgKey = "flag_status"
+	msgFlagAction    msgKey = "flag_action"
+	msgInvalidFix    msgKey = "invalid_fix"
+	msgQuizChanged   msgKey = "quiz_changed"
+)
+
+// Live games
+const (
+	msgQuestionSeconds  msgKey = "question_seconds"
+	msgNotPlayableLive  msgKey = "not_playable_live"
+	msgImagesNotLive    msgKey = "images_not_live"
+	msgTooManySessions  msgKey = "too_many_sessions"
+	msgNoLiveSession    msgKey = "no_live_session"
+	msgHostOnly         msgKey = "host_only"
+	msgPickNickname     msgKey = "pick_nickname"
+	msgNicknameTaken    msgKey = "nickname_taken"
+	msgGameFull         msgKey = "game_full"
+	msgGameFinished     msgKey = "game_finished"
+	msgQuestionNotOpen  msgKey = "question_not_open"
+	msgWaitForPlayers   msgKey = "wait_for_players"
+	msgCantNow          msgKey = "cant_now"
+	msgLiveNotStarted   msgKey = "live_not_started"
+	msgServerRestarting msgKey = "server_restarting"
+	msgLastQuestion     msgKey = "last_question"
+	msgHostEnded        msgKey = "host_ended"
+	msgHostLeft         msgKey = "host_left"
+)
+
+// Prompt admin
+const (
+	msgInvalidPrompt       msgKey = "invalid_prompt"
+	msgPromptNotFound      msgKey = "prompt_not_found"
+	msgSaveDefaultFirst    msgKey = "save_default_first"
+	msgQuestionCountNumber msgKey = "question_count_number"
+)
+
+// The AI service (LLMError.UserMessage), also shown for failed jobs
+const (
+	msgAIBusy        msgKey = "ai_busy"
+	msgAIUnavailable msgKey = "ai_unavailable"
+	msgAITimeout     msgKey = "ai_timeout"
+	msgAIBadOutput   msgKey = "ai_bad_output"
+	msgAIRejected    msgKey = "ai_rejected"
+)
+
+// Languages there are messages in. English is the one every message has.
+var messageLanguages = []string{"en", "es", "fr", "de"}
+
+// Every message in every language in messageLanguages
+var messageCatalog = map[msgKey]map[string]string{
+	msgMethodNotAllowed: {"en": "Method not allowed", "es": "Método no permitido", "fr": "Méthode non autorisée", "de": "Methode nicht erlaubt"},
+	msgDatabaseError:    {"en": "Database error", "es": "Error de base de datos", "fr": "Erreur de base de données", "de": "Datenbankfehler"},
+	msgInvalidJSON:      {"en": "Invalid JSON", "es": "JSON no válido", "fr": "JSON invalide", "de": "Ungültiges JSON"},
+	msgInvalidRequest:   {"en": "Invalid request", "es": "Solicitud no válida", "fr": "Requête invalide", "de": "Ungültige Anfrage"},
+	msgMissingID:        {"en": "Missing id", "es": "Falta el id", "fr": "Identifiant manquant", "de": "ID fehlt"},
+	msgUnauthorized:     {"en": "Unauthorized", "es": "No autorizado", "fr": "Non autorisé", "de": "Nicht angemeldet"},
+	msgForbidden:        {"en": "Forbidden", "es": "Prohibido", "fr": "Interdit", "de": "Zugriff verweigert"},
+	msgMissingCSRFToken: {"en": "Missing CSRF token", "es": "Falta el token CSRF", "fr": "Jeton CSRF manquant", "de": "CSRF-Token fehlt"},
+	msgInvalidCSRFToken: {"en": "Invalid CSRF token", "es": "Token CSRF no válido", "fr": "Jeton CSRF invalide", "de": "Ungültiges CSRF-Token"},
+	msgCSRFTokenFailed: {"en": "Error generating CSRF token", "es": "Error al generar el token CSRF",
+		"fr": "Erreur lors de la création du jeton CSRF", "de": "Fehler beim Erzeugen des CSRF-Tokens"},
+
+	// Accounts and API tokens
+	msgInvalidCredentials: {"en": "Invalid credentials", "es": "Credenciales no válidas", "fr": "Identifiants invalides", "de": "Ungültige Anmeldedaten"},
+	msgCredentialsMissing: {"en": "Email and password are required", "es": "Se requieren correo electrónico y contraseña",
+		"fr": "L'e-mail et le mot de passe sont obligatoires", "de": "E-Mail und Passwort sind erforderlich"},
+	msgUserExists: {"en": "User already exists or error saving user", "es": "El usuario ya existe o no se pudo guardar",
+		"fr": "L'utilisateur existe déjà ou n'a pas pu être enregistré", "de": "Der Benutzer existiert bereits oder konnte nicht gespeichert werden"},
+	msgSignupsDisabled: {"en": "Signups are disabled", "es": "Los registros están desactivados", "fr": "Les inscriptions sont désactivées", "de": "Registrierungen sind deaktiviert"},
+	msgPasswordFailed: {"en": "Error hashing password", "es": "Error al procesar la contraseña",
+		"fr": "Erreur lors du traitement du mot de passe", "de": "Fehler beim Verarbeiten des Passworts"},
+	msgTokenNameRequired: {"en": "Token name is required", "es": "El nombre del token es obligatorio", "fr": "Le nom du jeton est obligatoire", "de": "Ein Name für das Token ist erforderlich"},
+	msgUnknownScope:      {"en": "Unknown scope: %s", "es": "Ámbito desconocido: %s", "fr": "Portée inconnue : %s", "de": "Unbekannter Bereich: %s"},
+	msgTokenFailed:       {"en": "Error generating token", "es": "Error al generar el token", "fr": "Erreur lors de la création du jeton", "de": "Fehler beim Erzeugen des Tokens"},
+	msgTokenSaveFailed:   {"en": "Failed to save token", "es": "No se pudo guardar el token", "fr": "Impossible d'enregistrer le jeton", "de": "Das Token konnte nicht gespeichert werden"},
+	msgTokenRevokeFailed: {"en": "Failed to revoke token", "es": "No se pudo revocar el token", "fr": "Impossible de révoquer le jeton", "de": "Das Token konnte nicht widerrufen werden"},
+	msgTokensFailed:      {"en": "Failed to query tokens", "es": "No se pudieron cargar los tokens", "fr": "Impossible de charger les jetons", "de": "Die Tokens konnten nicht geladen werden"},
+	msgTokenNotFound:     {"en": "Token not found", "es": "Token no encontrado", "fr": "Jeton introuvable", "de": "Token nicht gefunden"},
+
+	// Quizzes, history and generation
+	msgQuizNotFound:      {"en": "Quiz not found", "es": "Cuestionario no encontrado", "fr": "Quiz introuvable", "de": "Quiz nicht gefunden"},
+	msgQuestionNotFound:  {"en": "Question not found", "es": "Pregunta no encontrada", "fr": "Question introuvable", "de": "Frage nicht gefunden"},
+	msgNoSuchQuestion:    {"en": "No such question", "es": "Esa pregunta no existe", "fr": "Cette question n'existe pas", "de": "Diese Frage gibt es nicht"},
+	msgInvalidQuizID:     {"en": "Invalid quiz ID", "es": "ID de cuestionario no válido", "fr": "Identifiant de quiz invalide", "de": "Ungültige Quiz-ID"},
+	msgInvalidQuiz:       {"en": "Invalid quiz: %s", "es": "Cuestionario no válido: %s", "fr": "Quiz invalide : %s", "de": "Ungültiges Quiz: %s"},
+	msgNoQuestions:       {"en": "This quiz has no questions", "es": "Este cuestionario no tiene preguntas", "fr": "Ce quiz n'a pas de questions", "de": "Dieses Quiz hat keine Fragen"},
+	msgTooManyQuestions:  {"en": "Too many questions", "es": "Demasiadas preguntas", "fr": "Trop de questions", "de": "Zu viele Fragen"},
+	msgSaveQuizFailed:    {"en": "Failed to save quiz", "es": "No se pudo guardar el cuestionario", "fr": "Impossible d'enregistrer le quiz", "de": "Das Quiz konnte nicht gespeichert werden"},
+	msgSaveAttemptFailed: {"en": "Failed to save attempt", "es": "No se pudo guardar el intento", "fr": "Impossible d'enregistrer la tentative", "de": "Der Versuch konnte nicht gespeichert werden"},
+	msgHistoryFailed:     {"en": "Failed to query history", "es": "No se pudo cargar el historial", "fr": "Impossible de charger l'historique", "de": "Der Verlauf konnte nicht geladen werden"},
+	msgTimedAttempt: {"en": "Timed quizzes are answered through /api/attempts/answer", "es": "Los cuestionarios con tiempo se responden mediante /api/attempts/answer",
+		"fr": "Les quiz chronométrés se répondent via /api/attempts/answer", "de": "Quizze mit Zeitlimit werden über /api/attempts/answer beantwortet"},
+	msgServerScored: {"en": "This attempt is scored by the server", "es": "Este intento lo puntúa el servidor",
+		"fr": "Cette tentative est notée par le serveur", "de": "Dieser Versuch wird vom Server bewertet"},
+	msgNoAPIKey: {"en": "OpenAI API key not configured", "es": "La clave de la API de OpenAI no está configurada",
+		"fr": "La clé d'API OpenAI n'est pas configurée", "de": "Der OpenAI-API-Schlüssel ist nicht konfiguriert"},
+	msgQueueFailed: {"en": "Could not queue quiz generation", "es": "No se pudo poner en cola la generación del cuestionario",
+		"fr": "Impossible de mettre la génération du quiz en file d'attente", "de": "Die Quiz-Erstellung konnte nicht eingereiht werden"},
+	msgGenerationFailed: {"en": "Something went wrong generating the quiz - try again", "es": "Algo falló al generar el cuestionario; inténtalo de nuevo",
+		"fr": "Un problème est survenu lors de la génération du quiz - réessayez", "de": "Beim Erstellen des Quiz ist etwas schiefgelaufen - versuch es noch einmal"},
+	msgJobNotFound: {"en": "Job not found", "es": "Tarea no encontrada", "fr": "Tâche introuvable", "de": "Auftrag nicht gefunden"},
+	msgJobInterrupted: {"en": "Quiz generation kept being interrupted, so it was given up", "es": "La generación del cuestionario se interrumpió demasiadas veces y se abandonó",
+		"fr": "La génération du quiz a été interrompue trop souvent et a été abandonnée", "de": "Die Quiz-Erstellung wurde zu oft unterbrochen und deshalb abgebrochen"},
+	msgQuizImageMissing: {"en": "An image for the quiz could not be found", "es": "No se encontró una imagen del cuestionario",
+		"fr": "Une image du quiz est introuvable", "de": "Ein Bild für das Quiz wurde nicht gefunden"},
+	msgUnsupportedLanguage: {"en": "Unsupported language: %s", "es": "Idioma no admitido: %s", "fr": "Langue non prise en charge : %s", "de": "Nicht unterstützte Sprache: %s"},
+	msgUntranslatable: {"en": "This quiz can't be translated: %s", "es": "Este cuestionario no se puede traducir: %s",
+		"fr": "Ce quiz ne peut pas être traduit : %s", "de": "Dieses Quiz kann nicht übersetzt werden: %s"},
+	msgTranslateFailed: {"en": "Could not translate the quiz", "es": "No se pudo traducir el cuestionario", "fr": "Impossible de traduire le quiz", "de": "Das Quiz konnte nicht übersetzt werden"},
+	msgExportFormat: {"en": "Format must be txt, gift, json or zip", "es": "El formato debe ser txt, gift, json o zip",
+		"fr": "Le format doit être txt, gift, json ou zip", "de": "Das Format muss txt, gift, json oder zip sein"},
+	msgZipFailed: {"en": "Could not build the zip", "es": "No se pudo crear el zip", "fr": "Impossible de créer le zip", "de": "Das Zip konnte nicht erstellt werden"},
+
+	// Uploads and images
+	msgFormError:      {"en": "Error parsing form", "es": "Error al leer el formulario", "fr": "Erreur de lecture du formulaire", "de": "Fehler beim Lesen des Formulars"},
+	msgFileMissing:    {"en": "Error retrieving file", "es": "Error al recibir el archivo", "fr": "Erreur lors de la réception du fichier", "de": "Fehler beim Empfangen der Datei"},
+	msgFileReadFailed: {"en": "Error reading file", "es": "Error al leer el archivo", "fr": "Erreur lors de la lecture du fichier", "de": "Fehler beim Lesen der Datei"},
+	msgFileSaveFailed: {"en": "Error saving file", "es": "Error al guardar el archivo", "fr": "Erreur lors de l'enregistrement du fichier", "de": "Fehler beim Speichern der Datei"},
+	msgExtractFailed:  {"en": "Error extracting text: %s", "es": "Error al extraer el texto: %s", "fr": "Erreur lors de l'extraction du texte : %s", "de": "Fehler beim Extrahieren des Textes: %s"},
+	msgInjection: {
+		"en": "This document contains text that looks like instructions for the AI, so it can't be used to make a quiz.",
+		"es": "Este documento contiene texto que parece instrucciones para la IA, así que no se puede usar para crear un cuestionario.",
+		"fr": "Ce document contient du texte qui ressemble à des instructions pour l'IA ; il ne peut donc pas servir à créer un quiz.",
+		"de": "Dieses Dokument enthält Text, der wie Anweisungen an die KI aussieht, und kann deshalb nicht für ein Quiz verwendet werden."},
+	msgUploadsOff: {"en": "Uploads are switched off", "es": "Las subidas están desactivadas", "fr": "Les importations sont désactivées", "de": "Uploads sind abgeschaltet"},
+	msgImagesNeedLogin: {"en": "Log in to make quizzes from images", "es": "Inicia sesión para crear cuestionarios a partir de imágenes",
+		"fr": "Connectez-vous pour créer des quiz à partir d'images", "de": "Melde dich an, um Quizze aus Bildern zu erstellen"},
+	msgNoImageText: {
+		"en": "No text could be read from the image. Log in to make quizzes about the picture itself.",
+		"es": "No se pudo leer ningún texto de la imagen. Inicia sesión para crear cuestionarios sobre la propia imagen.",
+		"fr": "Aucun texte n'a pu être lu dans l'image. Connectez-vous pour créer des quiz sur l'image elle-même.",
+		"de": "Im Bild konnte kein Text gelesen werden. Melde dich an, um Quizze über das Bild selbst zu erstellen."},
+	msgTooManyImages: {"en": "A quiz can be made from at most %d images", "es": "Un cuestionario se puede crear a partir de %d imágenes como máximo",
+		"fr": "Un quiz peut être créé à partir de %d images au maximum", "de": "Ein Quiz kann aus höchstens %d Bildern erstellt werden"},
+	msgImageNotFound:   {"en": "Image not found", "es": "Imagen no encontrada", "fr": "Image introuvable", "de": "Bild nicht gefunden"},
+	msgInvalidImageID:  {"en": "Invalid image ID", "es": "ID de imagen no válido", "fr": "Identifiant d'image invalide", "de": "Ungültige Bild-ID"},
+	msgImageTooBig:     {"en": "Images can be at most %d MB", "es": "Las imágenes pueden ocupar como máximo %d MB", "fr": "Les images ne peuvent pas dépasser %d Mo", "de": "Bilder dürfen höchstens %d MB groß sein"},
+	msgImageType:       {"en": "Images must be PNG, JPEG or GIF", "es": "Las imágenes deben ser PNG, JPEG o GIF", "fr": "Les images doivent être au format PNG, JPEG ou GIF", "de": "Bilder müssen PNG, JPEG oder GIF sein"},
+	msgImageUnreadable: {"en": "Image could not be read", "es": "No se pudo leer la imagen", "fr": "Impossible de lire l'image", "de": "Das Bild konnte nicht gelesen werden"},
+
+	// Timed and adaptive quizzes
+	msgTimeLimits: {"en": "Time limits must be between 0 and %d seconds", "es": "Los límites de tiempo deben estar entre 0 y %d segundos",
+		"fr": "Les limites de temps doivent être comprises entre 0 et %d secondes", "de": "Zeitlimits müssen zwischen 0 und %d Sekunden liegen"},
+	msgAttemptRunning: {"en": "Time limits can't be changed while an attempt is under way", "es": "Los límites de tiempo no se pueden cambiar mientras hay un intento en curso",
+		"fr": "Les limites de temps ne peuvent pas changer pendant une tentative en cours", "de": "Zeitlimits können während eines laufenden Versuchs nicht geändert werden"},
+	msgStartFirst:      {"en": "Start the quiz first", "es": "Empieza primero el cuestionario", "fr": "Commencez d'abord le quiz", "de": "Starte zuerst das Quiz"},
+	msgAttemptFinished: {"en": "This attempt is already finished", "es": "Este intento ya ha terminado", "fr": "Cette tentative est déjà terminée", "de": "Dieser Versuch ist bereits beendet"},
+	msgAlreadyAnswered: {"en": "That question has already been answered", "es": "Esa pregunta ya se ha respondido", "fr": "Cette question a déjà reçu une réponse", "de": "Diese Frage wurde bereits beantwortet"},
+	msgTimeUp: {"en": "Time is up - your answers so far have been submitted", "es": "Se acabó el tiempo: se han enviado tus respuestas hasta ahora",
+		"fr": "Le temps est écoulé : vos réponses jusqu'ici ont été envoyées", "de": "Die Zeit ist um - deine bisherigen Antworten wurden abgegeben"},
+	msgEmptyAnswer:      {"en": "Answer must not be empty", "es": "La respuesta no puede estar vacía", "fr": "La réponse ne peut pas être vide", "de": "Die Antwort darf nicht leer sein"},
+	msgAdaptiveNotFound: {"en": "Adaptive quiz not found", "es": "Cuestionario adaptativo no encontrado", "fr": "Quiz adaptatif introuvable", "de": "Adaptives Quiz nicht gefunden"},
+	msgAdaptiveNeedsTopic: {"en": "Adaptive quizzes need a topic", "es": "Los cuestionarios adaptativos necesitan un tema",
+		"fr": "Les quiz adaptatifs ont besoin d'un sujet", "de": "Adaptive Quizze brauchen ein Thema"},
+	msgAdaptiveNoDocument: {"en": "Adaptive quizzes work from a topic, not an uploaded document", "es": "Los cuestionarios adaptativos parten de un tema, no de un documento subido",
+		"fr": "Les quiz adaptatifs partent d'un sujet, pas d'un document importé", "de": "Adaptive Quizze gehen von einem Thema aus, nicht von einem hochgeladenen Dokument"},
+	msgNextQuestionFailed: {"en": "Could not get the next question", "es": "No se pudo obtener la siguiente pregunta",
+		"fr": "Impossible d'obtenir la question suivante", "de": "Die nächste Frage konnte nicht geladen werden"},
+	msgNextQuestionRetry: {"en": "Could not get the next question - try again", "es": "No se pudo obtener la siguiente pregunta; inténtalo de nuevo",
+		"fr": "Impossible d'obtenir la question suivante - réessayez", "de": "Die nächste Frage konnte nicht geladen werden - versuch es noch einmal"},
+	msgNoQuestionWaiting: {"en": "There's no question waiting for an answer", "es": "No hay ninguna pregunta esperando respuesta",
+		"fr": "Aucune question n'attend de réponse", "de": "Es wartet keine Frage auf eine Antwort"},
+
+	// Question bank and flags
+	msgBankLimit: {"en": "limit must be between 1 and %d", "es": "limit debe estar entre 1 y %d", "fr": "limit doit être compris entre 1 et %d", "de": "limit muss zwischen 1 und %d liegen"},
+	msgBankSample: {"en": "sample.count must be between 1 and %d", "es": "sample.count debe estar entre 1 y %d",
+		"fr": "sample.count doit être compris entre 1 et %d", "de": "sample.count muss zwischen 1 und %d liegen"},
+	msgBankPickOne: {"en": "Give question_ids or sample, not both", "es": "Indica question_ids o sample, no ambos",
+		"fr": "Indiquez question_ids ou sample, pas les deux", "de": "Gib question_ids oder sample an, nicht beides"},
+	msgBankPickNone: {"en": "Give question_ids or sample", "es": "Indica question_ids o sample", "fr": "Indiquez question_ids ou sample", "de": "Gib question_ids oder sample an"},
+	msgBankNoMatches: {"en": "No matching questions in your bank", "es": "No hay preguntas que coincidan en tu banco",
+		"fr": "Aucune question correspondante dans votre banque", "de": "Keine passenden Fragen in deiner Fragensammlung"},
+	msgFlagReason: {"en": "Reason must be one of: %s", "es": "El motivo debe ser uno de: %s", "fr": "La raison doit être l'une de : %s", "de": "Der Grund muss einer der folgenden sein: %s"},
+	msgFlagStatus: {"en": "Status must be open, fixed or dismissed", "es": "El estado debe ser open, fixed o dismissed",
+		"fr": "Le statut doit être open, fixed ou dismissed", "de": "Der Status muss open, fixed oder dismissed sein"},
+	msgFlagAction: {"en": `Action must be "dismiss" or "fix"`, "es": `La acción debe ser "dismiss" o "fix"`,
+		"fr": `L'action doit être "dismiss" ou "fix"`, "de": `Die Aktion muss "dismiss" oder "fix" sein`},
+	msgInvalidFix: {"en": "Invalid fix: %s", "es": "Corrección no válida: %s", "fr": "Correction invalide : %s", "de": "Ungültige Korrektur: %s"},
+	msgQuizChanged: {"en": "The quiz changed while you were fixing it. Reload it and try again.", "es": "El cuestionario cambió mientras lo corregías. Vuelve a cargarlo e inténtalo de nuevo.",
+		"fr": "Le quiz a changé pendant que vous le corrigiez. Rechargez-le et réessayez.", "de": "Das Quiz wurde geändert, während du es korrigiert hast. Lade es neu und versuche es noch einmal."},
+
+	// Live games
+	msgQuestionSeconds: {"en": "question_seconds must be between %d and %d", "es": "question_seconds debe estar entre %d y %d",
+		"fr": "question_seconds doit être compris entre %d et %d", "de": "question_seconds muss zwischen %d und %d liegen"},
+	msgNotPlayableLive: {"en": "%s questions can't be played live", "es": "Las preguntas de tipo %s no se pueden jugar en directo",
+		"fr": "Les questions de type %s ne peuvent pas être jouées en direct", "de": "Fragen vom Typ %s können nicht live gespielt werden"},
+	msgImagesNotLive: {"en": "Questions with images can't be played live", "es": "Las preguntas con imágenes no se pueden jugar en directo",
+		"fr": "Les questions avec des images ne peuvent pas être jouées en direct", "de": "Fragen mit Bildern können nicht live gespielt werden"},
+	msgTooManySessions: {"en": "Too many live sessions are running, try again later", "es": "Hay demasiadas sesiones en directo en marcha, inténtalo más tarde",
+		"fr": "Trop de sessions en direct sont en cours, réessayez plus tard", "de": "Es laufen zu viele Live-Sitzungen, versuche es später noch einmal"},
+	msgNoLiveSession:   {"en": "No live session with that PIN", "es": "No hay ninguna sesión en directo con ese PIN", "fr": "Aucune session en direct avec ce code PIN", "de": "Keine Live-Sitzung mit dieser PIN"},
+	msgHostOnly:        {"en": "Only the host can run this session", "es": "Solo el anfitrión puede dirigir esta sesión", "fr": "Seul l'hôte peut animer cette session", "de": "Nur der Gastgeber kann diese Sitzung leiten"},
+	msgPickNickname:    {"en": "Pick a nickname", "es": "Elige un apodo", "fr": "Choisissez un pseudo", "de": "Wähle einen Spitznamen"},
+	msgNicknameTaken:   {"en": "That nickname is taken", "es": "Ese apodo ya está en uso", "fr": "Ce pseudo est déjà pris", "de": "Dieser Spitzname ist schon vergeben"},
+	msgGameFull:        {"en": "This game is full", "es": "Esta partida está completa", "fr": "Cette partie est complète", "de": "Dieses Spiel ist voll"},
+	msgGameFinished:    {"en": "This game has finished", "es": "Esta partida ha terminado", "fr": "Cette partie est terminée", "de": "Dieses Spiel ist vorbei"},
+	msgQuestionNotOpen: {"en": "That question isn't open", "es": "Esa pregunta no está abierta", "fr": "Cette question n'est pas ouverte", "de": "Diese Frage ist nicht offen"},
+	msgWaitForPlayers: {"en": "Wait for at least one player to join", "es": "Espera a que se una al menos un jugador",
+		"fr": "Attendez qu'au moins un joueur rejoigne la partie", "de": "Warte, bis mindestens ein Spieler beigetreten ist"},
+	msgCantNow: {"en": "Can't %s now", "es": "Ahora no se puede hacer %s", "fr": "Impossible de faire %s maintenant", "de": "%s ist gerade nicht möglich"},
+	msgLiveNotStarted: {"en": "The session wasn't started in time", "es": "La sesión no se inició a tiempo",
+		"fr": "La session n'a pas été lancée à temps", "de": "Die Sitzung wurde nicht rechtzeitig gestartet"},
+	msgServerRestarting: {"en": "The server is restarting", "es": "El servidor se está reiniciando", "fr": "Le serveur redémarre", "de": "Der Server wird neu gestartet"},
+	msgLastQuestion:     {"en": "That was the last question", "es": "Esa era la última pregunta", "fr": "C'était la dernière question", "de": "Das war die letzte Frage"},
+	msgHostEnded:        {"en": "The host ended the game", "es": "El anfitrión terminó la partida", "fr": "L'hôte a terminé la partie", "de": "Der Gastgeber hat das Spiel beendet"},
+	msgHostLeft:         {"en": "The host left", "es": "El anfitrión se ha ido", "fr": "L'hôte est parti", "de": "Der Gastgeber ist gegangen"},
+
+	// Prompt admin
+	msgInvalidPrompt:  {"en": "Invalid prompt: %s", "es": "Plantilla no válida: %s", "fr": "Modèle invalide : %s", "de": "Ungültige Vorlage: %s"},
+	msgPromptNotFound: {"en": "Prompt not found: %s", "es": "Plantilla no encontrada: %s", "fr": "Modèle introuvable : %s", "de": "Vorlage nicht gefunden: %s"},
+	msgSaveDefaultFirst: {"en": "Save %s/v%d/default first - the other variants fall back to it", "es": "Guarda primero %s/v%d/default: las demás variantes recurren a ella",
+		"fr": "Enregistrez d'abord %s/v%d/default : les autres variantes s'y rabattent", "de": "Speichere zuerst %s/v%d/default - die anderen Varianten greifen darauf zurück"},
+	msgQuestionCountNumber: {"en": "questionCount must be a number", "es": "questionCount debe ser un número", "fr": "questionCount doit être un nombre", "de": "questionCount muss eine Zahl sein"},
+
+	// The AI service
+	msgAIBusy: {"en": "The AI service is busy right now. Please try again in a minute.", "es": "El servicio de IA está ocupado ahora mismo. Inténtalo de nuevo en un minuto.",
+		"fr": "Le service d'IA est occupé pour le moment. Réessayez dans une minute.", "de": "Der KI-Dienst ist gerade ausgelastet. Bitte versuche es in einer Minute noch einmal."},
+	msgAIUnavailable: {"en": "The AI service is temporarily unavailable. Please try again shortly.", "es": "El servicio de IA no está disponible temporalmente. Inténtalo de nuevo en breve.",
+		"fr": "Le service d'IA est temporairement indisponible. Réessayez dans quelques instants.", "de": "Der KI-Dienst ist vorübergehend nicht erreichbar. Bitte versuche es gleich noch einmal."},
+	msgAITimeout: {"en": "The AI service took too long to answer. Try again, or ask for fewer questions.", "es": "El servicio de IA tardó demasiado en responder. Inténtalo de nuevo o pide menos preguntas.",
+		"fr": "Le service d'IA a mis trop de temps à répondre. Réessayez ou demandez moins de questions.", "de": "Der KI-Dienst hat zu lange für die Antwort gebraucht. Versuche es noch einmal oder frage nach weniger Fragen."},
+	msgAIBadOutput: {"en": "The AI returned a quiz we couldn't read. Please try again.", "es": "La IA devolvió un cuestionario que no pudimos leer. Inténtalo de nuevo.",
+		"fr": "L'IA a renvoyé un quiz illisible. Réessayez.", "de": "Die KI hat ein Quiz geliefert, das wir nicht lesen konnten. Bitte versuche es noch einmal."},
+	msgAIRejected: {"en": "The AI service rejected the request. Please contact the site administrator if this keeps happening.", "es": "El servicio de IA rechazó la solicitud. Si sigue ocurriendo, ponte en contacto con el administrador del sitio.",
+		"fr": "Le service d'IA a refusé la requête. Si cela se reproduit, contactez l'administrateur du site.", "de": "Der KI-Dienst hat die Anfrage abgelehnt. Wenn das weiterhin passiert, wende dich an den Administrator der Website."},
+}
+
+// The message in lang, or in English if it hasn't been translated
+func (k msgKey) text(lang string, args ...interface{}) string {
+	texts := messageCatalog[k]
+	text, ok := texts[lang]
+	if !ok {
+		text = texts["en"]
+	}
+	if len(args) > 0 {
+		return fmt.Sprintf(text, args...)
+	}
+	return text
+}
+
+// A message as an error, for code that can't write the response itself.
+// Error() is the English text, for logs.
+type messageError struct {
+	key  msgKey
+	args []interface{}
+}
+
+func (k msgKey) err(args ...interface{}) error {
+	return &messageError{key: k, args: args}
+}
+
+func (e *messageError) Error() string {
+	return e.key.text("en", e.args...)
+}
+
+// An error's text in lang: translated if it's one of our messages, as it
+// is otherwise
+func errorText(err error, lang string) string {
+	var m *messageError
+	if errors.As(err, &m) {
+		return m.key.text(lang, m.args...)
+	}
+	return err.Error()
+}
+
+// The language to answer a request in ("" for English)
+func requestLanguage(r *http.Request) string {
+	return acceptedLanguage(r.Header.Get("Accept-Language"))
+}
+
+// Reply with a message as a plain-text error, in the request's language
+func httpError(w http.ResponseWriter, r *http.Request, key msgKey, code int, args ...interface{}) {
+	http.Error(w, key.text(requestLanguage(r), args...), code)
+}
+
+// Reply with an error from a message (see msgKey.err) as a plain-text error
+func httpErrorFrom(w http.ResponseWriter, r *http.Request, err error, code int) {
+	http.Error(w, errorText(err, requestLanguage(r)), code)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"regexp"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+var formatVerb = regexp.MustCompile(`%[a-z]`)
+
+// Every message key declared in messages.go is in the catalog, in every
+// language, with the same arguments as the English text
+func TestMessageCatalog(t *testing.T) {
+	file, err := parser.ParseFile(token.NewFileSet(), "messages.go", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []msgKey
+	for _, decl := range file.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.CONST {
+			continue
+		}
+		for _, spec := range gen.Specs {
+			for _, v := range spec.(*ast.ValueSpec).Values {
+				if lit, ok := v.(*ast.BasicLit); ok && lit.Kind == token.STRING {
+					s, _ := strconv.Unquote(lit.Value)
+					keys = append(keys, msgKey(s))
+				}
+			}
+		}
+	}
+	if len(keys) != len(messageCatalog) {
+		t.Errorf("%d keys declared, %d in the catalog", len(keys), len(messageCatalog))
+	}
+
+	for _, key := range keys {
+		texts, ok := messageCatalog[key]
+		if !ok {
+			t.Errorf("%s: not in the catalog", key)
+			continue
+		}
+		verbs := formatVerb.FindAllString(texts["en"], -1)
+		for _, lang := range messageLanguages {
+			text, ok := texts[lang]
+			if !ok || text == "" {
+				t.Errorf("%s: no %s text", key, lang)
+			} else if got := formatVerb.FindAllString(text, -1); !slices.Equal(got, verbs) {
+				t.Errorf("%s: %s text takes %v, English takes %v", key, lang, got, verbs)
+			}
+		}
+	}
+}
+
+// Failed jobs are shown in the reader's language whichever way they failed
+func TestJobErrorLanguage(t *testing.T) {
+	for _, tc := range []struct {
+		code, stored, want string
+	}{
+		{llmRateLimited, msgAIBusy.text("en"), msgAIBusy.text("de")},
+		{string(msgQuizImageMissing), msgQuizImageMissing.text("en"), msgQuizImageMissing.text("de")},
+		{string(msgGenerationFailed), msgGenerationFailed.text("en"), msgGenerationFailed.text("de")},
+		{"failed", "sql: database is locked", msgGenerationFailed.text("de")}, // Stored before failures all had a message key
+	} {
+		job := &Job{ErrorCode: tc.code, Error: tc.stored}
+		job.localizeError("de")
+		if job.Error != tc.want {
+			t.Errorf("%s: %q, want %q", tc.code, job.Error, tc.want)
+		}
+	}
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ============================================================================
+// METRICS - Counters and histograms served in Prometheus text format
+// ============================================================================
+
+// Default latency buckets in seconds - quiz generation sits at the slow end
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120}
+
+// All the numbers the app keeps about itself
+type Metrics struct {
+	httpRequests       *counterVec   // Requests by route, method and status
+	httpDuration       *histogramVec // Request latency by route and method
+	llmRequests        *counterVec   // AI calls by model and outcome (ok, rate_limited, timeout...)
+	llmDuration        *histogramVec // AI call latency by model
+	quizGenerations    *counterVec   // Generated quizzes by result (success/failure)
+	suspiciousSources  *counterVec   // Uploaded documents with instruction-like text, by action (warned/rejected)
+	cacheLookups       *counterVec   // Generation cache lookups by result (hit/miss/bypass)
+	duplicateQuestions *counterVec   // Repeated questions by action (flagged/replaced)
+	timedAttempts      *counterVec   // Timed attempt events (started, finished, auto_submitted, late_answer)
+	liveEvents         *counterVec   // Live session events (created, started, finished, abandoned, joined)
+	adaptiveQuestions  *counterVec   // Where adaptive quiz questions came from (bank or generated)
+	questionFlags      *counterVec   // Questions flagged by users, by reason
+	verifiedQuestions  *counterVec   // Answer checks by outcome (agreed, disagreed, replaced, failed)
+	quizTranslations   *counterVec   // Saved quizzes translated, by result (success/failure)
+	mediaSaved         *counterVec   // Images saved, by source (upload/pdf)
+	ocrImages          *counterVec   // Images read with OCR, by source (upload/pdf) and result (text, empty, failed)
+}
+
+// Make the app's metrics, all starting at zero
+func NewMetrics() *Metrics {
+	return &Metrics{
+		httpRequests: newCounterVec("askify_http_requests_total",
+			"HTTP requests handled, by route, method and status code.", "route", "method", "status"),
+		httpDuration: newHistogramVec("askify_http_request_duration_seconds",
+			"HTTP request latency in seconds, by route and method.", latencyBuckets, "route", "method"),
+		llmRequests: newCounterVec("askify_llm_requests_total",
+			"Calls to the AI provider, by model and outcome (ok, or the kind of failure).", "model", "outcome"),
+		llmDuration: newHistogramVec("askify_llm_request_duration_seconds",
+			"AI provider call latency in seconds, by model.", latencyBuckets, "model"),
+		quizGenerations: newCounterVec("askify_quiz_generations_total",
+			"Quiz generation requests, by result (success or failure).", "result"),
+		suspiciousSources: newCounterVec("askify_suspicious_sources_total",
+			"Uploaded documents that looked like they held instructions for the AI, by action (warned or rejected).", "action"),
+		cacheLookups: newCounterVec("askify_generation_cache_lookups_total",
+			"Generation cache lookups, by result (hit, miss, or bypass when the user asked for new questions).", "result"),
+		duplicateQuestions: newCounterVec("askify_duplicate_questions_total",
+			"Generated questions that repeated an earlier one, by action (flagged or replaced).", "action"),
+		timedAttempts: newCounterVec("askify_timed_attempts_total",
+			"Timed quiz attempts, by event (started, finished, auto_submitted, late_answer).", "event"),
+		liveEvents: newCounterVec("askify_live_events_total",
+			"Live multiplayer sessions, by event (created, started, finished, abandoned, joined).", "event"),
+		adaptiveQuestions: newCounterVec("askify_adaptive_questions_total",
+			"Questions picked for adaptive quizzes, by source (bank or generated).", "source"),
+		questionFlags: newCounterVec("askify_question_flags_total",
+			"Questions flagged by users, by reason (wrong_answer, ambiguous or off_topic).", "reason"),
+		verifiedQuestions: newCounterVec("askify_verified_questions_total",
+			"Generated answers checked by a second AI call, by outcome (agreed, disagreed, replaced or failed).", "outcome"),
+		quizTranslations: newCounterVec("askify_quiz_translations_total",
+			"Saved quizzes translated into another language, by result (success or failure).", "result"),
+		mediaSaved: newCounterVec("askify_media_saved_total",
+			"Images saved for questions, by source (upload, or pdf when taken from an uploaded PDF).", "source"),
+		ocrImages: newCounterVec("askify_ocr_images_total",
+			"Images read with OCR, by source (upload, or pdf for scanned pages) and result (text, empty or failed).", "source", "result"),
+	}
+}
+
+// Write every metric in Prometheus text exposition format
+func (m *Metrics) writeAll(w io.Writer) {
+	m.httpRequests.writeTo(w)
+	m.httpDuration.writeTo(w)
+	m.llmRequests.writeTo(w)
+	m.llmDuration.writeTo(w)
+	m.quizGenerations.writeTo(w)
+	m.suspiciousSources.writeTo(w)
+	m.cacheLookups.writeTo(w)
+	m.duplicateQuestions.writeTo(w)
+	m.timedAttempts.writeTo(w)
+	m.liveEvents.writeTo(w)
+	m.adaptiveQuestions.writeTo(w)
+	m.questionFlags.writeTo(w)
+	m.verifiedQuestions.writeTo(w)
+	m.quizTranslations.writeTo(w)
+	m.mediaSaved.writeTo(w)
+	m.ocrImages.writeTo(w)
+}
+
+// Serve /metrics for Prometheus to scrape
+func (a *App) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	a.metrics.writeAll(w)
+}
+
+// ----------- Counters -----------
+
+// A counter split by label values, e.g. requests by route and status
+type counterVec struct {
+	name, help string
+	labels     []string
+	mu         sync.Mutex
+	values     map[string]float64 // Joined label values -> count
+}
+
+func newCounterVec(name, help string, labels ...string) *counterVec {
+	return &counterVec{name: name, help: help, labels: labels, values: map[string]float64{}}
+}
+
+// Add one to the counter for these label values
+func (c *counterVec) Inc(labelValues ...string) {
+	key := strings.Join(labelValues, "\xff")
+	c.mu.Lock()
+	c.values[key]++
+	c.mu.Unlock()
+}
+
+func (c *counterVec) writeTo(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	for _, key := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, formatLabels(c.labels, key, "", ""), formatFloat(c.values[key]))
+	}
+}
+
+// ----------- Histograms -----------
+
+// A histogram split by label values, e.g. latency by route
+type histogramVec struct {
+	name, help string
+	labels     []string
+	buckets    []float64
+	mu         sync.Mutex
+	series     map[string]*histogram
+}
+
+// Observations for one set of label values
+type histogram struct {
+	counts []uint64 // One per bucket (not cumulative - we add them up when writing)
+	sum    float64
+	count  uint64
+}
+
+func newHistogramVec(name, help string, buckets []float64, labels ...string) *histogramVec {
+	return &histogramVec{name: name, help: help, labels: labels, buckets: buckets, series: map[string]*histogram{}}
+}
+
+// Record one observation (e.g. a request's duration in seconds)
+func (h *histogramVec) Observe(value float64, labelValues ...string) {
+	key := strings.Join(labelValues, "\xff")
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	for i, upper := range h.buckets {
+		if value <= upper {
+			s.counts[i]++
+			break
+		}
+	}
+	s.sum += value
+	s.count++
+}
+
+func (h *histogramVec) writeTo(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	keys := make([]string, 0, len(h.series))
+	for k := range h.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := h.series[key]
+		var cumulative uint64
+		for i, upper := range h.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, key, "le", formatFloat(upper)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, key, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, formatLabels(h.labels, key, "", ""), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, formatLabels(h.labels, key, "", ""), s.count)
+	}
+}
+
+// ----------- Formatting helpers -----------
+
+func sortedKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Build {a="x",b="y"} from label names and joined values, with an optional extra label (le for buckets)
+func formatLabels(names []string, key, extraName, extraValue string) string {
+	var parts []string
+	if len(names) > 0 {
+		for i, v := range strings.Split(key, "\xff") {
+			if i < len(names) {
+				parts = append(parts, names[i]+"="+quoteLabel(v))
+			}
+		}
+	}
+	if extraName != "" {
+		parts = append(parts, extraName+"="+quoteLabel(extraValue))
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// Quote a label value the way Prometheus expects (only backslashes, quotes and newlines are escaped)
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func quoteLabel(v string) string {
+	return `"` + labelEscaper.Replace(v) + `"`
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// ============================================================================
+// OCR - Reading the text in scanned pages and pictures
+// ============================================================================
+//
+// A scanned handout is a PDF of pictures, with no text layer for the PDF
+// library to read, and a photo of a page is just a picture. Pages like that
+// and uploaded images are put through an OCR engine instead. The engine is
+// pluggable (ocr.engine); the one built in runs the tesseract command, so
+// Tesseract has to be installed. Without an engine uploads work as before,
+// and scans come back without their text.
+//
+// A scanned page is read from the pictures on it (see eachPDFImage), which
+// is what scanners produce. Scans compressed as fax (CCITT) or JBIG2 are
+// ones the PDF library can't decode, so those pages stay blank.
+
+// Limits for OCR
+const (
+	ocrMinText = 20   // Less text than this on a page or picture counts as none (a page number, a photo's stray letters)
+	ocrMinSide = 200  // Pictures smaller than this are too small to hold readable text
+	ocrMaxSide = 7000 // Bigger scans would take too much memory to convert (600 dpi A4 is 4960x7016)
+)
+
+// Anything that can read the text in a picture
+type OCREngine interface {
+	Recognize(ctx context.Context, image []byte) (string, error) // PNG, JPEG or GIF
+	Name() string
+}
+
+// Pick the OCR engine from the config. Tesseract is only used if its
+// command can be found; without it there's no OCR, which isn't worth
+// refusing to start over.
+func newOCREngine(cfg OCRConfig) OCREngine {
+	if cfg.Engine != "tesseract" {
+		return nil
+	}
+	path, err := exec.LookPath(cfg.Command)
+	if err != nil {
+		slog.Warn("OCR is off: tesseract not found", "command", cfg.Command, "error", err)
+		return nil
+	}
+	return &tesseractOCR{path: path, languages: cfg.Languages, slots: make(chan struct{}, cfg.MaxConcurrent)}
+}
+
+// Is a picture this size worth reading, and small enough to be safe to?
+func ocrReadable(width, height int) bool {
+	return width >= ocrMinSide && height >= ocrMinSide && width <= ocrMaxSide && height <= ocrMaxSide
+}
+
+// The text of an uploaded PDF. Pages with (next to) no text are taken to be
+// scans and read with OCR. Also returns the pages whose text came from OCR.
+func (a *App) pdfText(ctx context.Context, path string) (string, []int, error) {
+	pages, err := extractPDFText(path)
+	if err != nil {
+		return "", nil, err
+	}
+	var blank []int
+	for i, text := range pages {
+		if len(strings.TrimSpace(text)) < ocrMinText {
+			blank = append(blank, i+1)
+		}
+	}
+
+	var scanned []int
+	for page, text := range a.ocrPDFPages(ctx, path, blank) {
+		pages[page-1] = text
+		scanned = append(scanned, page)
+	}
+	slices.Sort(scanned)
+	return strings.Join(pages, "\n"), scanned, nil
+}
+
+// Read pages of a PDF with OCR, from the pictures on them, in the time an
+// upload gets for OCR. Returns the text found on each page.
+func (a *App) ocrPDFPages(ctx context.Context, path string, pages []int) map[int]string {
+	texts := map[int]string{}
+	if a.ocr == nil || len(pages) == 0 {
+		return texts
+	}
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(a.config.OCR.Timeout))
+	defer cancel()
+
+	err := eachPDFImage(path, func(page, width, height int) bool {
+		return slices.Contains(pages, page) && ocrReadable(width, height)
+	}, func(page int, data []byte) bool {
+		if text := a.recognize(ctx, data, "pdf"); text != "" {
+			texts[page] = strings.TrimSpace(texts[page] + "\n" + text)
+		}
+		return ctx.Err() == nil
+	})
+	if err != nil {
+		logFor(ctx).Warn("could not read scanned pages", "error", err)
+	}
+	if ctx.Err() != nil {
+		logFor(ctx).Warn("ran out of time reading scanned pages", "timeout", time.Duration(a.config.OCR.Timeout).String(), "pages_read", len(texts))
+	}
+	return texts
+}
+
+// The text in an uploaded picture of the given size (from checkImage), or ""
+// if there's none to be had. Pictures outside the sizes scanned pages are
+// held to aren't read at all.
+func (a *App) imageText(ctx context.Context, image []byte, width, height int) string {
+	if a.ocr == nil {
+		return ""
+	}
+	if !ocrReadable(width, height) {
+		logFor(ctx).Info("picture not read with OCR: wrong size", "width", width, "height", height)
+		return ""
+	}
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(a.config.OCR.Timeout))
+	defer cancel()
+	return a.recognize(ctx, image, "upload")
+}
+
+// Read one picture with the OCR engine. Returns "" when there's no engine,
+// it fails, or it finds too little text to be worth having.
+func (a *App) recognize(ctx context.Context, image []byte, source string) string {
+	if a.ocr == nil {
+		return ""
+	}
+	text, err := a.ocr.Recognize(ctx, image)
+	if err != nil {
+		a.metrics.ocrImages.Inc(source, "failed")
+		logFor(ctx).Warn("OCR failed", "engine", a.ocr.Name(), "source", source, "error", err)
+		return ""
+	}
+	text = cleanOCRText(text)
+	if len(text) < ocrMinText {
+		a.metrics.ocrImages.Inc(source, "empty")
+		return ""
+	}
+	a.metrics.ocrImages.Inc(source, "text")
+	return text
+}
+
+var ocrBlankLines = regexp.MustCompile(`\n{3,}`)
+
+// Tidy OCR output: page breaks become line breaks, trailing spaces go, and
+// there's never more than one blank line in a row
+func cleanOCRText(s string) string {
+	lines := strings.Split(strings.ReplaceAll(s, "\f", "\n"), "\n")
+	for i := range lines {
+		lines[i] = strings.TrimRightFunc(lines[i], unicode.IsSpace)
+	}
+	return strings.TrimSpace(ocrBlankLines.ReplaceAllString(strings.Join(lines, "\n"), "\n\n"))
+}
+
+// ----------- Tesseract -----------
+
+// Runs the tesseract command for each picture
+type tesseractOCR struct {
+	path      string
+	languages string        // As tesseract's -l takes them: "eng", "eng+deu"
+	slots     chan struct{} // One per tesseract allowed to run at once (ocr.max_concurrent)
+}
+
+func (t *tesseractOCR) Name() string { return "tesseract" }
+
+// The picture goes in on stdin and the text comes out on stdout, so nothing
+// is written to disk
+func (t *tesseractOCR) Recognize(ctx context.Context, image []byte) (string, error) {
+	// Wait for a turn, or until the upload runs out of time
+	select {
+	case t.slots <- struct{}{}:
+		defer func() { <-t.slots }()
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+
+	args := []string{"stdin", "stdout"}
+	if t.languages != "" {
+		args = append(args, "-l", t.languages)
+	}
+	cmd := exec.CommandContext(ctx, t.path, args...)
+	cmd.Stdin = bytes.NewReader(image)
+	// One thread per picture - several uploads at once would otherwise each
+	// try to take every core
+	cmd.Env = append(os.Environ(), "OMP_THREAD_LIMIT=1")
+	cmd.WaitDelay = time.Second // Don't wait on a killed tesseract's output for long
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		return "", fmt.Errorf("tesseract: %w: %s", err, truncate(strings.TrimSpace(stderr.String()), 200))
+	}
+	return stdout.String(), nil
+}
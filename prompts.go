@@ -0,0 +1,330 @@
+package main
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+// ============================================================================
+// PROMPT TEMPLATES - Named, versioned prompts with per-type and per-language variants
+// ============================================================================
+//
+// Templates live at <name>/v<version>/<variant>.tmpl. The variant is the quiz
+// type ("true-false", "short-answer"...) or "default", optionally followed by
+// a language code ("default.es", "true-false.fr"). Each file defines a
+// "system" and a "user" block using Go's text/template syntax, and can
+// define a "format" block saying how the questions it asks for are written
+// ("markdown", see markup.go; plain text without one).
+//
+// The defaults are built into the binary. Files in prompts.dir override them
+// (or add new versions), and templates saved in the database override both.
+// The newest version of a prompt is used unless prompts.versions pins one.
+
+//go:embed prompts
+var embeddedPrompts embed.FS
+
+// Where a template came from, lowest priority first
+const (
+	promptSourceEmbedded = "embedded"
+	promptSourceDisk     = "disk"
+	promptSourceDatabase = "database"
+)
+
+var promptPriority = map[string]int{promptSourceEmbedded: 0, promptSourceDisk: 1, promptSourceDatabase: 2}
+
+// Template paths look like quiz/v2/true-false.es.tmpl
+var promptPath = regexp.MustCompile(`^([a-z0-9-]+)/v([0-9]+)/([a-z0-9.-]+)\.tmpl$`)
+
+// One version of one variant of a prompt
+type PromptTemplate struct {
+	Name    string `json:"name"`
+	Version int    `json:"version"`
+	Variant string `json:"variant"`
+	Source  string `json:"source"`
+	Body    string `json:"body,omitempty"`
+
+	tmpl *template.Template
+}
+
+// Short reference recorded against each quiz, e.g. "quiz/v1/true-false"
+func (p *PromptTemplate) Ref() string {
+	return fmt.Sprintf("%s/v%d/%s", p.Name, p.Version, p.Variant)
+}
+
+// Values a prompt template can use
+type PromptData struct {
+	Topic         string
+	Difficulty    string
+	QuestionCount int
+	QuizType      string
+	Language      string // Language code, e.g. "en"
+	LanguageName  string // The language's English name, e.g. "Spanish" (empty when none was asked for)
+	HasSource     bool   // An uploaded document is attached in a separate message
+
+	Questions []QuizQuestion // For the verify prompt (answers taken out) and the translate prompt (answers left in)
+}
+
+// A prompt ready to send, plus which template produced it
+type RenderedPrompt struct {
+	System string `json:"system"`
+	User   string `json:"user"`
+	Format string `json:"format,omitempty"` // How the questions come back written ("markdown" or plain)
+	Ref    string `json:"ref"`
+	Source string `json:"source"`
+}
+
+// All known prompt templates
+type PromptLibrary struct {
+	pinned map[string]int // Prompt name -> version to use instead of the newest
+
+	mu        sync.RWMutex
+	templates map[string]*PromptTemplate // Keyed by Ref()
+}
+
+// Load the built-in templates, then any from dir, then any from the database
+func LoadPromptLibrary(ctx context.Context, dir string, pinned map[string]int, store PromptStore) (*PromptLibrary, error) {
+	lib := &PromptLibrary{pinned: pinned, templates: map[string]*PromptTemplate{}}
+
+	sub, err := fs.Sub(embeddedPrompts, "prompts")
+	if err != nil {
+		return nil, err
+	}
+	if err := lib.loadFS(sub, promptSourceEmbedded); err != nil {
+		return nil, err
+	}
+	if dir != "" {
+		if err := lib.loadFS(os.DirFS(dir), promptSourceDisk); err != nil {
+			return nil, fmt.Errorf("prompts dir %s: %w", dir, err)
+		}
+	}
+
+	saved, err := store.ListPrompts(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("load prompts from database: %w", err)
+	}
+	for _, p := range saved {
+		p.Source = promptSourceDatabase
+		if err := lib.Add(p); err != nil {
+			return nil, err
+		}
+	}
+
+	for name, version := range pinned {
+		if !lib.hasVersion(name, version) {
+			return nil, fmt.Errorf("prompts.versions pins %s to v%d, which doesn't exist", name, version)
+		}
+	}
+	return lib, nil
+}
+
+// Read every template file in a directory tree
+func (l *PromptLibrary) loadFS(fsys fs.FS, source string) error {
+	return fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || path.Ext(p) != ".tmpl" {
+			return err
+		}
+		m := promptPath.FindStringSubmatch(p)
+		if m == nil {
+			return fmt.Errorf("%s: expected <name>/v<version>/<variant>.tmpl", p)
+		}
+		body, err := fs.ReadFile(fsys, p)
+		if err != nil {
+			return err
+		}
+		version, _ := strconv.Atoi(m[2])
+		return l.Add(PromptTemplate{Name: m[1], Version: version, Variant: m[3], Source: source, Body: string(body)})
+	})
+}
+
+// Parse a template and add it, replacing one from a lower priority source
+func (l *PromptLibrary) Add(p PromptTemplate) error {
+	tmpl, err := parsePrompt(p)
+	if err != nil {
+		return err
+	}
+	p.tmpl = tmpl
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if old, ok := l.templates[p.Ref()]; ok && promptPriority[old.Source] > promptPriority[p.Source] {
+		return nil
+	}
+	l.templates[p.Ref()] = &p
+	return nil
+}
+
+// Check a template's name and parse its body, making sure it has both blocks we need
+func parsePrompt(p PromptTemplate) (*template.Template, error) {
+	if !promptPath.MatchString(p.Ref()+".tmpl") || p.Version < 1 {
+		return nil, fmt.Errorf("invalid prompt name %q, version %d or variant %q", p.Name, p.Version, p.Variant)
+	}
+	tmpl, err := template.New(p.Ref()).Option("missingkey=error").Parse(p.Body)
+	if err != nil {
+		return nil, fmt.Errorf("prompt %s: %w", p.Ref(), err)
+	}
+	for _, block := range []string{"system", "user"} {
+		if tmpl.Lookup(block) == nil {
+			return nil, fmt.Errorf("prompt %s: missing {{define %q}} block", p.Ref(), block)
+		}
+	}
+	return tmpl, nil
+}
+
+// Render a prompt for a quiz type and language, picking the closest variant:
+// type+language, type, default+language, then default.
+func (l *PromptLibrary) Render(name string, data PromptData) (*RenderedPrompt, error) {
+	return l.RenderVersion(name, 0, data)
+}
+
+// Like Render, but for a specific version (0 means the one normally used)
+func (l *PromptLibrary) RenderVersion(name string, version int, data PromptData) (*RenderedPrompt, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if version == 0 {
+		version = l.activeVersion(name)
+	}
+	kind := promptVariant(data.QuizType)
+	candidates := []string{kind, "default"}
+	if lang := strings.ToLower(data.Language); lang != "" {
+		candidates = []string{kind + "." + lang, kind, "default." + lang, "default"}
+	}
+
+	for _, variant := range candidates {
+		if p, ok := l.templates[fmt.Sprintf("%s/v%d/%s", name, version, variant)]; ok {
+			return p.render(data)
+		}
+	}
+	return nil, fmt.Errorf("no %s/v%d prompt for %q (is there a default variant?)", name, version, kind)
+}
+
+// Run a parsed template's system, user and format blocks
+func (p *PromptTemplate) render(data PromptData) (*RenderedPrompt, error) {
+	var system, user strings.Builder
+	if err := p.tmpl.ExecuteTemplate(&system, "system", data); err != nil {
+		return nil, fmt.Errorf("render %s: %w", p.Ref(), err)
+	}
+	if err := p.tmpl.ExecuteTemplate(&user, "user", data); err != nil {
+		return nil, fmt.Errorf("render %s: %w", p.Ref(), err)
+	}
+	var format strings.Builder
+	if p.tmpl.Lookup("format") != nil {
+		if err := p.tmpl.ExecuteTemplate(&format, "format", data); err != nil {
+			return nil, fmt.Errorf("render %s: %w", p.Ref(), err)
+		}
+	}
+	if f := strings.TrimSpace(format.String()); f != "" && f != formatMarkdown {
+		return nil, fmt.Errorf("render %s: format must be %q or empty, not %q", p.Ref(), formatMarkdown, f)
+	}
+	return &RenderedPrompt{
+		System: strings.TrimSpace(system.String()),
+		User:   strings.TrimSpace(user.String()),
+		Format: strings.TrimSpace(format.String()),
+		Ref:    p.Ref(),
+		Source: p.Source,
+	}, nil
+}
+
+// Made-up values for previewing a prompt, and for trying one out before
+// it's saved
+func samplePromptData() PromptData {
+	return PromptData{
+		Topic:         "Photosynthesis",
+		Difficulty:    "Medium",
+		QuestionCount: 5,
+		QuizType:      "Multiple Choice",
+		Questions: []QuizQuestion{{
+			Question:      "Which gas do plants take in for photosynthesis?",
+			Options:       []string{"Oxygen", "Carbon dioxide", "Nitrogen", "Hydrogen"},
+			CorrectAnswer: "Carbon dioxide",
+			Explanation:   "Plants take in carbon dioxide and give out oxygen.",
+		}},
+	}
+}
+
+// Parse a template and render it with sample values, with and without a
+// language and a document. A body can parse and still fail when it's run
+// (a field that doesn't exist, say), and that's better found out before
+// every quiz it's used for fails.
+func checkPrompt(p PromptTemplate) error {
+	tmpl, err := parsePrompt(p)
+	if err != nil {
+		return err
+	}
+	p.tmpl = tmpl
+	for _, extras := range []bool{false, true} {
+		data := samplePromptData()
+		if extras {
+			data.Language, data.LanguageName, data.HasSource = "es", languageName("es"), true
+		}
+		if _, err := p.render(data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Every template, sorted by name, version and variant (bodies left out)
+func (l *PromptLibrary) List() []PromptTemplate {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	list := make([]PromptTemplate, 0, len(l.templates))
+	for _, p := range l.templates {
+		list = append(list, PromptTemplate{Name: p.Name, Version: p.Version, Variant: p.Variant, Source: p.Source})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Name != list[j].Name {
+			return list[i].Name < list[j].Name
+		}
+		if list[i].Version != list[j].Version {
+			return list[i].Version < list[j].Version
+		}
+		return list[i].Variant < list[j].Variant
+	})
+	return list
+}
+
+// The pinned version of a prompt, or else the newest one with a default
+// variant - a version with only a true-false template, say, can't render
+// the other quiz types, so it isn't used until its default is added
+// (caller holds the lock)
+func (l *PromptLibrary) activeVersion(name string) int {
+	if v, ok := l.pinned[name]; ok {
+		return v
+	}
+	newest := 0
+	for _, p := range l.templates {
+		if p.Name == name && p.Variant == "default" && p.Version > newest {
+			newest = p.Version
+		}
+	}
+	return newest
+}
+
+func (l *PromptLibrary) hasVersion(name string, version int) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	_, ok := l.templates[fmt.Sprintf("%s/v%d/default", name, version)]
+	return ok
+}
+
+// Turn a quiz type into a variant name: "True/False" -> "true-false"
+var nonVariantChars = regexp.MustCompile(`[^a-z0-9]+`)
+
+func promptVariant(quizType string) string {
+	v := strings.Trim(nonVariantChars.ReplaceAllString(strings.ToLower(quizType), "-"), "-")
+	if v == "" {
+		return "default"
+	}
+	return v
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"io/fs"
+	"testing"
+)
+
+// The built-in prompts, as LoadPromptLibrary reads them before the database
+func builtInPrompts(t *testing.T) *PromptLibrary {
+	t.Helper()
+	lib := &PromptLibrary{templates: map[string]*PromptTemplate{}}
+	sub, err := fs.Sub(embeddedPrompts, "prompts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := lib.loadFS(sub, promptSourceEmbedded); err != nil {
+		t.Fatal(err)
+	}
+	return lib
+}
+
+// A new version with only a type variant mustn't become the one every quiz
+// type is rendered from - it has nothing for the others to fall back to
+func TestVariantOnlyVersionIsNotActive(t *testing.T) {
+	lib := builtInPrompts(t)
+	current, err := lib.Render("quiz", PromptData{Topic: "Rivers", QuestionCount: 3, QuizType: "Multiple Choice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, err := fs.ReadFile(embeddedPrompts, "prompts/quiz/v2/true-false.tmpl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := lib.Add(PromptTemplate{Name: "quiz", Version: 99, Variant: "true-false", Source: promptSourceDatabase, Body: string(body)}); err != nil {
+		t.Fatal(err)
+	}
+	for _, quizType := range []string{"Multiple Choice", "True/False", "Short Answer"} {
+		p, err := lib.Render("quiz", PromptData{Topic: "Rivers", QuestionCount: 3, QuizType: quizType})
+		if err != nil {
+			t.Fatalf("%s: %v", quizType, err)
+		}
+		if p.Ref == "quiz/v99/true-false" {
+			t.Errorf("%s: rendered from a version without a default", quizType)
+		}
+	}
+
+	// Once the default is there, the new version takes over
+	body, err = fs.ReadFile(embeddedPrompts, "prompts/quiz/v2/default.tmpl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := lib.Add(PromptTemplate{Name: "quiz", Version: 99, Variant: "default", Source: promptSourceDatabase, Body: string(body)}); err != nil {
+		t.Fatal(err)
+	}
+	p, err := lib.Render("quiz", PromptData{Topic: "Rivers", QuestionCount: 3, QuizType: "True/False"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Ref != "quiz/v99/true-false" {
+		t.Errorf("rendered %s after v99 got a default, was %s before", p.Ref, current.Ref)
+	}
+}
+
+// A template that parses but fails when it's run mustn't be saved
+func TestCheckPromptRenders(t *testing.T) {
+	body, err := fs.ReadFile(embeddedPrompts, "prompts/quiz/v2/default.tmpl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := checkPrompt(PromptTemplate{Name: "quiz", Version: 99, Variant: "default", Body: string(body)}); err != nil {
+		t.Errorf("built-in prompt: %v", err)
+	}
+
+	broken := `{{define "system"}}{{.Nope}}{{end}}{{define "user"}}Questions about {{.Topic}}{{end}}`
+	if err := checkPrompt(PromptTemplate{Name: "quiz", Version: 99, Variant: "default", Body: broken}); err == nil {
+		t.Error("a prompt using a field that doesn't exist was accepted")
+	}
+}
@@ -0,0 +1,394 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ============================================================================
+// QUESTION TYPES - Beyond picking one option
+// ============================================================================
+//
+// Besides multiple choice, true/false and short answer, a question can be:
+//
+//   multi_select  pick every right option       options + correctAnswers
+//   ordering      put items in the right order  correctAnswers in order (options shuffled)
+//   matching      pair each prompt with a match pairs (options are the right-hand sides, shuffled)
+//   cloze         fill in the blanks ("___")    correctAnswers, one per blank
+//   numeric       give a number                 correctAnswer + tolerance (+ unit)
+//
+// Questions saved before there were types have no "type" and are told apart
+// by their options, as they always were. For the new types correctAnswer is
+// still filled in, with a readable summary, so anything that only shows the
+// answer (live reveals, flags, older pages) keeps working.
+//
+// Answers to multi-select, ordering, matching and cloze questions are lists.
+// The API takes them as JSON arrays; inside the server, and in what's stored
+// for an attempt, an answer is always a string - lists as their JSON.
+
+// Question types
+const (
+	typeMultipleChoice = "multiple_choice"
+	typeTrueFalse      = "true_false"
+	typeShortAnswer    = "short_answer"
+	typeMultiSelect    = "multi_select"
+	typeOrdering       = "ordering"
+	typeMatching       = "matching"
+	typeCloze          = "cloze"
+	typeNumeric        = "numeric"
+)
+
+// Every question type with the name quizzes, the bank and the page use for it
+var questionKinds = []struct{ ID, Name string }{
+	{typeMultipleChoice, "Multiple Choice"},
+	{typeTrueFalse, "True/False"},
+	{typeShortAnswer, "Short Answer"},
+	{typeMultiSelect, "Multi-Select"},
+	{typeOrdering, "Ordering"},
+	{typeMatching, "Matching"},
+	{typeCloze, "Fill in the Blank"},
+	{typeNumeric, "Numeric"},
+}
+
+// One prompt and its match in a matching question
+type MatchPair struct {
+	Left  string `json:"left"`
+	Right string `json:"right"`
+}
+
+// Limits on the lists in a question, so the page stays usable
+const (
+	maxQuestionItems = 10 // Options, items to order or pairs to match
+	maxClozeBlanks   = 5
+)
+
+// Blanks in a cloze question: three or more underscores
+var clozeBlank = regexp.MustCompile(`_{3,}`)
+
+// A question's type: its own, or for older questions the one its options imply
+func kindOf(q QuizQuestion) string {
+	if q.Type != "" {
+		return q.Type
+	}
+	switch {
+	case len(q.Options) == 0:
+		return typeShortAnswer
+	case len(q.Options) == 2 && strings.EqualFold(q.Options[0], "True") && strings.EqualFold(q.Options[1], "False"):
+		return typeTrueFalse
+	default:
+		return typeMultipleChoice
+	}
+}
+
+// The name of a question type ("Multi-Select"), or the ID if it isn't one
+func kindName(kind string) string {
+	for _, k := range questionKinds {
+		if k.ID == kind {
+			return k.Name
+		}
+	}
+	return kind
+}
+
+// The question types a quiz type asks for. "Mixed" is the original three;
+// anything unknown is treated as multiple choice, as it always has been.
+func kindsFor(quizType string) []string {
+	if strings.EqualFold(quizType, "Mixed") {
+		return []string{typeMultipleChoice, typeTrueFalse, typeShortAnswer}
+	}
+	for _, k := range questionKinds {
+		if strings.EqualFold(k.Name, quizType) || k.ID == quizType {
+			return []string{k.ID}
+		}
+	}
+	return []string{typeMultipleChoice}
+}
+
+// Types whose answers are lists
+func listKind(kind string) bool {
+	return kind == typeMultiSelect || kind == typeOrdering || kind == typeMatching || kind == typeCloze
+}
+
+// Types the original three (and the page before them) already knew
+func legacyKind(kind string) bool {
+	return kind == typeMultipleChoice || kind == typeTrueFalse || kind == typeShortAnswer
+}
+
+// ----------- Preparing and checking questions -----------
+
+// Fill in what the AI isn't asked for: the type (from the quiz type, for the
+// new types), shuffled options for ordering and matching questions, and the
+// readable correctAnswer summary. Markdown questions are cleaned first.
+func prepareQuestions(questions []QuizQuestion, quizType string) {
+	kinds := kindsFor(quizType)
+	for i := range questions {
+		q := &questions[i]
+		sanitizeQuestion(q)
+		if q.Type == "" && len(kinds) == 1 && !legacyKind(kinds[0]) {
+			q.Type = kinds[0]
+		}
+		switch q.Type {
+		case typeMultiSelect:
+			q.CorrectAnswer = strings.Join(q.CorrectAnswers, "; ")
+		case typeOrdering:
+			if len(q.Options) != len(q.CorrectAnswers) {
+				q.Options = shuffled(q.CorrectAnswers)
+			} else if slices.Equal(q.Options, q.CorrectAnswers) && len(q.Options) > 1 {
+				q.Options = shuffled(q.Options)
+			}
+			q.CorrectAnswer = strings.Join(q.CorrectAnswers, " → ")
+		case typeMatching:
+			rights := make([]string, len(q.Pairs))
+			parts := make([]string, len(q.Pairs))
+			for j, p := range q.Pairs {
+				rights[j] = p.Right
+				parts[j] = p.Left + " → " + p.Right
+			}
+			if len(q.Options) != len(rights) {
+				q.Options = shuffled(rights)
+			} else if slices.Equal(q.Options, rights) && len(q.Options) > 1 {
+				q.Options = shuffled(q.Options)
+			}
+			q.CorrectAnswer = strings.Join(parts, "; ")
+		case typeCloze:
+			q.Options = []string{}
+			q.CorrectAnswer = strings.Join(q.CorrectAnswers, "; ")
+		case typeNumeric:
+			q.Options = []string{}
+			q.CorrectAnswer = strings.TrimSpace(q.CorrectAnswer)
+			q.Tolerance = math.Abs(q.Tolerance)
+		}
+	}
+}
+
+// A copy of the items in a different order (when there's more than one)
+func shuffled(items []string) []string {
+	out := slices.Clone(items)
+	for tries := 0; tries < 5; tries++ {
+		rand.Shuffle(len(out), func(i, j int) { out[i], out[j] = out[j], out[i] })
+		if !slices.Equal(out, items) {
+			break
+		}
+	}
+	return out
+}
+
+// Can the question be shown and marked? (validateQuestions runs this on each)
+func validateQuestion(q QuizQuestion) error {
+	if strings.TrimSpace(q.Question) == "" {
+		return errors.New("has no text")
+	}
+	if q.Format != "" && q.Format != formatMarkdown {
+		return fmt.Errorf("has format %q, which should be %q or left out", q.Format, formatMarkdown)
+	}
+	switch kind := kindOf(q); kind {
+	case typeMultipleChoice, typeTrueFalse, typeShortAnswer:
+		if strings.TrimSpace(q.CorrectAnswer) == "" {
+			return errors.New("has no correct answer")
+		}
+		if len(q.Options) > 0 && !slices.Contains(q.Options, q.CorrectAnswer) {
+			return fmt.Errorf("has correct answer %q, which is not one of the options", q.CorrectAnswer)
+		}
+	case typeMultiSelect:
+		if len(q.Options) < 2 || len(q.Options) > maxQuestionItems {
+			return fmt.Errorf("needs 2 to %d options", maxQuestionItems)
+		}
+		if len(q.CorrectAnswers) == 0 {
+			return errors.New("has no correct answers")
+		}
+		for _, a := range q.CorrectAnswers {
+			if !slices.Contains(q.Options, a) {
+				return fmt.Errorf("has correct answer %q, which is not one of the options", a)
+			}
+		}
+		if hasRepeats(q.Options) || hasRepeats(q.CorrectAnswers) {
+			return errors.New("repeats an option")
+		}
+	case typeOrdering:
+		if len(q.CorrectAnswers) < 2 || len(q.CorrectAnswers) > maxQuestionItems {
+			return fmt.Errorf("needs 2 to %d items to order", maxQuestionItems)
+		}
+		if hasRepeats(q.CorrectAnswers) || hasBlank(q.CorrectAnswers) {
+			return errors.New("has a blank or repeated item")
+		}
+		sorted, items := slices.Sorted(slices.Values(q.Options)), slices.Sorted(slices.Values(q.CorrectAnswers))
+		if !slices.Equal(sorted, items) {
+			return errors.New("has options that aren't the items to order")
+		}
+	case typeMatching:
+		if len(q.Pairs) < 2 || len(q.Pairs) > maxQuestionItems {
+			return fmt.Errorf("needs 2 to %d pairs", maxQuestionItems)
+		}
+		lefts, rights := make([]string, len(q.Pairs)), make([]string, len(q.Pairs))
+		for i, p := range q.Pairs {
+			lefts[i], rights[i] = p.Left, p.Right
+		}
+		if hasRepeats(lefts) || hasRepeats(rights) || hasBlank(lefts) || hasBlank(rights) {
+			return errors.New("has a blank or repeated pair")
+		}
+		if !slices.Equal(slices.Sorted(slices.Values(q.Options)), slices.Sorted(slices.Values(rights))) {
+			return errors.New("has options that aren't the matches")
+		}
+	case typeCloze:
+		blanks := len(clozeBlank.FindAllString(q.Question, -1))
+		if blanks == 0 || blanks > maxClozeBlanks {
+			return fmt.Errorf("needs 1 to %d blanks (___)", maxClozeBlanks)
+		}
+		if len(q.CorrectAnswers) != blanks || hasBlank(q.CorrectAnswers) {
+			return fmt.Errorf("has %d blanks but %d answers", blanks, len(q.CorrectAnswers))
+		}
+	case typeNumeric:
+		if _, ok := parseNumber(q.CorrectAnswer); !ok {
+			return fmt.Errorf("has correct answer %q, which is not a number", q.CorrectAnswer)
+		}
+	default:
+		return fmt.Errorf("has unknown type %q", kind)
+	}
+	return nil
+}
+
+func hasRepeats(items []string) bool {
+	seen := map[string]bool{}
+	for _, s := range items {
+		key := strings.ToLower(strings.TrimSpace(s))
+		if seen[key] {
+			return true
+		}
+		seen[key] = true
+	}
+	return false
+}
+
+func hasBlank(items []string) bool {
+	return slices.ContainsFunc(items, func(s string) bool { return strings.TrimSpace(s) == "" })
+}
+
+// ----------- Marking answers -----------
+
+// Does this answer match the question's correct one? Options must match
+// exactly; typed answers (short answer, blanks) are compared ignoring case
+// and spacing; numbers can be off by the question's tolerance.
+func gradeAnswer(q QuizQuestion, answer string) bool {
+	switch kindOf(q) {
+	case typeMultipleChoice, typeTrueFalse:
+		return answer == q.CorrectAnswer
+	case typeShortAnswer:
+		return sameText(answer, q.CorrectAnswer)
+	case typeMultiSelect:
+		got := answerList(answer)
+		return len(got) == len(q.CorrectAnswers) && !hasRepeats(got) &&
+			!slices.ContainsFunc(got, func(a string) bool { return !slices.Contains(q.CorrectAnswers, a) })
+	case typeOrdering:
+		return slices.Equal(answerList(answer), q.CorrectAnswers)
+	case typeMatching:
+		got := answerList(answer)
+		if len(got) != len(q.Pairs) {
+			return false
+		}
+		for i, p := range q.Pairs {
+			if got[i] != p.Right {
+				return false
+			}
+		}
+		return true
+	case typeCloze:
+		got := answerList(answer)
+		if len(got) != len(q.CorrectAnswers) {
+			return false
+		}
+		for i, want := range q.CorrectAnswers {
+			if !sameText(got[i], want) {
+				return false
+			}
+		}
+		return true
+	case typeNumeric:
+		got, ok := parseNumber(answer)
+		want, _ := parseNumber(q.CorrectAnswer)
+		// A hair of slack so 0.1+0.2 style rounding never marks a right answer wrong
+		return ok && math.Abs(got-want) <= q.Tolerance+1e-9*math.Max(1, math.Abs(want))
+	}
+	return false
+}
+
+// Typed answers match ignoring case and spacing
+func sameText(got, want string) bool {
+	normalize := func(s string) string {
+		return strings.Join(strings.Fields(strings.ToLower(s)), " ")
+	}
+	return normalize(got) != "" && normalize(got) == normalize(want)
+}
+
+// Read a number the way people type one: "1,000", "3,5" (a decimal comma),
+// "9.81 m/s" (anything after the number is ignored)
+var (
+	leadingNumber  = regexp.MustCompile(`^[-+]?(\d[\d,]*\.?\d*|\.\d+)([eE][-+]?\d+)?`)
+	thousandsComma = regexp.MustCompile(`,\d{3}(\D|$)`) // "1,000" rather than "3,5"
+)
+
+func parseNumber(s string) (float64, bool) {
+	s = strings.ReplaceAll(strings.TrimSpace(s), " ", "")
+	if strings.Count(s, ",") == 1 && !strings.Contains(s, ".") && !thousandsComma.MatchString(s) {
+		s = strings.Replace(s, ",", ".", 1) // Decimal comma
+	}
+	m := leadingNumber.FindString(s)
+	if m == "" {
+		return 0, false
+	}
+	f, err := strconv.ParseFloat(strings.ReplaceAll(m, ",", ""), 64)
+	return f, err == nil && !math.IsInf(f, 0) && !math.IsNaN(f)
+}
+
+// ----------- Answers on the wire -----------
+
+// An answer as a client sends it: a string, a list of strings, or a number.
+// Held as the string stored for the attempt - lists as their JSON.
+type AnswerText string
+
+func (a *AnswerText) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*a = AnswerText(s)
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(b, &list); err == nil {
+		*a = AnswerText(encodeAnswerList(list))
+		return nil
+	}
+	var n json.Number
+	if err := json.Unmarshal(b, &n); err == nil {
+		*a = AnswerText(n.String())
+		return nil
+	}
+	return errors.New("answer must be a string or a list of strings")
+}
+
+// A list answer as a string
+func encodeAnswerList(list []string) string {
+	if list == nil {
+		list = []string{}
+	}
+	b, _ := json.Marshal(list)
+	return string(b)
+}
+
+// A list answer back from its string. A plain string counts as a list of one,
+// so a single pick of a multi-select question still reads.
+func answerList(answer string) []string {
+	var list []string
+	if strings.HasPrefix(strings.TrimSpace(answer), "[") && json.Unmarshal([]byte(answer), &list) == nil {
+		return list
+	}
+	if answer == "" {
+		return nil
+	}
+	return []string{answer}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// ============================================================================
+// QUIZ FORMAT - The JSON schema we ask the AI for, and reading its answer
+// ============================================================================
+
+// One generated question, as the frontend expects it
+type QuizQuestion struct {
+	Type          string   `json:"type,omitempty"`   // Left out for the original three types (see question_types.go)
+	Format        string   `json:"format,omitempty"` // "markdown" when the text has Markdown, math and code in it (see markup.go), else plain
+	Question      string   `json:"question"`
+	Options       []string `json:"options"` // Empty for short answer questions
+	CorrectAnswer string   `json:"correctAnswer"`
+	Explanation   string   `json:"explanation"`
+
+	CorrectAnswers []string    `json:"correctAnswers,omitempty"` // Multi-select, ordering (in order) and cloze (one per blank)
+	Pairs          []MatchPair `json:"pairs,omitempty"`          // Matching
+	Tolerance      float64     `json:"tolerance,omitempty"`      // Numeric: how far off an answer can be
+	Unit           string      `json:"unit,omitempty"`           // Numeric: shown next to the answer box
+
+	ImageID  int `json:"imageId,omitempty"` // The user's image the question is about (see media.go)
+	ImageRef int `json:"image,omitempty"`   // Which of the request's images the AI said, from 1 (turned into ImageID, never saved)
+
+	Duplicate     *DuplicateInfo     `json:"duplicate,omitempty"`     // Set when it repeats an earlier question (never asked of the AI)
+	LowConfidence *LowConfidenceInfo `json:"lowConfidence,omitempty"` // Set when a second check didn't agree with the answer (never asked of the AI)
+}
+
+// What the AI is asked to return. Strict JSON schema mode needs an object at
+// the top, so the questions are wrapped; the frontend still gets a plain array.
+type quizEnvelope struct {
+	Questions []QuizQuestion `json:"questions"`
+}
+
+// Build the JSON schema for a quiz of the given type ("Multiple Choice",
+// "True/False", "Short Answer", "Mixed" or one of the newer types). Strict
+// mode wants every property listed as required and no extras allowed.
+func quizSchema(quizType string) map[string]interface{} {
+	if kinds := kindsFor(quizType); !legacyKind(kinds[0]) {
+		return kindsSchema(kinds)
+	}
+
+	option := map[string]interface{}{"type": "string"}
+	answer := map[string]interface{}{"type": "string"}
+	optionsHelp := "The answer choices, one of which is exactly the correct answer"
+
+	switch strings.ToLower(quizType) {
+	case "true/false":
+		option = map[string]interface{}{"type": "string", "enum": []string{"True", "False"}}
+		answer = map[string]interface{}{"type": "string", "enum": []string{"True", "False"}}
+		optionsHelp = `Always ["True", "False"]`
+	case "short answer":
+		optionsHelp = "Always an empty array - the user types their answer"
+	case "mixed":
+		optionsHelp = "Answer choices for multiple choice or true/false questions, empty for short answer"
+	}
+
+	question := map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"question":      map[string]interface{}{"type": "string"},
+			"options":       map[string]interface{}{"type": "array", "items": option, "description": optionsHelp},
+			"correctAnswer": answer,
+			"explanation":   map[string]interface{}{"type": "string", "description": "Brief explanation why this is correct"},
+		},
+		"required":             []string{"question", "options", "correctAnswer", "explanation"},
+		"additionalProperties": false,
+	}
+	return questionsSchema(question)
+}
+
+// What each field means for each of the newer question types
+var kindFieldHelp = map[string]map[string]string{
+	typeMultiSelect: {
+		"options":        "All the choices, right and wrong",
+		"correctAnswers": "Every right choice, each exactly the text of one of the options",
+	},
+	typeOrdering: {
+		"correctAnswers": "The items in the correct order",
+	},
+	typeMatching: {
+		"pairs": "Each item with its match",
+	},
+	typeCloze: {
+		"correctAnswers": "The word or phrase for each blank (___) in the question, in order",
+	},
+	typeNumeric: {
+		"correctAnswer": "The answer as a plain number, with no units",
+		"tolerance":     "How far off an answer can be and still be right, 0 for exactly",
+		"unit":          "The unit of the answer, empty if it has none",
+	},
+}
+
+// The schema for questions of the given types - only the fields they use.
+// Used for the newer types, and for translating quizzes that mix them.
+func kindsSchema(kinds []string) map[string]interface{} {
+	uses := func(with ...string) bool {
+		return slices.ContainsFunc(kinds, func(k string) bool { return slices.Contains(with, k) })
+	}
+	help := func(field string) map[string]interface{} {
+		prop := map[string]interface{}{}
+		if len(kinds) == 1 && kindFieldHelp[kinds[0]][field] != "" {
+			prop["description"] = kindFieldHelp[kinds[0]][field]
+		}
+		return prop
+	}
+	stringList := map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}}
+
+	properties := map[string]interface{}{"question": map[string]interface{}{"type": "string"}}
+	if uses(typeMultipleChoice, typeTrueFalse, typeShortAnswer, typeMultiSelect) {
+		properties["options"] = merge(help("options"), stringList)
+	}
+	if uses(typeMultipleChoice, typeTrueFalse, typeShortAnswer, typeNumeric) {
+		properties["correctAnswer"] = merge(help("correctAnswer"), map[string]interface{}{"type": "string"})
+	}
+	if uses(typeMultiSelect, typeOrdering, typeCloze) {
+		properties["correctAnswers"] = merge(help("correctAnswers"), stringList)
+	}
+	if uses(typeMatching) {
+		pair := map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"left":  map[string]interface{}{"type": "string"},
+				"right": map[string]interface{}{"type": "string"},
+			},
+			"required":             []string{"left", "right"},
+			"additionalProperties": false,
+		}
+		properties["pairs"] = merge(help("pairs"), map[string]interface{}{"type": "array", "items": pair})
+	}
+	if uses(typeNumeric) {
+		properties["tolerance"] = merge(help("tolerance"), map[string]interface{}{"type": "number"})
+		properties["unit"] = merge(help("unit"), map[string]interface{}{"type": "string"})
+	}
+	properties["explanation"] = map[string]interface{}{"type": "string", "description": "Brief explanation why this is correct"}
+
+	required := make([]string, 0, len(properties))
+	for _, name := range []string{"question", "options", "correctAnswer", "correctAnswers", "pairs", "tolerance", "unit", "explanation"} {
+		if properties[name] != nil {
+			required = append(required, name)
+		}
+	}
+	return questionsSchema(map[string]interface{}{
+		"type":                 "object",
+		"properties":           properties,
+		"required":             required,
+		"additionalProperties": false,
+	})
+}
+
+func merge(a, b map[string]interface{}) map[string]interface{} {
+	for k, v := range b {
+		a[k] = v
+	}
+	return a
+}
+
+// Wrap a question's schema in the {"questions": [...]} envelope
+func questionsSchema(question map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"questions": map[string]interface{}{"type": "array", "items": question},
+		},
+		"required":             []string{"questions"},
+		"additionalProperties": false,
+	}
+}
+
+// Read the questions out of the AI's answer. With JSON schema mode the answer
+// is exactly the JSON we asked for; without it the model may wrap it in code
+// fences or chat around it, so we look for the first JSON value that holds
+// questions. Both {"questions": [...]} and a bare [...] are accepted.
+// The questions are then marked with the format the prompt asked for ("" for
+// plain text), filled in for their type and checked.
+func parseQuizContent(content, quizType, format string) ([]QuizQuestion, error) {
+	questions, err := decodeQuizContent(content)
+	if err != nil {
+		return nil, err
+	}
+	for i := range questions {
+		questions[i].Format = format
+	}
+	prepareQuestions(questions, quizType)
+	return questions, validateQuestions(questions)
+}
+
+// Find and decode the questions in the AI's answer, without checking them
+func decodeQuizContent(content string) ([]QuizQuestion, error) {
+	content = strings.TrimSpace(content)
+	if questions, err := decodeQuestions([]byte(content)); err == nil {
+		return questions, nil
+	}
+
+	var firstErr error
+	candidates := 0
+	for i := 0; i < len(content) && candidates < 50; i++ {
+		if content[i] != '{' && content[i] != '[' {
+			continue
+		}
+		candidates++
+		var raw json.RawMessage
+		if err := json.NewDecoder(strings.NewReader(content[i:])).Decode(&raw); err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		if questions, err := decodeQuestions(raw); err == nil {
+			return questions, nil
+		}
+	}
+
+	if firstErr == nil {
+		firstErr = errors.New("no JSON found")
+	}
+	return nil, fmt.Errorf("quiz is not valid JSON: %w", firstErr)
+}
+
+// Decode either the {"questions": [...]} envelope or a bare array
+func decodeQuestions(raw []byte) ([]QuizQuestion, error) {
+	raw = []byte(strings.TrimSpace(string(raw)))
+	if len(raw) > 0 && raw[0] == '[' {
+		var questions []QuizQuestion
+		err := json.Unmarshal(raw, &questions)
+		return questions, err
+	}
+	var env quizEnvelope
+	if err := json.Unmarshal(raw, &env); err != nil {
+		return nil, err
+	}
+	if env.Questions == nil {
+		return nil, errors.New(`no "questions" in object`)
+	}
+	return env.Questions, nil
+}
+
+// Make sure every question can actually be shown and marked
+func validateQuestions(questions []QuizQuestion) error {
+	if len(questions) == 0 {
+		return errors.New("quiz has no questions")
+	}
+	for i, q := range questions {
+		if err := validateQuestion(q); err != nil {
+			return fmt.Errorf("question %d %w", i+1, err)
+		}
+	}
+	return nil
+}
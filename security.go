@@ -0,0 +1,133 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"time"
+)
+
+// ============================================================================
+// BROWSER SECURITY - Cookies, CSRF protection and security headers
+// ============================================================================
+
+// CSRF token lives in a cookie the page's JavaScript can read and echo back
+const (
+	csrfCookieName = "askify_csrf"
+	csrfHeaderName = "X-CSRF-Token"
+)
+
+// Content Security Policy for the app - Tailwind, Google Fonts and KaTeX
+// (for math in questions) come from CDNs
+const contentSecurityPolicy = "default-src 'self'; " +
+	"script-src 'self' https://cdn.tailwindcss.com https://cdn.jsdelivr.net; " +
+	"style-src 'self' 'unsafe-inline' https://fonts.googleapis.com https://cdn.jsdelivr.net; " +
+	"font-src 'self' https://fonts.gstatic.com https://cdn.jsdelivr.net; " +
+	"img-src 'self' data:; " +
+	"connect-src 'self'; " +
+	"frame-ancestors 'none'; " +
+	"base-uri 'self'; " +
+	"form-action 'self'"
+
+// Build the session cookie with our hardened settings - it lasts as long as the session does
+func (a *App) newSessionCookie(sessionID string) *http.Cookie {
+	return &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    sessionID,
+		HttpOnly: true, // Prevent JavaScript access (security)
+		Secure:   a.config.Session.CookieSecure,
+		SameSite: a.config.sameSite(),
+		Path:     "/",
+		MaxAge:   int(time.Duration(a.config.Session.Lifetime).Seconds()),
+	}
+}
+
+// Cookie that tells the browser to forget the session (logout)
+func (a *App) expiredSessionCookie() *http.Cookie {
+	c := a.newSessionCookie("")
+	c.MaxAge = -1
+	return c
+}
+
+// Make a new random CSRF token
+func generateCSRFToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Methods that change data and therefore need a CSRF token
+func isMutatingMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	default:
+		return true
+	}
+}
+
+// Double-submit CSRF protection: every visitor gets a random token cookie, and
+// any request that changes data must echo it back in the X-CSRF-Token header.
+// Another site can make the browser send our cookie, but it can't read it.
+func (a *App) csrfProtect(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Bearer-token clients don't use cookies, so there's nothing to forge
+		if _, ok := bearerToken(r); ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		c, err := r.Cookie(csrfCookieName)
+		if err != nil || c.Value == "" {
+			// First visit - hand out a token for the page to use
+			token, err := generateCSRFToken()
+			if err != nil {
+				httpError(w, r, msgCSRFTokenFailed, http.StatusInternalServerError)
+				return
+			}
+			http.SetCookie(w, &http.Cookie{
+				Name:     csrfCookieName,
+				Value:    token,
+				HttpOnly: false, // JavaScript has to read this one
+				Secure:   a.config.Session.CookieSecure,
+				SameSite: a.config.sameSite(),
+				Path:     "/",
+			})
+			c = &http.Cookie{Name: csrfCookieName, Value: token}
+			if isMutatingMethod(r.Method) {
+				httpError(w, r, msgMissingCSRFToken, http.StatusForbidden)
+				return
+			}
+		}
+
+		if isMutatingMethod(r.Method) {
+			sent := r.Header.Get(csrfHeaderName)
+			if sent == "" || subtle.ConstantTimeCompare([]byte(sent), []byte(c.Value)) != 1 {
+				httpError(w, r, msgInvalidCSRFToken, http.StatusForbidden)
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Add security headers to every response
+func (a *App) securityHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		h.Set("Content-Security-Policy", contentSecurityPolicy)
+		h.Set("X-Frame-Options", "DENY")
+		h.Set("X-Content-Type-Options", "nosniff")
+		h.Set("Referrer-Policy", "strict-origin-when-cross-origin")
+		h.Set("Permissions-Policy", "camera=(), microphone=(), geolocation=()")
+		if a.config.Session.CookieSecure {
+			// Only claim HTTPS-only when we're actually deployed behind HTTPS
+			h.Set("Strict-Transport-Security", "max-age=31536000; includeSubDomains")
+		}
+		next.ServeHTTP(w, r)
+	})
+}
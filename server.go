@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// ============================================================================
+// HTTP SERVER - Timeouts, graceful shutdown and health checks
+// ============================================================================
+
+// Run the web server until SIGINT/SIGTERM, then let in-flight requests
+// (and quiz generations the workers are busy with) finish before returning
+func (a *App) Serve() error {
+	cfg := a.config.Server
+	srv := &http.Server{
+		Addr:         cfg.Addr,
+		Handler:      a.Handler(),
+		ReadTimeout:  time.Duration(cfg.ReadTimeout),
+		WriteTimeout: time.Duration(cfg.WriteTimeout),
+		IdleTimeout:  time.Duration(cfg.IdleTimeout),
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	// Quiz generation workers and the timed-attempt sweeper run until the same signal arrives
+	a.startWorkers(ctx)
+	a.startAttemptSweeper(ctx)
+
+	// Start listening in the background (HTTPS if a certificate was configured)
+	errCh := make(chan error, 1)
+	go func() {
+		slog.Info("server starting", "addr", cfg.Addr, "tls", a.config.tlsEnabled())
+		if a.config.tlsEnabled() {
+			errCh <- srv.ListenAndServeTLS(cfg.TLSCert, cfg.TLSKey)
+		} else {
+			errCh <- srv.ListenAndServe()
+		}
+	}()
+
+	select {
+	case err := <-errCh:
+		return err // Couldn't start (port taken, bad certificate...)
+	case <-ctx.Done():
+	}
+
+	// Stop advertising readiness, stop accepting connections, and wait for the rest
+	stop()
+	a.shuttingDown.Store(true)
+	slog.Info("shutting down, waiting for in-flight requests to finish")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.ShutdownTimeout))
+	defer cancel()
+	// Even if requests outlast the timeout, live games and generations still get wound down
+	shutdownErr := srv.Shutdown(shutdownCtx)
+
+	// Shutdown doesn't wait for WebSockets, so end live games here (saving their results)
+	a.live.shutdown()
+
+	// Workers finish the job they're on before exiting; anything cut off here is requeued once its lease runs out
+	done := make(chan struct{})
+	go func() {
+		a.generations.Wait()
+		close(done)
+	}()
+	var drainErr error
+	select {
+	case <-done:
+	case <-shutdownCtx.Done():
+		drainErr = errors.New("shutdown timed out with quiz generations still running")
+	}
+	if err := errors.Join(shutdownErr, drainErr); err != nil {
+		return err
+	}
+
+	slog.Info("server stopped")
+	return nil
+}
+
+// Liveness: the process is up and answering
+func (a *App) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+// Readiness: the database answers, the AI provider is configured, and we're not shutting down
+func (a *App) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	checks := map[string]string{}
+	ready := true
+
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+	defer cancel()
+	if err := a.store.Ping(ctx); err != nil {
+		checks["database"] = "unavailable: " + err.Error()
+		ready = false
+	} else {
+		checks["database"] = "ok"
+	}
+
+	if a.llm.Configured() {
+		checks["llm"] = "ok"
+	} else {
+		checks["llm"] = "missing API key"
+		ready = false
+	}
+
+	if a.shuttingDown.Load() {
+		checks["server"] = "shutting down"
+		ready = false
+	} else {
+		checks["server"] = "ok"
+	}
+
+	status, code := "ok", http.StatusOK
+	if !ready {
+		status, code = "unavailable", http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": status, "checks": checks})
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// ============================================================================
+// INJECTION CORPUS - Known attacks and harmless documents for the upload guard
+// ============================================================================
+//
+// Every file in testdata/injection_corpus goes through the same cleanup and
+// scan as a real upload. Files named attack-*.txt must be flagged and
+// clean-*.txt must not be, so a new attack (or a false alarm someone
+// reports) becomes a new file there. No document may break out of its
+// markers, and the grounding check has to tell on-topic questions from
+// off-topic ones.
+
+const injectionCorpus = "testdata/injection_corpus"
+
+func TestInjectionCorpus(t *testing.T) {
+	entries, err := os.ReadDir(injectionCorpus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		name := e.Name()
+		t.Run(name, func(t *testing.T) {
+			body, err := os.ReadFile(filepath.Join(injectionCorpus, name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			text := cleanSource(string(body), 20000)
+			found := scanSource(text)
+
+			switch {
+			case strings.HasPrefix(name, "attack-") && len(found) == 0:
+				t.Error("attack not detected")
+			case strings.HasPrefix(name, "clean-") && len(found) > 0:
+				t.Errorf("false alarm: %s", strings.Join(found, ", "))
+			}
+			if !staysInsideMarkers(text) {
+				t.Error("document can break out of its markers")
+			}
+		})
+	}
+}
+
+// The photosynthesis notes double as the grounding check's document
+func TestGrounding(t *testing.T) {
+	notes, err := os.ReadFile(filepath.Join(injectionCorpus, "clean-photosynthesis.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	onTopic := []QuizQuestion{
+		{Question: "Where in the cell does photosynthesis mainly take place?", CorrectAnswer: "Chloroplasts"},
+		{Question: "Which enzyme fixes carbon dioxide in the Calvin cycle?", CorrectAnswer: "RuBisCO"},
+	}
+	offTopic := []QuizQuestion{
+		{Question: "What is the capital of France?", CorrectAnswer: "Paris"},
+		{Question: "Please enter your password to continue", CorrectAnswer: "hunter2"},
+	}
+	if err := checkGrounding(onTopic, string(notes), ""); err != nil {
+		t.Errorf("on-topic questions rejected: %v", err)
+	}
+	if checkGrounding(offTopic, string(notes), "") == nil {
+		t.Error("off-topic quiz was accepted")
+	}
+}
+
+// Does the wrapped document contain our markers only where we put them?
+func staysInsideMarkers(text string) bool {
+	boundary := newSourceBoundary()
+	return strings.Count(wrapSource(text, boundary), boundary) == 2
+}
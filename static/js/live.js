@@ -0,0 +1,348 @@
+// Live quiz page: hosts run a game from one of their saved quizzes, players
+// join with the PIN and a nickname. Everything after joining happens over
+// one WebSocket; the server keeps the clock and the scores.
+//
+//   /live?quiz=12    host quiz 12 (must be logged in as its owner)
+//   /live?pin=123456 join a game, with the PIN filled in
+
+const params = new URLSearchParams(location.search);
+const isHost = params.has('quiz');
+
+// DOM Elements
+const joinForm = document.getElementById('joinForm'); // PIN and nickname form for players
+const pinInput = document.getElementById('pinInput');
+const nicknameInput = document.getElementById('nicknameInput');
+const liveStage = document.getElementById('liveStage'); // Shown once connected
+const liveTitle = document.getElementById('liveTitle');
+const livePin = document.getElementById('livePin');
+const liveStatus = document.getElementById('liveStatus'); // One line saying what's going on
+const liveTimerTrack = document.getElementById('liveTimerTrack');
+const liveTimerBar = document.getElementById('liveTimerBar');
+const liveContent = document.getElementById('liveContent'); // Players, question or leaderboard
+const hostControls = document.getElementById('hostControls');
+const hostNext = document.getElementById('hostNext');
+const hostEnd = document.getElementById('hostEnd');
+const liveError = document.getElementById('liveError');
+
+// Game state
+let socket = null;
+let myNickname = ''; // Players only
+let hostState = 'lobby'; // What the host's main button does next
+let timerInterval = null;
+let finished = false;
+
+/**
+ * Reads the CSRF token the server put in a cookie
+ * @returns {string} Token to send back in the X-CSRF-Token header
+ */
+function getCSRFToken() {
+    const match = document.cookie.match(/(?:^|;\s*)askify_csrf=([^;]*)/);
+    return match ? decodeURIComponent(match[1]) : '';
+}
+
+/**
+ * Shows an error under the page (or hides it when message is empty)
+ * @param {string} message - What went wrong
+ */
+function showError(message) {
+    liveError.textContent = message || '';
+    liveError.classList.toggle('hidden', !message);
+}
+
+/**
+ * Makes an element with some classes and text. Nicknames come from other
+ * players, so everything goes in as text rather than HTML.
+ */
+function el(tag, className, text) {
+    const node = document.createElement(tag);
+    if (className) node.className = className;
+    if (text !== undefined) node.textContent = text;
+    return node;
+}
+
+// ----------- Connecting -----------
+
+/**
+ * Opens the game's WebSocket
+ * @param {Object} query - pin plus either role=host or nickname
+ */
+function connect(query) {
+    const scheme = location.protocol === 'https:' ? 'wss:' : 'ws:';
+    socket = new WebSocket(`${scheme}//${location.host}/api/live/ws?${new URLSearchParams(query)}`);
+
+    socket.onmessage = (event) => {
+        let msg;
+        try {
+            msg = JSON.parse(event.data);
+        } catch {
+            return;
+        }
+        handleMessage(msg);
+    };
+    socket.onclose = () => {
+        stopTimer();
+        if (!finished) {
+            liveStatus.textContent = 'Disconnected.';
+            hostControls.classList.add('hidden');
+        }
+    };
+}
+
+/**
+ * Sends a message to the server
+ * @param {Object} msg - Has a type, e.g. {type: 'next'}
+ */
+function send(msg) {
+    if (socket && socket.readyState === WebSocket.OPEN) {
+        socket.send(JSON.stringify(msg));
+    }
+}
+
+// Hosts create the session first, then connect to it
+async function startHosting() {
+    try {
+        const resp = await fetch('/api/live/sessions', {
+            method: 'POST',
+            headers: {'Content-Type': 'application/json', 'X-CSRF-Token': getCSRFToken()},
+            body: JSON.stringify({quiz_id: parseInt(params.get('quiz'), 10)})
+        });
+        if (!resp.ok) {
+            showError(resp.status === 401 ? 'Log in to host a live quiz.' : (await resp.text()).trim());
+            return;
+        }
+        const session = await resp.json();
+        liveStage.classList.remove('hidden');
+        hostControls.classList.remove('hidden');
+        connect({pin: session.pin, role: 'host'});
+    } catch (err) {
+        showError('Could not start the live session.');
+    }
+}
+
+// Players join with the form
+joinForm.addEventListener('submit', (e) => {
+    e.preventDefault();
+    const pin = pinInput.value.trim();
+    const nickname = nicknameInput.value.trim();
+    if (!/^\d{6}$/.test(pin) || !nickname) {
+        showError('Enter the 6-digit PIN and a nickname.');
+        return;
+    }
+    showError('');
+    myNickname = nickname;
+    joinForm.classList.add('hidden');
+    liveStage.classList.remove('hidden');
+    liveStatus.textContent = 'Joining...';
+    connect({pin, nickname});
+});
+
+hostNext.addEventListener('click', () => {
+    const command = {lobby: 'start', question: 'skip', leaderboard: 'next'}[hostState];
+    if (command) send({type: command});
+});
+
+hostEnd.addEventListener('click', () => {
+    if (confirm('End the game for everyone?')) send({type: 'end'});
+});
+
+// ----------- Messages from the server -----------
+
+/**
+ * Updates the page for one message from the server
+ * @param {Object} msg - See live.go for the message types
+ */
+function handleMessage(msg) {
+    switch (msg.type) {
+        case 'session': // Host connected
+            liveTitle.textContent = msg.title;
+            livePin.textContent = `PIN ${msg.pin}`;
+            liveStatus.textContent = `Players join at ${location.host}/live with PIN ${msg.pin}`;
+            break;
+        case 'joined': // Player connected
+            myNickname = msg.nickname;
+            liveTitle.textContent = msg.title;
+            livePin.textContent = msg.nickname;
+            liveStatus.textContent = "You're in! Waiting for the host to start...";
+            break;
+        case 'lobby':
+            if (hostState === 'lobby') showLobby(msg.players);
+            break;
+        case 'question':
+            showQuestion(msg);
+            break;
+        case 'answered':
+            liveStatus.textContent = 'Answer locked in. Waiting for everyone else...';
+            break;
+        case 'progress':
+            liveStatus.textContent = `${msg.answered} of ${msg.players} answered`;
+            break;
+        case 'leaderboard':
+            showLeaderboard(msg);
+            break;
+        case 'result':
+            liveStatus.textContent = msg.correct
+                ? `Correct! +${msg.points} points. You're #${msg.rank} with ${msg.score}.`
+                : `Not this time. You're #${msg.rank} with ${msg.score}.`;
+            break;
+        case 'host_left':
+            liveStatus.textContent = 'The host lost connection. Hang on...';
+            break;
+        case 'finished':
+            finished = true;
+            showFinal(msg);
+            break;
+        case 'error':
+            showError(msg.message);
+            if (!isHost && liveStatus.textContent === 'Joining...') {
+                // Couldn't join (bad nickname, game full...) - let them try again
+                liveStage.classList.add('hidden');
+                joinForm.classList.remove('hidden');
+            }
+            break;
+    }
+}
+
+/**
+ * Lists who's waiting in the lobby
+ * @param {Array<string>} players - Nicknames
+ */
+function showLobby(players) {
+    liveContent.innerHTML = '';
+    liveContent.appendChild(el('p', 'text-sm font-semibold text-gray-500 mb-2', `${players.length} player${players.length === 1 ? '' : 's'}`));
+    const list = el('div', 'flex flex-wrap gap-2');
+    players.forEach(name => list.appendChild(el('span', 'bg-orange-100 text-orange-800 rounded-full px-3 py-1 text-sm font-medium', name)));
+    liveContent.appendChild(list);
+    if (isHost) {
+        hostNext.textContent = 'Start';
+        hostNext.disabled = players.length === 0;
+    }
+}
+
+/**
+ * Shows a question: answer buttons for players, the question alone for the host
+ * @param {Object} msg - The question message
+ */
+function showQuestion(msg) {
+    hostState = 'question';
+    showError('');
+    liveContent.innerHTML = '';
+    liveContent.appendChild(el('p', 'text-sm text-gray-500 mb-1', `Question ${msg.index + 1} of ${msg.total}`));
+    liveContent.appendChild(el('h3', 'text-xl font-semibold text-gray-900 mb-4', msg.question));
+    liveStatus.textContent = isHost ? 'Waiting for answers...' : 'Pick an answer!';
+
+    const options = el('div', 'grid gap-3');
+    (msg.options || []).forEach((option, i) => {
+        const btn = el('button', 'live-option', `${String.fromCharCode(65 + i)}. ${option}`);
+        btn.type = 'button';
+        if (isHost) {
+            btn.disabled = true;
+        } else {
+            btn.addEventListener('click', () => {
+                options.querySelectorAll('button').forEach(b => b.disabled = true);
+                btn.classList.add('chosen');
+                send({type: 'answer', index: msg.index, answer: option});
+            });
+        }
+        options.appendChild(btn);
+    });
+    liveContent.appendChild(options);
+
+    if (isHost) {
+        hostNext.textContent = 'Reveal answer';
+        hostNext.disabled = false;
+    }
+    // Count down against the server's clock, not ours
+    startTimer(Date.parse(msg.ends_at) - Date.parse(msg.server_time), msg.seconds * 1000);
+}
+
+/**
+ * Shows the right answer and the standings after a question
+ * @param {Object} msg - The leaderboard message
+ */
+function showLeaderboard(msg) {
+    hostState = 'leaderboard';
+    stopTimer();
+    liveContent.innerHTML = '';
+    liveContent.appendChild(el('p', 'text-sm text-gray-500 mb-1', `Question ${msg.index + 1} of ${msg.total}`));
+    liveContent.appendChild(el('p', 'font-semibold text-green-700 mb-1', `Answer: ${msg.correctAnswer}`));
+    if (msg.explanation) {
+        liveContent.appendChild(el('p', 'text-sm text-gray-600 mb-4', msg.explanation));
+    }
+    liveContent.appendChild(renderStandings(msg.standings, true));
+    if (isHost) {
+        liveStatus.textContent = 'Leaderboard';
+        hostNext.textContent = msg.last ? 'Finish' : 'Next question';
+        hostNext.disabled = false;
+    }
+}
+
+/**
+ * Shows the final standings
+ * @param {Object} msg - The finished message
+ */
+function showFinal(msg) {
+    stopTimer();
+    hostControls.classList.add('hidden');
+    liveStatus.textContent = msg.reason;
+    liveContent.innerHTML = '';
+    liveContent.appendChild(el('h3', 'text-xl font-bold text-gray-900 mb-4', 'Final standings'));
+    liveContent.appendChild(renderStandings(msg.standings, false));
+    if (isHost) {
+        liveContent.appendChild(el('p', 'text-sm text-gray-500 mt-4', 'Results have been saved.'));
+    }
+}
+
+/**
+ * Builds the leaderboard list
+ * @param {Array<Object>} standings - Best first
+ * @param {boolean} showGained - Add the points from the last question
+ */
+function renderStandings(standings, showGained) {
+    const list = el('div');
+    (standings || []).forEach(s => {
+        const row = el('div', 'standing' + (s.nickname === myNickname ? ' me' : ''));
+        row.appendChild(el('span', 'font-medium', `${s.rank}. ${s.nickname}`));
+        row.appendChild(el('span', 'text-gray-700', showGained && s.gained ? `${s.score} (+${s.gained})` : `${s.score}`));
+        list.appendChild(row);
+    });
+    return list;
+}
+
+// ----------- Countdown -----------
+
+/**
+ * Runs the countdown bar
+ * @param {number} remaining - Milliseconds left
+ * @param {number} total - Milliseconds the question lasts
+ */
+function startTimer(remaining, total) {
+    stopTimer();
+    const ends = Date.now() + remaining;
+    liveTimerTrack.classList.remove('hidden');
+    const tick = () => {
+        const left = Math.max(0, ends - Date.now());
+        liveTimerBar.style.width = `${(left / total) * 100}%`;
+        if (left === 0) stopTimer(false);
+    };
+    tick();
+    timerInterval = setInterval(tick, 250);
+}
+
+/**
+ * Stops the countdown
+ * @param {boolean} hide - Also hide the bar
+ */
+function stopTimer(hide = true) {
+    clearInterval(timerInterval);
+    timerInterval = null;
+    if (hide) liveTimerTrack.classList.add('hidden');
+}
+
+// Start up as host or player
+if (isHost) {
+    startHosting();
+} else {
+    pinInput.value = params.get('pin') || '';
+    joinForm.classList.remove('hidden');
+    (pinInput.value ? nicknameInput : pinInput).focus();
+}
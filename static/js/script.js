@@ -1,8 +1,20 @@
 // Global state variables for the application
 let uploadedText = ''; // Stores text extracted from uploaded files
+let uploadedName = ''; // Name of the uploaded file, used as the quiz's topic
+let uploadedImages = []; // Images kept from the upload ({id, url, page}), for questions about them
+const MAX_QUIZ_IMAGES = 4; // How many images the server will make one quiz from
 let currentQuizId = null; // Tracks the currently active quiz ID
 let currentUser = null; // Stores current user information
 
+/**
+ * Reads the CSRF token the server put in a cookie
+ * @returns {string} Token to send back in the X-CSRF-Token header
+ */
+function getCSRFToken() {
+    const match = document.cookie.match(/(?:^|;\s*)askify_csrf=([^;]*)/);
+    return match ? decodeURIComponent(match[1]) : '';
+}
+
 // DOM Elements - Quiz Functionality
 const uploadZone = document.getElementById('uploadZone'); // File upload area
 const fileInput = document.getElementById('fileInput'); // Hidden file input element
@@ -14,6 +26,7 @@ const resultsSection = document.getElementById('resultsSection'); // Quiz result
 const quizContainer = document.getElementById('quizContainer'); // Container for quiz questions
 const copyBtn = document.getElementById('copyBtn'); // Copy quiz button
 const downloadBtn = document.getElementById('downloadBtn'); // Download quiz button
+const giftBtn = document.getElementById('giftBtn'); // Export for Moodle (GIFT) button
 
 // Celebration Screen Elements
 const celebrationScreen = document.getElementById('celebrationScreen');
@@ -281,6 +294,14 @@ signupBtn?.addEventListener('click', () => showSignupModal());
 loginBtnMobile?.addEventListener('click', () => showLoginModal(true));
 signupBtnMobile?.addEventListener('click', () => showSignupModal());
 
+// Buttons rendered into innerHTML use data-action instead of inline onclick (blocked by our CSP)
+document.addEventListener('click', (e) => {
+    const target = e.target.closest('[data-action]');
+    if (!target) return;
+    if (target.dataset.action === 'show-login') showLoginModal(true);
+    if (target.dataset.action === 'reset-upload') resetUpload();
+});
+
 // Tab switching and modal cancellation
 showLoginBtn?.addEventListener('click', () => showLoginModal(true));
 showSignupBtn?.addEventListener('click', () => showSignupModal());
@@ -312,7 +333,7 @@ authForm?.addEventListener('submit', async function(e) {
         // Send authentication request
         const resp = await fetch(endpoint, {
             method: 'POST', 
-            headers: {'Content-Type':'application/json'}, 
+            headers: {'Content-Type':'application/json', 'X-CSRF-Token': getCSRFToken()}, 
             body: JSON.stringify(body)
         });
         
@@ -343,7 +364,7 @@ authForm?.addEventListener('submit', async function(e) {
  */
 async function logoutUser() {
     try {
-        await fetch('/api/logout', { method: 'POST' });
+        await fetch('/api/logout', { method: 'POST', headers: {'X-CSRF-Token': getCSRFToken()} });
         currentUser = null;
         await refreshSessionAndHistory(); // Update UI to logged out state
         profileDropdown?.classList.remove('open'); // Close profile dropdown
@@ -385,7 +406,7 @@ async function refreshSessionAndHistory() {
                 <div class="empty-history">
                     <div class="empty-history-icon">📚</div>
                     <p class="text-gray-600 mb-2">Login to see your quiz history</p>
-                    <button onclick="showLoginModal(true)" class="celebration-btn celebration-btn-primary" style="padding: 8px 16px; font-size: 14px;">
+                    <button data-action="show-login" class="celebration-btn celebration-btn-primary" style="padding: 8px 16px; font-size: 14px;">
                         Sign In
                     </button>
                 </div>
@@ -458,6 +479,7 @@ function renderQuizHistory(list) {
             <div class="history-title">${q.prompt.length > 60 ? q.prompt.slice(0, 57) + '...' : q.prompt}</div>
             <div class="history-meta">
                 <div class="history-date">${formatDate(q.date)}</div>
+                <a class="history-live" href="/live?quiz=${q.quiz_id}" title="Play this quiz live with a group">Host live</a>
                 <div class="history-status">
                     ${isComplete ? 
                         `<span class="status-complete">
@@ -472,7 +494,10 @@ function renderQuizHistory(list) {
                 </div>
             </div>
         `;
-        el.onclick = () => loadPastQuiz(q.quiz_id);
+        el.onclick = (e) => {
+            if (e.target.closest('.history-live')) return; // Off to the live page instead
+            loadPastQuiz(q.quiz_id);
+        };
         quizHistoryList.appendChild(el);
     });
 }
@@ -509,6 +534,19 @@ async function loadPastQuiz(quizID) {
         if (!resp.ok) throw new Error('Failed to load quiz');
         
         const detail = await resp.json();
+
+        // An unfinished timed quiz carries on against the clock (starting it if need be)
+        if ((detail.time_limit || detail.question_time_limit) && !detail.is_complete) {
+            const timed = await startTimedAttempt(detail.quiz_id);
+            if (!timed.is_complete) {
+                currentQuizId = detail.quiz_id;
+                displayQuiz(detail.questions, timed);
+                resultsSection.classList.remove('hidden');
+                return;
+            }
+            // Time ran out: load it again, answers and all
+            return loadPastQuiz(quizID);
+        }
         displayQuizWithAnswers(detail.questions, detail.user_answers, detail.is_complete, detail.score, detail.quiz_id);
     } catch (err) {
         alert('Could not load quiz detail: ' + err.message);
@@ -518,6 +556,8 @@ async function loadPastQuiz(quizID) {
 // Initialize application when DOM is loaded
 document.addEventListener('DOMContentLoaded', function() {
     refreshSessionAndHistory();
+    resumePendingGeneration(); // Carry on with a quiz that was generating before a refresh
+    resumeAdaptiveQuiz(); // Or an adaptive quiz that was under way
     
     // Celebration screen event listeners
     celebrationReviewBtn?.addEventListener('click', () => {
@@ -586,15 +626,19 @@ async function handleFileUpload(file) {
 
         const response = await fetch('/api/upload', {
             method: 'POST',
+            headers: {'X-CSRF-Token': getCSRFToken()},
             body: formData
         });
 
         if (!response.ok) {
-            throw new Error('Upload failed');
+            const errorText = await response.text();
+            throw new Error(errorText || 'Upload failed');
         }
 
         const data = await response.json();
         uploadedText = data.text; // Store extracted text
+        uploadedName = file.name;
+        uploadedImages = data.images || [];
         
         // Show success state
         uploadZone.innerHTML = `
@@ -604,7 +648,13 @@ async function handleFileUpload(file) {
                 </svg>
                 <p class="text-gray-700 font-medium">${file.name}</p>
                 <p class="text-gray-500 text-sm">File uploaded successfully</p>
-                <button onclick="resetUpload()" class="mt-3 text-orange-500 text-sm hover:text-orange-600">Upload different file</button>
+                ${data.language && languageLabel(data.language) ? `<p class="text-gray-500 text-xs mt-1">Written in ${languageLabel(data.language)} - with Language on Auto, so is the quiz</p>` : ''}
+                ${data.ocr ? '<p class="text-gray-500 text-xs mt-1">Text was read from a scan or picture, so it may have mistakes</p>' : ''}
+                ${uploadedImages.length ? `
+                    <div class="flex gap-2 mt-2">${uploadedImages.slice(0, MAX_QUIZ_IMAGES).map(img => `<img src="${img.url}" alt="" class="h-12 rounded border border-gray-200">`).join('')}</div>
+                    <p class="text-gray-500 text-xs mt-1">Questions can be about ${uploadedImages.length > MAX_QUIZ_IMAGES ? `the first ${MAX_QUIZ_IMAGES} of its ${uploadedImages.length} images` : uploadedImages.length === 1 ? 'this image' : 'these images'}</p>
+                ` : ''}
+                <button data-action="reset-upload" class="mt-3 text-orange-500 text-sm hover:text-orange-600">Upload different file</button>
             </div>
         `;
 
@@ -619,10 +669,14 @@ async function handleFileUpload(file) {
                 <svg class="w-12 h-12 text-red-500 mb-2" fill="none" stroke="currentColor" viewBox="0 0 24 24">
                     <path stroke-linecap="round" stroke-linejoin="round" stroke-width="2" d="M6 18L18 6M6 6l12 12"></path>
                 </svg>
-                <p class="text-red-600">Upload failed. Please try again.</p>
-                <button onclick="resetUpload()" class="mt-3 text-orange-500 text-sm hover:text-orange-600">Try again</button>
+                <p class="text-red-600 upload-error">Upload failed. Please try again.</p>
+                <button data-action="reset-upload" class="mt-3 text-orange-500 text-sm hover:text-orange-600">Try again</button>
             </div>
         `;
+        // The server says why it turned an image away (wrong type, too big...)
+        if (error.message !== 'Upload failed') {
+            uploadZone.querySelector('.upload-error').textContent = error.message;
+        }
     }
 }
 
@@ -642,10 +696,12 @@ function resetUpload() {
                 <span class="text-orange-500 font-medium">Document</span>
                 <span> to create quiz</span>
             </p>
-            <p class="text-gray-400 text-sm">Supports PDF, Word (DOCX), and TXT files</p>
+            <p class="text-gray-400 text-sm">Supports PDF, Word (DOCX), TXT and image files</p>
         </div>
     `;
     uploadedText = '';
+    uploadedName = '';
+    uploadedImages = [];
     fileInput.value = '';
 }
 
@@ -665,9 +721,12 @@ pasteBtn?.addEventListener('click', async () => {
  * Handles quiz generation when generate button is clicked
  */
 generateBtn?.addEventListener('click', async () => {
-    let topic = uploadedText || topicInput.value.trim();
-    
-    if (!topic) {
+    // Document text goes separately so the server can treat it as untrusted
+    const source = uploadedText;
+    const images = uploadedImages.slice(0, MAX_QUIZ_IMAGES).map(img => img.id);
+    const topic = source ? uploadedName : (topicInput.value.trim() || (images.length ? uploadedName : ''));
+
+    if (!topic && !source) {
         alert('Please enter a topic or upload a file to create a quiz.');
         return;
     }
@@ -676,25 +735,44 @@ generateBtn?.addEventListener('click', async () => {
     const difficulty = document.getElementById('difficulty').value;
     const questionCount = parseInt(document.getElementById('questionCount').value);
     const quizType = document.getElementById('quizType').value;
+    const fresh = document.getElementById('freshQuestions')?.checked || false;
+    const language = document.getElementById('quizLanguage')?.value || ''; // Empty lets the server decide
+    const timer = document.getElementById('timerSetting')?.value || ''; // "600" for the whole quiz, "q30" per question
+    const time_limit = timer.startsWith('q') ? 0 : Number(timer) || 0;
+    const question_time_limit = timer.startsWith('q') ? Number(timer.slice(1)) : 0;
+
+    // Adaptive quizzes are asked one question at a time instead
+    if (difficulty === 'Adaptive') {
+        if (source || images.length) {
+            alert('Adaptive quizzes work from a topic. Type one in instead of uploading a document.');
+            return;
+        }
+        await startAdaptiveQuiz(topic, quizType, language);
+        return;
+    }
 
     // Show loading state
-    loadingSpinner.classList.remove('hidden');
-    resultsSection.classList.add('hidden');
-    generateBtn.disabled = true;
-    generateBtn.classList.add('opacity-50', 'cursor-not-allowed');
+    setGenerating(true);
 
     try {
-        // Generate quiz via API
+        // Queue the quiz - the server generates it in the background
         const response = await fetch('/api/generate-quiz', {
             method: 'POST',
             headers: {
-                'Content-Type': 'application/json'
+                'Content-Type': 'application/json',
+                'X-CSRF-Token': getCSRFToken()
             },
             body: JSON.stringify({
                 topic,
+                source,
+                images,
                 difficulty,
                 questionCount,
-                quizType
+                quizType,
+                language,
+                fresh,
+                time_limit,
+                question_time_limit
             })
         });
 
@@ -703,44 +781,128 @@ generateBtn?.addEventListener('click', async () => {
             throw new Error(errorText || 'Failed to generate quiz');
         }
 
-        const quiz = await response.json();
-        
-        // Save quiz to database if user is logged in
-        if (await isUserLoggedIn()) {
-            try {
-                const saveResponse = await fetch('/api/save-quiz', {
-                    method: 'POST',
-                    headers: {
-                        'Content-Type': 'application/json'
-                    },
-                    body: JSON.stringify({
-                        prompt: topic.substring(0, 200), // Truncate for display
-                        questions: quiz
-                    })
-                });
-                
-                if (saveResponse.ok) {
-                    const saveResult = await saveResponse.json();
-                    currentQuizId = saveResult.quiz_id;
-                    
-                    // Refresh history to show the new quiz
-                    refreshSessionAndHistory();
-                }
-            } catch (saveError) {
-                console.error('Failed to save quiz:', saveError);
-            }
-        }
-        
-        displayQuiz(quiz); // Display the generated quiz
+        const job = await response.json();
+
+        // Remember the job so a refresh can pick up where we left off
+        localStorage.setItem(PENDING_JOB_KEY, job.id);
+        await finishGeneration(job.id);
     } catch (error) {
         alert('Error generating quiz: ' + error.message);
-    } finally {
-        // Reset loading state
+        setGenerating(false);
+    }
+});
+
+// ----------- Background Generation Jobs -----------
+
+const PENDING_JOB_KEY = 'askify_pending_job'; // localStorage key for the job we're waiting on
+
+/**
+ * Shows or hides the loading state while a quiz is being generated
+ * @param {boolean} busy - True while waiting for a quiz
+ */
+function setGenerating(busy) {
+    if (busy) {
+        loadingSpinner.classList.remove('hidden');
+        resultsSection.classList.add('hidden');
+        generateBtn.disabled = true;
+        generateBtn.classList.add('opacity-50', 'cursor-not-allowed');
+    } else {
         loadingSpinner.classList.add('hidden');
         generateBtn.disabled = false;
         generateBtn.classList.remove('opacity-50', 'cursor-not-allowed');
     }
-});
+}
+
+/**
+ * Waits for a generation job to finish: follows the event stream,
+ * falling back to polling if the stream isn't available
+ * @param {string} jobId - Job ID from /api/generate-quiz
+ * @returns {Promise<Object>} The finished job
+ */
+function waitForJob(jobId) {
+    const url = '/api/jobs?id=' + encodeURIComponent(jobId);
+    const isDone = job => job.status === 'succeeded' || job.status === 'failed';
+
+    const poll = async (resolve, reject) => {
+        try {
+            const response = await fetch(url);
+            if (!response.ok) {
+                const errorText = await response.text();
+                throw new Error(errorText || 'Failed to check quiz status');
+            }
+            const job = await response.json();
+            if (isDone(job)) {
+                resolve(job);
+            } else {
+                setTimeout(() => poll(resolve, reject), 2000);
+            }
+        } catch (error) {
+            reject(error);
+        }
+    };
+
+    return new Promise((resolve, reject) => {
+        if (!window.EventSource) {
+            poll(resolve, reject);
+            return;
+        }
+
+        const events = new EventSource('/api/jobs/events?id=' + encodeURIComponent(jobId));
+        events.onmessage = (event) => {
+            const job = JSON.parse(event.data);
+            if (isDone(job)) {
+                events.close();
+                resolve(job);
+            }
+        };
+        events.onerror = () => {
+            // Stream dropped (server restarting, proxy timeout...) - poll instead
+            events.close();
+            poll(resolve, reject);
+        };
+    });
+}
+
+/**
+ * Waits for a job and shows the quiz it produced
+ * @param {string} jobId - Job ID from /api/generate-quiz
+ */
+async function finishGeneration(jobId) {
+    setGenerating(true);
+    try {
+        const job = await waitForJob(jobId);
+        localStorage.removeItem(PENDING_JOB_KEY);
+
+        if (job.status === 'failed') {
+            throw new Error(job.error || 'Failed to generate quiz');
+        }
+
+        // Logged-in users get the quiz saved to their history by the server
+        let timed = null;
+        if (job.quiz_id) {
+            currentQuizId = job.quiz_id;
+            refreshSessionAndHistory(); // Refresh history to show the new quiz
+            timed = await startTimedAttemptIfTimed(job.quiz_id);
+        }
+
+        displayQuiz(job.result, timed); // Display the generated quiz
+    } catch (error) {
+        localStorage.removeItem(PENDING_JOB_KEY);
+        alert('Error generating quiz: ' + error.message);
+    } finally {
+        setGenerating(false);
+    }
+}
+
+/**
+ * Picks up a generation that was still running when the page was refreshed
+ */
+function resumePendingGeneration() {
+    const jobId = localStorage.getItem(PENDING_JOB_KEY);
+    if (jobId) {
+        finishGeneration(jobId);
+    }
+}
 
 /**
  * Checks if user is logged in
@@ -754,17 +916,25 @@ async function isUserLoggedIn() {
  * Displays generated quiz in the UI
  * @param {Array} quiz - Array of quiz questions
  */
-function displayQuiz(quiz) {
+function displayQuiz(quiz, timed = null) {
     quizContainer.innerHTML = ''; // Clear previous quiz
     let answers = new Array(quiz.length).fill(null); // Track user answers
+    let chosen = new Array(quiz.length).fill(null); // The options they picked, saved with the attempt
+
+    // Timed quizzes get a countdown, and answers are marked by the server
+    stopQuizTimer();
+    timedAttempt = timed;
+    if (timed) {
+        startQuizTimer(timed);
+    }
 
     // Create question cards for each quiz question
     quiz.forEach((item, index) => {
         const questionCard = document.createElement('div');
         questionCard.className = 'bg-white border border-gray-200 rounded-xl p-6 shadow-sm';
 
-        let optionsHTML = '';
-        if (item.options && item.options.length > 0) {
+        let optionsHTML = `<div id="typed-${index}"></div><div id="feedback-${index}"></div>`;
+        if (isSinglePick(item)) {
             // Generate options buttons
             optionsHTML = `
                 <div class="mt-4 space-y-2" id="options-${index}">
@@ -773,7 +943,7 @@ function displayQuiz(quiz) {
                             class="option-btn w-full text-left flex items-center gap-2 p-3 rounded-lg border border-gray-200 bg-gray-50 text-gray-700 hover:bg-orange-50 transition-colors focus:outline-none"
                             style="cursor:pointer;">
                             <span class="font-medium">${String.fromCharCode(65 + i)}.</span>
-                            <span>${option}</span>
+                            <span>${renderText(item, option)}</span>
                         </button>
                     `).join('')}
                 </div>
@@ -786,11 +956,15 @@ function displayQuiz(quiz) {
             <div class="flex items-start gap-3">
                 <span class="bg-orange-500 text-white font-bold rounded-full w-8 h-8 flex items-center justify-center flex-shrink-0">${index + 1}</span>
                 <div class="flex-1">
-                    <h3 class="text-lg font-semibold text-gray-900 mb-2">${item.question}</h3>
+                    <h3 class="text-lg font-semibold text-gray-900 mb-2">${renderText(item, item.question)}</h3>
+                    ${questionImage(item)}
+                    ${item.duplicate ? `<p class="text-xs text-amber-600 mb-2">${item.duplicate.inQuiz ? 'Very similar to another question in this quiz' : "Similar to a question you've had before"}</p>` : ''}
+                    ${item.lowConfidence ? `<p class="text-xs text-amber-600 mb-2">A second check didn't agree with this question's answer, so it may be wrong</p>` : ''}
                     ${optionsHTML}
                 </div>
             </div>
         `;
+        addFlagControl(questionCard, index);
         quizContainer.appendChild(questionCard);
     });
 
@@ -798,79 +972,128 @@ function displayQuiz(quiz) {
     quiz.forEach((item, index) => {
         const optionsDiv = document.getElementById(`options-${index}`);
         const feedbackDiv = document.getElementById(`feedback-${index}`);
-        if (!optionsDiv) return;
+        if (!optionsDiv) {
+            // Everything but a single pick has its own answer controls
+            const showTyped = (answer, isCorrect) => {
+                feedbackDiv.innerHTML = typedAnswerFeedback(item, answer, isCorrect);
+                answers[index] = isCorrect ? 'correct' : 'wrong';
+                if (answers.every(a => a !== null)) {
+                    showResultSummary(answers.filter(a => a === 'correct').length, answers.length, chosen);
+                }
+            };
+            const widget = answerWidget(item, async answer => {
+                chosen[index] = answer;
+                if (!timedAttempt) {
+                    showTyped(answer, gradeLocally(item, answer));
+                    return;
+                }
+                const result = await sendTimedAnswer(index, answer);
+                if (result) {
+                    revealAnswer(item, result);
+                    showTyped(answer, result.correct);
+                }
+            });
+            document.getElementById(`typed-${index}`).appendChild(widget);
+            // Questions already answered before a refresh stay answered
+            if (timed && timed.answers[index] != null) {
+                lockAnswerWidget(widget);
+                showTyped(timed.answers[index], gradeLocally(item, timed.answers[index]));
+            }
+            return;
+        }
         
         let answered = false; // Track if question has been answered
         
+        // Show how an answer went: lock the question and colour the options
+        const showAnswer = function (isCorrect) {
+            // Disable all buttons in this question
+            optionsDiv.querySelectorAll('button.option-btn').forEach(optBtn => {
+                optBtn.disabled = true;
+                optBtn.classList.add('cursor-not-allowed','opacity-75');
+            });
+            
+            const correct = item.correctAnswer;
+            
+            // Highlight correct option in green
+            optionsDiv.querySelectorAll('button.option-btn').forEach(optBtn => {
+                const optText = optBtn.getAttribute('data-opt');
+                if (optText === correct) {
+                    optBtn.classList.remove('border-gray-200', 'bg-gray-50', 'hover:bg-orange-50');
+                    optBtn.classList.add('bg-green-100', 'border-green-400', 'text-green-900', 'font-semibold');
+                }
+            });
+            
+            if (isCorrect) {
+                // Correct answer
+                this.classList.remove('bg-gray-50','border-gray-200');
+                this.classList.add('bg-green-100','border-green-400','text-green-900', 'font-semibold');
+                
+                // Create explanation with requested color scheme
+                const explanationHTML = item.explanation ? `
+                    <div class="explanation-container">
+                        <div class="explanation-title">Explanation</div>
+                        <div class="explanation-content">${renderText(item, item.explanation)}</div>
+                    </div>
+                ` : '';
+                
+                feedbackDiv.innerHTML = `
+                    <div class="mt-4 p-3 rounded-lg bg-green-50 border border-green-200 text-green-900 flex items-center gap-3">
+                        <span class="text-2xl">✅</span> <span class="font-bold">Correct!</span>
+                    </div>
+                    ${explanationHTML}
+                `;
+                answers[index] = 'correct';
+            } else {
+                // Incorrect answer
+                this.classList.remove('bg-gray-50','border-gray-200');
+                this.classList.add('bg-red-100','border-red-400','text-red-900','font-semibold');
+                
+                // Create explanation with requested color scheme
+                const explanationHTML = item.explanation ? `
+                    <div class="explanation-container">
+                        <div class="explanation-title">Explanation</div>
+                        <div class="explanation-content">${renderText(item, item.explanation)}</div>
+                    </div>
+                ` : '';
+                
+                feedbackDiv.innerHTML = `
+                    <div class="mt-4 p-3 rounded-lg bg-red-50 border border-red-200 text-red-900 flex items-center gap-3">
+                        <span class="text-2xl">❌</span> <span class="font-bold">Wrong!</span>
+                    </div>
+                    ${explanationHTML}
+                `;
+                answers[index] = 'wrong';
+            }
+            
+            // If all questions have been answered, show result summary
+            if (answers.every(a => a !== null)) {
+                const correctCount = answers.filter(a => a === 'correct').length;
+                showResultSummary(correctCount, answers.length, chosen);
+            }
+        };
+        
+        // Questions already answered before a refresh stay answered
+        if (timed && timed.answers[index] != null) {
+            answered = true;
+            const chosen = [...optionsDiv.querySelectorAll('button.option-btn')].find(b => b.getAttribute('data-opt') === timed.answers[index]);
+            showAnswer.call(chosen || optionsDiv.querySelector('button.option-btn'), gradeLocally(item, timed.answers[index]));
+        }
+        
         optionsDiv.querySelectorAll('button.option-btn').forEach(btn => {
-            btn.addEventListener('click', function () {
+            btn.addEventListener('click', async function () {
                 if (answered) return; // Prevent multiple answers
                 answered = true;
                 
-                // Disable all buttons in this question
-                optionsDiv.querySelectorAll('button.option-btn').forEach(optBtn => {
-                    optBtn.disabled = true;
-                    optBtn.classList.add('cursor-not-allowed','opacity-75');
-                });
-                
                 const selected = this.getAttribute('data-opt');
-                const correct = item.correctAnswer;
-                
-                // Highlight correct option in green
-                optionsDiv.querySelectorAll('button.option-btn').forEach(optBtn => {
-                    const optText = optBtn.getAttribute('data-opt');
-                    if (optText === correct) {
-                        optBtn.classList.remove('border-gray-200', 'bg-gray-50', 'hover:bg-orange-50');
-                        optBtn.classList.add('bg-green-100', 'border-green-400', 'text-green-900', 'font-semibold');
-                    }
-                });
-                
-                if (selected === correct) {
-                    // Correct answer
-                    this.classList.remove('bg-gray-50','border-gray-200');
-                    this.classList.add('bg-green-100','border-green-400','text-green-900', 'font-semibold');
-                    
-                    // Create explanation with requested color scheme
-                    const explanationHTML = item.explanation ? `
-                        <div class="explanation-container">
-                            <div class="explanation-title">Explanation</div>
-                            <div class="explanation-content">${item.explanation}</div>
-                        </div>
-                    ` : '';
-                    
-                    feedbackDiv.innerHTML = `
-                        <div class="mt-4 p-3 rounded-lg bg-green-50 border border-green-200 text-green-900 flex items-center gap-3">
-                            <span class="text-2xl">✅</span> <span class="font-bold">Correct!</span>
-                        </div>
-                        ${explanationHTML}
-                    `;
-                    answers[index] = 'correct';
-                } else {
-                    // Incorrect answer
-                    this.classList.remove('bg-gray-50','border-gray-200');
-                    this.classList.add('bg-red-100','border-red-400','text-red-900','font-semibold');
-                    
-                    // Create explanation with requested color scheme
-                    const explanationHTML = item.explanation ? `
-                        <div class="explanation-container">
-                            <div class="explanation-title">Explanation</div>
-                            <div class="explanation-content">${item.explanation}</div>
-                        </div>
-                    ` : '';
-                    
-                    feedbackDiv.innerHTML = `
-                        <div class="mt-4 p-3 rounded-lg bg-red-50 border border-red-200 text-red-900 flex items-center gap-3">
-                            <span class="text-2xl">❌</span> <span class="font-bold">Wrong!</span>
-                        </div>
-                        ${explanationHTML}
-                    `;
-                    answers[index] = 'wrong';
+                chosen[index] = selected;
+                if (!timedAttempt) {
+                    showAnswer.call(this, gradeLocally(item, selected));
+                    return;
                 }
-                
-                // If all questions have been answered, show result summary
-                if (answers.every(a => a !== null)) {
-                    const correctCount = answers.filter(a => a === 'correct').length;
-                    showResultSummary(correctCount, answers.length);
+                const result = await sendTimedAnswer(index, selected);
+                if (result) {
+                    revealAnswer(item, result);
+                    showAnswer.call(this, result.correct);
                 }
             });
         });
@@ -889,8 +1112,9 @@ function displayQuiz(quiz) {
  * Shows quiz result summary
  * @param {number} correct - Number of correct answers
  * @param {number} total - Total number of questions
+ * @param {Array} chosen - The option picked for each question
  */
-function showResultSummary(correct, total) {
+function showResultSummary(correct, total, chosen) {
     // Remove any previous summary to prevent duplicates
     let existingSummary = document.getElementById('quiz-summary-score');
     if (existingSummary) existingSummary.remove();
@@ -905,23 +1129,32 @@ function showResultSummary(correct, total) {
     showCelebrationScreen(correct, total);
     
     // Save quiz attempt
-    sendQuizAttempt(correct, total);
+    sendQuizAttempt(correct, total, chosen);
 }
 
 /**
  * Sends quiz attempt to server
  * @param {number} correct - Number of correct answers
  * @param {number} total - Total number of questions
+ * @param {Array} chosen - The option picked for each question, so the score can be
+ *                         worked out again if a question's answer gets fixed
  */
-async function sendQuizAttempt(correct, total) {
+async function sendQuizAttempt(correct, total, chosen) {
     if (!currentQuizId) return;
+    if (timedAttempt) {
+        // The server marked every answer of a timed quiz as it came in
+        stopQuizTimer();
+        refreshSessionAndHistory();
+        return;
+    }
     
     try {
         await fetch('/api/save-quiz-attempt', {
             method: 'POST', 
-            headers: {'Content-Type':'application/json'},
+            headers: {'Content-Type':'application/json', 'X-CSRF-Token': getCSRFToken()},
             body: JSON.stringify({
                 quiz_id: currentQuizId, 
+                answers_json: JSON.stringify(chosen),
                 score: correct, 
                 is_complete: true
             })
@@ -943,14 +1176,34 @@ async function sendQuizAttempt(correct, total) {
  */
 function displayQuizWithAnswers(questions, userAnswers, isComplete, score, quizId) {
     currentQuizId = quizId;
+    stopQuizTimer();
+    timedAttempt = null;
     quizContainer.innerHTML = '';
     
     questions.forEach((item, index) => {
         const questionCard = document.createElement('div');
         questionCard.className = 'bg-white border border-gray-200 rounded-xl p-6 shadow-sm';
+        questionCard.dataset.question = index;
 
         let optionsHTML = '';
-        if (item.options && item.options.length > 0) {
+        if (!isSinglePick(item)) {
+            const userAnswer = userAnswers ? userAnswers[index] : null;
+            optionsHTML = `
+                ${questionKind(item) === 'matching' ? `<div class="mt-4 text-gray-700">${item.pairs.map(p => `${renderText(item, p.left)} → ${renderText(item, p.right)}`).join('<br>')}</div>` : ''}
+                <div class="mt-4 p-3 rounded-lg border bg-green-100 border-green-400 text-green-900 font-semibold">Answer: ${formatAnswer(item, item.correctAnswer)}</div>
+                ${userAnswer != null ? `
+                    <div class="mt-2 p-3 rounded-lg border ${gradeLocally(item, userAnswer) ? 'bg-green-50 border-green-200 text-green-700' : 'bg-red-100 border-red-400 text-red-900'}">
+                        ${gradeLocally(item, userAnswer) ? '✓' : '✗'} Your answer: ${formatAnswer(item, userAnswer)}
+                    </div>
+                ` : ''}
+                ${item.explanation ? `
+                    <div class="explanation-container">
+                        <div class="explanation-title">Explanation</div>
+                        <div class="explanation-content">${renderText(item, item.explanation)}</div>
+                    </div>
+                ` : ''}
+            `;
+        } else if (item.options && item.options.length > 0) {
             const userAnswer = userAnswers ? userAnswers[index] : null;
             const correctAnswer = item.correctAnswer;
             
@@ -971,7 +1224,7 @@ function displayQuizWithAnswers(questions, userAnswers, isComplete, score, quizI
                         return `
                             <div class="w-full text-left flex items-center gap-2 p-3 rounded-lg border ${bgClass}">
                                 <span class="font-medium">${String.fromCharCode(65 + i)}.</span>
-                                <span>${option}</span>
+                                <span>${renderText(item, option)}</span>
                                 ${isUserAnswer && isCorrectAnswer ? '<span class="ml-2 text-green-600">✓ Your answer</span>' : ''}
                                 ${isUserAnswer && !isCorrectAnswer ? '<span class="ml-2 text-red-600">✗ Your answer</span>' : ''}
                             </div>
@@ -981,7 +1234,7 @@ function displayQuizWithAnswers(questions, userAnswers, isComplete, score, quizI
                 ${item.explanation ? `
                     <div class="explanation-container">
                         <div class="explanation-title">Explanation</div>
-                        <div class="explanation-content">${item.explanation}</div>
+                        <div class="explanation-content">${renderText(item, item.explanation)}</div>
                     </div>
                 ` : ''}
             `;
@@ -991,11 +1244,14 @@ function displayQuizWithAnswers(questions, userAnswers, isComplete, score, quizI
             <div class="flex items-start gap-3">
                 <span class="bg-orange-500 text-white font-bold rounded-full w-8 h-8 flex items-center justify-center flex-shrink-0">${index + 1}</span>
                 <div class="flex-1">
-                    <h3 class="text-lg font-semibold text-gray-900 mb-2">${item.question}</h3>
+                    <h3 class="text-lg font-semibold text-gray-900 mb-2">${renderText(item, item.question)}</h3>
+                    ${questionImage(item)}
+                    ${item.lowConfidence ? `<p class="text-xs text-amber-600 mb-2">A second check didn't agree with this answer${item.lowConfidence.checkerAnswer ? ` (it said: ${item.lowConfidence.checkerAnswer})` : ' (it found no single right answer)'}</p>` : ''}
                     ${optionsHTML}
                 </div>
             </div>
         `;
+        addFlagControl(questionCard, index, () => showQuizFlags(quizId, questions));
         quizContainer.appendChild(questionCard);
     });
 
@@ -1007,8 +1263,818 @@ function displayQuizWithAnswers(questions, userAnswers, isComplete, score, quizI
         quizContainer.appendChild(resultDiv);
     }
     
+    if (quizId) {
+        quizContainer.appendChild(translateControl(quizId));
+    }
+
     resultsSection.classList.remove('hidden');
     resultsSection.scrollIntoView({ behavior: 'smooth', block: 'start' });
+    showQuizFlags(quizId, questions); // Owners can sort out flagged questions here
+}
+
+// ----------- Question Images -----------
+
+/**
+ * The picture a question is about, if it has one. Images are only served to
+ * their owner, so this works for quizzes you made yourself.
+ * @param {Object} item - The question
+ */
+function questionImage(item) {
+    if (!item.imageId) return '';
+    return `<img src="/api/media?id=${Number(item.imageId)}" alt="Picture for this question" class="question-image mb-3 max-h-80 max-w-full rounded-lg border border-gray-200">`;
+}
+
+// ----------- Markup -----------
+// Questions with "format": "markdown" can have **bold**, *italics*, `code`,
+// fenced code blocks and LaTeX math ($...$ inline, $$...$$ on its own).
+// The text is split the same way as markupSegments in markup.go, so the two
+// have to change together. Everything is escaped first; math is drawn by
+// KaTeX, or shown as code if it didn't load.
+
+function escapeMarkup(text) {
+    return String(text).replace(/&/g, '&amp;').replace(/</g, '&lt;').replace(/>/g, '&gt;').replace(/"/g, '&quot;');
+}
+
+/**
+ * Splits marked-up text into prose, code and math
+ * @param {string} s - The text
+ * @returns {Array} Segments of {kind, text, lang}
+ */
+function markupSegments(s) {
+    const segs = [];
+    let prose = '';
+    const add = seg => {
+        if (prose) segs.push({ kind: 'text', text: prose });
+        prose = '';
+        segs.push(seg);
+    };
+    let i = 0;
+    while (i < s.length) {
+        if ((i === 0 || s[i - 1] === '\n') && s.startsWith('```', i)) {
+            const seg = { kind: 'codeblock', text: '', lang: '' };
+            const nl = s.indexOf('\n', i + 3);
+            if (nl < 0) {
+                seg.lang = s.slice(i + 3); // Just the opening fence
+                i = s.length;
+            } else {
+                seg.lang = s.slice(i + 3, nl);
+                const body = s.slice(nl + 1);
+                const end = body.startsWith('```') ? 0 : body.indexOf('\n```');
+                if (end < 0) {
+                    seg.text = body;
+                    i = s.length;
+                } else {
+                    seg.text = body.slice(0, end);
+                    i = nl + 1 + (end === 0 ? 3 : end + 4);
+                }
+            }
+            seg.lang = seg.lang.trim();
+            add(seg);
+        } else if (s.startsWith('$$', i)) {
+            const end = s.indexOf('$$', i + 2);
+            if (end >= 0) {
+                add({ kind: 'displaymath', text: s.slice(i + 2, end) });
+                i = end + 2;
+            } else {
+                prose += '$$';
+                i += 2;
+            }
+        } else if (s[i] === '\\' && s[i + 1] === '$') {
+            prose += '\\$';
+            i += 2;
+        } else if (s[i] === '`' && s.indexOf('`', i + 1) > i + 1) {
+            const end = s.indexOf('`', i + 1);
+            add({ kind: 'code', text: s.slice(i + 1, end) });
+            i = end + 1;
+        } else if (s[i] === '$' && closingDollar(s, i) > 0) {
+            const end = closingDollar(s, i);
+            add({ kind: 'math', text: s.slice(i + 1, end) });
+            i = end + 1;
+        } else {
+            prose += s[i];
+            i++;
+        }
+    }
+    if (prose) segs.push({ kind: 'text', text: prose });
+    return segs;
+}
+
+/**
+ * The $ closing inline math opened at s[open], or -1. "$5 and $10" isn't math.
+ */
+function closingDollar(s, open) {
+    const next = s[open + 1];
+    if (next === undefined || next === ' ' || next === '\n' || next === '$') return -1;
+    for (let j = open + 2; j < s.length; j++) {
+        if (s[j] === '\n' && s[j + 1] === '\n') return -1; // Math doesn't run across paragraphs
+        if (s[j] !== '$' || s[j - 1] === '\\') continue;
+        if (s[j - 1] === ' ' || /[0-9]/.test(s[j + 1] || '')) continue;
+        return j;
+    }
+    return -1;
+}
+
+/**
+ * Marked-up text as HTML
+ * @param {string} text - Markdown with math and code
+ * @returns {string} HTML
+ */
+function renderMarkup(text) {
+    return markupSegments(String(text)).map(seg => {
+        const safe = escapeMarkup(seg.text);
+        switch (seg.kind) {
+            case 'code':
+                return `<code class="px-1 rounded bg-gray-100 text-sm font-mono">${safe}</code>`;
+            case 'codeblock':
+                return `<pre class="my-2 p-3 rounded-lg bg-gray-900 text-gray-100 text-sm font-mono overflow-x-auto text-left"><code>${safe}</code></pre>`;
+            case 'math':
+            case 'displaymath':
+                return renderMath(seg.text, seg.kind === 'displaymath');
+            default:
+                return safe.replace(/\\\$/g, '$')
+                    .replace(/\*\*([^*\n]+)\*\*/g, '<strong>$1</strong>')
+                    .replace(/\*([^*\s][^*\n]*)\*/g, '<em>$1</em>')
+                    .replace(/\n/g, '<br>');
+        }
+    }).join('');
+}
+
+function renderMath(tex, display) {
+    if (window.katex) {
+        try {
+            return katex.renderToString(tex, { displayMode: display, throwOnError: false, trust: false });
+        } catch (err) {
+            // Fall through and show the TeX
+        }
+    }
+    return `<code class="px-1 rounded bg-gray-100 text-sm font-mono">${escapeMarkup(tex)}</code>`;
+}
+
+/**
+ * A piece of a question's text as HTML: Markdown questions are rendered,
+ * anything else is escaped
+ * @param {Object} item - The question
+ * @param {string} text - Its question, an option, an explanation...
+ */
+function renderText(item, text) {
+    if (text == null) return '';
+    return item && item.format === 'markdown' ? renderMarkup(text) : escapeMarkup(text);
+}
+
+// ----------- Question Types -----------
+// Besides picking one option (multiple choice, true/false), questions can be
+// short answer, multi-select, ordering, matching, fill in the blank or
+// numeric. List answers travel as JSON text, as the server stores them.
+
+/**
+ * A question's type. Older questions have none, and are told apart by their options.
+ * @param {Object} item - The question
+ * @returns {string} multiple_choice, true_false, short_answer, multi_select, ordering, matching, cloze or numeric
+ */
+function questionKind(item) {
+    if (item.type) return item.type;
+    const options = item.options || [];
+    if (options.length === 0) return 'short_answer';
+    if (options.length === 2 && options[0].toLowerCase() === 'true' && options[1].toLowerCase() === 'false') return 'true_false';
+    return 'multiple_choice';
+}
+
+// Answered by clicking one option?
+function isSinglePick(item) {
+    const kind = questionKind(item);
+    return kind === 'multiple_choice' || kind === 'true_false';
+}
+
+/**
+ * Reads a list answer back from its JSON text. A plain string is a list of one.
+ * @param {string} answer
+ * @returns {Array<string>}
+ */
+function answerList(answer) {
+    if (typeof answer === 'string' && answer.trim().startsWith('[')) {
+        try {
+            const list = JSON.parse(answer);
+            if (Array.isArray(list)) return list.map(String);
+        } catch (err) { /* Not a list after all */ }
+    }
+    return answer ? [String(answer)] : [];
+}
+
+/**
+ * Reads a number the way people type one: "1,000", "3,5" or "9.81 m/s". Same rules as the server.
+ * @param {string} text
+ * @returns {number|null}
+ */
+function parseNumber(text) {
+    let s = String(text).trim().replace(/ /g, '');
+    if ((s.match(/,/g) || []).length === 1 && !s.includes('.') && !/,\d{3}(\D|$)/.test(s)) {
+        s = s.replace(',', '.'); // Decimal comma
+    }
+    const m = s.match(/^[-+]?(\d[\d,]*\.?\d*|\.\d+)([eE][-+]?\d+)?/);
+    if (!m) return null;
+    const n = parseFloat(m[0].replace(/,/g, ''));
+    return isFinite(n) ? n : null;
+}
+
+/**
+ * Marks an answer the same way the server does
+ * @param {Object} item - The question
+ * @param {string} answer - The answer, lists as JSON text
+ * @returns {boolean}
+ */
+function gradeLocally(item, answer) {
+    const normalize = s => String(s).toLowerCase().split(/\s+/).filter(Boolean).join(' ');
+    const sameText = (got, want) => normalize(got) !== '' && normalize(got) === normalize(want);
+    const list = answerList(answer);
+    const want = item.correctAnswers || [];
+    switch (questionKind(item)) {
+        case 'multiple_choice':
+        case 'true_false':
+            return answer === item.correctAnswer;
+        case 'short_answer':
+            return sameText(answer, item.correctAnswer);
+        case 'multi_select':
+            return list.length === want.length && new Set(list).size === list.length && list.every(a => want.includes(a));
+        case 'ordering':
+            return list.length === want.length && list.every((a, i) => a === want[i]);
+        case 'matching':
+            return list.length === item.pairs.length && item.pairs.every((p, i) => list[i] === p.right);
+        case 'cloze':
+            return list.length === want.length && want.every((w, i) => sameText(list[i], w));
+        case 'numeric': {
+            const got = parseNumber(answer), target = parseNumber(item.correctAnswer);
+            return got !== null && target !== null && Math.abs(got - target) <= (item.tolerance || 0) + 1e-9 * Math.max(1, Math.abs(target));
+        }
+    }
+    return false;
+}
+
+/**
+ * An answer as people read it: lists joined up, matches shown with what they match
+ * @param {Object} item - The question
+ * @param {string} answer - The answer, lists as JSON text
+ * @returns {string} HTML-safe text
+ */
+function formatAnswer(item, answer) {
+    const safe = s => renderText(item, s);
+    const kind = questionKind(item);
+    if (kind === 'matching' && String(answer).trim().startsWith('[')) {
+        return answerList(answer).map((right, i) => `${safe(item.pairs?.[i]?.left ?? item.left?.[i] ?? '?')} → ${safe(right)}`).join('; ');
+    }
+    if (kind === 'ordering' && String(answer).trim().startsWith('[')) {
+        return answerList(answer).map(safe).join(' → ');
+    }
+    let text = String(answer).trim().startsWith('[') ? answerList(answer).map(safe).join('; ') : safe(answer);
+    if (kind === 'numeric' && item.unit) text += ' ' + safe(item.unit);
+    return text;
+}
+
+/**
+ * How a typed or list answer went, with the right answer if it was wrong
+ * @param {Object} item - The question
+ * @param {string} answer - What they answered
+ * @param {boolean} isCorrect - Whether it was right
+ * @returns {string} HTML
+ */
+function typedAnswerFeedback(item, answer, isCorrect) {
+    const explanationHTML = item.explanation ? `
+        <div class="explanation-container">
+            <div class="explanation-title">Explanation</div>
+            <div class="explanation-content">${renderText(item, item.explanation)}</div>
+        </div>
+    ` : '';
+    return (isCorrect ? `
+        <div class="mt-4 p-3 rounded-lg bg-green-50 border border-green-200 text-green-900 flex items-center gap-3">
+            <span class="text-2xl">✅</span> <span class="font-bold">Correct!</span>
+        </div>
+    ` : `
+        <div class="mt-4 p-3 rounded-lg bg-red-50 border border-red-200 text-red-900">
+            <div class="flex items-center gap-3"><span class="text-2xl">❌</span> <span class="font-bold">Wrong!</span></div>
+            <div class="mt-2 text-sm">Your answer: ${formatAnswer(item, answer)}</div>
+            <div class="text-sm">The answer is ${formatAnswer(item, item.correctAnswer)}.</div>
+        </div>
+    `) + explanationHTML;
+}
+
+/**
+ * Builds the answer controls for questions that aren't a single pick.
+ * Question text goes through renderText, never in as HTML.
+ * @param {Object} item - The question (adaptive questions carry `left` instead of `pairs`)
+ * @param {Function} onAnswer - Called once, with the answer as a string (lists as JSON text)
+ * @returns {HTMLElement}
+ */
+function answerWidget(item, onAnswer) {
+    const box = document.createElement('div');
+    box.className = 'answer-widget mt-4 space-y-2';
+    const rowClass = 'flex items-center gap-2 p-3 rounded-lg border border-gray-200 bg-gray-50 text-gray-700';
+    const inputClass = 'flex-1 border border-gray-300 rounded-lg px-3 py-2';
+    const hint = text => {
+        const p = document.createElement('p');
+        p.className = 'text-sm text-gray-500';
+        p.textContent = text;
+        box.appendChild(p);
+    };
+    const textInput = placeholder => {
+        const input = document.createElement('input');
+        input.type = 'text';
+        input.className = inputClass;
+        input.placeholder = placeholder;
+        return input;
+    };
+    let read; // The answer so far, or null while it isn't complete
+
+    switch (questionKind(item)) {
+        case 'multi_select': {
+            hint('Select all that apply.');
+            const checks = item.options.map(option => {
+                const label = document.createElement('label');
+                label.className = rowClass + ' cursor-pointer';
+                label.innerHTML = '<input type="checkbox"><span></span>';
+                label.querySelector('span').innerHTML = renderText(item, option);
+                box.appendChild(label);
+                return label.querySelector('input');
+            });
+            read = () => {
+                const picked = item.options.filter((_, i) => checks[i].checked);
+                return picked.length ? picked : null;
+            };
+            break;
+        }
+        case 'ordering': {
+            hint('Put these in order with the arrows.');
+            const order = [...item.options];
+            const list = document.createElement('div');
+            list.className = 'space-y-2';
+            const draw = () => {
+                list.innerHTML = '';
+                order.forEach((text, i) => {
+                    const row = document.createElement('div');
+                    row.className = rowClass;
+                    row.innerHTML = `<span class="font-medium">${i + 1}.</span><span class="flex-1"></span>
+                        <button type="button" class="px-2 text-gray-500 hover:text-orange-600" title="Move up">↑</button>
+                        <button type="button" class="px-2 text-gray-500 hover:text-orange-600" title="Move down">↓</button>`;
+                    row.querySelector('.flex-1').innerHTML = renderText(item, text);
+                    const [up, down] = row.querySelectorAll('button');
+                    up.disabled = i === 0;
+                    down.disabled = i === order.length - 1;
+                    up.addEventListener('click', () => { [order[i - 1], order[i]] = [order[i], order[i - 1]]; draw(); });
+                    down.addEventListener('click', () => { [order[i + 1], order[i]] = [order[i], order[i + 1]]; draw(); });
+                    list.appendChild(row);
+                });
+            };
+            draw();
+            box.appendChild(list);
+            read = () => [...order];
+            break;
+        }
+        case 'matching': {
+            const lefts = item.left || (item.pairs || []).map(p => p.left);
+            const selects = lefts.map(left => {
+                const row = document.createElement('div');
+                row.className = rowClass;
+                row.innerHTML = '<span class="flex-1"></span><span>→</span><select class="flex-1 border border-gray-300 rounded-lg px-2 py-1"><option value="">Choose…</option></select>';
+                row.querySelector('span').innerHTML = renderText(item, left);
+                const select = row.querySelector('select');
+                item.options.forEach(option => select.add(new Option(option, option)));
+                box.appendChild(row);
+                return select;
+            });
+            read = () => selects.every(sel => sel.value) ? selects.map(sel => sel.value) : null;
+            break;
+        }
+        case 'cloze': {
+            const blanks = (item.question.match(/_{3,}/g) || []).length || 1;
+            const inputs = Array.from({length: blanks}, (_, i) => {
+                const row = document.createElement('div');
+                row.className = 'flex items-center gap-2';
+                row.innerHTML = `<span class="font-medium text-gray-700">${i + 1}.</span>`;
+                const input = textInput(blanks > 1 ? `Blank ${i + 1}` : 'Fill in the blank');
+                row.appendChild(input);
+                box.appendChild(row);
+                return input;
+            });
+            read = () => inputs.every(input => input.value.trim()) ? inputs.map(input => input.value.trim()) : null;
+            break;
+        }
+        case 'numeric': {
+            const row = document.createElement('div');
+            row.className = 'flex items-center gap-2';
+            const input = textInput('Your answer');
+            input.inputMode = 'decimal';
+            row.appendChild(input);
+            if (item.unit) {
+                const unit = document.createElement('span');
+                unit.className = 'text-gray-700';
+                unit.textContent = item.unit;
+                row.appendChild(unit);
+            }
+            box.appendChild(row);
+            read = () => parseNumber(input.value) !== null ? input.value.trim() : null;
+            break;
+        }
+        default: {
+            // Short answer: type it in
+            const input = textInput('Your answer');
+            box.appendChild(input);
+            read = () => input.value.trim() || null;
+        }
+    }
+
+    const btn = document.createElement('button');
+    btn.type = 'button';
+    btn.className = 'bg-orange-500 hover:bg-orange-600 text-white font-medium px-4 py-2 rounded-lg';
+    btn.textContent = 'Answer';
+    btn.addEventListener('click', () => {
+        const value = read();
+        if (value === null) return;
+        lockAnswerWidget(box);
+        onAnswer(Array.isArray(value) ? JSON.stringify(value) : value);
+    });
+    box.addEventListener('keydown', e => {
+        if (e.key === 'Enter' && e.target.type === 'text') btn.click();
+    });
+    box.appendChild(btn);
+    return box;
+}
+
+// Stop an answer widget taking any more input
+function lockAnswerWidget(box) {
+    box.querySelectorAll('input, select, button').forEach(el => el.disabled = true);
+}
+
+// ----------- Timed Quizzes -----------
+
+let timedAttempt = null; // Attempt status from the server while a timed quiz is on screen
+let quizTimerInterval = null; // Ticks the countdown once a second
+
+/**
+ * Starts (or picks up) the server's clock on a quiz
+ * @param {number} quizId - Quiz to take
+ * @returns {Promise<Object>} Attempt status: expires_at, answers, score...
+ */
+async function startTimedAttempt(quizId) {
+    const resp = await fetch('/api/attempts/start', {
+        method: 'POST',
+        headers: {'Content-Type': 'application/json', 'X-CSRF-Token': getCSRFToken()},
+        body: JSON.stringify({ quiz_id: quizId })
+    });
+    if (!resp.ok) {
+        throw new Error(await resp.text() || 'Could not start the timer');
+    }
+    return await resp.json();
+}
+
+/**
+ * Starts the clock on a freshly generated quiz if it has a time limit
+ * @param {number} quizId - The saved quiz
+ * @returns {Promise<Object|null>} Attempt status, or null for untimed quizzes
+ */
+async function startTimedAttemptIfTimed(quizId) {
+    const resp = await fetch(`/api/quiz-detail?id=${quizId}`);
+    if (!resp.ok) return null;
+    const detail = await resp.json();
+    if (!detail.time_limit && !detail.question_time_limit) return null;
+    return await startTimedAttempt(quizId);
+}
+
+/**
+ * Sends one answer of a timed quiz to be marked
+ * @param {number} index - Question number (from 0)
+ * @param {string} answer - Chosen option
+ * @returns {Promise<Object|null>} The marked answer, or null if time ran out
+ */
+async function sendTimedAnswer(index, answer) {
+    try {
+        const resp = await fetch('/api/attempts/answer', {
+            method: 'POST',
+            headers: {'Content-Type': 'application/json', 'X-CSRF-Token': getCSRFToken()},
+            body: JSON.stringify({ quiz_id: timedAttempt.quiz_id, question: index, answer })
+        });
+        if (!resp.ok) {
+            lockTimedQuiz(await resp.text() || 'This quiz is closed.');
+            return null;
+        }
+        const result = await resp.json();
+        timedAttempt = result;
+        startQuizTimer(result); // The next question's clock has started
+        return result;
+    } catch (err) {
+        lockTimedQuiz('Could not send your answer: ' + err.message);
+        return null;
+    }
+}
+
+/**
+ * Fills in a question's answer once the server has marked it (quiz detail
+ * leaves answers out while a timed attempt is going)
+ * @param {Object} item - The question
+ * @param {Object} result - What /api/attempts/answer sent back
+ */
+function revealAnswer(item, result) {
+    item.correctAnswer = result.correctAnswer;
+    item.explanation = result.explanation;
+}
+
+/**
+ * Shows a countdown to when the next answer has to be in
+ * @param {Object} status - Attempt status with expires_at and server_time
+ */
+function startQuizTimer(status) {
+    stopQuizTimer();
+    if (!status.expires_at) return;
+
+    let bar = document.getElementById('quizTimerBar');
+    if (!bar) {
+        bar = document.createElement('div');
+        bar.id = 'quizTimerBar';
+        bar.className = 'sticky top-0 z-10 p-3 rounded-lg bg-orange-50 border border-orange-200 text-orange-900 font-semibold text-center';
+        quizContainer.prepend(bar);
+    }
+
+    // Count down on the server's clock, in case this computer's is off
+    const offset = Date.parse(status.server_time) - Date.now();
+    const expires = Date.parse(status.expires_at);
+    const label = status.question_time_limit ? 'Time left for this question' : 'Time left';
+    const tick = () => {
+        const left = Math.max(0, Math.ceil((expires - (Date.now() + offset)) / 1000));
+        bar.textContent = `${label}: ${Math.floor(left / 60)}:${String(left % 60).padStart(2, '0')}`;
+        if (left === 0) {
+            lockTimedQuiz("Time's up - your answers so far have been submitted.");
+            setTimeout(refreshSessionAndHistory, 3000); // Once the server has caught up
+        }
+    };
+    tick();
+    quizTimerInterval = setInterval(tick, 1000);
+}
+
+function stopQuizTimer() {
+    if (quizTimerInterval) {
+        clearInterval(quizTimerInterval);
+        quizTimerInterval = null;
+    }
+}
+
+/**
+ * Stops a timed quiz from taking more answers
+ * @param {string} message - Why, shown where the countdown was
+ */
+function lockTimedQuiz(message) {
+    stopQuizTimer();
+    timedAttempt = null;
+    quizContainer.querySelectorAll('button.option-btn').forEach(btn => {
+        btn.disabled = true;
+        btn.classList.add('cursor-not-allowed', 'opacity-75');
+    });
+    const bar = document.getElementById('quizTimerBar');
+    if (bar) {
+        bar.textContent = message;
+        bar.classList.replace('bg-orange-50', 'bg-red-50');
+        bar.classList.replace('text-orange-900', 'text-red-900');
+    }
+}
+
+// ----------- Adaptive Quizzes -----------
+
+const ADAPTIVE_KEY = 'askify_adaptive_quiz'; // localStorage key for the adaptive quiz under way
+
+/**
+ * Starts an adaptive quiz and shows its first question
+ * @param {string} topic - What the quiz is about
+ * @param {string} quizType - Multiple Choice, True/False, Short Answer or Mixed
+ * @param {string} language - Language code, or empty for no particular one
+ */
+async function startAdaptiveQuiz(topic, quizType, language) {
+    if (!currentUser) {
+        alert('Log in to take an adaptive quiz - it keeps track of how you do.');
+        showLoginModal(true);
+        return;
+    }
+    setGenerating(true);
+    try {
+        const resp = await fetch('/api/adaptive/start', {
+            method: 'POST',
+            headers: {'Content-Type': 'application/json', 'X-CSRF-Token': getCSRFToken()},
+            body: JSON.stringify({ topic, quizType, language })
+        });
+        if (!resp.ok) {
+            throw new Error(await resp.text() || 'Could not start the quiz');
+        }
+        const status = await resp.json();
+        localStorage.setItem(ADAPTIVE_KEY, status.id);
+        showAdaptiveQuiz(status);
+    } catch (error) {
+        alert('Error starting adaptive quiz: ' + error.message);
+    } finally {
+        setGenerating(false);
+    }
+}
+
+/**
+ * Picks up an adaptive quiz that was under way before a refresh
+ */
+async function resumeAdaptiveQuiz() {
+    const id = localStorage.getItem(ADAPTIVE_KEY);
+    if (!id) return;
+    try {
+        const resp = await fetch(`/api/adaptive?id=${encodeURIComponent(id)}`);
+        if (!resp.ok) {
+            localStorage.removeItem(ADAPTIVE_KEY);
+            return;
+        }
+        const status = await resp.json();
+        if (status.finished) {
+            localStorage.removeItem(ADAPTIVE_KEY);
+            return;
+        }
+        showAdaptiveQuiz(status);
+    } catch (err) {
+        // Try again next time the page loads
+    }
+}
+
+/**
+ * Clears the quiz area and shows where an adaptive quiz has got to
+ * @param {Object} status - From the /api/adaptive endpoints
+ */
+function showAdaptiveQuiz(status) {
+    stopQuizTimer();
+    timedAttempt = null;
+    quizContainer.innerHTML = '';
+    showAdaptiveStatus(status);
+    resultsSection.classList.remove('hidden');
+    resultsSection.scrollIntoView({ behavior: 'smooth', block: 'start' });
+}
+
+/**
+ * Adds the waiting question, the final report, or a way to fetch the next question
+ * @param {Object} status - From the /api/adaptive endpoints
+ */
+function showAdaptiveStatus(status) {
+    if (status.finished) {
+        showAdaptiveReport(status);
+    } else if (status.question) {
+        showAdaptiveQuestion(status);
+    } else {
+        showAdaptiveRetry(status.id, 'The next question is not ready yet.');
+    }
+}
+
+/**
+ * Shows one adaptive question. Question text goes through renderText, never in as HTML.
+ * @param {Object} status - Has the question waiting for an answer
+ */
+function showAdaptiveQuestion(status) {
+    const q = status.question;
+    const card = document.createElement('div');
+    card.className = 'bg-white border border-gray-200 rounded-xl p-6 shadow-sm';
+    card.innerHTML = `
+        <div class="flex items-start gap-3">
+            <span class="bg-orange-500 text-white font-bold rounded-full w-8 h-8 flex items-center justify-center flex-shrink-0">${q.index + 1}</span>
+            <div class="flex-1">
+                <p class="text-xs text-gray-500 mb-1">${q.level} question · estimated level so far: ${status.level}</p>
+                <h3 class="text-lg font-semibold text-gray-900 mb-2"></h3>
+                ${questionImage(q)}
+                <div class="mt-4 space-y-2 adaptive-options"></div>
+                <div class="adaptive-feedback"></div>
+            </div>
+        </div>
+    `;
+    card.querySelector('h3').innerHTML = renderText(q, q.question);
+    const optionsDiv = card.querySelector('.adaptive-options');
+    let answered = false;
+    const submit = async (answer, chosen) => {
+        if (answered || !answer) return;
+        answered = true;
+        optionsDiv.querySelectorAll('button, input').forEach(el => el.disabled = true);
+        chosen?.classList.add('bg-orange-100', 'border-orange-400');
+        await answerAdaptive(status.id, answer, card, q);
+    };
+
+    if (isSinglePick(q)) {
+        q.options.forEach((option, i) => {
+            const btn = document.createElement('button');
+            btn.type = 'button';
+            btn.className = 'option-btn w-full text-left flex items-center gap-2 p-3 rounded-lg border border-gray-200 bg-gray-50 text-gray-700 hover:bg-orange-50 transition-colors focus:outline-none';
+            btn.innerHTML = `${String.fromCharCode(65 + i)}. ${renderText(q, option)}`;
+            btn.addEventListener('click', () => submit(option, btn));
+            optionsDiv.appendChild(btn);
+        });
+    } else {
+        // Typed answers, and everything else that isn't a single pick
+        optionsDiv.appendChild(answerWidget(q, answer => submit(answer)));
+    }
+    quizContainer.appendChild(card);
+    card.scrollIntoView({ behavior: 'smooth', block: 'center' });
+}
+
+/**
+ * Sends an adaptive answer, shows how it went, then moves on
+ * @param {number} id - The adaptive quiz
+ * @param {string} answer - What the learner chose or typed
+ * @param {HTMLElement} card - The question's card
+ * @param {Object} item - The question
+ */
+async function answerAdaptive(id, answer, card, item) {
+    const feedbackDiv = card.querySelector('.adaptive-feedback');
+    try {
+        const resp = await fetch('/api/adaptive/answer', {
+            method: 'POST',
+            headers: {'Content-Type': 'application/json', 'X-CSRF-Token': getCSRFToken()},
+            body: JSON.stringify({ id, answer })
+        });
+        if (!resp.ok) {
+            throw new Error(await resp.text() || 'Could not send your answer');
+        }
+        const result = await resp.json();
+
+        feedbackDiv.innerHTML = result.correct ? `
+            <div class="mt-4 p-3 rounded-lg bg-green-50 border border-green-200 text-green-900 flex items-center gap-3">
+                <span class="text-2xl">✅</span> <span class="font-bold">Correct!</span>
+            </div>
+        ` : `
+            <div class="mt-4 p-3 rounded-lg bg-red-50 border border-red-200 text-red-900 flex items-center gap-3">
+                <span class="text-2xl">❌</span> <span class="font-bold">Wrong!</span> <span class="adaptive-correct"></span>
+            </div>
+        `;
+        if (!result.correct) {
+            feedbackDiv.querySelector('.adaptive-correct').innerHTML = `The answer is ${formatAnswer(item, result.correctAnswer)}.`;
+        }
+        if (result.explanation) {
+            const explanation = document.createElement('div');
+            explanation.className = 'explanation-container';
+            explanation.innerHTML = '<div class="explanation-title">Explanation</div><div class="explanation-content"></div>';
+            explanation.querySelector('.explanation-content').innerHTML = renderText(item, result.explanation);
+            feedbackDiv.appendChild(explanation);
+        }
+
+        if (result.next_error) {
+            showAdaptiveRetry(id, result.next_error);
+        } else {
+            showAdaptiveStatus(result.status);
+        }
+    } catch (err) {
+        feedbackDiv.innerHTML = '<div class="mt-4 p-3 rounded-lg bg-red-50 border border-red-200 text-red-900"></div>';
+        feedbackDiv.firstElementChild.textContent = err.message;
+    }
+}
+
+/**
+ * Offers to try getting the next question again
+ * @param {number} id - The adaptive quiz
+ * @param {string} message - What went wrong
+ */
+function showAdaptiveRetry(id, message) {
+    const box = document.createElement('div');
+    box.className = 'p-4 rounded-lg bg-orange-50 border border-orange-200 text-orange-900 flex items-center justify-between gap-3';
+    box.innerHTML = '<span></span><button type="button" class="bg-orange-500 hover:bg-orange-600 text-white font-medium px-4 py-2 rounded-lg">Next question</button>';
+    box.querySelector('span').textContent = message;
+    box.querySelector('button').addEventListener('click', async () => {
+        box.querySelector('button').disabled = true;
+        try {
+            const resp = await fetch('/api/adaptive/next', {
+                method: 'POST',
+                headers: {'Content-Type': 'application/json', 'X-CSRF-Token': getCSRFToken()},
+                body: JSON.stringify({ id })
+            });
+            if (!resp.ok) {
+                throw new Error(await resp.text() || 'Could not get the next question');
+            }
+            box.remove();
+            showAdaptiveStatus(await resp.json());
+        } catch (err) {
+            box.querySelector('span').textContent = err.message;
+            box.querySelector('button').disabled = false;
+        }
+    });
+    quizContainer.appendChild(box);
+}
+
+/**
+ * Shows the learner's estimated level once the adaptive quiz is done
+ * @param {Object} status - Finished status with chances per difficulty
+ */
+function showAdaptiveReport(status) {
+    localStorage.removeItem(ADAPTIVE_KEY);
+    const chances = Object.entries(status.chances || {})
+        .map(([level, p]) => `<div class="flex justify-between"><span>${level}</span><span class="font-semibold">${Math.round(p * 100)}%</span></div>`)
+        .join('');
+    const report = document.createElement('div');
+    report.id = 'quiz-summary-score';
+    report.className = 'mt-8 mb-6 p-6 bg-gray-100 rounded-xl border border-gray-300 shadow';
+    report.innerHTML = `
+        <div class="text-center text-2xl font-bold mb-2">Your level: <span class="text-orange-600">${status.level}</span></div>
+        <p class="text-center text-gray-600 mb-4">${status.correct} of ${status.answered} correct · ability ${status.ability.toFixed(2)} ± ${status.std_error.toFixed(2)}</p>
+        <div class="max-w-xs mx-auto text-gray-700">
+            <p class="text-sm text-gray-500 mb-1">Chance of getting a question right:</p>
+            ${chances}
+        </div>
+    `;
+    quizContainer.appendChild(report);
+    report.scrollIntoView({ behavior: 'smooth', block: 'center' });
+    if (status.quiz_id) {
+        currentQuizId = status.quiz_id;
+        refreshSessionAndHistory(); // It's in their history now
+    }
 }
 
 /**
@@ -1029,6 +2095,261 @@ function resetQuizInterface() {
     
     // Reset current quiz ID
     currentQuizId = null;
+    stopQuizTimer();
+    timedAttempt = null;
+}
+
+// ----------- Languages -----------
+
+/**
+ * What a language calls itself, taken from the language picker
+ * @param {string} code - Language code, e.g. "fr"
+ * @returns {string} Its name, or empty if the picker doesn't have it
+ */
+function languageLabel(code) {
+    const option = document.querySelector(`#quizLanguage option[value="${CSS.escape(code)}"]`);
+    return option && code ? option.textContent : '';
+}
+
+/**
+ * Builds the "translate this quiz" row shown under a saved quiz. The
+ * translation is saved as a new quiz, which is opened once it's ready.
+ * @param {number} quizId - The saved quiz
+ */
+function translateControl(quizId) {
+    const row = document.createElement('div');
+    row.className = 'mt-4 flex flex-wrap items-center gap-2 text-sm';
+    row.innerHTML = `
+        <span class="text-gray-700">Translate into</span>
+        <select class="translate-language border border-gray-300 rounded-lg px-2 py-1"></select>
+        <button type="button" class="translate-btn bg-white border border-gray-300 rounded-lg px-3 py-1 text-gray-700 font-medium hover:bg-gray-50">Translate</button>
+        <span class="translate-status text-gray-500"></span>
+    `;
+    const select = row.querySelector('select');
+    document.querySelectorAll('#quizLanguage option').forEach(option => {
+        if (option.value) select.appendChild(option.cloneNode(true));
+    });
+
+    const button = row.querySelector('button');
+    const status = row.querySelector('.translate-status');
+    button.addEventListener('click', async () => {
+        button.disabled = true;
+        status.textContent = 'Translating...';
+        try {
+            const resp = await fetch('/api/quiz/translate', {
+                method: 'POST',
+                headers: {'Content-Type': 'application/json', 'X-CSRF-Token': getCSRFToken()},
+                body: JSON.stringify({quiz_id: quizId, language: select.value})
+            });
+            if (!resp.ok) throw new Error((await resp.text()).trim());
+            const result = await resp.json();
+            await loadPastQuiz(result.quiz_id);
+            refreshSessionAndHistory();
+        } catch (err) {
+            status.textContent = err.message || 'Could not translate the quiz.';
+            button.disabled = false;
+        }
+    });
+    return row;
+}
+
+// ----------- Question Flags -----------
+
+// Why a question can be flagged, as people see it
+const FLAG_REASONS = {
+    wrong_answer: 'The answer is wrong',
+    ambiguous: 'It could have more than one answer',
+    off_topic: "It's off-topic"
+};
+
+/**
+ * Adds a "Report a problem" link to a saved quiz's question card
+ * @param {HTMLElement} card - The question's card
+ * @param {number} index - Which question it is
+ * @param {Function} onFlagged - Called once the flag is saved
+ */
+function addFlagControl(card, index, onFlagged) {
+    if (!currentUser || !currentQuizId) return; // Only saved quizzes can be flagged
+    const box = document.createElement('div');
+    card.querySelector('.flex-1').appendChild(box);
+    showFlagLink(box, index, onFlagged);
+}
+
+/**
+ * Puts the "Report a problem" link (back) in its box
+ */
+function showFlagLink(box, index, onFlagged) {
+    box.className = 'mt-3 text-right';
+    box.innerHTML = '<button type="button" class="text-xs text-gray-400 hover:text-orange-600">⚑ Report a problem</button>';
+    box.querySelector('button').addEventListener('click', () => showFlagForm(box, index, onFlagged));
+}
+
+/**
+ * Swaps the link for a form asking what's wrong with the question
+ */
+function showFlagForm(box, index, onFlagged) {
+    const quizId = currentQuizId;
+    box.className = 'mt-3 p-3 rounded-lg bg-gray-50 border border-gray-200 text-left';
+    box.innerHTML = `
+        <p class="text-sm font-medium text-gray-700 mb-2">What's wrong with this question?</p>
+        <select class="flag-reason w-full border border-gray-300 rounded-lg px-3 py-2 text-sm mb-2">
+            ${Object.entries(FLAG_REASONS).map(([value, label]) => `<option value="${value}">${label}</option>`).join('')}
+        </select>
+        <input type="text" maxlength="500" class="flag-comment w-full border border-gray-300 rounded-lg px-3 py-2 text-sm mb-2" placeholder="Anything to add? (optional)">
+        <div class="flex gap-2 justify-end">
+            <button type="button" class="flag-cancel text-sm text-gray-500 px-3 py-1">Cancel</button>
+            <button type="button" class="flag-send bg-orange-500 hover:bg-orange-600 text-white text-sm font-medium px-3 py-1 rounded-lg">Report</button>
+        </div>
+        <p class="flag-error text-xs text-red-600 mt-2 hidden"></p>
+    `;
+    box.querySelector('.flag-cancel').addEventListener('click', () => showFlagLink(box, index, onFlagged));
+    box.querySelector('.flag-send').addEventListener('click', async function () {
+        this.disabled = true;
+        const error = box.querySelector('.flag-error');
+        try {
+            const resp = await fetch('/api/flags', {
+                method: 'POST',
+                headers: {'Content-Type': 'application/json', 'X-CSRF-Token': getCSRFToken()},
+                body: JSON.stringify({
+                    quiz_id: quizId,
+                    question: index,
+                    reason: box.querySelector('.flag-reason').value,
+                    comment: box.querySelector('.flag-comment').value
+                })
+            });
+            if (!resp.ok) throw new Error((await resp.text()).trim());
+            box.className = 'mt-3 text-right text-xs text-gray-500';
+            box.textContent = 'Thanks, this question has been reported.';
+            if (onFlagged) onFlagged();
+        } catch (err) {
+            error.textContent = err.message || 'Could not report the question.';
+            error.classList.remove('hidden');
+            this.disabled = false;
+        }
+    });
+}
+
+/**
+ * Shows the open flags on a quiz under their questions, with ways to
+ * dismiss them or fix the question's answer
+ * @param {number} quizId - The quiz on screen
+ * @param {Array} questions - Its questions
+ */
+async function showQuizFlags(quizId, questions) {
+    let flags;
+    try {
+        const resp = await fetch(`/api/flags?quiz_id=${quizId}&status=open`);
+        if (!resp.ok) return;
+        flags = (await resp.json()).flags;
+    } catch (err) {
+        return;
+    }
+    if (quizId !== currentQuizId) return; // They've moved on to another quiz
+
+    const byQuestion = {};
+    flags.forEach(f => (byQuestion[f.question] = byQuestion[f.question] || []).push(f));
+    quizContainer.querySelectorAll('.flag-review').forEach(box => box.remove());
+    Object.entries(byQuestion).forEach(([index, list]) => {
+        const card = quizContainer.querySelector(`[data-question="${index}"]`);
+        if (card && questions[index]) {
+            card.querySelector('.flex-1').appendChild(flagReviewBox(quizId, Number(index), questions[index], list));
+        }
+    });
+}
+
+/**
+ * Builds the owner's review box for one flagged question. Flag comments go
+ * in as text, not HTML.
+ * @param {number} quizId - The quiz
+ * @param {number} index - Which question
+ * @param {Object} question - The question as it is now
+ * @param {Array} flags - Its open flags
+ */
+function flagReviewBox(quizId, index, question, flags) {
+    const box = document.createElement('div');
+    box.className = 'flag-review mt-4 p-3 rounded-lg bg-amber-50 border border-amber-200';
+    box.innerHTML = `
+        <p class="text-sm font-semibold text-amber-800 mb-1">Flagged ${flags.length === 1 ? 'once' : `${flags.length} times`}</p>
+        <ul class="text-sm text-amber-900 list-disc ml-5 mb-3"></ul>
+        <div class="flex flex-wrap items-center gap-2">
+            <span class="text-sm text-gray-700">Correct answer:</span>
+            <span class="flag-answer flex-1"></span>
+            <button type="button" class="flag-fix bg-orange-500 hover:bg-orange-600 text-white text-sm font-medium px-3 py-1 rounded-lg">Fix</button>
+            <button type="button" class="flag-dismiss text-sm text-gray-600 border border-gray-300 px-3 py-1 rounded-lg">Dismiss</button>
+        </div>
+        <p class="flag-error text-xs text-red-600 mt-2 hidden"></p>
+    `;
+    const list = box.querySelector('ul');
+    flags.forEach(f => {
+        const li = document.createElement('li');
+        li.textContent = (FLAG_REASONS[f.reason] || f.reason) + (f.comment ? ` - "${f.comment}"` : '');
+        list.appendChild(li);
+    });
+
+    // Pick from the options, or type a short answer. List answers are typed
+    // separated by semicolons, matches as "left = right".
+    const kind = questionKind(question);
+    let answerInput;
+    if (isSinglePick(question)) {
+        answerInput = document.createElement('select');
+        question.options.forEach(option => {
+            const opt = document.createElement('option');
+            opt.value = opt.textContent = option;
+            opt.selected = option === question.correctAnswer;
+            answerInput.appendChild(opt);
+        });
+    } else {
+        answerInput = document.createElement('input');
+        answerInput.type = 'text';
+        answerInput.value = question.correctAnswer || '';
+        if (kind === 'multi_select' || kind === 'ordering' || kind === 'cloze') {
+            answerInput.value = (question.correctAnswers || []).join('; ');
+        } else if (kind === 'matching') {
+            answerInput.value = (question.pairs || []).map(p => `${p.left} = ${p.right}`).join('; ');
+        }
+    }
+    answerInput.className = 'w-full border border-gray-300 rounded-lg px-2 py-1 text-sm';
+    box.querySelector('.flag-answer').appendChild(answerInput);
+
+    const error = box.querySelector('.flag-error');
+    const resolve = async (body) => {
+        box.querySelectorAll('button').forEach(b => b.disabled = true);
+        try {
+            const resp = await fetch('/api/flags/resolve', {
+                method: 'POST',
+                headers: {'Content-Type': 'application/json', 'X-CSRF-Token': getCSRFToken()},
+                body: JSON.stringify({quiz_id: quizId, question: index, ...body})
+            });
+            if (!resp.ok) throw new Error((await resp.text()).trim());
+            return await resp.json();
+        } catch (err) {
+            error.textContent = err.message || 'Could not save that.';
+            error.classList.remove('hidden');
+            box.querySelectorAll('button').forEach(b => b.disabled = false);
+            return null;
+        }
+    };
+    box.querySelector('.flag-dismiss').addEventListener('click', async () => {
+        if (await resolve({action: 'dismiss'})) box.remove();
+    });
+    box.querySelector('.flag-fix').addEventListener('click', async () => {
+        const parts = answerInput.value.split(';').map(part => part.trim()).filter(Boolean);
+        let fix = {correctAnswer: answerInput.value};
+        if (kind === 'multi_select' || kind === 'ordering' || kind === 'cloze') {
+            fix = {correctAnswers: parts};
+        } else if (kind === 'matching') {
+            fix = {pairs: parts.map(part => {
+                const [left, ...right] = part.split('=');
+                return {left: left.trim(), right: right.join('=').trim()};
+            })};
+        }
+        const result = await resolve({action: 'fix', fix});
+        if (!result) return;
+        // Scores may have changed, so show the quiz and history again
+        await loadPastQuiz(quizId);
+        refreshSessionAndHistory();
+    });
+    return box;
 }
 
 // ----------- Quiz Export Functionality -----------
@@ -1050,8 +2371,15 @@ copyBtn?.addEventListener('click', () => {
     });
 });
 
-// Download quiz as text file
+// Download quiz as text file. Saved quizzes come from the server, which
+// writes every question type out in full (and zips the text up with the
+// pictures when there are any); unsaved ones are copied off the page.
 downloadBtn?.addEventListener('click', () => {
+    if (currentQuizId) {
+        const format = document.querySelector('#quizContainer img.question-image') ? 'zip' : 'txt';
+        window.location = `/api/quiz/export?id=${currentQuizId}&format=${format}`;
+        return;
+    }
     const quizText = Array.from(document.querySelectorAll('#quizContainer > div')).map((card, index) => {
         return card.innerText;
     }).join('\n\n');
@@ -1065,4 +2393,13 @@ downloadBtn?.addEventListener('click', () => {
     a.click();
     document.body.removeChild(a);
     URL.revokeObjectURL(url);
-});
\ No newline at end of file
+});
+
+// Download a saved quiz in GIFT format, for importing into Moodle
+giftBtn?.addEventListener('click', () => {
+    if (!currentQuizId) {
+        alert('Log in to save the quiz, then you can export it for Moodle.');
+        return;
+    }
+    window.location = `/api/quiz/export?id=${currentQuizId}&format=gift`;
+});
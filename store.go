@@ -0,0 +1,1500 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ============================================================================
+// STORAGE LAYER - All the SQL lives here, handlers only see these interfaces
+// ============================================================================
+
+// Returned when a row the caller asked for doesn't exist (or isn't theirs)
+var ErrNotFound = errors.New("not found")
+
+// Returned when a timed attempt can't take any more answers
+var (
+	ErrAttemptFinished = errors.New("attempt already finished")
+	ErrAlreadyAnswered = errors.New("question already answered")
+)
+
+// Returned when a quiz was changed by someone else between reading and saving it
+var ErrQuizChanged = errors.New("quiz changed since it was read")
+
+// Everything we store about users
+type UserStore interface {
+	CreateUser(ctx context.Context, email, passwordHash, name string) (int, error)
+	UserByID(ctx context.Context, id int) (*User, error)
+	UserByEmail(ctx context.Context, email string) (*User, string, error) // Also returns the password hash
+}
+
+// Everything we store about generated quizzes
+type QuizStore interface {
+	SaveQuiz(ctx context.Context, userID int, prompt, questionsJSON, promptVersion, model string) (int, error)
+	QuizHistory(ctx context.Context, userID int) ([]QuizHistoryItem, error)
+	GetQuiz(ctx context.Context, userID, quizID int) (*Quiz, error)
+	SetQuizTiming(ctx context.Context, userID, quizID, timeLimit, questionTimeLimit int) error
+}
+
+// Everything we store about quiz attempts and scores
+type AttemptStore interface {
+	SaveAttempt(ctx context.Context, userID int, req SaveQuizAttemptRequest) error
+	GetAttempt(ctx context.Context, userID, quizID int) (*Attempt, error)
+
+	// Timed attempts, where the server keeps the clock and the score
+	StartAttempt(ctx context.Context, userID, quizID int, now, deadline, expires time.Time) (*Attempt, bool, error) // False if it was already started (and is returned as it was)
+	RecordAnswer(ctx context.Context, attemptID, questionCount int, ans AttemptAnswer, now, expires time.Time) (*Attempt, error)
+	AttemptAnswers(ctx context.Context, attemptID int) ([]AttemptAnswer, error)
+	ExpireAttempts(ctx context.Context, before time.Time) (int, error) // Auto-submit attempts whose time ran out before this
+	QuizAttempts(ctx context.Context, quizID int) ([]Attempt, error)   // Everyone's attempts at a quiz
+}
+
+// Everything we store about personal API tokens
+type TokenStore interface {
+	CreateToken(ctx context.Context, userID int, name, tokenHash string, scopes []string) (int, error)
+	ListTokens(ctx context.Context, userID int) ([]APIToken, error)
+	TokenUser(ctx context.Context, tokenHash string) (*User, int, []string, error) // User, token ID, scopes
+	TouchToken(ctx context.Context, tokenID int) error
+	RevokeToken(ctx context.Context, userID, tokenID int) error
+}
+
+// The queue of background quiz generations
+type JobStore interface {
+	CreateJob(ctx context.Context, id string, userID int, requestJSON string) error
+	GetJob(ctx context.Context, id string) (*Job, error)
+	ClaimJob(ctx context.Context, leaseUntil time.Time) (*Job, error) // Oldest queued job, now marked running; ErrNotFound if the queue is empty
+	FinishJob(ctx context.Context, id, resultJSON string, quizID int) error
+	FailJob(ctx context.Context, id, code, message string) error
+	RenewJobLease(ctx context.Context, id string, leaseUntil time.Time) error // The worker is still on it
+	RequeueExpiredJobs(ctx context.Context, now time.Time) (int, error)       // Put back running jobs whose worker stopped renewing them
+}
+
+// Prompt templates saved by admins (they override the built-in ones)
+type PromptStore interface {
+	ListPrompts(ctx context.Context) ([]PromptTemplate, error)
+	SavePrompt(ctx context.Context, p PromptTemplate) error
+}
+
+// Generated question pools kept for identical requests
+type CacheStore interface {
+	CachedPool(ctx context.Context, key string, now time.Time) (*CachedPool, error) // ErrNotFound if missing or expired
+	SaveCachedPool(ctx context.Context, pool CachedPool) error                      // Also clears out expired pools
+}
+
+// Each user's reusable questions, pulled out of their quizzes
+type BankStore interface {
+	AddToBank(ctx context.Context, userID, quizID int, questions []BankQuestion) error
+	SearchBank(ctx context.Context, userID int, f BankFilter) ([]BankQuestion, error)
+	BankQuestions(ctx context.Context, userID int, ids []int) ([]BankQuestion, error) // In the order asked for; unknown IDs are left out
+	SetBankTags(ctx context.Context, userID, id int, tags []string) error
+}
+
+// Results of finished live sessions
+type LiveStore interface {
+	SaveLiveSession(ctx context.Context, result LiveSessionResult) (int, error)
+	LiveSessions(ctx context.Context, hostUserID, limit int) ([]LiveSessionResult, error) // Newest first, players best first
+}
+
+// Adaptive quizzes, in progress and finished
+type AdaptiveStore interface {
+	CreateAdaptive(ctx context.Context, q *AdaptiveQuiz) (int, error)
+	GetAdaptive(ctx context.Context, userID, id int) (*AdaptiveQuiz, error)
+	// Save q's progress, unless another request changed it first: the stored
+	// quiz must still be at revision, or it's ErrAlreadyAnswered
+	UpdateAdaptive(ctx context.Context, q *AdaptiveQuiz, revision int) error
+}
+
+// Questions users have flagged, and fixes to them
+type FlagStore interface {
+	FlagQuestion(ctx context.Context, f QuestionFlag) (int, error) // Flagging the same question again replaces the user's flag and reopens it
+	QuizFlags(ctx context.Context, ownerID int, f FlagFilter) ([]QuestionFlag, error)
+	ResolveFlags(ctx context.Context, ownerID, quizID, question int, status string, now time.Time) (int, error) // Closes the question's open flags
+	// Swap in a fixed question, re-score the attempts it changes and mark
+	// its open flags fixed, all or nothing. Returns how many flags it closed.
+	FixQuestion(ctx context.Context, fix QuestionFix) (int, error)
+	FlagStats(ctx context.Context) ([]FlagStat, error)
+}
+
+// Images questions can show, uploaded or taken from a PDF
+type MediaStore interface {
+	SaveMedia(ctx context.Context, m *Media) (int, error) // Saving the same image again returns the ID it already has
+	GetMedia(ctx context.Context, userID, id int) (*Media, error)
+}
+
+// The full storage backend the app runs on
+type Store interface {
+	UserStore
+	QuizStore
+	AttemptStore
+	TokenStore
+	JobStore
+	PromptStore
+	CacheStore
+	BankStore
+	LiveStore
+	AdaptiveStore
+	FlagStore
+	MediaStore
+	Ping(ctx context.Context) error
+	Backend() string // "sqlite" or "postgres"
+	Close() error
+}
+
+// A saved quiz as stored in the database
+type Quiz struct {
+	ID            int
+	UserID        int
+	Prompt        string
+	QuestionsJSON string
+	PromptVersion string // Which prompt template made it, e.g. "quiz/v1/default" (empty if unknown)
+	Model         string // Which model wrote it (empty if unknown)
+	CreatedAt     string
+
+	TimeLimit         int // Seconds for the whole quiz, 0 for no limit
+	QuestionTimeLimit int // Seconds for each question, 0 for no limit
+}
+
+// Is there a clock on this quiz?
+func (q *Quiz) Timed() bool {
+	return q.TimeLimit > 0 || q.QuestionTimeLimit > 0
+}
+
+// Questions generated for one normalized request, served again until they expire
+type CachedPool struct {
+	Key           string
+	QuestionsJSON string
+	PromptVersion string
+	ExpiresAt     time.Time
+}
+
+// One question in a user's question bank
+type BankQuestion struct {
+	ID         int             `json:"id"`
+	QuizID     int             `json:"quiz_id,omitempty"` // The quiz it first appeared in
+	Topic      string          `json:"topic"`
+	Difficulty string          `json:"difficulty"`
+	Type       string          `json:"type"` // Multiple Choice, True/False, Short Answer, Multi-Select, ... (see questionKinds)
+	Tags       []string        `json:"tags"`
+	Question   json.RawMessage `json:"question"` // Exactly as it appeared in the quiz
+	CreatedAt  string          `json:"created_at"`
+}
+
+// What to look for in the question bank (empty fields match everything)
+type BankFilter struct {
+	Query      string // Full-text search over question text, explanation and tags
+	Topic      string // Topic contains this, ignoring case
+	Difficulty string
+	Type       string
+	Tag        string
+	Random     bool // Random order instead of best match / newest first
+	Limit      int
+}
+
+// How a live session went, saved when it ends
+type LiveSessionResult struct {
+	ID            int                `json:"id"`
+	HostUserID    int                `json:"-"`
+	QuizID        int                `json:"quiz_id"`
+	Title         string             `json:"title"`
+	QuestionCount int                `json:"question_count"` // Questions actually played (the host can end early)
+	StartedAt     time.Time          `json:"started_at"`
+	FinishedAt    time.Time          `json:"finished_at"`
+	Players       []LivePlayerResult `json:"players"`
+}
+
+// One player's result in a live session
+type LivePlayerResult struct {
+	Nickname string          `json:"nickname"`
+	Rank     int             `json:"rank"`
+	Score    int             `json:"score"`
+	Correct  int             `json:"correct"`
+	Answers  json.RawMessage `json:"answers"` // Per question: answer, correct, points, time_ms
+}
+
+// An adaptive quiz: questions are picked one at a time to suit how the
+// learner is doing, until we're sure enough of their level
+type AdaptiveQuiz struct {
+	ID        int
+	UserID    int
+	Topic     string
+	QuizType  string
+	Language  string
+	Items     []AdaptiveItem // Questions asked so far, in order; only the last can be unanswered
+	Ability   float64        // Current estimate of the learner's ability (0 is Medium, see adaptive.go)
+	StdError  float64        // How unsure that estimate still is
+	Finished  bool
+	QuizID    int // The quiz saved to history once it finished
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// One question in an adaptive quiz and how it was answered
+type AdaptiveItem struct {
+	Question QuizQuestion `json:"question"`
+	Level    string       `json:"level"`  // Easy, Medium or Hard
+	Source   string       `json:"source"` // Where it came from: bank or generated
+	Answered bool         `json:"answered"`
+	Answer   string       `json:"answer,omitempty"`
+	Correct  bool         `json:"correct"`
+}
+
+// How many of the quiz's questions have been answered
+func (q *AdaptiveQuiz) answered() int {
+	n := 0
+	for _, item := range q.Items {
+		if item.Answered {
+			n++
+		}
+	}
+	return n
+}
+
+// Goes up every time a question is asked or answered, so two requests
+// working on the same quiz can't both save
+func (q *AdaptiveQuiz) revision() int {
+	return len(q.Items) + q.answered()
+}
+
+// A user's report that a question is wrong, unclear or off-topic
+type QuestionFlag struct {
+	ID         int        `json:"id"`
+	QuizID     int        `json:"quiz_id"`
+	QuizPrompt string     `json:"quiz_prompt"` // Only filled in by QuizFlags
+	Question   int        `json:"question"`    // Index into the quiz's questions
+	UserID     int        `json:"-"`           // Who flagged it
+	Reason     string     `json:"reason"`      // wrong_answer, ambiguous or off_topic
+	Comment    string     `json:"comment"`
+	Status     string     `json:"status"` // open, fixed or dismissed
+	CreatedAt  time.Time  `json:"created_at"`
+	ResolvedAt *time.Time `json:"resolved_at,omitempty"`
+}
+
+// Which flags to list (zero values match everything)
+type FlagFilter struct {
+	QuizID int
+	Status string
+	Limit  int
+}
+
+// A corrected question and what it changes
+type QuestionFix struct {
+	UserID   int // The quiz's owner
+	QuizID   int
+	Question int
+	OldJSON  string // The quiz's questions as they were read, so two fixes at once can't both save
+	NewJSON  string // The quiz's questions with the fix in place
+	OldText  string // Text of the question before the fix, to find its copy in the bank
+	Fixed    json.RawMessage
+	Rescores []AttemptRescore
+	Now      time.Time
+}
+
+// How a fix changes one attempt's score
+type AttemptRescore struct {
+	AttemptID int
+	Delta     int  // +1 if the answer is now right, -1 if it's now wrong
+	Correct   bool // Whether the answer is right now
+}
+
+// An image a user owns
+type Media struct {
+	ID        int
+	UserID    int
+	Name      string // File name it was uploaded as, or "<file>.pdf page 3"
+	MimeType  string
+	Data      []byte
+	Width     int
+	Height    int
+	CreatedAt time.Time
+}
+
+// Flags on quizzes from one prompt version and model
+type FlagStat struct {
+	PromptVersion string         `json:"prompt_version"` // Empty for quizzes not made by a prompt (picked from the bank, adaptive...)
+	Model         string         `json:"model"`
+	Quizzes       int            `json:"quizzes"` // Quizzes made this way, flagged or not
+	Flags         int            `json:"flags"`
+	Open          int            `json:"open"`
+	Fixed         int            `json:"fixed"`
+	Dismissed     int            `json:"dismissed"`
+	Reasons       map[string]int `json:"reasons"` // Flags by reason
+}
+
+// A user's attempt at a quiz
+type Attempt struct {
+	ID          int
+	AnswersJSON string
+	Score       int
+	IsComplete  bool
+	CompletedAt string
+
+	// Only set for attempts started through /api/attempts/start
+	QuestionStartedAt time.Time // When the clock started on the next question
+	Deadline          time.Time // End of the whole-quiz time limit (zero if there isn't one)
+	ExpiresAt         time.Time // When the attempt runs out: the deadline, or sooner if a question's time is up first
+	AutoSubmitted     bool      // Finished by running out of time rather than by answering everything
+}
+
+// Did the server keep the clock on this attempt?
+func (a *Attempt) Tracked() bool {
+	return !a.QuestionStartedAt.IsZero()
+}
+
+// One answer in a tracked attempt
+type AttemptAnswer struct {
+	Question int    `json:"question"` // Index in the quiz
+	Answer   string `json:"answer"`
+	Correct  bool   `json:"correct"`
+	TimeMs   int64  `json:"time_ms"` // From the question's clock starting to the answer arriving
+}
+
+// Pick a backend from the DSN: postgres:// URLs go to PostgreSQL, anything else is a SQLite file
+func openStore(dsn string) (Store, error) {
+	switch {
+	case strings.HasPrefix(dsn, "postgres://"), strings.HasPrefix(dsn, "postgresql://"):
+		return openPostgresStore(dsn)
+	default:
+		return openSQLiteStore(strings.TrimPrefix(dsn, "sqlite://"))
+	}
+}
+
+// ============================================================================
+// SHARED SQL IMPLEMENTATION - Both backends speak (almost) the same SQL
+// ============================================================================
+
+// The few places SQLite and PostgreSQL differ
+type dialect struct {
+	name         string
+	numberedArgs bool // PostgreSQL wants $1, $2... instead of ?
+}
+
+// Rewrite ? placeholders for databases that want $1, $2...
+func (d dialect) rebind(query string) string {
+	if !d.numberedArgs {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, ch := range query {
+		if ch == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(ch)
+	}
+	return b.String()
+}
+
+// SQL-backed store used by both the SQLite and PostgreSQL backends
+type sqlStore struct {
+	db    *sql.DB
+	d     dialect
+	stmts map[string]*sql.Stmt // Hot queries, prepared once at startup
+}
+
+// Queries that run on almost every request get prepared up front
+var hotQueries = map[string]string{
+	"userByID": "SELECT id, email, name FROM users WHERE id=?",
+	"tokenUser": `SELECT t.id, t.scopes, u.id, u.email, u.name
+            FROM api_tokens t JOIN users u ON u.id=t.user_id
+            WHERE t.token_hash=? AND t.revoked_at IS NULL`,
+	"touchToken": "UPDATE api_tokens SET last_used_at=CURRENT_TIMESTAMP WHERE id=?",
+	"quizHistory": `SELECT q.id, q.prompt, COALESCE(a.score,0), q.created_at, COALESCE(a.is_complete,FALSE), q.questions_json
+            FROM quizzes q
+            LEFT JOIN quiz_attempts a ON q.id=a.quiz_id AND a.user_id=?
+            WHERE q.user_id=? ORDER BY q.created_at DESC, q.id DESC`,
+	"quizByID": `SELECT id, user_id, prompt, questions_json, COALESCE(prompt_version,''), COALESCE(model,''), created_at, time_limit, question_time_limit
+            FROM quizzes WHERE id=? AND user_id=?`,
+	"attemptByQuiz": `SELECT id, COALESCE(answers_json,''), COALESCE(score,0), is_complete, completed_at,
+            question_started_at, deadline_at, expires_at, auto_submitted
+            FROM quiz_attempts WHERE quiz_id=? AND user_id=?`,
+	"jobByID": `SELECT id, COALESCE(user_id,0), status, request_json, result_json, COALESCE(quiz_id,0), error_code, error, attempts, created_at, updated_at
+            FROM generation_jobs WHERE id=?`,
+	"cachedPool": "SELECT cache_key, questions_json, COALESCE(prompt_version,''), expires_at FROM generation_cache WHERE cache_key=? AND expires_at>?",
+	"claimJob": `UPDATE generation_jobs SET status='running', attempts=attempts+1, lease_until=?, updated_at=CURRENT_TIMESTAMP
+            WHERE id=(SELECT id FROM generation_jobs WHERE status='queued' ORDER BY id LIMIT 1) AND status='queued'
+            RETURNING id, COALESCE(user_id,0), request_json, attempts, created_at`,
+}
+
+// Wrap an open database, preparing the hot queries
+func newSQLStore(db *sql.DB, d dialect) (*sqlStore, error) {
+	s := &sqlStore{db: db, d: d, stmts: map[string]*sql.Stmt{}}
+	for name, query := range hotQueries {
+		stmt, err := db.Prepare(d.rebind(query))
+		if err != nil {
+			s.Close()
+			return nil, fmt.Errorf("prepare %s: %w", name, err)
+		}
+		s.stmts[name] = stmt
+	}
+
+	// Quizzes saved before the question bank existed still have questions worth reusing
+	if n, err := s.backfillQuestionBank(context.Background()); err != nil {
+		slog.Warn("could not fill question bank from saved quizzes", "error", err)
+	} else if n > 0 {
+		slog.Info("filled question bank from saved quizzes", "questions", n)
+	}
+	return s, nil
+}
+
+// Shorthand for running queries with the right placeholders
+func (s *sqlStore) exec(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return s.db.ExecContext(ctx, s.d.rebind(query), args...)
+}
+
+func (s *sqlStore) queryRow(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return s.db.QueryRowContext(ctx, s.d.rebind(query), args...)
+}
+
+func (s *sqlStore) query(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return s.db.QueryContext(ctx, s.d.rebind(query), args...)
+}
+
+// Insert a row and get its new ID back (RETURNING works in both SQLite and PostgreSQL)
+func (s *sqlStore) insertID(ctx context.Context, query string, args ...interface{}) (int, error) {
+	var id int
+	err := s.queryRow(ctx, query+" RETURNING id", args...).Scan(&id)
+	return id, err
+}
+
+// Turn "no rows" into our own ErrNotFound so callers don't depend on database/sql
+func notFound(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (s *sqlStore) Backend() string {
+	return s.d.name
+}
+
+func (s *sqlStore) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+func (s *sqlStore) Close() error {
+	for _, stmt := range s.stmts {
+		stmt.Close()
+	}
+	return s.db.Close()
+}
+
+// ----------- Users -----------
+
+func (s *sqlStore) CreateUser(ctx context.Context, email, passwordHash, name string) (int, error) {
+	return s.insertID(ctx, "INSERT INTO users(email, password_hash, name) VALUES(?, ?, ?)", email, passwordHash, name)
+}
+
+func (s *sqlStore) UserByID(ctx context.Context, id int) (*User, error) {
+	var user User
+	err := s.stmts["userByID"].QueryRowContext(ctx, id).Scan(&user.ID, &user.Email, &user.Name)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &user, nil
+}
+
+func (s *sqlStore) UserByEmail(ctx context.Context, email string) (*User, string, error) {
+	user := User{Email: email}
+	var hash string
+	err := s.queryRow(ctx, "SELECT id, password_hash, name FROM users WHERE email=?", email).Scan(&user.ID, &hash, &user.Name)
+	if err != nil {
+		return nil, "", notFound(err)
+	}
+	return &user, hash, nil
+}
+
+// ----------- Quizzes -----------
+
+func (s *sqlStore) SaveQuiz(ctx context.Context, userID int, prompt, questionsJSON, promptVersion, model string) (int, error) {
+	return s.insertID(ctx, "INSERT INTO quizzes (user_id, prompt, questions_json, prompt_version, model) VALUES (?, ?, ?, ?, ?)",
+		userID, prompt, questionsJSON, nullableString(promptVersion), nullableString(model))
+}
+
+func (s *sqlStore) QuizHistory(ctx context.Context, userID int) ([]QuizHistoryItem, error) {
+	rows, err := s.stmts["quizHistory"].QueryContext(ctx, userID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []QuizHistoryItem
+	for rows.Next() {
+		var it QuizHistoryItem
+		if err := rows.Scan(&it.QuizID, &it.Prompt, &it.Score, &it.Date, &it.IsComplete, &it.QuestionsJSON); err == nil {
+			result = append(result, it)
+		}
+	}
+	return result, rows.Err()
+}
+
+func (s *sqlStore) GetQuiz(ctx context.Context, userID, quizID int) (*Quiz, error) {
+	var q Quiz
+	err := s.stmts["quizByID"].QueryRowContext(ctx, quizID, userID).Scan(&q.ID, &q.UserID, &q.Prompt, &q.QuestionsJSON, &q.PromptVersion, &q.Model, &q.CreatedAt,
+		&q.TimeLimit, &q.QuestionTimeLimit)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &q, nil
+}
+
+// Change a quiz's time limits. Attempts already started keep the limits they started with.
+func (s *sqlStore) SetQuizTiming(ctx context.Context, userID, quizID, timeLimit, questionTimeLimit int) error {
+	res, err := s.exec(ctx, "UPDATE quizzes SET time_limit=?, question_time_limit=? WHERE id=? AND user_id=?",
+		timeLimit, questionTimeLimit, quizID, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// ----------- Attempts -----------
+
+// Save or update the user's attempt (one attempt per user per quiz)
+func (s *sqlStore) SaveAttempt(ctx context.Context, userID int, req SaveQuizAttemptRequest) error {
+	var attemptID int
+	err := s.queryRow(ctx, "SELECT id FROM quiz_attempts WHERE quiz_id=? AND user_id=?", req.QuizID, userID).Scan(&attemptID)
+	switch {
+	case err == nil:
+		// Update existing attempt, stamping completed_at when it's finished
+		query := "UPDATE quiz_attempts SET answers_json=?, score=?, is_complete=? WHERE id=?"
+		if req.IsComplete {
+			query = "UPDATE quiz_attempts SET answers_json=?, score=?, is_complete=?, completed_at=CURRENT_TIMESTAMP WHERE id=?"
+		}
+		_, err = s.exec(ctx, query, req.Answers, req.Score, req.IsComplete, attemptID)
+		return err
+	case errors.Is(err, sql.ErrNoRows):
+		// Create new attempt record
+		_, err = s.exec(ctx, "INSERT INTO quiz_attempts (user_id,quiz_id,answers_json,score,is_complete) VALUES (?,?,?,?,?)",
+			userID, req.QuizID, req.Answers, req.Score, req.IsComplete)
+		return err
+	default:
+		return err
+	}
+}
+
+func (s *sqlStore) GetAttempt(ctx context.Context, userID, quizID int) (*Attempt, error) {
+	return scanAttempt(s.stmts["attemptByQuiz"].QueryRowContext(ctx, quizID, userID))
+}
+
+// Read an attemptByQuiz row. The clock columns hold Unix milliseconds.
+func scanAttempt(row *sql.Row) (*Attempt, error) {
+	var a Attempt
+	var completedAt sql.NullString
+	var questionStarted, deadline, expires sql.NullInt64
+	err := row.Scan(&a.ID, &a.AnswersJSON, &a.Score, &a.IsComplete, &completedAt,
+		&questionStarted, &deadline, &expires, &a.AutoSubmitted)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	a.CompletedAt = completedAt.String
+	a.QuestionStartedAt = fromMillis(questionStarted)
+	a.Deadline = fromMillis(deadline)
+	a.ExpiresAt = fromMillis(expires)
+	return &a, nil
+}
+
+// Start the clock on the user's attempt at a quiz. An untracked attempt (one
+// saved by the browser) is thrown away and started over; a tracked one is
+// left alone, so reloading the page can't reset the timer.
+func (s *sqlStore) StartAttempt(ctx context.Context, userID, quizID int, now, deadline, expires time.Time) (*Attempt, bool, error) {
+	a, err := s.GetAttempt(ctx, userID, quizID)
+	switch {
+	case err == nil && a.Tracked():
+		return a, false, nil
+	case err == nil:
+		_, err = s.exec(ctx, `UPDATE quiz_attempts SET answers_json=NULL, score=0, is_complete=FALSE, completed_at=NULL,
+                started_at=CURRENT_TIMESTAMP, question_started_at=?, deadline_at=?, expires_at=?, auto_submitted=FALSE
+                WHERE id=? AND question_started_at IS NULL`,
+			now.UnixMilli(), nullableMillis(deadline), nullableMillis(expires), a.ID)
+	case errors.Is(err, ErrNotFound):
+		_, err = s.exec(ctx, `INSERT INTO quiz_attempts (user_id, quiz_id, score, is_complete, question_started_at, deadline_at, expires_at)
+                VALUES (?, ?, 0, FALSE, ?, ?, ?)`,
+			userID, quizID, now.UnixMilli(), nullableMillis(deadline), nullableMillis(expires))
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	a, err = s.GetAttempt(ctx, userID, quizID)
+	return a, err == nil, err
+}
+
+// Store one answer: add it to answers_json and the score, and start the
+// clock on the next question. The attempt is finished once every question
+// has an answer.
+func (s *sqlStore) RecordAnswer(ctx context.Context, attemptID, questionCount int, ans AttemptAnswer, now, expires time.Time) (*Attempt, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Lock the attempt first so two answers at once can't both read the old answers
+	_, err = tx.ExecContext(ctx, s.d.rebind("UPDATE quiz_attempts SET score=score WHERE id=?"), attemptID)
+	if err != nil {
+		return nil, err
+	}
+	var userID, quizID int
+	var answersJSON string
+	var complete bool
+	err = tx.QueryRowContext(ctx, s.d.rebind("SELECT user_id, quiz_id, COALESCE(answers_json,''), is_complete FROM quiz_attempts WHERE id=?"),
+		attemptID).Scan(&userID, &quizID, &answersJSON, &complete)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	if complete {
+		return nil, ErrAttemptFinished
+	}
+
+	// answers_json is the same array of chosen options the browser used to save
+	answers := make([]interface{}, questionCount)
+	json.Unmarshal([]byte(answersJSON), &answers)
+	if len(answers) < questionCount {
+		answers = append(answers, make([]interface{}, questionCount-len(answers))...)
+	}
+	if ans.Question < 0 || ans.Question >= len(answers) {
+		return nil, fmt.Errorf("question %d out of range", ans.Question)
+	}
+	if answers[ans.Question] != nil {
+		return nil, ErrAlreadyAnswered
+	}
+	answers[ans.Question] = ans.Answer
+	answered := 0
+	for _, v := range answers {
+		if v != nil {
+			answered++
+		}
+	}
+	complete = answered >= questionCount
+	updated, _ := json.Marshal(answers)
+
+	_, err = tx.ExecContext(ctx, s.d.rebind("INSERT INTO attempt_answers (attempt_id, question, answer, correct, time_ms) VALUES (?, ?, ?, ?, ?)"),
+		attemptID, ans.Question, ans.Answer, ans.Correct, ans.TimeMs)
+	if err != nil {
+		return nil, err
+	}
+
+	point := 0
+	if ans.Correct {
+		point = 1
+	}
+	query := "UPDATE quiz_attempts SET answers_json=?, score=score+?, question_started_at=?, expires_at=? WHERE id=?"
+	args := []interface{}{string(updated), point, now.UnixMilli(), nullableMillis(expires), attemptID}
+	if complete {
+		query = "UPDATE quiz_attempts SET answers_json=?, score=score+?, is_complete=TRUE, completed_at=CURRENT_TIMESTAMP, expires_at=NULL WHERE id=?"
+		args = []interface{}{string(updated), point, attemptID}
+	}
+	if _, err = tx.ExecContext(ctx, s.d.rebind(query), args...); err != nil {
+		return nil, err
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return s.GetAttempt(ctx, userID, quizID)
+}
+
+func (s *sqlStore) AttemptAnswers(ctx context.Context, attemptID int) ([]AttemptAnswer, error) {
+	rows, err := s.query(ctx, "SELECT question, answer, correct, time_ms FROM attempt_answers WHERE attempt_id=? ORDER BY question", attemptID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	answers := []AttemptAnswer{}
+	for rows.Next() {
+		var a AttemptAnswer
+		if err := rows.Scan(&a.Question, &a.Answer, &a.Correct, &a.TimeMs); err != nil {
+			return nil, err
+		}
+		answers = append(answers, a)
+	}
+	return answers, rows.Err()
+}
+
+// Submit every unfinished attempt that ran out of time, with the answers it has
+func (s *sqlStore) ExpireAttempts(ctx context.Context, before time.Time) (int, error) {
+	res, err := s.exec(ctx, `UPDATE quiz_attempts SET is_complete=TRUE, auto_submitted=TRUE, completed_at=CURRENT_TIMESTAMP, expires_at=NULL
+            WHERE is_complete=FALSE AND expires_at IS NOT NULL AND expires_at<?`, before.UnixMilli())
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+func (s *sqlStore) QuizAttempts(ctx context.Context, quizID int) ([]Attempt, error) {
+	rows, err := s.query(ctx, "SELECT id, COALESCE(answers_json,''), COALESCE(score,0), is_complete FROM quiz_attempts WHERE quiz_id=? ORDER BY id", quizID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var attempts []Attempt
+	for rows.Next() {
+		var a Attempt
+		if err := rows.Scan(&a.ID, &a.AnswersJSON, &a.Score, &a.IsComplete); err != nil {
+			return nil, err
+		}
+		attempts = append(attempts, a)
+	}
+	return attempts, rows.Err()
+}
+
+// NULL for a zero time, Unix milliseconds otherwise
+func nullableMillis(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t.UnixMilli()
+}
+
+func fromMillis(ms sql.NullInt64) time.Time {
+	if !ms.Valid {
+		return time.Time{}
+	}
+	return time.UnixMilli(ms.Int64)
+}
+
+// ----------- API tokens -----------
+
+func (s *sqlStore) CreateToken(ctx context.Context, userID int, name, tokenHash string, scopes []string) (int, error) {
+	return s.insertID(ctx, "INSERT INTO api_tokens (user_id, name, token_hash, scopes) VALUES (?, ?, ?, ?)",
+		userID, name, tokenHash, strings.Join(scopes, ","))
+}
+
+func (s *sqlStore) ListTokens(ctx context.Context, userID int) ([]APIToken, error) {
+	rows, err := s.query(ctx, `SELECT id, name, scopes, created_at, last_used_at, revoked_at IS NOT NULL
+            FROM api_tokens WHERE user_id=? ORDER BY created_at DESC, id DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []APIToken{}
+	for rows.Next() {
+		var t APIToken
+		var scopes string
+		var lastUsed sql.NullString
+		if err := rows.Scan(&t.ID, &t.Name, &scopes, &t.CreatedAt, &lastUsed, &t.Revoked); err == nil {
+			t.Scopes = strings.Split(scopes, ",")
+			t.LastUsedAt = lastUsed.String
+			result = append(result, t)
+		}
+	}
+	return result, rows.Err()
+}
+
+func (s *sqlStore) TokenUser(ctx context.Context, tokenHash string) (*User, int, []string, error) {
+	var user User
+	var tokenID int
+	var scopes string
+	err := s.stmts["tokenUser"].QueryRowContext(ctx, tokenHash).Scan(&tokenID, &scopes, &user.ID, &user.Email, &user.Name)
+	if err != nil {
+		return nil, 0, nil, notFound(err)
+	}
+	return &user, tokenID, strings.Split(scopes, ","), nil
+}
+
+func (s *sqlStore) TouchToken(ctx context.Context, tokenID int) error {
+	_, err := s.stmts["touchToken"].ExecContext(ctx, tokenID)
+	return err
+}
+
+func (s *sqlStore) RevokeToken(ctx context.Context, userID, tokenID int) error {
+	res, err := s.exec(ctx, "UPDATE api_tokens SET revoked_at=CURRENT_TIMESTAMP WHERE id=? AND user_id=? AND revoked_at IS NULL",
+		tokenID, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// ----------- Prompt templates -----------
+
+func (s *sqlStore) ListPrompts(ctx context.Context) ([]PromptTemplate, error) {
+	rows, err := s.query(ctx, "SELECT name, version, variant, body FROM prompt_templates ORDER BY name, version, variant")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []PromptTemplate
+	for rows.Next() {
+		var p PromptTemplate
+		if err := rows.Scan(&p.Name, &p.Version, &p.Variant, &p.Body); err != nil {
+			return nil, err
+		}
+		result = append(result, p)
+	}
+	return result, rows.Err()
+}
+
+// Insert or replace one version of one variant
+func (s *sqlStore) SavePrompt(ctx context.Context, p PromptTemplate) error {
+	_, err := s.exec(ctx, `INSERT INTO prompt_templates (name, version, variant, body) VALUES (?, ?, ?, ?)
+            ON CONFLICT (name, version, variant) DO UPDATE SET body=excluded.body, updated_at=CURRENT_TIMESTAMP`,
+		p.Name, p.Version, p.Variant, p.Body)
+	return err
+}
+
+// ----------- Generation cache -----------
+
+// Expiry times are stored as Unix seconds so both databases compare them the same way
+func (s *sqlStore) CachedPool(ctx context.Context, key string, now time.Time) (*CachedPool, error) {
+	var p CachedPool
+	var expires int64
+	err := s.stmts["cachedPool"].QueryRowContext(ctx, key, now.Unix()).Scan(&p.Key, &p.QuestionsJSON, &p.PromptVersion, &expires)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	p.ExpiresAt = time.Unix(expires, 0)
+	return &p, nil
+}
+
+func (s *sqlStore) SaveCachedPool(ctx context.Context, p CachedPool) error {
+	_, err := s.exec(ctx, `INSERT INTO generation_cache (cache_key, questions_json, prompt_version, expires_at) VALUES (?, ?, ?, ?)
+            ON CONFLICT (cache_key) DO UPDATE SET questions_json=excluded.questions_json,
+                prompt_version=excluded.prompt_version, expires_at=excluded.expires_at, created_at=CURRENT_TIMESTAMP`,
+		p.Key, p.QuestionsJSON, nullableString(p.PromptVersion), p.ExpiresAt.Unix())
+	if err != nil {
+		return err
+	}
+	_, err = s.exec(ctx, "DELETE FROM generation_cache WHERE expires_at<=?", time.Now().Unix())
+	return err
+}
+
+// ----------- Question bank -----------
+
+// Tags are kept as ",tag1,tag2," so one LIKE finds a tag and full-text search sees them as words
+func joinTags(tags []string) string {
+	return "," + strings.Join(tags, ",") + ","
+}
+
+func splitTags(joined string) []string {
+	tags := []string{}
+	for _, t := range strings.Split(joined, ",") {
+		if t != "" {
+			tags = append(tags, t)
+		}
+	}
+	return tags
+}
+
+func (s *sqlStore) AddToBank(ctx context.Context, userID, quizID int, questions []BankQuestion) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	insert := s.d.rebind(`INSERT INTO question_bank
+            (user_id, quiz_id, topic, difficulty, question_type, tags, question_text, explanation, question_json)
+            VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	for _, q := range questions {
+		// The text columns are what full-text search looks at
+		var text struct {
+			Question    string `json:"question"`
+			Explanation string `json:"explanation"`
+		}
+		json.Unmarshal(q.Question, &text)
+		_, err := tx.ExecContext(ctx, insert, userID, nullableID(quizID), q.Topic, q.Difficulty, q.Type,
+			joinTags(q.Tags), text.Question, text.Explanation, string(q.Question))
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *sqlStore) SearchBank(ctx context.Context, userID int, f BankFilter) ([]BankQuestion, error) {
+	from := "question_bank b"
+	where := []string{"b.user_id=?"}
+	args := []interface{}{userID}
+	order := "b.id DESC"
+	var orderArgs []interface{}
+
+	if f.Query != "" {
+		if s.d.name == "postgres" {
+			doc := "to_tsvector('simple', b.question_text || ' ' || b.explanation || ' ' || b.tags)"
+			where = append(where, doc+" @@ plainto_tsquery('simple', ?)")
+			args = append(args, f.Query)
+			order = "ts_rank(" + doc + ", plainto_tsquery('simple', ?)) DESC"
+			orderArgs = []interface{}{f.Query}
+		} else if match := ftsQuery(f.Query); match != "" {
+			from += " JOIN question_bank_fts f ON f.rowid=b.id"
+			where = append(where, "question_bank_fts MATCH ?")
+			args = append(args, match)
+			order = "f.rank"
+		}
+	}
+	if f.Topic != "" {
+		where = append(where, "LOWER(b.topic) LIKE ?")
+		args = append(args, "%"+strings.ToLower(f.Topic)+"%")
+	}
+	if f.Difficulty != "" {
+		where = append(where, "LOWER(b.difficulty)=?")
+		args = append(args, strings.ToLower(f.Difficulty))
+	}
+	if f.Type != "" {
+		where = append(where, "LOWER(b.question_type)=?")
+		args = append(args, strings.ToLower(f.Type))
+	}
+	if f.Tag != "" {
+		where = append(where, "b.tags LIKE ?")
+		args = append(args, "%,"+f.Tag+",%")
+	}
+	if f.Random {
+		order, orderArgs = "RANDOM()", nil
+	}
+	args = append(append(args, orderArgs...), f.Limit)
+
+	return s.scanBank(s.query(ctx, `SELECT b.id, COALESCE(b.quiz_id,0), b.topic, b.difficulty, b.question_type, b.tags, b.question_json, b.created_at
+            FROM `+from+` WHERE `+strings.Join(where, " AND ")+` ORDER BY `+order+` LIMIT ?`, args...))
+}
+
+func (s *sqlStore) BankQuestions(ctx context.Context, userID int, ids []int) ([]BankQuestion, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	args := []interface{}{userID}
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	found, err := s.scanBank(s.query(ctx, `SELECT id, COALESCE(quiz_id,0), topic, difficulty, question_type, tags, question_json, created_at
+            FROM question_bank WHERE user_id=? AND id IN (?`+strings.Repeat(",?", len(ids)-1)+`)`, args...))
+	if err != nil {
+		return nil, err
+	}
+
+	byID := map[int]BankQuestion{}
+	for _, q := range found {
+		byID[q.ID] = q
+	}
+	var result []BankQuestion
+	for _, id := range ids {
+		if q, ok := byID[id]; ok {
+			result = append(result, q)
+		}
+	}
+	return result, nil
+}
+
+func (s *sqlStore) SetBankTags(ctx context.Context, userID, id int, tags []string) error {
+	res, err := s.exec(ctx, "UPDATE question_bank SET tags=? WHERE id=? AND user_id=?", joinTags(tags), id, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Read question bank rows
+func (s *sqlStore) scanBank(rows *sql.Rows, err error) ([]BankQuestion, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []BankQuestion{}
+	for rows.Next() {
+		var q BankQuestion
+		var tags, question string
+		if err := rows.Scan(&q.ID, &q.QuizID, &q.Topic, &q.Difficulty, &q.Type, &tags, &question, &q.CreatedAt); err != nil {
+			return nil, err
+		}
+		q.Tags = splitTags(tags)
+		q.Question = json.RawMessage(question)
+		result = append(result, q)
+	}
+	return result, rows.Err()
+}
+
+// Turn what someone typed into an FTS5 query: every word has to appear,
+// as a prefix, and FTS5's own syntax (quotes, NEAR, *, ^) is taken literally
+func ftsQuery(q string) string {
+	var terms []string
+	for _, word := range strings.Fields(q) {
+		word = strings.ReplaceAll(word, `"`, "")
+		if word != "" {
+			terms = append(terms, `"`+word+`"*`)
+		}
+	}
+	return strings.Join(terms, " ")
+}
+
+// Fill the bank from quizzes saved before it existed. Only runs while the
+// bank is empty, so it happens once per database.
+func (s *sqlStore) backfillQuestionBank(ctx context.Context) (int, error) {
+	var n int
+	if err := s.queryRow(ctx, "SELECT COUNT(*) FROM question_bank").Scan(&n); err != nil || n > 0 {
+		return 0, err
+	}
+
+	rows, err := s.query(ctx, "SELECT id, user_id, prompt, questions_json FROM quizzes ORDER BY id")
+	if err != nil {
+		return 0, err
+	}
+	type oldQuiz struct {
+		id, userID            int
+		prompt, questionsJSON string
+	}
+	var quizzes []oldQuiz
+	for rows.Next() {
+		var q oldQuiz
+		if err := rows.Scan(&q.id, &q.userID, &q.prompt, &q.questionsJSON); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		quizzes = append(quizzes, q)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	added := 0
+	for _, q := range quizzes {
+		questions := bankEntries(q.questionsJSON, q.prompt, "")
+		if err := s.AddToBank(ctx, q.userID, q.id, questions); err != nil {
+			return added, err
+		}
+		added += len(questions)
+	}
+	return added, nil
+}
+
+// ----------- Generation jobs -----------
+
+// NULL instead of 0 for optional IDs (anonymous jobs, jobs without a saved quiz)
+func nullableID(id int) interface{} {
+	if id == 0 {
+		return nil
+	}
+	return id
+}
+
+// NULL instead of "" for optional text
+func nullableString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+func (s *sqlStore) CreateJob(ctx context.Context, id string, userID int, requestJSON string) error {
+	_, err := s.exec(ctx, "INSERT INTO generation_jobs (id, user_id, request_json) VALUES (?, ?, ?)",
+		id, nullableID(userID), requestJSON)
+	return err
+}
+
+func (s *sqlStore) GetJob(ctx context.Context, id string) (*Job, error) {
+	var j Job
+	var result, code, message sql.NullString
+	err := s.stmts["jobByID"].QueryRowContext(ctx, id).Scan(&j.ID, &j.UserID, &j.Status, &j.RequestJSON,
+		&result, &j.QuizID, &code, &message, &j.Attempts, &j.CreatedAt, &j.UpdatedAt)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	j.ResultJSON = result.String
+	j.ErrorCode = code.String
+	j.Error = message.String
+	return &j, nil
+}
+
+// Mark the oldest queued job as running and return it. Job IDs are
+// time-ordered, so ORDER BY id is first in, first out. The status check in
+// the outer WHERE means two workers racing for the same row can't both win.
+// The claim lasts until leaseUntil unless the worker renews it.
+func (s *sqlStore) ClaimJob(ctx context.Context, leaseUntil time.Time) (*Job, error) {
+	var j Job
+	err := s.stmts["claimJob"].QueryRowContext(ctx, leaseUntil.Unix()).Scan(&j.ID, &j.UserID, &j.RequestJSON, &j.Attempts, &j.CreatedAt)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	j.Status = JobRunning
+	return &j, nil
+}
+
+func (s *sqlStore) FinishJob(ctx context.Context, id, resultJSON string, quizID int) error {
+	_, err := s.exec(ctx, `UPDATE generation_jobs SET status=?, result_json=?, quiz_id=?, error_code=NULL, error=NULL, updated_at=CURRENT_TIMESTAMP
+            WHERE id=?`, JobSucceeded, resultJSON, nullableID(quizID), id)
+	return err
+}
+
+func (s *sqlStore) FailJob(ctx context.Context, id, code, message string) error {
+	_, err := s.exec(ctx, "UPDATE generation_jobs SET status=?, error_code=?, error=?, updated_at=CURRENT_TIMESTAMP WHERE id=?",
+		JobFailed, code, message, id)
+	return err
+}
+
+func (s *sqlStore) RenewJobLease(ctx context.Context, id string, leaseUntil time.Time) error {
+	_, err := s.exec(ctx, "UPDATE generation_jobs SET lease_until=? WHERE id=? AND status=?",
+		leaseUntil.Unix(), id, JobRunning)
+	return err
+}
+
+// Jobs without a lease were claimed by a version that didn't keep one
+func (s *sqlStore) RequeueExpiredJobs(ctx context.Context, now time.Time) (int, error) {
+	res, err := s.exec(ctx, `UPDATE generation_jobs SET status=?, lease_until=NULL, updated_at=CURRENT_TIMESTAMP
+            WHERE status=? AND (lease_until IS NULL OR lease_until<?)`, JobQueued, JobRunning, now.Unix())
+	if err != nil {
+		return 0, err
+	}
+	n, _ := res.RowsAffected()
+	return int(n), nil
+}
+
+// ----------- Live sessions -----------
+
+func (s *sqlStore) SaveLiveSession(ctx context.Context, r LiveSessionResult) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var id int
+	err = tx.QueryRowContext(ctx, s.d.rebind(`INSERT INTO live_sessions (host_user_id, quiz_id, title, question_count, started_at, finished_at)
+            VALUES (?, ?, ?, ?, ?, ?) RETURNING id`),
+		r.HostUserID, nullableID(r.QuizID), r.Title, r.QuestionCount, r.StartedAt.UnixMilli(), r.FinishedAt.UnixMilli()).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+	insert := s.d.rebind("INSERT INTO live_players (session_id, nickname, player_rank, score, correct_count, answers_json) VALUES (?, ?, ?, ?, ?, ?)")
+	for _, p := range r.Players {
+		if _, err := tx.ExecContext(ctx, insert, id, p.Nickname, p.Rank, p.Score, p.Correct, string(p.Answers)); err != nil {
+			return 0, err
+		}
+	}
+	return id, tx.Commit()
+}
+
+func (s *sqlStore) LiveSessions(ctx context.Context, hostUserID, limit int) ([]LiveSessionResult, error) {
+	rows, err := s.query(ctx, `SELECT id, COALESCE(quiz_id,0), title, question_count, started_at, finished_at
+            FROM live_sessions WHERE host_user_id=? ORDER BY finished_at DESC, id DESC LIMIT ?`, hostUserID, limit)
+	if err != nil {
+		return nil, err
+	}
+	sessions := []LiveSessionResult{}
+	byID := map[int]int{}
+	for rows.Next() {
+		r := LiveSessionResult{HostUserID: hostUserID, Players: []LivePlayerResult{}}
+		var started, finished int64
+		if err := rows.Scan(&r.ID, &r.QuizID, &r.Title, &r.QuestionCount, &started, &finished); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		r.StartedAt, r.FinishedAt = time.UnixMilli(started).UTC(), time.UnixMilli(finished).UTC()
+		byID[r.ID] = len(sessions)
+		sessions = append(sessions, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil || len(sessions) == 0 {
+		return sessions, err
+	}
+
+	// Then everyone who played in them
+	oldest := sessions[0].ID
+	for _, r := range sessions {
+		oldest = min(oldest, r.ID)
+	}
+	rows, err = s.query(ctx, `SELECT p.session_id, p.nickname, p.player_rank, p.score, p.correct_count, p.answers_json
+            FROM live_players p JOIN live_sessions l ON l.id=p.session_id
+            WHERE l.host_user_id=? AND p.session_id>=? ORDER BY p.session_id, p.player_rank, p.nickname`,
+		hostUserID, oldest)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var sessionID int
+		var p LivePlayerResult
+		var answers string
+		if err := rows.Scan(&sessionID, &p.Nickname, &p.Rank, &p.Score, &p.Correct, &answers); err != nil {
+			return nil, err
+		}
+		p.Answers = json.RawMessage(answers)
+		if i, ok := byID[sessionID]; ok {
+			sessions[i].Players = append(sessions[i].Players, p)
+		}
+	}
+	return sessions, rows.Err()
+}
+
+// ----------- Adaptive quizzes -----------
+
+func (s *sqlStore) CreateAdaptive(ctx context.Context, q *AdaptiveQuiz) (int, error) {
+	items, err := json.Marshal(q.Items)
+	if err != nil {
+		return 0, err
+	}
+	var id int
+	err = s.queryRow(ctx, `INSERT INTO adaptive_quizzes (user_id, topic, quiz_type, language, items_json, revision, ability, std_error, created_at, updated_at)
+            VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?) RETURNING id`,
+		q.UserID, q.Topic, q.QuizType, q.Language, string(items), q.revision(), q.Ability, q.StdError,
+		q.CreatedAt.UnixMilli(), q.UpdatedAt.UnixMilli()).Scan(&id)
+	return id, err
+}
+
+func (s *sqlStore) GetAdaptive(ctx context.Context, userID, id int) (*AdaptiveQuiz, error) {
+	q := AdaptiveQuiz{UserID: userID}
+	var items string
+	var created, updated int64
+	err := s.queryRow(ctx, `SELECT id, topic, quiz_type, language, items_json, ability, std_error, finished, COALESCE(quiz_id,0), created_at, updated_at
+            FROM adaptive_quizzes WHERE id=? AND user_id=?`, id, userID).Scan(
+		&q.ID, &q.Topic, &q.QuizType, &q.Language, &items, &q.Ability, &q.StdError, &q.Finished, &q.QuizID, &created, &updated)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	if err := json.Unmarshal([]byte(items), &q.Items); err != nil {
+		return nil, fmt.Errorf("adaptive quiz %d: %w", id, err)
+	}
+	q.CreatedAt, q.UpdatedAt = time.UnixMilli(created).UTC(), time.UnixMilli(updated).UTC()
+	return &q, nil
+}
+
+func (s *sqlStore) UpdateAdaptive(ctx context.Context, q *AdaptiveQuiz, revision int) error {
+	items, err := json.Marshal(q.Items)
+	if err != nil {
+		return err
+	}
+	res, err := s.exec(ctx, `UPDATE adaptive_quizzes SET items_json=?, revision=?, ability=?, std_error=?, finished=?, quiz_id=?, updated_at=?
+            WHERE id=? AND user_id=? AND revision=?`,
+		string(items), q.revision(), q.Ability, q.StdError, q.Finished, nullableID(q.QuizID), q.UpdatedAt.UnixMilli(),
+		q.ID, q.UserID, revision)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrAlreadyAnswered
+	}
+	return nil
+}
+
+// ----------- Question flags -----------
+
+func (s *sqlStore) FlagQuestion(ctx context.Context, f QuestionFlag) (int, error) {
+	var id int
+	err := s.queryRow(ctx, `INSERT INTO question_flags (quiz_id, question, user_id, reason, comment, status, created_at)
+            VALUES (?, ?, ?, ?, ?, 'open', ?)
+            ON CONFLICT (quiz_id, question, user_id) DO UPDATE SET reason=excluded.reason, comment=excluded.comment,
+                status='open', created_at=excluded.created_at, resolved_at=NULL
+            RETURNING id`,
+		f.QuizID, f.Question, f.UserID, f.Reason, f.Comment, f.CreatedAt.UnixMilli()).Scan(&id)
+	return id, err
+}
+
+// Flags on the owner's quizzes, newest first
+func (s *sqlStore) QuizFlags(ctx context.Context, ownerID int, f FlagFilter) ([]QuestionFlag, error) {
+	where := []string{"q.user_id=?"}
+	args := []interface{}{ownerID}
+	if f.QuizID != 0 {
+		where = append(where, "f.quiz_id=?")
+		args = append(args, f.QuizID)
+	}
+	if f.Status != "" {
+		where = append(where, "f.status=?")
+		args = append(args, f.Status)
+	}
+	if f.Limit <= 0 {
+		f.Limit = 100
+	}
+	args = append(args, f.Limit)
+
+	rows, err := s.query(ctx, `SELECT f.id, f.quiz_id, q.prompt, f.question, f.user_id, f.reason, f.comment, f.status, f.created_at, f.resolved_at
+            FROM question_flags f JOIN quizzes q ON q.id=f.quiz_id
+            WHERE `+strings.Join(where, " AND ")+` ORDER BY f.id DESC LIMIT ?`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	flags := []QuestionFlag{}
+	for rows.Next() {
+		var fl QuestionFlag
+		var created int64
+		var resolved sql.NullInt64
+		err := rows.Scan(&fl.ID, &fl.QuizID, &fl.QuizPrompt, &fl.Question, &fl.UserID, &fl.Reason, &fl.Comment, &fl.Status, &created, &resolved)
+		if err != nil {
+			return nil, err
+		}
+		fl.CreatedAt = time.UnixMilli(created).UTC()
+		if resolved.Valid {
+			t := time.UnixMilli(resolved.Int64).UTC()
+			fl.ResolvedAt = &t
+		}
+		flags = append(flags, fl)
+	}
+	return flags, rows.Err()
+}
+
+func (s *sqlStore) ResolveFlags(ctx context.Context, ownerID, quizID, question int, status string, now time.Time) (int, error) {
+	res, err := s.exec(ctx, `UPDATE question_flags SET status=?, resolved_at=?
+            WHERE quiz_id=? AND question=? AND status='open' AND quiz_id IN (SELECT id FROM quizzes WHERE user_id=?)`,
+		status, now.UnixMilli(), quizID, question, ownerID)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+func (s *sqlStore) FixQuestion(ctx context.Context, fix QuestionFix) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// Only if nobody changed the quiz since it was read
+	res, err := tx.ExecContext(ctx, s.d.rebind("UPDATE quizzes SET questions_json=? WHERE id=? AND user_id=? AND questions_json=?"),
+		fix.NewJSON, fix.QuizID, fix.UserID, fix.OldJSON)
+	if err != nil {
+		return 0, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return 0, ErrQuizChanged
+	}
+
+	// The copy in the question bank, so it doesn't turn up wrong in new quizzes
+	var text struct {
+		Question    string `json:"question"`
+		Explanation string `json:"explanation"`
+	}
+	json.Unmarshal(fix.Fixed, &text)
+	_, err = tx.ExecContext(ctx, s.d.rebind(`UPDATE question_bank SET question_json=?, question_text=?, explanation=?
+            WHERE user_id=? AND quiz_id=? AND question_text=?`),
+		string(fix.Fixed), text.Question, text.Explanation, fix.UserID, fix.QuizID, fix.OldText)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, r := range fix.Rescores {
+		_, err = tx.ExecContext(ctx, s.d.rebind("UPDATE quiz_attempts SET score=COALESCE(score,0)+? WHERE id=? AND quiz_id=?"),
+			r.Delta, r.AttemptID, fix.QuizID)
+		if err != nil {
+			return 0, err
+		}
+		_, err = tx.ExecContext(ctx, s.d.rebind("UPDATE attempt_answers SET correct=? WHERE attempt_id=? AND question=?"),
+			r.Correct, r.AttemptID, fix.Question)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	res, err = tx.ExecContext(ctx, s.d.rebind("UPDATE question_flags SET status='fixed', resolved_at=? WHERE quiz_id=? AND question=? AND status='open'"),
+		fix.Now.UnixMilli(), fix.QuizID, fix.Question)
+	if err != nil {
+		return 0, err
+	}
+	closed, _ := res.RowsAffected()
+	return int(closed), tx.Commit()
+}
+
+// Flag counts for every prompt version and model quizzes were made with
+func (s *sqlStore) FlagStats(ctx context.Context) ([]FlagStat, error) {
+	type source struct{ version, model string }
+	stats := map[source]*FlagStat{}
+	var order []source
+
+	rows, err := s.query(ctx, `SELECT COALESCE(prompt_version,''), COALESCE(model,''), COUNT(*)
+            FROM quizzes GROUP BY COALESCE(prompt_version,''), COALESCE(model,'')
+            ORDER BY COALESCE(prompt_version,''), COALESCE(model,'')`)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		st := &FlagStat{Reasons: map[string]int{}}
+		if err := rows.Scan(&st.PromptVersion, &st.Model, &st.Quizzes); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		src := source{st.PromptVersion, st.Model}
+		stats[src] = st
+		order = append(order, src)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = s.query(ctx, `SELECT COALESCE(q.prompt_version,''), COALESCE(q.model,''), f.reason, f.status, COUNT(*)
+            FROM question_flags f JOIN quizzes q ON q.id=f.quiz_id
+            GROUP BY COALESCE(q.prompt_version,''), COALESCE(q.model,''), f.reason, f.status`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var src source
+		var reason, status string
+		var n int
+		if err := rows.Scan(&src.version, &src.model, &reason, &status, &n); err != nil {
+			return nil, err
+		}
+		st, ok := stats[src]
+		if !ok {
+			continue // The quiz was saved after the first query
+		}
+		st.Flags += n
+		st.Reasons[reason] += n
+		switch status {
+		case "open":
+			st.Open += n
+		case "fixed":
+			st.Fixed += n
+		case "dismissed":
+			st.Dismissed += n
+		}
+	}
+
+	result := make([]FlagStat, 0, len(order))
+	for _, src := range order {
+		result = append(result, *stats[src])
+	}
+	return result, rows.Err()
+}
+
+// ----------- Media -----------
+
+func (s *sqlStore) SaveMedia(ctx context.Context, m *Media) (int, error) {
+	sum := sha256.Sum256(m.Data)
+	var id int
+	err := s.queryRow(ctx, `INSERT INTO media (user_id, name, mime_type, data, sha256, width, height, created_at)
+            VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+            ON CONFLICT (user_id, sha256) DO UPDATE SET sha256=excluded.sha256
+            RETURNING id`,
+		m.UserID, m.Name, m.MimeType, m.Data, hex.EncodeToString(sum[:]), m.Width, m.Height, m.CreatedAt.UnixMilli()).Scan(&id)
+	return id, err
+}
+
+func (s *sqlStore) GetMedia(ctx context.Context, userID, id int) (*Media, error) {
+	m := Media{UserID: userID}
+	var created int64
+	err := s.queryRow(ctx, `SELECT id, name, mime_type, data, width, height, created_at FROM media WHERE id=? AND user_id=?`,
+		id, userID).Scan(&m.ID, &m.Name, &m.MimeType, &m.Data, &m.Width, &m.Height, &created)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	m.CreatedAt = time.UnixMilli(created).UTC()
+	return &m, nil
+}
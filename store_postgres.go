@@ -0,0 +1,205 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+
+	_ "github.com/jackc/pgx/v5/stdlib" // PostgreSQL driver registered as "pgx"
+)
+
+// ============================================================================
+// POSTGRESQL BACKEND - For deployments that outgrow a single SQLite file
+// ============================================================================
+
+// Connect to PostgreSQL and make sure all tables exist
+func openPostgresStore(dsn string) (*sqlStore, error) {
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("open DB: %w", err)
+	}
+
+	// Test connection works
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("ping DB: %w", err)
+	}
+
+	if err := initPostgresSchema(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return newSQLStore(db, dialect{name: "postgres", numberedArgs: true})
+}
+
+// Create tables if they don't exist - same shape as the SQLite schema
+func initPostgresSchema(db *sql.DB) error {
+	tables := []struct{ name, ddl string }{
+		{"users", `CREATE TABLE IF NOT EXISTS users (
+                id BIGSERIAL PRIMARY KEY,
+                email TEXT UNIQUE NOT NULL,
+                password_hash TEXT NOT NULL,
+                name TEXT NOT NULL DEFAULT '',
+                created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+        )`},
+		{"quizzes", `CREATE TABLE IF NOT EXISTS quizzes (
+                id BIGSERIAL PRIMARY KEY,
+                user_id BIGINT NOT NULL REFERENCES users(id),
+                prompt TEXT NOT NULL,
+                questions_json TEXT NOT NULL,
+                created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+        )`},
+		{"quizzes prompt_version", `ALTER TABLE quizzes ADD COLUMN IF NOT EXISTS prompt_version TEXT`},
+		{"quiz_attempts", `CREATE TABLE IF NOT EXISTS quiz_attempts (
+                id BIGSERIAL PRIMARY KEY,
+                user_id BIGINT NOT NULL REFERENCES users(id),
+                quiz_id BIGINT NOT NULL REFERENCES quizzes(id),
+                answers_json TEXT,
+                score INTEGER,
+                is_complete BOOLEAN NOT NULL DEFAULT FALSE,
+                started_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+                completed_at TIMESTAMPTZ
+        )`},
+		{"api_tokens", `CREATE TABLE IF NOT EXISTS api_tokens (
+                id BIGSERIAL PRIMARY KEY,
+                user_id BIGINT NOT NULL REFERENCES users(id),
+                name TEXT NOT NULL,
+                token_hash TEXT UNIQUE NOT NULL,
+                scopes TEXT NOT NULL DEFAULT 'read',
+                created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+                last_used_at TIMESTAMPTZ,
+                revoked_at TIMESTAMPTZ
+        )`},
+		{"generation_jobs", `CREATE TABLE IF NOT EXISTS generation_jobs (
+                id TEXT PRIMARY KEY,
+                user_id BIGINT REFERENCES users(id),
+                status TEXT NOT NULL DEFAULT 'queued',
+                request_json TEXT NOT NULL,
+                result_json TEXT,
+                quiz_id BIGINT REFERENCES quizzes(id),
+                error_code TEXT,
+                error TEXT,
+                attempts INTEGER NOT NULL DEFAULT 0,
+                lease_until BIGINT,
+                created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+                updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+        )`},
+		{"prompt_templates", `CREATE TABLE IF NOT EXISTS prompt_templates (
+                name TEXT NOT NULL,
+                version INTEGER NOT NULL,
+                variant TEXT NOT NULL,
+                body TEXT NOT NULL,
+                created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+                updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+                PRIMARY KEY (name, version, variant)
+        )`},
+		{"generation_cache", `CREATE TABLE IF NOT EXISTS generation_cache (
+                cache_key TEXT PRIMARY KEY,
+                questions_json TEXT NOT NULL,
+                prompt_version TEXT,
+                expires_at BIGINT NOT NULL,
+                created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+        )`},
+		{"question_bank", `CREATE TABLE IF NOT EXISTS question_bank (
+                id BIGSERIAL PRIMARY KEY,
+                user_id BIGINT NOT NULL REFERENCES users(id),
+                quiz_id BIGINT REFERENCES quizzes(id),
+                topic TEXT NOT NULL DEFAULT '',
+                difficulty TEXT NOT NULL DEFAULT '',
+                question_type TEXT NOT NULL DEFAULT '',
+                tags TEXT NOT NULL DEFAULT ',',
+                question_text TEXT NOT NULL,
+                explanation TEXT NOT NULL DEFAULT '',
+                question_json TEXT NOT NULL,
+                created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+        )`},
+		{"question_bank index", `CREATE INDEX IF NOT EXISTS idx_question_bank_user ON question_bank(user_id, id)`},
+		{"question_bank search index", `CREATE INDEX IF NOT EXISTS idx_question_bank_search ON question_bank
+                USING GIN (to_tsvector('simple', question_text || ' ' || explanation || ' ' || tags))`},
+		{"generation_jobs error_code", `ALTER TABLE generation_jobs ADD COLUMN IF NOT EXISTS error_code TEXT`},
+		{"generation_jobs lease_until", `ALTER TABLE generation_jobs ADD COLUMN IF NOT EXISTS lease_until BIGINT`},
+		{"generation_jobs index", `CREATE INDEX IF NOT EXISTS idx_generation_jobs_status ON generation_jobs(status, id)`},
+		{"quizzes time limits", `ALTER TABLE quizzes ADD COLUMN IF NOT EXISTS time_limit INTEGER NOT NULL DEFAULT 0,
+                ADD COLUMN IF NOT EXISTS question_time_limit INTEGER NOT NULL DEFAULT 0`},
+		{"quiz_attempts clock", `ALTER TABLE quiz_attempts ADD COLUMN IF NOT EXISTS question_started_at BIGINT,
+                ADD COLUMN IF NOT EXISTS deadline_at BIGINT,
+                ADD COLUMN IF NOT EXISTS expires_at BIGINT,
+                ADD COLUMN IF NOT EXISTS auto_submitted BOOLEAN NOT NULL DEFAULT FALSE`},
+		{"quiz_attempts index", `CREATE INDEX IF NOT EXISTS idx_quiz_attempts_expires ON quiz_attempts(expires_at)`},
+		{"attempt_answers", `CREATE TABLE IF NOT EXISTS attempt_answers (
+                attempt_id BIGINT NOT NULL REFERENCES quiz_attempts(id),
+                question INTEGER NOT NULL,
+                answer TEXT NOT NULL,
+                correct BOOLEAN NOT NULL,
+                time_ms BIGINT NOT NULL,
+                answered_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+                PRIMARY KEY (attempt_id, question)
+        )`},
+		{"live_sessions", `CREATE TABLE IF NOT EXISTS live_sessions (
+                id BIGSERIAL PRIMARY KEY,
+                host_user_id BIGINT NOT NULL REFERENCES users(id),
+                quiz_id BIGINT REFERENCES quizzes(id),
+                title TEXT NOT NULL,
+                question_count INTEGER NOT NULL,
+                started_at BIGINT NOT NULL,
+                finished_at BIGINT NOT NULL
+        )`},
+		{"live_players", `CREATE TABLE IF NOT EXISTS live_players (
+                session_id BIGINT NOT NULL REFERENCES live_sessions(id),
+                nickname TEXT NOT NULL,
+                player_rank INTEGER NOT NULL,
+                score INTEGER NOT NULL,
+                correct_count INTEGER NOT NULL,
+                answers_json TEXT NOT NULL,
+                PRIMARY KEY (session_id, nickname)
+        )`},
+		{"live_sessions index", `CREATE INDEX IF NOT EXISTS idx_live_sessions_host ON live_sessions(host_user_id, finished_at)`},
+		{"adaptive_quizzes", `CREATE TABLE IF NOT EXISTS adaptive_quizzes (
+                id BIGSERIAL PRIMARY KEY,
+                user_id BIGINT NOT NULL REFERENCES users(id),
+                topic TEXT NOT NULL,
+                quiz_type TEXT NOT NULL,
+                language TEXT NOT NULL DEFAULT '',
+                items_json TEXT NOT NULL,
+                revision INTEGER NOT NULL DEFAULT 0,
+                ability DOUBLE PRECISION NOT NULL DEFAULT 0,
+                std_error DOUBLE PRECISION NOT NULL DEFAULT 1,
+                finished BOOLEAN NOT NULL DEFAULT FALSE,
+                quiz_id BIGINT REFERENCES quizzes(id),
+                created_at BIGINT NOT NULL,
+                updated_at BIGINT NOT NULL
+        )`},
+		{"quizzes model", `ALTER TABLE quizzes ADD COLUMN IF NOT EXISTS model TEXT`},
+		{"question_flags", `CREATE TABLE IF NOT EXISTS question_flags (
+                id BIGSERIAL PRIMARY KEY,
+                quiz_id BIGINT NOT NULL REFERENCES quizzes(id),
+                question INTEGER NOT NULL,
+                user_id BIGINT NOT NULL REFERENCES users(id),
+                reason TEXT NOT NULL,
+                comment TEXT NOT NULL DEFAULT '',
+                status TEXT NOT NULL DEFAULT 'open',
+                created_at BIGINT NOT NULL,
+                resolved_at BIGINT,
+                UNIQUE (quiz_id, question, user_id)
+        )`},
+		{"media", `CREATE TABLE IF NOT EXISTS media (
+                id BIGSERIAL PRIMARY KEY,
+                user_id BIGINT NOT NULL REFERENCES users(id),
+                name TEXT NOT NULL,
+                mime_type TEXT NOT NULL,
+                data BYTEA NOT NULL,
+                sha256 TEXT NOT NULL,
+                width INTEGER NOT NULL,
+                height INTEGER NOT NULL,
+                created_at BIGINT NOT NULL,
+                UNIQUE (user_id, sha256)
+        )`},
+	}
+
+	for _, t := range tables {
+		if _, err := db.Exec(t.ddl); err != nil {
+			return fmt.Errorf("create %s table: %w", t.name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,376 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+
+	_ "modernc.org/sqlite" // Pure-Go SQLite driver (no CGO required)
+)
+
+// ============================================================================
+// SQLITE BACKEND - The default, a single file next to the binary
+// ============================================================================
+
+// Open (or create) a SQLite database file and make sure all tables exist
+func openSQLiteStore(path string) (*sqlStore, error) {
+	// WAL lets readers and a writer work side by side, and busy_timeout makes
+	// writers wait for a lock instead of failing straight away. These are
+	// per-connection settings, so they go in the DSN to apply to the whole pool.
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)")
+	if err != nil {
+		return nil, fmt.Errorf("open DB: %w", err)
+	}
+
+	// Test connection works
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("ping DB: %w", err)
+	}
+
+	if err := initSQLiteSchema(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return newSQLStore(db, dialect{name: "sqlite"})
+}
+
+// Create tables if they don't exist
+func initSQLiteSchema(db *sql.DB) error {
+	// Create users table
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS users (
+                id INTEGER PRIMARY KEY AUTOINCREMENT,
+                email TEXT UNIQUE NOT NULL,
+                password_hash TEXT NOT NULL,
+                name TEXT NOT NULL DEFAULT '',
+                created_at DATETIME NOT NULL DEFAULT (datetime('now'))
+        )`)
+	if err != nil {
+		return fmt.Errorf("create users table: %w", err)
+	}
+
+	// If we added the name column later, check if it exists and add it
+	var nameColumnExists bool
+	err = db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('users') WHERE name='name'`).Scan(&nameColumnExists)
+	if err == nil && !nameColumnExists {
+		slog.Info("adding name column to users table")
+		_, err = db.Exec(`ALTER TABLE users ADD COLUMN name TEXT NOT NULL DEFAULT ''`)
+		if err != nil {
+			slog.Warn("could not add name column", "error", err)
+		}
+	}
+
+	// Create table for storing generated quizzes
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS quizzes (
+                id INTEGER PRIMARY KEY AUTOINCREMENT,
+                user_id INTEGER NOT NULL,
+                prompt TEXT NOT NULL,
+                questions_json TEXT NOT NULL,
+                created_at DATETIME NOT NULL DEFAULT (datetime('now')),
+                FOREIGN KEY(user_id) REFERENCES users(id)
+        )`)
+	if err != nil {
+		return fmt.Errorf("create quizzes table: %w", err)
+	}
+
+	// Remember which prompt template made each quiz (added after the first release)
+	var promptVersionExists bool
+	err = db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('quizzes') WHERE name='prompt_version'`).Scan(&promptVersionExists)
+	if err == nil && !promptVersionExists {
+		slog.Info("adding prompt_version column to quizzes table")
+		if _, err = db.Exec(`ALTER TABLE quizzes ADD COLUMN prompt_version TEXT`); err != nil {
+			return fmt.Errorf("add quizzes.prompt_version: %w", err)
+		}
+	}
+
+	// Create table for tracking quiz attempts and scores
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS quiz_attempts (
+                id INTEGER PRIMARY KEY AUTOINCREMENT,
+                user_id INTEGER NOT NULL,
+                quiz_id INTEGER NOT NULL,
+                answers_json TEXT,
+                score INTEGER,
+                is_complete BOOLEAN NOT NULL DEFAULT 0,
+                started_at DATETIME NOT NULL DEFAULT (datetime('now')),
+                completed_at DATETIME,
+                FOREIGN KEY(user_id) REFERENCES users(id),
+                FOREIGN KEY(quiz_id) REFERENCES quizzes(id)
+        )`)
+	if err != nil {
+		return fmt.Errorf("create quiz_attempts table: %w", err)
+	}
+
+	// Create table for personal API tokens (only the hash is stored)
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS api_tokens (
+                id INTEGER PRIMARY KEY AUTOINCREMENT,
+                user_id INTEGER NOT NULL,
+                name TEXT NOT NULL,
+                token_hash TEXT UNIQUE NOT NULL,
+                scopes TEXT NOT NULL DEFAULT 'read',
+                created_at DATETIME NOT NULL DEFAULT (datetime('now')),
+                last_used_at DATETIME,
+                revoked_at DATETIME,
+                FOREIGN KEY(user_id) REFERENCES users(id)
+        )`)
+	if err != nil {
+		return fmt.Errorf("create api_tokens table: %w", err)
+	}
+
+	// Create table for queued quiz generations (IDs are time-ordered UUIDs)
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS generation_jobs (
+                id TEXT PRIMARY KEY,
+                user_id INTEGER,
+                status TEXT NOT NULL DEFAULT 'queued',
+                request_json TEXT NOT NULL,
+                result_json TEXT,
+                quiz_id INTEGER,
+                error_code TEXT,
+                error TEXT,
+                attempts INTEGER NOT NULL DEFAULT 0,
+                lease_until INTEGER,
+                created_at DATETIME NOT NULL DEFAULT (datetime('now')),
+                updated_at DATETIME NOT NULL DEFAULT (datetime('now')),
+                FOREIGN KEY(user_id) REFERENCES users(id),
+                FOREIGN KEY(quiz_id) REFERENCES quizzes(id)
+        )`)
+	if err != nil {
+		return fmt.Errorf("create generation_jobs table: %w", err)
+	}
+	// Create table for prompt templates saved by admins
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS prompt_templates (
+                name TEXT NOT NULL,
+                version INTEGER NOT NULL,
+                variant TEXT NOT NULL,
+                body TEXT NOT NULL,
+                created_at DATETIME NOT NULL DEFAULT (datetime('now')),
+                updated_at DATETIME NOT NULL DEFAULT (datetime('now')),
+                PRIMARY KEY (name, version, variant)
+        )`)
+	if err != nil {
+		return fmt.Errorf("create prompt_templates table: %w", err)
+	}
+
+	// Create table for cached question pools (see generation_cache.go)
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS generation_cache (
+                cache_key TEXT PRIMARY KEY,
+                questions_json TEXT NOT NULL,
+                prompt_version TEXT,
+                expires_at INTEGER NOT NULL,
+                created_at DATETIME NOT NULL DEFAULT (datetime('now'))
+        )`)
+	if err != nil {
+		return fmt.Errorf("create generation_cache table: %w", err)
+	}
+
+	// Create table for the question bank, with an FTS5 index kept in step by triggers
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS question_bank (
+                id INTEGER PRIMARY KEY AUTOINCREMENT,
+                user_id INTEGER NOT NULL,
+                quiz_id INTEGER,
+                topic TEXT NOT NULL DEFAULT '',
+                difficulty TEXT NOT NULL DEFAULT '',
+                question_type TEXT NOT NULL DEFAULT '',
+                tags TEXT NOT NULL DEFAULT ',',
+                question_text TEXT NOT NULL,
+                explanation TEXT NOT NULL DEFAULT '',
+                question_json TEXT NOT NULL,
+                created_at DATETIME NOT NULL DEFAULT (datetime('now')),
+                FOREIGN KEY(user_id) REFERENCES users(id),
+                FOREIGN KEY(quiz_id) REFERENCES quizzes(id)
+        )`)
+	if err != nil {
+		return fmt.Errorf("create question_bank table: %w", err)
+	}
+	for _, ddl := range []string{
+		`CREATE INDEX IF NOT EXISTS idx_question_bank_user ON question_bank(user_id, id)`,
+		`CREATE VIRTUAL TABLE IF NOT EXISTS question_bank_fts USING fts5(
+                question_text, explanation, tags, content='question_bank', content_rowid='id')`,
+		`CREATE TRIGGER IF NOT EXISTS question_bank_ai AFTER INSERT ON question_bank BEGIN
+                INSERT INTO question_bank_fts(rowid, question_text, explanation, tags)
+                VALUES (new.id, new.question_text, new.explanation, new.tags);
+        END`,
+		`CREATE TRIGGER IF NOT EXISTS question_bank_ad AFTER DELETE ON question_bank BEGIN
+                INSERT INTO question_bank_fts(question_bank_fts, rowid, question_text, explanation, tags)
+                VALUES ('delete', old.id, old.question_text, old.explanation, old.tags);
+        END`,
+		`CREATE TRIGGER IF NOT EXISTS question_bank_au AFTER UPDATE ON question_bank BEGIN
+                INSERT INTO question_bank_fts(question_bank_fts, rowid, question_text, explanation, tags)
+                VALUES ('delete', old.id, old.question_text, old.explanation, old.tags);
+                INSERT INTO question_bank_fts(rowid, question_text, explanation, tags)
+                VALUES (new.id, new.question_text, new.explanation, new.tags);
+        END`,
+	} {
+		if _, err = db.Exec(ddl); err != nil {
+			return fmt.Errorf("create question_bank search index: %w", err)
+		}
+	}
+
+	// error_code came after the first version of the jobs table
+	var errorCodeExists bool
+	err = db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('generation_jobs') WHERE name='error_code'`).Scan(&errorCodeExists)
+	if err == nil && !errorCodeExists {
+		slog.Info("adding error_code column to generation_jobs table")
+		if _, err = db.Exec(`ALTER TABLE generation_jobs ADD COLUMN error_code TEXT`); err != nil {
+			return fmt.Errorf("add generation_jobs.error_code: %w", err)
+		}
+	}
+	// ...and so did leases on running jobs (unix seconds)
+	var leaseExists bool
+	err = db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('generation_jobs') WHERE name='lease_until'`).Scan(&leaseExists)
+	if err == nil && !leaseExists {
+		slog.Info("adding lease_until column to generation_jobs table")
+		if _, err = db.Exec(`ALTER TABLE generation_jobs ADD COLUMN lease_until INTEGER`); err != nil {
+			return fmt.Errorf("add generation_jobs.lease_until: %w", err)
+		}
+	}
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_generation_jobs_status ON generation_jobs(status, id)`)
+	if err != nil {
+		return fmt.Errorf("create generation_jobs index: %w", err)
+	}
+
+	// Time limits and the server-kept clock for timed quizzes (see exam.go).
+	// Clock columns are Unix milliseconds.
+	for _, c := range []struct{ table, column, ddl string }{
+		{"quizzes", "time_limit", "INTEGER NOT NULL DEFAULT 0"},
+		{"quizzes", "question_time_limit", "INTEGER NOT NULL DEFAULT 0"},
+		{"quiz_attempts", "question_started_at", "INTEGER"},
+		{"quiz_attempts", "deadline_at", "INTEGER"},
+		{"quiz_attempts", "expires_at", "INTEGER"},
+		{"quiz_attempts", "auto_submitted", "BOOLEAN NOT NULL DEFAULT 0"},
+	} {
+		var exists bool
+		err = db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info(?) WHERE name=?`, c.table, c.column).Scan(&exists)
+		if err == nil && !exists {
+			slog.Info("adding " + c.column + " column to " + c.table + " table")
+			if _, err = db.Exec(`ALTER TABLE ` + c.table + ` ADD COLUMN ` + c.column + ` ` + c.ddl); err != nil {
+				return fmt.Errorf("add %s.%s: %w", c.table, c.column, err)
+			}
+		}
+	}
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_quiz_attempts_expires ON quiz_attempts(expires_at)`)
+	if err != nil {
+		return fmt.Errorf("create quiz_attempts index: %w", err)
+	}
+
+	// Create table for each answer in a timed attempt and how long it took
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS attempt_answers (
+                attempt_id INTEGER NOT NULL,
+                question INTEGER NOT NULL,
+                answer TEXT NOT NULL,
+                correct BOOLEAN NOT NULL,
+                time_ms INTEGER NOT NULL,
+                answered_at DATETIME NOT NULL DEFAULT (datetime('now')),
+                PRIMARY KEY (attempt_id, question),
+                FOREIGN KEY(attempt_id) REFERENCES quiz_attempts(id)
+        )`)
+	if err != nil {
+		return fmt.Errorf("create attempt_answers table: %w", err)
+	}
+
+	// Create tables for finished live sessions and their players (times are Unix milliseconds)
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS live_sessions (
+                id INTEGER PRIMARY KEY AUTOINCREMENT,
+                host_user_id INTEGER NOT NULL,
+                quiz_id INTEGER,
+                title TEXT NOT NULL,
+                question_count INTEGER NOT NULL,
+                started_at INTEGER NOT NULL,
+                finished_at INTEGER NOT NULL,
+                FOREIGN KEY(host_user_id) REFERENCES users(id),
+                FOREIGN KEY(quiz_id) REFERENCES quizzes(id)
+        )`)
+	if err != nil {
+		return fmt.Errorf("create live_sessions table: %w", err)
+	}
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS live_players (
+                session_id INTEGER NOT NULL,
+                nickname TEXT NOT NULL,
+                player_rank INTEGER NOT NULL,
+                score INTEGER NOT NULL,
+                correct_count INTEGER NOT NULL,
+                answers_json TEXT NOT NULL,
+                PRIMARY KEY (session_id, nickname),
+                FOREIGN KEY(session_id) REFERENCES live_sessions(id)
+        )`)
+	if err != nil {
+		return fmt.Errorf("create live_players table: %w", err)
+	}
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_live_sessions_host ON live_sessions(host_user_id, finished_at)`)
+	if err != nil {
+		return fmt.Errorf("create live_sessions index: %w", err)
+	}
+
+	// Create table for adaptive quizzes (questions and answers as JSON, times as Unix milliseconds)
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS adaptive_quizzes (
+                id INTEGER PRIMARY KEY AUTOINCREMENT,
+                user_id INTEGER NOT NULL,
+                topic TEXT NOT NULL,
+                quiz_type TEXT NOT NULL,
+                language TEXT NOT NULL DEFAULT '',
+                items_json TEXT NOT NULL,
+                revision INTEGER NOT NULL DEFAULT 0,
+                ability REAL NOT NULL DEFAULT 0,
+                std_error REAL NOT NULL DEFAULT 1,
+                finished BOOLEAN NOT NULL DEFAULT 0,
+                quiz_id INTEGER,
+                created_at INTEGER NOT NULL,
+                updated_at INTEGER NOT NULL,
+                FOREIGN KEY(user_id) REFERENCES users(id),
+                FOREIGN KEY(quiz_id) REFERENCES quizzes(id)
+        )`)
+	if err != nil {
+		return fmt.Errorf("create adaptive_quizzes table: %w", err)
+	}
+
+	// Which model wrote each quiz, for the flag statistics (see flags.go)
+	var modelExists bool
+	err = db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('quizzes') WHERE name='model'`).Scan(&modelExists)
+	if err == nil && !modelExists {
+		slog.Info("adding model column to quizzes table")
+		if _, err = db.Exec(`ALTER TABLE quizzes ADD COLUMN model TEXT`); err != nil {
+			return fmt.Errorf("add quizzes.model: %w", err)
+		}
+	}
+
+	// Create table for questions users have flagged (times are Unix milliseconds).
+	// Each user has at most one flag on a question.
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS question_flags (
+                id INTEGER PRIMARY KEY AUTOINCREMENT,
+                quiz_id INTEGER NOT NULL,
+                question INTEGER NOT NULL,
+                user_id INTEGER NOT NULL,
+                reason TEXT NOT NULL,
+                comment TEXT NOT NULL DEFAULT '',
+                status TEXT NOT NULL DEFAULT 'open',
+                created_at INTEGER NOT NULL,
+                resolved_at INTEGER,
+                UNIQUE (quiz_id, question, user_id),
+                FOREIGN KEY(quiz_id) REFERENCES quizzes(id),
+                FOREIGN KEY(user_id) REFERENCES users(id)
+        )`)
+	if err != nil {
+		return fmt.Errorf("create question_flags table: %w", err)
+	}
+
+	// Create table for images (see media.go). The same image saved twice by
+	// one user is one row, found by its SHA-256.
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS media (
+                id INTEGER PRIMARY KEY AUTOINCREMENT,
+                user_id INTEGER NOT NULL,
+                name TEXT NOT NULL,
+                mime_type TEXT NOT NULL,
+                data BLOB NOT NULL,
+                sha256 TEXT NOT NULL,
+                width INTEGER NOT NULL,
+                height INTEGER NOT NULL,
+                created_at INTEGER NOT NULL,
+                UNIQUE (user_id, sha256),
+                FOREIGN KEY(user_id) REFERENCES users(id)
+        )`)
+	if err != nil {
+		return fmt.Errorf("create media table: %w", err)
+	}
+
+	return nil
+}
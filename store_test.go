@@ -0,0 +1,788 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ============================================================================
+// STORE CONFORMANCE SUITE - Same checks against every storage backend
+// ============================================================================
+//
+// Run with:   go test -run TestStoreConformance
+//
+// SQLite is checked in a throwaway file. PostgreSQL is checked when
+// ASKIFY_POSTGRES_TEST_URL points at a database the checks may write test
+// users and quizzes to; without it, or if the server can't be reached,
+// the PostgreSQL run is skipped.
+
+// One named check run against a store
+type storeCheck struct {
+	name string
+	run  func(ctx context.Context, s Store) error
+}
+
+// Everything a backend has to get right for the app to work on it
+var storeChecks = []storeCheck{
+	{"users: create and look up", checkUsers},
+	{"users: duplicate email rejected", checkDuplicateEmail},
+	{"quizzes: save, load and ownership", checkQuizzes},
+	{"attempts: insert then update", checkAttempts},
+	{"attempts: timed start, answer and expire", checkTimedAttempts},
+	{"history: newest first with scores", checkHistory},
+	{"tokens: create, use and revoke", checkTokens},
+	{"jobs: queue, claim, finish and requeue", checkJobs},
+	{"prompts: save, replace and list", checkPrompts},
+	{"cache: save, replace and expire", checkCache},
+	{"bank: add, search, tag and pick", checkBank},
+	{"live: save results and list them", checkLive},
+	{"adaptive: create, answer and finish", checkAdaptive},
+	{"flags: flag, fix, re-score and count", checkFlags},
+	{"media: save, dedupe and ownership", checkMedia},
+}
+
+// Run every check against each backend
+func TestStoreConformance(t *testing.T) {
+	t.Run("sqlite", func(t *testing.T) {
+		runStoreChecks(t, filepath.Join(t.TempDir(), "check.db"))
+	})
+	t.Run("postgres", func(t *testing.T) {
+		dsn := os.Getenv("ASKIFY_POSTGRES_TEST_URL")
+		if dsn == "" {
+			t.Skip("ASKIFY_POSTGRES_TEST_URL not set")
+		}
+		runStoreChecks(t, dsn)
+	})
+}
+
+func runStoreChecks(t *testing.T, dsn string) {
+	s, err := openStore(dsn)
+	if err != nil {
+		if isPostgresDSN(dsn) {
+			t.Skipf("%s: %v", redactDSN(dsn), err)
+		}
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	ctx := context.Background()
+	for _, c := range storeChecks {
+		t.Run(c.name, func(t *testing.T) {
+			if err := c.run(ctx, s); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+// Is this a PostgreSQL connection string?
+func isPostgresDSN(dsn string) bool {
+	return strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://")
+}
+
+// Make a user with a unique email so checks don't collide with each other
+func newCheckUser(ctx context.Context, s Store) (int, string, error) {
+	email := "check-" + uuid.New().String() + "@example.com"
+	id, err := s.CreateUser(ctx, email, "hash", "Checker")
+	return id, email, err
+}
+
+// ----------- The checks -----------
+
+func checkUsers(ctx context.Context, s Store) error {
+	id, email, err := newCheckUser(ctx, s)
+	if err != nil {
+		return err
+	}
+	u, err := s.UserByID(ctx, id)
+	if err != nil {
+		return fmt.Errorf("UserByID: %w", err)
+	}
+	if u.Email != email || u.Name != "Checker" {
+		return fmt.Errorf("UserByID returned %+v", u)
+	}
+	u, hash, err := s.UserByEmail(ctx, email)
+	if err != nil {
+		return fmt.Errorf("UserByEmail: %w", err)
+	}
+	if u.ID != id || hash != "hash" {
+		return fmt.Errorf("UserByEmail returned %+v / %q", u, hash)
+	}
+	if _, err := s.UserByID(ctx, -1); !errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("UserByID(unknown) = %v, want ErrNotFound", err)
+	}
+	return nil
+}
+
+func checkDuplicateEmail(ctx context.Context, s Store) error {
+	_, email, err := newCheckUser(ctx, s)
+	if err != nil {
+		return err
+	}
+	if _, err := s.CreateUser(ctx, email, "hash", "Again"); err == nil {
+		return errors.New("second CreateUser with same email succeeded")
+	}
+	return nil
+}
+
+func checkQuizzes(ctx context.Context, s Store) error {
+	owner, _, err := newCheckUser(ctx, s)
+	if err != nil {
+		return err
+	}
+	other, _, err := newCheckUser(ctx, s)
+	if err != nil {
+		return err
+	}
+	quizID, err := s.SaveQuiz(ctx, owner, "Photosynthesis", `[{"question":"q1"}]`, "quiz/v1/default", "gpt-test")
+	if err != nil {
+		return fmt.Errorf("SaveQuiz: %w", err)
+	}
+	q, err := s.GetQuiz(ctx, owner, quizID)
+	if err != nil {
+		return fmt.Errorf("GetQuiz: %w", err)
+	}
+	if q.Prompt != "Photosynthesis" || q.QuestionsJSON != `[{"question":"q1"}]` || q.PromptVersion != "quiz/v1/default" || q.Model != "gpt-test" || q.CreatedAt == "" {
+		return fmt.Errorf("GetQuiz returned %+v", q)
+	}
+	if _, err := s.GetQuiz(ctx, other, quizID); !errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("GetQuiz by another user = %v, want ErrNotFound", err)
+	}
+	return nil
+}
+
+func checkAttempts(ctx context.Context, s Store) error {
+	userID, _, err := newCheckUser(ctx, s)
+	if err != nil {
+		return err
+	}
+	quizID, err := s.SaveQuiz(ctx, userID, "Attempts", `[]`, "", "")
+	if err != nil {
+		return err
+	}
+	if _, err := s.GetAttempt(ctx, userID, quizID); !errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("GetAttempt before saving = %v, want ErrNotFound", err)
+	}
+
+	if err := s.SaveAttempt(ctx, userID, SaveQuizAttemptRequest{QuizID: quizID, Answers: `["a"]`, Score: 1}); err != nil {
+		return fmt.Errorf("SaveAttempt (insert): %w", err)
+	}
+	a, err := s.GetAttempt(ctx, userID, quizID)
+	if err != nil {
+		return fmt.Errorf("GetAttempt: %w", err)
+	}
+	if a.Score != 1 || a.IsComplete || a.CompletedAt != "" {
+		return fmt.Errorf("after insert got %+v", a)
+	}
+
+	if err := s.SaveAttempt(ctx, userID, SaveQuizAttemptRequest{QuizID: quizID, Answers: `["a","b"]`, Score: 2, IsComplete: true}); err != nil {
+		return fmt.Errorf("SaveAttempt (update): %w", err)
+	}
+	a, err = s.GetAttempt(ctx, userID, quizID)
+	if err != nil {
+		return fmt.Errorf("GetAttempt: %w", err)
+	}
+	if a.Score != 2 || !a.IsComplete || a.CompletedAt == "" || a.AnswersJSON != `["a","b"]` {
+		return fmt.Errorf("after update got %+v", a)
+	}
+	return nil
+}
+
+func checkTimedAttempts(ctx context.Context, s Store) error {
+	userID, _, err := newCheckUser(ctx, s)
+	if err != nil {
+		return err
+	}
+	quizID, err := s.SaveQuiz(ctx, userID, "Timed", `[{},{}]`, "", "")
+	if err != nil {
+		return err
+	}
+	if err := s.SetQuizTiming(ctx, userID, quizID, 600, 30); err != nil {
+		return fmt.Errorf("SetQuizTiming: %w", err)
+	}
+	if q, err := s.GetQuiz(ctx, userID, quizID); err != nil || q.TimeLimit != 600 || q.QuestionTimeLimit != 30 {
+		return fmt.Errorf("GetQuiz after SetQuizTiming = %+v, %v", q, err)
+	}
+	if err := s.SetQuizTiming(ctx, userID+1000000, quizID, 60, 0); !errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("SetQuizTiming by another user = %v, want ErrNotFound", err)
+	}
+
+	// An attempt the browser saved is replaced when the clock starts
+	if err := s.SaveAttempt(ctx, userID, SaveQuizAttemptRequest{QuizID: quizID, Answers: `["x"]`, Score: 5}); err != nil {
+		return err
+	}
+	start := time.Now().Truncate(time.Millisecond)
+	deadline, expires := start.Add(10*time.Minute), start.Add(30*time.Second)
+	a, started, err := s.StartAttempt(ctx, userID, quizID, start, deadline, expires)
+	if err != nil {
+		return fmt.Errorf("StartAttempt: %w", err)
+	}
+	if !started || !a.Tracked() || a.Score != 0 || a.AnswersJSON != "" || !a.Deadline.Equal(deadline) || !a.ExpiresAt.Equal(expires) {
+		return fmt.Errorf("after StartAttempt got started=%v %+v", started, a)
+	}
+	if again, started, err := s.StartAttempt(ctx, userID, quizID, start.Add(time.Minute), time.Time{}, time.Time{}); err != nil || started || !again.QuestionStartedAt.Equal(start) {
+		return fmt.Errorf("second StartAttempt = started=%v %+v, %v; want the first clock kept", started, again, err)
+	}
+
+	answered := start.Add(5 * time.Second)
+	a, err = s.RecordAnswer(ctx, a.ID, 2, AttemptAnswer{Question: 1, Answer: "B", Correct: true, TimeMs: 5000}, answered, answered.Add(30*time.Second))
+	if err != nil {
+		return fmt.Errorf("RecordAnswer: %w", err)
+	}
+	if a.Score != 1 || a.IsComplete || a.AnswersJSON != `[null,"B"]` || !a.QuestionStartedAt.Equal(answered) {
+		return fmt.Errorf("after first answer got %+v", a)
+	}
+	if _, err := s.RecordAnswer(ctx, a.ID, 2, AttemptAnswer{Question: 1, Answer: "C"}, answered, answered); !errors.Is(err, ErrAlreadyAnswered) {
+		return fmt.Errorf("answering twice = %v, want ErrAlreadyAnswered", err)
+	}
+
+	// Expiry only touches attempts whose time is up
+	if n, err := s.ExpireAttempts(ctx, answered); err != nil || n != 0 {
+		return fmt.Errorf("ExpireAttempts before expiry = %d, %v; want 0", n, err)
+	}
+	if _, err := s.ExpireAttempts(ctx, answered.Add(time.Minute)); err != nil {
+		return fmt.Errorf("ExpireAttempts: %w", err)
+	}
+	a, err = s.GetAttempt(ctx, userID, quizID)
+	if err != nil || !a.IsComplete || !a.AutoSubmitted || a.Score != 1 || a.CompletedAt == "" {
+		return fmt.Errorf("after expiry got %+v, %v", a, err)
+	}
+	if _, err := s.RecordAnswer(ctx, a.ID, 2, AttemptAnswer{Question: 0, Answer: "A"}, answered, answered); !errors.Is(err, ErrAttemptFinished) {
+		return fmt.Errorf("answering after expiry = %v, want ErrAttemptFinished", err)
+	}
+
+	answers, err := s.AttemptAnswers(ctx, a.ID)
+	if err != nil || len(answers) != 1 || answers[0] != (AttemptAnswer{Question: 1, Answer: "B", Correct: true, TimeMs: 5000}) {
+		return fmt.Errorf("AttemptAnswers = %+v, %v", answers, err)
+	}
+	return nil
+}
+
+func checkHistory(ctx context.Context, s Store) error {
+	userID, _, err := newCheckUser(ctx, s)
+	if err != nil {
+		return err
+	}
+	first, err := s.SaveQuiz(ctx, userID, "first", `[]`, "", "")
+	if err != nil {
+		return err
+	}
+	second, err := s.SaveQuiz(ctx, userID, "second", `[]`, "", "")
+	if err != nil {
+		return err
+	}
+	if err := s.SaveAttempt(ctx, userID, SaveQuizAttemptRequest{QuizID: first, Score: 3, IsComplete: true}); err != nil {
+		return err
+	}
+
+	items, err := s.QuizHistory(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("QuizHistory: %w", err)
+	}
+	if len(items) != 2 || items[0].QuizID != second || items[1].QuizID != first {
+		return fmt.Errorf("QuizHistory order = %+v", items)
+	}
+	if items[1].Score != 3 || !items[1].IsComplete || items[0].IsComplete {
+		return fmt.Errorf("QuizHistory scores = %+v", items)
+	}
+	return nil
+}
+
+func checkTokens(ctx context.Context, s Store) error {
+	userID, _, err := newCheckUser(ctx, s)
+	if err != nil {
+		return err
+	}
+	hash := hashAPIToken("askify_check_" + uuid.New().String())
+	tokenID, err := s.CreateToken(ctx, userID, "ci", hash, []string{scopeRead, scopeWrite})
+	if err != nil {
+		return fmt.Errorf("CreateToken: %w", err)
+	}
+
+	u, gotID, scopes, err := s.TokenUser(ctx, hash)
+	if err != nil {
+		return fmt.Errorf("TokenUser: %w", err)
+	}
+	if u.ID != userID || gotID != tokenID || len(scopes) != 2 {
+		return fmt.Errorf("TokenUser returned %+v, %d, %v", u, gotID, scopes)
+	}
+	if err := s.TouchToken(ctx, tokenID); err != nil {
+		return fmt.Errorf("TouchToken: %w", err)
+	}
+
+	tokens, err := s.ListTokens(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("ListTokens: %w", err)
+	}
+	if len(tokens) != 1 || tokens[0].LastUsedAt == "" || tokens[0].Revoked {
+		return fmt.Errorf("ListTokens = %+v", tokens)
+	}
+
+	if err := s.RevokeToken(ctx, userID, tokenID); err != nil {
+		return fmt.Errorf("RevokeToken: %w", err)
+	}
+	if _, _, _, err := s.TokenUser(ctx, hash); !errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("TokenUser after revoke = %v, want ErrNotFound", err)
+	}
+	if err := s.RevokeToken(ctx, userID, tokenID); !errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("second RevokeToken = %v, want ErrNotFound", err)
+	}
+	return nil
+}
+
+func checkJobs(ctx context.Context, s Store) error {
+	userID, _, err := newCheckUser(ctx, s)
+	if err != nil {
+		return err
+	}
+	first, second := uuid.Must(uuid.NewV7()).String(), uuid.Must(uuid.NewV7()).String()
+	if err := s.CreateJob(ctx, first, userID, `{"topic":"first"}`); err != nil {
+		return fmt.Errorf("CreateJob: %w", err)
+	}
+	if err := s.CreateJob(ctx, second, 0, `{"topic":"second"}`); err != nil {
+		return fmt.Errorf("CreateJob anonymous: %w", err)
+	}
+
+	job, err := s.GetJob(ctx, first)
+	if err != nil {
+		return fmt.Errorf("GetJob: %w", err)
+	}
+	if job.Status != JobQueued || job.UserID != userID || job.Attempts != 0 || job.CreatedAt == "" {
+		return fmt.Errorf("GetJob = %+v", job)
+	}
+
+	// Oldest first (other checks may share a PostgreSQL database, so skip strangers' jobs)
+	claimed, err := claimCheckJob(ctx, s, first, second)
+	if err != nil {
+		return err
+	}
+	if claimed.ID != first || claimed.Status != JobRunning || claimed.Attempts != 1 || claimed.RequestJSON != `{"topic":"first"}` {
+		return fmt.Errorf("first ClaimJob = %+v", claimed)
+	}
+	quizID, err := s.SaveQuiz(ctx, userID, "first", "[]", "", "")
+	if err != nil {
+		return fmt.Errorf("SaveQuiz: %w", err)
+	}
+	if err := s.FinishJob(ctx, first, "[]", quizID); err != nil {
+		return fmt.Errorf("FinishJob: %w", err)
+	}
+	if job, _ := s.GetJob(ctx, first); job == nil || job.Status != JobSucceeded || job.ResultJSON != "[]" || job.QuizID != quizID {
+		return fmt.Errorf("GetJob after finish = %+v", job)
+	}
+
+	// A running job stays with its worker while the lease lasts, comes back
+	// once it runs out, and can then fail
+	if claimed, err = claimCheckJob(ctx, s, first, second); err != nil || claimed.ID != second || claimed.UserID != 0 {
+		return fmt.Errorf("second ClaimJob = %+v, %v", claimed, err)
+	}
+	now := time.Now()
+	if err := s.RenewJobLease(ctx, second, now.Add(time.Hour)); err != nil {
+		return fmt.Errorf("RenewJobLease: %w", err)
+	}
+	if _, err := s.RequeueExpiredJobs(ctx, now); err != nil {
+		return fmt.Errorf("RequeueExpiredJobs: %w", err)
+	}
+	if job, _ := s.GetJob(ctx, second); job == nil || job.Status != JobRunning {
+		return fmt.Errorf("GetJob with a live lease = %+v", job)
+	}
+	if n, err := s.RequeueExpiredJobs(ctx, now.Add(2*time.Hour)); err != nil || n < 1 {
+		return fmt.Errorf("RequeueExpiredJobs after the lease = %d, %v", n, err)
+	}
+	if claimed, err = claimCheckJob(ctx, s, first, second); err != nil || claimed.ID != second || claimed.Attempts != 2 {
+		return fmt.Errorf("ClaimJob after requeue = %+v, %v", claimed, err)
+	}
+	if err := s.FailJob(ctx, second, llmTimeout, "boom"); err != nil {
+		return fmt.Errorf("FailJob: %w", err)
+	}
+	if job, _ := s.GetJob(ctx, second); job == nil || job.Status != JobFailed || job.ErrorCode != llmTimeout || job.Error != "boom" {
+		return fmt.Errorf("GetJob after fail = %+v", job)
+	}
+
+	if _, err := s.ClaimJob(ctx, time.Now().Add(time.Minute)); !errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("ClaimJob on empty queue = %v, want ErrNotFound", err)
+	}
+	if _, err := s.GetJob(ctx, uuid.New().String()); !errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("GetJob unknown = %v, want ErrNotFound", err)
+	}
+	return nil
+}
+
+// Claim jobs until one of ours turns up, failing any left behind by earlier runs
+func claimCheckJob(ctx context.Context, s Store, ours ...string) (*Job, error) {
+	for {
+		job, err := s.ClaimJob(ctx, time.Now().Add(time.Minute))
+		if err != nil {
+			return nil, fmt.Errorf("ClaimJob: %w", err)
+		}
+		for _, id := range ours {
+			if job.ID == id {
+				return job, nil
+			}
+		}
+		s.FailJob(ctx, job.ID, "failed", "left over from an earlier test run")
+	}
+}
+
+func checkPrompts(ctx context.Context, s Store) error {
+	name := "check-" + uuid.New().String()[:8]
+	p := PromptTemplate{Name: name, Version: 1, Variant: "default", Body: "first"}
+	if err := s.SavePrompt(ctx, p); err != nil {
+		return fmt.Errorf("SavePrompt: %w", err)
+	}
+	p.Body = "second"
+	if err := s.SavePrompt(ctx, p); err != nil {
+		return fmt.Errorf("SavePrompt again: %w", err)
+	}
+
+	list, err := s.ListPrompts(ctx)
+	if err != nil {
+		return fmt.Errorf("ListPrompts: %w", err)
+	}
+	var found []PromptTemplate
+	for _, t := range list {
+		if t.Name == name {
+			found = append(found, t)
+		}
+	}
+	if len(found) != 1 || found[0].Version != 1 || found[0].Variant != "default" || found[0].Body != "second" {
+		return fmt.Errorf("ListPrompts = %+v", found)
+	}
+	return nil
+}
+
+func checkCache(ctx context.Context, s Store) error {
+	key := "check-" + uuid.New().String()
+	now := time.Now()
+	pool := CachedPool{Key: key, QuestionsJSON: `[{"q":1}]`, PromptVersion: "quiz/v1/default", ExpiresAt: now.Add(time.Hour)}
+	if err := s.SaveCachedPool(ctx, pool); err != nil {
+		return fmt.Errorf("SaveCachedPool: %w", err)
+	}
+	pool.QuestionsJSON = `[{"q":2}]`
+	if err := s.SaveCachedPool(ctx, pool); err != nil {
+		return fmt.Errorf("SaveCachedPool again: %w", err)
+	}
+
+	got, err := s.CachedPool(ctx, key, now)
+	if err != nil {
+		return fmt.Errorf("CachedPool: %w", err)
+	}
+	if got.QuestionsJSON != `[{"q":2}]` || got.PromptVersion != "quiz/v1/default" || got.ExpiresAt.Unix() != pool.ExpiresAt.Unix() {
+		return fmt.Errorf("CachedPool = %+v", got)
+	}
+	if _, err := s.CachedPool(ctx, key, now.Add(2*time.Hour)); !errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("expired pool: want ErrNotFound, got %v", err)
+	}
+	if _, err := s.CachedPool(ctx, "check-missing", now); !errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("missing pool: want ErrNotFound, got %v", err)
+	}
+	return nil
+}
+
+func checkBank(ctx context.Context, s Store) error {
+	owner, _, err := newCheckUser(ctx, s)
+	if err != nil {
+		return err
+	}
+	other, _, err := newCheckUser(ctx, s)
+	if err != nil {
+		return err
+	}
+	quizID, err := s.SaveQuiz(ctx, owner, "Plants", `[]`, "", "")
+	if err != nil {
+		return fmt.Errorf("SaveQuiz: %w", err)
+	}
+	err = s.AddToBank(ctx, owner, quizID, []BankQuestion{
+		{Topic: "Plants", Difficulty: "Easy", Type: "Multiple Choice", Tags: []string{},
+			Question: []byte(`{"question":"Where does photosynthesis happen?","explanation":"In the chloroplasts"}`)},
+		{Topic: "Plants", Difficulty: "Hard", Type: "True/False", Tags: []string{"exam"},
+			Question: []byte(`{"question":"Roots absorb water","explanation":"Through root hairs"}`)},
+	})
+	if err != nil {
+		return fmt.Errorf("AddToBank: %w", err)
+	}
+
+	// Full-text search, by prefix and through the explanation
+	found, err := s.SearchBank(ctx, owner, BankFilter{Query: "chloro", Limit: 10})
+	if err != nil {
+		return fmt.Errorf("SearchBank: %w", err)
+	}
+	if len(found) != 1 || found[0].Type != "Multiple Choice" || found[0].QuizID != quizID {
+		return fmt.Errorf("SearchBank(chloro) = %+v", found)
+	}
+	first := found[0].ID
+	if found, _ := s.SearchBank(ctx, other, BankFilter{Query: "chloro", Limit: 10}); len(found) != 0 {
+		return fmt.Errorf("other user's search found %d questions", len(found))
+	}
+
+	// Tags: filter, replace, and search sees the new ones
+	if found, _ := s.SearchBank(ctx, owner, BankFilter{Tag: "exam", Limit: 10}); len(found) != 1 || found[0].Tags[0] != "exam" {
+		return fmt.Errorf("SearchBank(tag=exam) = %+v", found)
+	}
+	if err := s.SetBankTags(ctx, owner, first, []string{"revision", "week-2"}); err != nil {
+		return fmt.Errorf("SetBankTags: %w", err)
+	}
+	if err := s.SetBankTags(ctx, other, first, []string{"mine"}); !errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("SetBankTags by another user: want ErrNotFound, got %v", err)
+	}
+	if found, _ := s.SearchBank(ctx, owner, BankFilter{Query: "revision", Difficulty: "easy", Limit: 10}); len(found) != 1 || found[0].ID != first {
+		return fmt.Errorf("SearchBank(revision, easy) = %+v", found)
+	}
+
+	// Picking by ID keeps the order asked for and skips other people's questions
+	picked, err := s.BankQuestions(ctx, owner, []int{first + 1, first, -1})
+	if err != nil {
+		return fmt.Errorf("BankQuestions: %w", err)
+	}
+	if len(picked) != 2 || picked[0].ID != first+1 || picked[1].ID != first {
+		return fmt.Errorf("BankQuestions = %+v", picked)
+	}
+	if picked, _ := s.BankQuestions(ctx, other, []int{first}); len(picked) != 0 {
+		return fmt.Errorf("other user picked %d questions", len(picked))
+	}
+	if random, _ := s.SearchBank(ctx, owner, BankFilter{Random: true, Limit: 1}); len(random) != 1 {
+		return fmt.Errorf("random sample = %+v", random)
+	}
+	return nil
+}
+
+func checkLive(ctx context.Context, s Store) error {
+	userID, _, err := newCheckUser(ctx, s)
+	if err != nil {
+		return err
+	}
+	quizID, err := s.SaveQuiz(ctx, userID, "Live", `[{},{}]`, "", "")
+	if err != nil {
+		return err
+	}
+
+	started := time.Now().Add(-time.Minute).Truncate(time.Millisecond)
+	ids := []int{}
+	for i := 0; i < 2; i++ {
+		id, err := s.SaveLiveSession(ctx, LiveSessionResult{
+			HostUserID: userID, QuizID: quizID, Title: fmt.Sprintf("Live %d", i), QuestionCount: 2,
+			StartedAt: started, FinishedAt: started.Add(time.Duration(i+1) * time.Second),
+			Players: []LivePlayerResult{
+				{Nickname: "Sam", Rank: 1, Score: 1800, Correct: 2, Answers: []byte(`[{"correct":true,"points":900}]`)},
+				{Nickname: "Alex", Rank: 2, Score: 0, Correct: 0, Answers: []byte(`[]`)},
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("SaveLiveSession: %w", err)
+		}
+		ids = append(ids, id)
+	}
+
+	sessions, err := s.LiveSessions(ctx, userID, 10)
+	if err != nil {
+		return fmt.Errorf("LiveSessions: %w", err)
+	}
+	if len(sessions) != 2 || sessions[0].ID != ids[1] || sessions[1].ID != ids[0] {
+		return fmt.Errorf("LiveSessions order = %+v, want newest first", sessions)
+	}
+	got := sessions[1]
+	if got.Title != "Live 0" || got.QuizID != quizID || !got.StartedAt.Equal(started) || len(got.Players) != 2 {
+		return fmt.Errorf("LiveSessions[1] = %+v", got)
+	}
+	if p := got.Players[0]; p.Nickname != "Sam" || p.Rank != 1 || p.Score != 1800 || string(p.Answers) != `[{"correct":true,"points":900}]` {
+		return fmt.Errorf("first player = %+v", p)
+	}
+	if other, err := s.LiveSessions(ctx, userID+1000000, 10); err != nil || len(other) != 0 {
+		return fmt.Errorf("another user's LiveSessions = %+v, %v", other, err)
+	}
+	return nil
+}
+
+func checkAdaptive(ctx context.Context, s Store) error {
+	userID, _, err := newCheckUser(ctx, s)
+	if err != nil {
+		return err
+	}
+	now := time.Now().Truncate(time.Millisecond)
+	q := &AdaptiveQuiz{
+		UserID: userID, Topic: "Rivers", QuizType: "Multiple Choice", StdError: 1, CreatedAt: now, UpdatedAt: now,
+		Items: []AdaptiveItem{{Question: QuizQuestion{Question: "Longest river?", Options: []string{"Nile", "Thames"}, CorrectAnswer: "Nile"}, Level: "Medium", Source: "generated"}},
+	}
+	if q.ID, err = s.CreateAdaptive(ctx, q); err != nil {
+		return fmt.Errorf("CreateAdaptive: %w", err)
+	}
+
+	got, err := s.GetAdaptive(ctx, userID, q.ID)
+	if err != nil || got.Topic != "Rivers" || len(got.Items) != 1 || got.Items[0].Question.CorrectAnswer != "Nile" || !got.CreatedAt.Equal(now) || got.Finished {
+		return fmt.Errorf("GetAdaptive = %+v, %v", got, err)
+	}
+	if _, err := s.GetAdaptive(ctx, userID+1000000, q.ID); !errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("GetAdaptive by another user = %v, want ErrNotFound", err)
+	}
+
+	// Answering moves the revision on, so a second save from the same starting point loses
+	revision := got.revision()
+	got.Items[0].Answered, got.Items[0].Answer, got.Items[0].Correct = true, "Nile", true
+	got.Ability, got.StdError, got.Finished = 0.4, 0.8, true
+	if err := s.UpdateAdaptive(ctx, got, revision); err != nil {
+		return fmt.Errorf("UpdateAdaptive: %w", err)
+	}
+	if err := s.UpdateAdaptive(ctx, got, revision); !errors.Is(err, ErrAlreadyAnswered) {
+		return fmt.Errorf("stale UpdateAdaptive = %v, want ErrAlreadyAnswered", err)
+	}
+	quizID, err := s.SaveQuiz(ctx, userID, "Adaptive: Rivers", `[]`, "", "")
+	if err != nil {
+		return err
+	}
+	got.QuizID = quizID
+	if err := s.UpdateAdaptive(ctx, got, got.revision()); err != nil {
+		return fmt.Errorf("UpdateAdaptive with quiz: %w", err)
+	}
+
+	got, err = s.GetAdaptive(ctx, userID, q.ID)
+	if err != nil || !got.Finished || got.QuizID != quizID || got.Ability != 0.4 || got.StdError != 0.8 || !got.Items[0].Correct || got.Items[0].Answer != "Nile" {
+		return fmt.Errorf("after UpdateAdaptive got %+v, %v", got, err)
+	}
+	return nil
+}
+
+func checkFlags(ctx context.Context, s Store) error {
+	owner, _, err := newCheckUser(ctx, s)
+	if err != nil {
+		return err
+	}
+	// A prompt version of its own, so other checks' quizzes don't show up in the stats
+	version := "check/" + uuid.New().String()
+	oldJSON := `[{"question":"Capital of Australia?","correctAnswer":"Sydney"},{"question":"q2"}]`
+	quizID, err := s.SaveQuiz(ctx, owner, "Capitals", oldJSON, version, "gpt-test")
+	if err != nil {
+		return err
+	}
+	if err := s.AddToBank(ctx, owner, quizID, []BankQuestion{{Question: []byte(`{"question":"Capital of Australia?","correctAnswer":"Sydney"}`)}}); err != nil {
+		return err
+	}
+	start := time.Now().Truncate(time.Millisecond)
+	a, _, err := s.StartAttempt(ctx, owner, quizID, start, time.Time{}, time.Time{})
+	if err != nil {
+		return err
+	}
+	if _, err := s.RecordAnswer(ctx, a.ID, 2, AttemptAnswer{Question: 0, Answer: "Canberra", Correct: false, TimeMs: 1000}, start, time.Time{}); err != nil {
+		return err
+	}
+
+	// Flagging twice keeps one flag, with the newer reason
+	now := start
+	if _, err := s.FlagQuestion(ctx, QuestionFlag{QuizID: quizID, Question: 0, UserID: owner, Reason: "ambiguous", CreatedAt: now}); err != nil {
+		return fmt.Errorf("FlagQuestion: %w", err)
+	}
+	if _, err := s.FlagQuestion(ctx, QuestionFlag{QuizID: quizID, Question: 0, UserID: owner, Reason: "wrong_answer", Comment: "It's Canberra", CreatedAt: now}); err != nil {
+		return fmt.Errorf("FlagQuestion again: %w", err)
+	}
+	if _, err := s.FlagQuestion(ctx, QuestionFlag{QuizID: quizID, Question: 1, UserID: owner, Reason: "off_topic", CreatedAt: now}); err != nil {
+		return err
+	}
+	flags, err := s.QuizFlags(ctx, owner, FlagFilter{QuizID: quizID, Status: "open"})
+	if err != nil || len(flags) != 2 || flags[1].Reason != "wrong_answer" || flags[1].Comment != "It's Canberra" || flags[1].QuizPrompt != "Capitals" || !flags[1].CreatedAt.Equal(now) {
+		return fmt.Errorf("QuizFlags = %+v, %v", flags, err)
+	}
+	if others, err := s.QuizFlags(ctx, owner+1000000, FlagFilter{QuizID: quizID}); err != nil || len(others) != 0 {
+		return fmt.Errorf("QuizFlags for another user = %+v, %v; want none", others, err)
+	}
+
+	// Dismissing closes only that question's flags, and only for the owner
+	if n, err := s.ResolveFlags(ctx, owner+1000000, quizID, 1, "dismissed", now); err != nil || n != 0 {
+		return fmt.Errorf("ResolveFlags by another user = %d, %v; want 0", n, err)
+	}
+	if n, err := s.ResolveFlags(ctx, owner, quizID, 1, "dismissed", now); err != nil || n != 1 {
+		return fmt.Errorf("ResolveFlags = %d, %v; want 1", n, err)
+	}
+
+	// Fixing the answer swaps the question everywhere and re-scores the attempt
+	fixed := `{"question":"Capital of Australia?","correctAnswer":"Canberra"}`
+	fix := QuestionFix{
+		UserID: owner, QuizID: quizID, Question: 0,
+		OldJSON:  oldJSON,
+		NewJSON:  `[` + fixed + `,{"question":"q2"}]`,
+		OldText:  "Capital of Australia?",
+		Fixed:    []byte(fixed),
+		Rescores: []AttemptRescore{{AttemptID: a.ID, Delta: 1, Correct: true}},
+		Now:      now,
+	}
+	if n, err := s.FixQuestion(ctx, fix); err != nil || n != 1 {
+		return fmt.Errorf("FixQuestion = %d, %v; want 1 flag closed", n, err)
+	}
+	if _, err := s.FixQuestion(ctx, fix); !errors.Is(err, ErrQuizChanged) {
+		return fmt.Errorf("FixQuestion from stale questions = %v, want ErrQuizChanged", err)
+	}
+	if q, err := s.GetQuiz(ctx, owner, quizID); err != nil || q.QuestionsJSON != fix.NewJSON {
+		return fmt.Errorf("GetQuiz after fix = %+v, %v", q, err)
+	}
+	if got, err := s.GetAttempt(ctx, owner, quizID); err != nil || got.Score != 1 {
+		return fmt.Errorf("attempt after fix = %+v, %v; want score 1", got, err)
+	}
+	if answers, err := s.AttemptAnswers(ctx, a.ID); err != nil || len(answers) != 1 || !answers[0].Correct {
+		return fmt.Errorf("answers after fix = %+v, %v", answers, err)
+	}
+	if bank, err := s.SearchBank(ctx, owner, BankFilter{Limit: 10}); err != nil || len(bank) != 1 || string(bank[0].Question) != fixed {
+		return fmt.Errorf("bank after fix = %+v, %v", bank, err)
+	}
+	if attempts, err := s.QuizAttempts(ctx, quizID); err != nil || len(attempts) != 1 || attempts[0].ID != a.ID {
+		return fmt.Errorf("QuizAttempts = %+v, %v", attempts, err)
+	}
+
+	stats, err := s.FlagStats(ctx)
+	if err != nil {
+		return fmt.Errorf("FlagStats: %w", err)
+	}
+	for _, st := range stats {
+		if st.PromptVersion == version {
+			if st.Model != "gpt-test" || st.Quizzes != 1 || st.Flags != 2 || st.Fixed != 1 || st.Dismissed != 1 || st.Open != 0 || st.Reasons["wrong_answer"] != 1 || st.Reasons["off_topic"] != 1 {
+				return fmt.Errorf("FlagStats for %s = %+v", version, st)
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("FlagStats left out %s", version)
+}
+
+func checkMedia(ctx context.Context, s Store) error {
+	owner, _, err := newCheckUser(ctx, s)
+	if err != nil {
+		return err
+	}
+	other, _, err := newCheckUser(ctx, s)
+	if err != nil {
+		return err
+	}
+
+	// Bytes that aren't valid UTF-8, to catch a backend storing them as text
+	data := []byte{0x89, 'P', 'N', 'G', 0x00, 0xff, 0xfe, 0x01}
+	created := time.Now().Truncate(time.Millisecond)
+	m := &Media{UserID: owner, Name: "cell.png", MimeType: "image/png", Data: data, Width: 640, Height: 480, CreatedAt: created}
+	id, err := s.SaveMedia(ctx, m)
+	if err != nil {
+		return fmt.Errorf("SaveMedia: %w", err)
+	}
+	again, err := s.SaveMedia(ctx, &Media{UserID: owner, Name: "copy.png", MimeType: "image/png", Data: data, Width: 640, Height: 480, CreatedAt: created})
+	if err != nil || again != id {
+		return fmt.Errorf("SaveMedia again = %d, %v, want %d", again, err, id)
+	}
+	theirs, err := s.SaveMedia(ctx, &Media{UserID: other, Name: "cell.png", MimeType: "image/png", Data: data, Width: 640, Height: 480, CreatedAt: created})
+	if err != nil || theirs == id {
+		return fmt.Errorf("SaveMedia for another user = %d, %v, want a new ID", theirs, err)
+	}
+
+	got, err := s.GetMedia(ctx, owner, id)
+	if err != nil {
+		return fmt.Errorf("GetMedia: %w", err)
+	}
+	if got.Name != "cell.png" || got.MimeType != "image/png" || string(got.Data) != string(data) || got.Width != 640 || got.Height != 480 || !got.CreatedAt.Equal(created) {
+		return fmt.Errorf("GetMedia = %+v", got)
+	}
+	if _, err := s.GetMedia(ctx, other, id); !errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("GetMedia by another user = %v, want ErrNotFound", err)
+	}
+	return nil
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// ============================================================================
+// PERSONAL API TOKENS - Letting scripts talk to Askify without a browser
+// ============================================================================
+
+// Every token starts with this so people can spot (and grep for) leaked ones
+const apiTokenPrefix = "askify_"
+
+// What a token is allowed to do
+const (
+	scopeRead  = "read"  // GET endpoints: history, quiz details, profile
+	scopeWrite = "write" // Everything that changes data or costs money
+)
+
+// All the scopes a user can pick from when minting a token
+var validTokenScopes = map[string]bool{
+	scopeRead:  true,
+	scopeWrite: true,
+}
+
+// When user creates a new token
+type CreateTokenRequest struct {
+	Name   string   `json:"name"`   // Label so they remember what it's for (e.g., "grading script")
+	Scopes []string `json:"scopes"` // What the token may do: "read", "write"
+}
+
+// When user revokes a token
+type RevokeTokenRequest struct {
+	ID int `json:"id"` // Which token to switch off
+}
+
+// Token information shown in the token list (never includes the secret)
+type APIToken struct {
+	ID         int      `json:"id"`           // Token identifier
+	Name       string   `json:"name"`         // Label the user gave it
+	Scopes     []string `json:"scopes"`       // What it's allowed to do
+	CreatedAt  string   `json:"created_at"`   // When it was minted
+	LastUsedAt string   `json:"last_used_at"` // When a request last used it (empty if never)
+	Revoked    bool     `json:"revoked"`      // Switched off by the user?
+}
+
+// Make a new random token - the plain value is only ever shown once
+func generateAPIToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return apiTokenPrefix + hex.EncodeToString(b), nil
+}
+
+// We only keep a hash in the database, like a password
+func hashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Pull the token out of "Authorization: Bearer askify_..."
+func bearerToken(r *http.Request) (string, bool) {
+	auth := r.Header.Get("Authorization")
+	if auth == "" {
+		return "", false
+	}
+	scheme, token, found := strings.Cut(auth, " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+// Which scope a request needs - reading is safe, anything else needs write
+func requiredScope(r *http.Request) string {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		return scopeRead
+	default:
+		return scopeWrite
+	}
+}
+
+// Check a token's scope list for the one we need
+func hasScope(scopes []string, want string) bool {
+	for _, s := range scopes {
+		if s == want {
+			return true
+		}
+	}
+	return false
+}
+
+// Get user info from a bearer token, checking it hasn't been revoked
+// and is allowed to make this kind of request
+func (a *App) getTokenUser(r *http.Request, token string) (*User, bool) {
+	user, tokenID, scopes, err := a.store.TokenUser(r.Context(), hashAPIToken(token))
+	if err != nil {
+		return nil, false // Unknown or revoked token
+	}
+
+	if !hasScope(scopes, requiredScope(r)) {
+		return nil, false // Token isn't allowed to do this
+	}
+
+	// Remember when the token was last used so users can spot stale ones
+	if err := a.store.TouchToken(r.Context(), tokenID); err != nil {
+		logFor(r.Context()).Warn("could not update token last_used_at", "token_id", tokenID, "error", err)
+	}
+
+	return user, true
+}
+
+// Get the user behind a request - bearer token if one was sent, otherwise the session cookie
+func (a *App) getRequestUser(r *http.Request) (*User, bool) {
+	if token, ok := bearerToken(r); ok {
+		return a.getTokenUser(r, token)
+	}
+	return a.getSessionUser(r)
+}
+
+// Wrap handlers that must only be reachable from a logged-in browser session.
+// Token management lives here so a leaked token can't mint more tokens.
+func (a *App) requireSession(handler func(http.ResponseWriter, *http.Request, *User)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := a.getSessionUser(r)
+		if !ok {
+			httpError(w, r, msgUnauthorized, http.StatusUnauthorized)
+			return
+		}
+		handler(w, r, user)
+	}
+}
+
+// ============================================================================
+// TOKEN MANAGEMENT HANDLERS - List, create, revoke
+// ============================================================================
+
+// List the user's tokens (GET) or mint a new one (POST)
+func (a *App) handleAPITokens(w http.ResponseWriter, r *http.Request, user *User) {
+	switch r.Method {
+	case http.MethodGet:
+		a.listAPITokens(w, r, user)
+	case http.MethodPost:
+		a.createAPIToken(w, r, user)
+	default:
+		httpError(w, r, msgMethodNotAllowed, http.StatusMethodNotAllowed)
+	}
+}
+
+// Send back all tokens for this user, newest first
+func (a *App) listAPITokens(w http.ResponseWriter, r *http.Request, user *User) {
+	result, err := a.store.ListTokens(r.Context(), user.ID)
+	if err != nil {
+		httpError(w, r, msgTokensFailed, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// Mint a new token and show the plain value exactly once
+func (a *App) createAPIToken(w http.ResponseWriter, r *http.Request, user *User) {
+	var req CreateTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, r, msgInvalidJSON, http.StatusBadRequest)
+		return
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		httpError(w, r, msgTokenNameRequired, http.StatusBadRequest)
+		return
+	}
+
+	// Default to read-only - safest choice if the user didn't say
+	if len(req.Scopes) == 0 {
+		req.Scopes = []string{scopeRead}
+	}
+	for _, s := range req.Scopes {
+		if !validTokenScopes[s] {
+			httpError(w, r, msgUnknownScope, http.StatusBadRequest, s)
+			return
+		}
+	}
+
+	token, err := generateAPIToken()
+	if err != nil {
+		httpError(w, r, msgTokenFailed, http.StatusInternalServerError)
+		return
+	}
+
+	tokenID, err := a.store.CreateToken(r.Context(), user.ID, req.Name, hashAPIToken(token), req.Scopes)
+	if err != nil {
+		httpError(w, r, msgTokenSaveFailed, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":     tokenID,
+		"name":   req.Name,
+		"scopes": req.Scopes,
+		"token":  token, // Only time the user will ever see this
+	})
+}
+
+// Switch a token off so it stops working immediately
+func (a *App) handleRevokeAPIToken(w http.ResponseWriter, r *http.Request, user *User) {
+	if r.Method != http.MethodPost {
+		httpError(w, r, msgMethodNotAllowed, http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req RevokeTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, r, msgInvalidJSON, http.StatusBadRequest)
+		return
+	}
+
+	err := a.store.RevokeToken(r.Context(), user.ID, req.ID)
+	if errors.Is(err, ErrNotFound) {
+		httpError(w, r, msgTokenNotFound, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		httpError(w, r, msgTokenRevokeFailed, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
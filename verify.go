@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ============================================================================
+// ANSWER CHECKS - A second opinion on the AI's answers
+// ============================================================================
+//
+// The model that writes a quiz also decides which answer is right, and now
+// and then it gets that wrong. With verify.mode switched on, every batch of
+// generated questions goes back to the AI without the answers - to
+// verify.model if one is set, otherwise the same model with the checker
+// prompt (prompts/verify) - and its answers are compared with the quiz's. In
+// "flag" mode the questions it disagrees with are marked low-confidence so
+// the page can say so; in "regenerate" mode the AI is asked once for
+// different questions in their place, and any that still don't pass are
+// flagged. Checked questions go into the generation cache, so a cache hit
+// doesn't pay for the check again.
+
+// Verify modes
+const (
+	verifyOff        = "off"
+	verifyFlag       = "flag"
+	verifyRegenerate = "regenerate"
+)
+
+var verifyModes = []string{verifyOff, verifyFlag, verifyRegenerate}
+
+// Attached to a question whose answer the checker didn't agree with
+type LowConfidenceInfo struct {
+	CheckerAnswer string `json:"checkerAnswer"` // What the checker said instead; empty if it found no single right answer
+}
+
+// Generate questions, then check their answers. Like dedupQuiz, a check
+// that goes wrong is logged and the questions are passed through as they
+// were - an unchecked quiz is still better than no quiz.
+func (a *App) generateVerified(ctx context.Context, log *slog.Logger, req QuizRequest) (string, string, error) {
+	quizJSON, promptRef, err := a.generateWithRetries(ctx, log, req)
+	if err != nil || a.config.Verify.Mode == verifyOff {
+		return quizJSON, promptRef, err
+	}
+	var questions []QuizQuestion
+	if err := json.Unmarshal([]byte(quizJSON), &questions); err != nil {
+		return quizJSON, promptRef, nil
+	}
+
+	disagreed, err := a.checkAnswers(ctx, req, questions)
+	if err != nil {
+		log.Warn("answer check failed", "model", a.checker.model, "error", err)
+		for range questions {
+			a.metrics.verifiedQuestions.Inc("failed")
+		}
+		return quizJSON, promptRef, nil
+	}
+	for range len(questions) - len(disagreed) {
+		a.metrics.verifiedQuestions.Inc("agreed")
+	}
+
+	if len(disagreed) > 0 && a.config.Verify.Mode == verifyRegenerate {
+		disagreed = a.replaceDisagreements(ctx, log, req, questions, disagreed)
+	}
+	for i, info := range disagreed {
+		questions[i].LowConfidence = info
+		a.metrics.verifiedQuestions.Inc("disagreed")
+	}
+	log.Info("checked answers", "questions", len(questions), "low_confidence", len(disagreed), "model", a.checker.model)
+
+	result, err := json.Marshal(questions)
+	if err != nil {
+		return quizJSON, promptRef, nil
+	}
+	return string(result), promptRef, nil
+}
+
+// Ask the checker to answer the questions blind. Returns the ones (by index)
+// whose answer it didn't agree with. Questions it skipped count as agreed.
+// Only questions with one answer are checked - a blind second answer to an
+// ordering or matching question says little about whether the first is right.
+// Nor are questions about images, which the checker isn't shown.
+func (a *App) checkAnswers(ctx context.Context, req QuizRequest, questions []QuizQuestion) (map[int]*LowConfidenceInfo, error) {
+	data := quizPromptData(req)
+	var checked []int // The question each one in the prompt is
+	for i, q := range questions {
+		if kind := kindOf(q); (legacyKind(kind) || kind == typeNumeric) && q.ImageID == 0 {
+			data.Questions = append(data.Questions, QuizQuestion{Question: q.Question, Options: q.Options})
+			checked = append(checked, i)
+		}
+	}
+	if len(checked) == 0 {
+		return map[int]*LowConfidenceInfo{}, nil
+	}
+	data.QuestionCount = len(checked)
+	prompt, err := a.prompts.Render("verify", data)
+	if err != nil {
+		return nil, err
+	}
+
+	messages := []OpenAIMessage{
+		{Role: "system", Content: prompt.System},
+		{Role: "user", Content: prompt.User},
+	}
+	// Questions on a document can only be checked against the document
+	if req.Source != "" {
+		boundary := newSourceBoundary()
+		messages[0].Content += "\n\n" + sourceRules(boundary, len(scanSource(req.Source)) > 0)
+		messages = append(messages, OpenAIMessage{Role: "user", Content: wrapSource(req.Source, boundary)})
+	}
+
+	content, err := a.checker.Chat(ctx, messages, &OutputSchema{Name: "answers", Schema: checkerSchema()})
+	if err != nil {
+		return nil, err
+	}
+	answers, err := parseCheckerAnswers(content)
+	if err != nil {
+		logFor(ctx).Warn("checker returned unusable answers", "prompt", prompt.Ref,
+			"error", err, "content_preview", truncate(content, 500))
+		return nil, err
+	}
+
+	disagreed := map[int]*LowConfidenceInfo{}
+	for n, i := range checked {
+		q := questions[i]
+		answer, ok := answers[n]
+		if ok && !sameAnswer(q, answer) {
+			disagreed[i] = &LowConfidenceInfo{CheckerAnswer: strings.TrimSpace(answer)}
+		}
+	}
+	return disagreed, nil
+}
+
+// Ask the AI for new questions in place of the ones that failed the check,
+// and check those too. Returns the disagreements that are left (those with
+// no replacement that passed).
+func (a *App) replaceDisagreements(ctx context.Context, log *slog.Logger, req QuizRequest, questions []QuizQuestion, disagreed map[int]*LowConfidenceInfo) map[int]*LowConfidenceInfo {
+	avoid := make([]string, 0, len(questions))
+	for _, q := range questions {
+		avoid = append(avoid, q.Question)
+	}
+	more := req
+	more.QuestionCount = len(disagreed)
+	more.Avoid = avoid
+	moreJSON, _, err := a.generateWithRetries(ctx, log, more)
+	if err != nil {
+		log.Warn("could not generate replacement questions", "error", err)
+		return disagreed
+	}
+	var replacements []QuizQuestion
+	if err := json.Unmarshal([]byte(moreJSON), &replacements); err != nil {
+		return disagreed
+	}
+	failed, err := a.checkAnswers(ctx, req, replacements)
+	if err != nil {
+		log.Warn("answer check of replacements failed", "error", err)
+		return disagreed
+	}
+
+	next := 0
+	for i := range questions {
+		if disagreed[i] == nil {
+			continue
+		}
+		for next < len(replacements) && failed[next] != nil {
+			next++
+		}
+		if next == len(replacements) {
+			break
+		}
+		questions[i] = replacements[next]
+		delete(disagreed, i)
+		a.metrics.verifiedQuestions.Inc("replaced")
+		next++
+	}
+	return disagreed
+}
+
+// Does the checker's answer match the quiz's? Options can come back as the
+// option text in any case, or as a letter; short answers match if they're
+// the same, or one has the other in it as whole words, so "Canberra" agrees
+// with "Canberra, Australia" (but "a" or "1" agree with nothing longer).
+// Numbers agree within the question's tolerance.
+func sameAnswer(q QuizQuestion, answer string) bool {
+	answer = strings.TrimSpace(answer)
+	if answer == "" {
+		return false
+	}
+	normalize := func(s string) string {
+		return strings.Join(strings.Fields(strings.ToLower(s)), " ")
+	}
+
+	if kindOf(q) == typeNumeric {
+		return gradeAnswer(q, answer)
+	}
+	if len(q.Options) > 0 {
+		for i, option := range q.Options {
+			letter := string(rune('A' + i))
+			if normalize(option) == normalize(answer) || strings.EqualFold(strings.TrimRight(answer, ".)"), letter) {
+				return option == q.CorrectAnswer
+			}
+		}
+		return false
+	}
+	got, want := normalize(answer), normalize(q.CorrectAnswer)
+	return want != "" && (got == want || containsWords(got, want) || containsWords(want, got))
+}
+
+// Shortest answer (in letters and digits) that counts as agreeing when it's
+// only part of the other one
+const verifyMinPartial = 4
+
+// Are short's words found together in long, and is there enough of short
+// for that to mean something?
+func containsWords(long, short string) bool {
+	words := func(s string) []string {
+		return strings.FieldsFunc(s, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsNumber(r) })
+	}
+	l, s := words(long), words(short)
+	if len(s) == 0 || utf8.RuneCountInString(strings.Join(s, "")) < verifyMinPartial {
+		return false
+	}
+	for i := 0; i+len(s) <= len(l); i++ {
+		if slices.Equal(l[i:i+len(s)], s) {
+			return true
+		}
+	}
+	return false
+}
+
+// The JSON schema for the checker's answers
+func checkerSchema() map[string]interface{} {
+	answer := map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"index":  map[string]interface{}{"type": "integer", "description": "The question's number"},
+			"answer": map[string]interface{}{"type": "string", "description": "Empty if there's no single right answer"},
+		},
+		"required":             []string{"index", "answer"},
+		"additionalProperties": false,
+	}
+	return map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"answers": map[string]interface{}{"type": "array", "items": answer},
+		},
+		"required":             []string{"answers"},
+		"additionalProperties": false,
+	}
+}
+
+// Read the checker's answers, by question index. Without JSON schema mode
+// the model may chat before the JSON or fence it, so reading starts at the
+// first brace and stops at the end of that object.
+func parseCheckerAnswers(content string) (map[int]string, error) {
+	start := strings.IndexByte(content, '{')
+	if start < 0 {
+		return nil, errors.New("no JSON found")
+	}
+	var env struct {
+		Answers []struct {
+			Index  int    `json:"index"`
+			Answer string `json:"answer"`
+		} `json:"answers"`
+	}
+	if err := json.NewDecoder(strings.NewReader(content[start:])).Decode(&env); err != nil {
+		return nil, fmt.Errorf("answers are not valid JSON: %w", err)
+	}
+	if env.Answers == nil {
+		return nil, errors.New("no answers in the response")
+	}
+	answers := map[int]string{}
+	for _, a := range env.Answers {
+		answers[a.Index] = a.Answer
+	}
+	return answers, nil
+}
@@ -0,0 +1,27 @@
+package main
+
+import "testing"
+
+// A checker's short answer only agrees when it's the quiz's answer, or a
+// real part of it - not any letter or digit that happens to be in there
+func TestSameShortAnswer(t *testing.T) {
+	for _, tc := range []struct {
+		want, got string
+		same      bool
+	}{
+		{"Canberra", "canberra", true},
+		{"Canberra", "Canberra, Australia", true},
+		{"Canberra, Australia", "Canberra", true},
+		{"The mitochondria", "mitochondria", true},
+		{"Canberra", "a", false},
+		{"Route 66", "6", false},
+		{"Sydney", "Sydney Opera House", true},
+		{"Photosynthesis", "synthesis", false},
+		{"Ohm", "ohms", false},
+	} {
+		q := QuizQuestion{Question: "?", Options: []string{}, CorrectAnswer: tc.want}
+		if got := sameAnswer(q, tc.got); got != tc.same {
+			t.Errorf("%q vs %q: %v, want %v", tc.want, tc.got, got, tc.same)
+		}
+	}
+}
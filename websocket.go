@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/coder/websocket"
+)
+
+// ============================================================================
+// WEBSOCKETS - Connections for live quiz sessions
+// ============================================================================
+//
+// The protocol itself is github.com/coder/websocket's job. wsConn wraps one of
+// its connections with what the game wants: whole text messages, writes
+// that never block the sender, a ping now and then, and a close that never
+// blocks. Each connection has its own outbox and a goroutine writing it out,
+// so a slow client only holds up its own messages, never the game.
+
+// Limits for one connection
+const (
+	wsMaxMessage   = 64 << 10         // Biggest message we'll read; game messages are tiny
+	wsPingEvery    = 30 * time.Second // Keeps idle connections open along the way
+	wsPongTimeout  = 45 * time.Second // Drop connections that don't answer a ping in this long
+	wsWriteTimeout = 10 * time.Second // A stuck client mustn't hold up the game
+	wsOutbox       = 64               // Messages waiting to go out before we give up on a client
+)
+
+// Close status codes we send
+const (
+	wsCloseNormal      = websocket.StatusNormalClosure
+	wsClosePolicyError = websocket.StatusPolicyViolation
+)
+
+// One upgraded connection. Writes may come from any goroutine; reads must
+// all come from one.
+type wsConn struct {
+	lang    string // What to say things in (see messages.go)
+	conn    *websocket.Conn
+	out     chan []byte   // Messages waiting to be written
+	closed  chan struct{} // Closed by Close
+	written chan struct{} // Closed once the writer has sent what it's going to
+	once    sync.Once
+
+	closeCode   websocket.StatusCode // What Close was called with, for the writer
+	closeReason string
+}
+
+// Switch a request over to the WebSocket protocol. On failure an HTTP error
+// has already been sent. Browsers send cookies with WebSocket requests from
+// any site, and CSRF tokens don't apply to them, so only our own pages are
+// accepted (the library checks Origin against Host).
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request) (*wsConn, error) {
+	// The server's read/write timeouts were meant for ordinary requests, and
+	// they stay on the connection once it's handed over
+	rc := http.NewResponseController(w)
+	rc.SetReadDeadline(time.Time{})
+	rc.SetWriteDeadline(time.Time{})
+
+	conn, err := websocket.Accept(w, r, nil)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetReadLimit(wsMaxMessage)
+	c := &wsConn{
+		lang:    requestLanguage(r),
+		conn:    conn,
+		out:     make(chan []byte, wsOutbox),
+		closed:  make(chan struct{}),
+		written: make(chan struct{}),
+	}
+	go c.writeLoop()
+	go c.keepAlive()
+	return c, nil
+}
+
+// Read the next whole message. Any error means the connection is gone.
+func (c *wsConn) ReadMessage() ([]byte, error) {
+	_, data, err := c.conn.Read(context.Background())
+	if err != nil {
+		c.Close(websocket.StatusGoingAway, "")
+		return nil, err
+	}
+	return data, nil
+}
+
+// Queue one text message. Never waits: a client whose outbox is full has
+// stopped reading, and gets hung up on.
+func (c *wsConn) WriteText(data []byte) {
+	select {
+	case <-c.closed:
+	case c.out <- data:
+	default:
+		c.Close(wsClosePolicyError, "too slow")
+	}
+}
+
+// Write out the outbox until the connection closes, then send whatever's
+// still queued (the game's goodbye, say) and do the close handshake
+func (c *wsConn) writeLoop() {
+	for {
+		select {
+		case data := <-c.out:
+			ctx, cancel := context.WithTimeout(context.Background(), wsWriteTimeout)
+			err := c.conn.Write(ctx, websocket.MessageText, data)
+			cancel()
+			if err != nil {
+				c.Close(websocket.StatusGoingAway, "")
+			}
+		case <-c.closed:
+			c.flush()
+			close(c.written)
+			c.conn.Close(c.closeCode, firstRunes(c.closeReason, 40))
+			return
+		}
+	}
+}
+
+// Write what's left in the outbox, giving up after wsWriteTimeout
+func (c *wsConn) flush() {
+	ctx, cancel := context.WithTimeout(context.Background(), wsWriteTimeout)
+	defer cancel()
+	for {
+		select {
+		case data := <-c.out:
+			if c.conn.Write(ctx, websocket.MessageText, data) != nil {
+				return
+			}
+		default:
+			return
+		}
+	}
+}
+
+// Ping until the connection closes, hanging up on a client that stops
+// answering. The pong arrives through ReadMessage, so this only works while
+// something is reading.
+func (c *wsConn) keepAlive() {
+	ticker := time.NewTicker(wsPingEvery)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.closed:
+			return
+		case <-ticker.C:
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), wsPongTimeout)
+		err := c.conn.Ping(ctx)
+		cancel()
+		if err != nil {
+			c.Close(websocket.StatusGoingAway, "no pong")
+			return
+		}
+	}
+}
+
+// Say goodbye and hang up once what's queued has been sent. The writer does
+// that in the background, so this never blocks. Safe to call more than once.
+func (c *wsConn) Close(code websocket.StatusCode, reason string) {
+	c.once.Do(func() {
+		c.closeCode, c.closeReason = code, reason
+		close(c.closed)
+	})
+}
+
+// Closed once the connection has been closed
+func (c *wsConn) Done() <-chan struct{} {
+	return c.closed
+}
+
+// Closed once a closed connection has sent the last of its messages (or
+// given up on them)
+func (c *wsConn) Written() <-chan struct{} {
+	return c.written
+}